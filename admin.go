@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token := r.Header.Get("X-Admin-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requireAdmin reports whether r carries the admin token, writing an error
+// response if it doesn't.
+func (fs *FileServer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if grant, ok := requestGrant(r); ok && grant.scope >= scopeAdmin {
+		return true
+	}
+	if !fs.canManage() {
+		auditNote(r, "management disabled")
+		httpError(w, r, "File management is disabled (start the server with -admin-token or an admin -token)", http.StatusForbidden)
+		return false
+	}
+	if fs.authLocked(w, r) {
+		return false
+	}
+	if grant, ok := fs.credential(requestToken(r)); !ok || grant.scope < scopeAdmin {
+		fs.authFailed(r, "invalid admin token")
+		httpError(w, r, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	fs.authLimit.succeed(fs.getClientIP(r))
+	return true
+}
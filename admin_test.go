@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test deleting and renaming received files
+func TestManageReceivedFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_manage_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.WriteFile(filepath.Join(tempDir, "bad.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "keep.txt"), []byte("y"), 0644)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+
+	rec := httptest.NewRecorder()
+	fs.handleFile(rec, httptest.NewRequest("DELETE", "/api/file?name=bad.txt", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Delete without -admin-token should be forbidden, got %d", rec.Code)
+	}
+
+	fs.adminToken = "s3cret"
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("DELETE", "/api/file?name=bad.txt", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	fs.handleFile(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Delete with wrong token = %d, expected 401", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/api/file?name=bad.txt", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	fs.handleFile(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Delete with token = %d, expected 200", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "bad.txt")); !os.IsNotExist(err) {
+		t.Error("bad.txt should have been deleted")
+	}
+
+	rename := func(name, to string) int {
+		form := url.Values{"name": {name}, "to": {to}}
+		req := httptest.NewRequest("POST", "/api/file/rename", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Admin-Token", "s3cret")
+		rec := httptest.NewRecorder()
+		fs.handleFileRename(rec, req)
+		return rec.Code
+	}
+
+	if code := rename("keep.txt", "archive/kept.txt"); code != http.StatusOK {
+		t.Errorf("Rename = %d, expected 200", code)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "archive", "kept.txt")); err != nil {
+		t.Errorf("Renamed file missing: %v", err)
+	}
+	if code := rename("archive/kept.txt", "../outside.txt"); code != http.StatusBadRequest {
+		t.Errorf("Rename outside root = %d, expected 400", code)
+	}
+	os.WriteFile(filepath.Join(tempDir, "other.txt"), []byte("z"), 0644)
+	if code := rename("other.txt", "archive/kept.txt"); code != http.StatusConflict {
+		t.Errorf("Rename onto existing file = %d, expected 409", code)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// archiveSource is a file or directory on disk and the name it gets inside
+// the archive. An empty name places a directory's contents at the root.
+// With skipHidden, hidden files and folders below path are left out, as
+// is anything the ignore rules match. With hardLinks, further names of a
+// file already in the archive are passed on as linkedFile.
+type archiveSource struct {
+	path       string
+	name       string
+	skipHidden bool
+	ignore     ignoreRules
+	hardLinks  bool
+}
+
+// filtered reports whether walking s can differ from walking its path.
+func (s archiveSource) filtered() bool {
+	return s.skipHidden || len(s.ignore) > 0 || s.hardLinks
+}
+
+// skips reports whether file, below the source's path, is left out.
+func (s archiveSource) skips(file string, fi os.FileInfo) bool {
+	if s.skipHidden && isHidden(fi) {
+		return true
+	}
+	if len(s.ignore) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(s.path, file)
+	return err == nil && s.ignore.match(filepath.ToSlash(rel), fi.IsDir())
+}
+
+// skipsBelow reports whether full, or any folder between the source's path
+// and full, is left out.
+func (s archiveSource) skipsBelow(full string) bool {
+	if !s.skipHidden && len(s.ignore) == 0 {
+		return false
+	}
+	var parents []string
+	for p := full; len(p) > len(filepath.Clean(s.path)); p = filepath.Dir(p) {
+		parents = append(parents, p)
+	}
+	// Outermost first: ignore rules only hold once the parents are in.
+	for i := len(parents) - 1; i >= 0; i-- {
+		if fi, err := os.Lstat(parents[i]); err == nil && s.skips(parents[i], fi) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s archiveSource) entryName(file string) string {
+	rel, err := filepath.Rel(s.path, file)
+	if err != nil || rel == "." {
+		return s.name
+	}
+	if s.name == "" {
+		return filepath.ToSlash(rel)
+	}
+	return s.name + "/" + filepath.ToSlash(rel)
+}
+
+// walkSources visits every file and directory of sources with its archive
+// entry name. The root of a nameless source is skipped. Sources are visited
+// in order and each tree in lexical order, so as long as the files don't
+// change every walk, and so every archive, is the same.
+func walkSources(sources []archiveSource, fn func(file, name string, fi os.FileInfo) error) error {
+	return walkSourcesWith(filepath.Walk, sources, fn)
+}
+
+// walkSourcesParallel is walkSources on walkParallel, for when only the
+// totals matter or the caller sorts: fn is called from several goroutines
+// and in no particular order, and which copy of a hard link is the first
+// is down to timing.
+func walkSourcesParallel(sources []archiveSource, fn func(file, name string, fi os.FileInfo) error) error {
+	return walkSourcesWith(walkParallel, sources, fn)
+}
+
+func walkSourcesWith(walk func(string, filepath.WalkFunc) error, sources []archiveSource, fn func(file, name string, fi os.FileInfo) error) error {
+	var linksMu sync.Mutex
+	links := map[fileID]string{}
+	for _, src := range sources {
+		err := walk(src.path, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if file != src.path && src.skips(file, fi) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			name := src.entryName(file)
+			if name == "" {
+				return nil
+			}
+			if src.hardLinks && fi.Mode().IsRegular() {
+				if id, ok := hardLinkID(fi); ok {
+					linksMu.Lock()
+					if first, seen := links[id]; seen {
+						fi = linkedFile{fi, relativeEntry(name, first)}
+					} else {
+						links[id] = name
+					}
+					linksMu.Unlock()
+				}
+			}
+			return fn(file, name, fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sourcesSize(sources []archiveSource) int64 {
+	if slices.ContainsFunc(sources, archiveSource.filtered) {
+		var size atomic.Int64
+		walkSourcesParallel(sources, func(_, _ string, fi os.FileInfo) error {
+			if !fi.IsDir() {
+				size.Add(fi.Size())
+			}
+			return nil
+		})
+		return size.Load()
+	}
+	var size int64
+	for _, src := range sources {
+		n, _ := calculateDirSize(src.path)
+		size += n
+	}
+	return size
+}
+
+// writeZipArchive streams sources as a zip to w, compressing file data
+// with method (zip.Deflate or zip.Store) and calling progress with the
+// number of file bytes written after each chunk.
+func writeZipArchive(w io.Writer, sources []archiveSource, method uint16, progress func(n int64)) error {
+	zipWriter := zip.NewWriter(w)
+	// One buffer for the whole archive rather than one per file.
+	buf := make([]byte, 32*1024)
+
+	err := walkSources(sources, func(file, name string, fi os.FileInfo) error {
+		header, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = method
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		var r io.Reader
+		if link, ok := fi.(linkedFile); ok {
+			r = strings.NewReader(link.target)
+		} else {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		_, err = io.CopyBuffer(writer, &progressReader{r: r, progress: progress}, buf)
+		return err
+	})
+	if err != nil {
+		zipWriter.Close()
+		return err
+	}
+	return zipWriter.Close()
+}
+
+// archiveETag identifies the archive writeZipArchive produces for sources
+// with method. It covers everything that goes into the archive except the
+// file contents, which are assumed unchanged if their size and
+// modification time are, plus the Go version, whose compressor may differ.
+func archiveETag(sources []archiveSource, method uint16) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %d\n", runtime.Version(), method)
+	err := walkSources(sources, func(file, name string, fi os.FileInfo) error {
+		fmt.Fprintf(h, "%q %d %d %o\n", name, fi.Size(), fi.ModTime().UnixNano(), fi.Mode())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return `"zip-` + hex.EncodeToString(h.Sum(nil))[:32] + `"`, nil
+}
+
+// parseRange reads a single-range Range header for a body of size bytes.
+// ok is false when the header should be ignored and the whole body sent;
+// a range past the end returns ok with start >= size.
+func parseRange(header string, size int64) (r byteRange, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	first, last, dash := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found || !dash || strings.Contains(spec, ",") {
+		return byteRange{}, false
+	}
+	if first == "" {
+		// A suffix: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return byteRange{}, false
+		}
+		return byteRange{max(size-n, 0), size - 1}, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return byteRange{}, false
+		}
+	}
+	return byteRange{start, min(end, size-1)}, true
+}
+
+// archiveRange returns the range of an archive of length bytes requested
+// by r, if its ETag matches any If-Range.
+func archiveRange(r *http.Request, etag string, length int64) (byteRange, bool) {
+	header := r.Header.Get("Range")
+	if etag == "" || header == "" {
+		return byteRange{}, false
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return byteRange{}, false
+	}
+	return parseRange(header, length)
+}
+
+var errRangeDone = errors.New("range written")
+
+// rangeWriter passes on only bytes r.start to r.end of what is written
+// through it, returning errRangeDone once the range is complete so the
+// writer producing the stream can stop.
+type rangeWriter struct {
+	w   io.Writer
+	pos int64
+	r   byteRange
+}
+
+func (rw *rangeWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	from := max(rw.r.start-rw.pos, 0)
+	to := min(rw.r.end+1-rw.pos, int64(n))
+	rw.pos += int64(n)
+	if from < to {
+		if _, err := rw.w.Write(b[from:to]); err != nil {
+			return 0, err
+		}
+	}
+	if rw.pos > rw.r.end {
+		return n, errRangeDone
+	}
+	return n, nil
+}
+
+// Sizes of the records archive/zip writes when streaming, used to work out
+// the length of a stored archive before writing it.
+const (
+	zipLocalHeaderLen      = 30
+	zipCentralHeaderLen    = 46
+	zipDataDescriptorLen   = 16
+	zipDataDescriptor64Len = 24
+	zipExtTimeExtraLen     = 9
+	zipZip64ExtraLen       = 28
+	zipEndLen              = 22
+	zipZip64EndAndLocator  = 56 + 20
+	zipMax32               = 1<<32 - 1
+	zipMax16               = 1<<16 - 1
+)
+
+var errIrregularFile = errors.New("not a regular file")
+
+// zipMethod is how archive entries are compressed.
+func (fs *FileServer) zipMethod() uint16 {
+	if fs.zipStore {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// storedArchiveSize returns the exact length of the archive
+// writeZipArchive produces for sources with zip.Store, mirroring the
+// layout archive/zip writes: a local header, the data and (for files) a
+// data descriptor per entry, then the central directory. It is only exact
+// if the files don't change before they are sent.
+func storedArchiveSize(sources []archiveSource) (int64, error) {
+	var offset, central, records int64
+	err := walkSources(sources, func(file, name string, fi os.FileInfo) error {
+		extra := int64(0)
+		if !fi.ModTime().IsZero() {
+			extra = zipExtTimeExtraLen
+		}
+		if fi.IsDir() {
+			name += "/"
+		}
+		if _, linked := fi.(linkedFile); !linked && !fi.IsDir() && !fi.Mode().IsRegular() {
+			// Symlinks and devices are read through, so their size on
+			// disk says nothing about what gets written.
+			return errIrregularFile
+		}
+		nameLen := int64(len(name))
+		entryOffset := offset
+
+		offset += zipLocalHeaderLen + nameLen + extra
+		zip64 := false
+		if !fi.IsDir() {
+			size := fi.Size()
+			zip64 = size >= zipMax32
+			offset += size
+			if zip64 {
+				offset += zipDataDescriptor64Len
+			} else {
+				offset += zipDataDescriptorLen
+			}
+		}
+
+		central += zipCentralHeaderLen + nameLen + extra
+		if zip64 || entryOffset >= zipMax32 {
+			central += zipZip64ExtraLen
+		}
+		records++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	size := offset + central + zipEndLen
+	if records >= zipMax16 || central >= zipMax32 || offset >= zipMax32 {
+		size += zipZip64EndAndLocator
+	}
+	return size, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+type progressReader struct {
+	r        io.Reader
+	progress func(n int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 && p.progress != nil {
+		p.progress(int64(n))
+	}
+	return n, err
+}
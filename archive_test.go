@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test the precomputed size of stored archives against real ones
+func TestStoredArchiveSize(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_archive_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	root := filepath.Join(tempDir, "photos")
+	os.MkdirAll(filepath.Join(root, "2024", "五月"), 0755)
+	os.MkdirAll(filepath.Join(root, "empty"), 0755)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(root, "zero.bin"), nil, 0644)
+	os.WriteFile(filepath.Join(root, "2024", "五月", "beach.jpg"), bytes.Repeat([]byte{0xff}, 70000), 0644)
+	other := filepath.Join(tempDir, "notes.md")
+	os.WriteFile(other, []byte("# notes"), 0644)
+
+	tests := []struct {
+		name    string
+		sources []archiveSource
+	}{
+		{"directory at root", []archiveSource{{path: root}}},
+		{"named directory", []archiveSource{{path: root, name: "photos"}}},
+		{"several sources", []archiveSource{{path: root, name: "photos"}, {path: other, name: "notes.md"}}},
+		{"single file", []archiveSource{{path: other, name: "notes.md"}}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := writeZipArchive(&buf, test.sources, zip.Store, nil); err != nil {
+			t.Fatalf("%s: writeZipArchive error: %v", test.name, err)
+		}
+		size, err := storedArchiveSize(test.sources)
+		if err != nil || size != int64(buf.Len()) {
+			t.Errorf("%s: storedArchiveSize = %d (%v), expected %d", test.name, size, err, buf.Len())
+		}
+	}
+
+	link := filepath.Join(tempDir, "linked")
+	os.Mkdir(link, 0755)
+	if err := os.Symlink(filepath.Join(root, "a.txt"), filepath.Join(link, "a.txt")); err == nil {
+		if _, err := storedArchiveSize([]archiveSource{{path: link}}); err == nil {
+			t.Error("storedArchiveSize should give up on symlinks")
+		}
+	}
+}
+
+// Test that stored directory downloads advertise their length
+func TestStoredArchiveDownload(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_archive_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	for i := 0; i < 5; i++ {
+		os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("file%d.log", i)), []byte(strings.Repeat("x", i*1000)), 0644)
+	}
+
+	for _, store := range []bool{true, false} {
+		fs := NewFileServer("send", tempDir, 8080, false)
+		fs.zipStore = store
+		rec := httptest.NewRecorder()
+		fs.handleDownload(rec, httptest.NewRequest("GET", "/api/download", nil))
+		length := rec.Header().Get("Content-Length")
+		if store && length != fmt.Sprint(rec.Body.Len()) {
+			t.Errorf("Stored archive Content-Length = %q, expected %d", length, rec.Body.Len())
+		}
+		if !store && length != "" {
+			t.Errorf("Deflated archive should not advertise a length, got %q", length)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil || len(zr.File) != 5 {
+			t.Errorf("zipStore=%t: expected a zip with 5 files (%v)", store, err)
+			continue
+		}
+		expected := zip.Deflate
+		if store {
+			expected = zip.Store
+		}
+		if zr.File[0].Method != expected {
+			t.Errorf("zipStore=%t: entries use method %d, expected %d", store, zr.File[0].Method, expected)
+		}
+	}
+}
+
+// Test Range header parsing for archives
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+		start  int64
+		end    int64
+	}{
+		{"bytes=0-99", true, 0, 99},
+		{"bytes=500-", true, 500, 999},
+		{"bytes=900-2000", true, 900, 999},
+		{"bytes=-100", true, 900, 999},
+		{"bytes=-5000", true, 0, 999},
+		{"bytes=1000-", true, 1000, 999},
+		{"bytes=0-9,20-29", false, 0, 0},
+		{"bytes=50-10", false, 0, 0},
+		{"items=0-9", false, 0, 0},
+		{"bytes=abc-", false, 0, 0},
+	}
+	for _, test := range tests {
+		r, ok := parseRange(test.header, 1000)
+		if ok != test.ok || (ok && (r.start != test.start || r.end != test.end)) {
+			t.Errorf("parseRange(%q) = %v, %t, expected %d-%d, %t", test.header, r, ok, test.start, test.end, test.ok)
+		}
+	}
+}
+
+// Test that archives are reproducible and can be resumed with a Range
+func TestArchiveResume(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_archive_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.MkdirAll(filepath.Join(tempDir, "sub"), 0755)
+	for i := 0; i < 5; i++ {
+		os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("file%d.log", i)), []byte(strings.Repeat("x", i*10000)), 0644)
+	}
+	os.WriteFile(filepath.Join(tempDir, "sub", "inner.txt"), []byte("inner"), 0644)
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	fs.zipStore = true
+	download := func(header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/download", nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		fs.handleDownload(rec, req)
+		return rec
+	}
+	full := download()
+	etag := full.Header().Get("ETag")
+	if full.Code != 200 || etag == "" || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("Stored archive: status %d, ETag %q, Accept-Ranges %q", full.Code, etag, full.Header().Get("Accept-Ranges"))
+	}
+	if again := download(); !bytes.Equal(again.Body.Bytes(), full.Body.Bytes()) || again.Header().Get("ETag") != etag {
+		t.Errorf("Generating the archive twice gave different results")
+	}
+
+	body := full.Body.Bytes()
+	size := int64(len(body))
+	tests := []struct {
+		name    string
+		header  []string
+		code    int
+		content []byte
+	}{
+		{"resume", []string{"Range", "bytes=12345-"}, 206, body[12345:]},
+		{"middle", []string{"Range", "bytes=100-20099"}, 206, body[100:20100]},
+		{"if-range match", []string{"Range", "bytes=500-", "If-Range", etag}, 206, body[500:]},
+		{"if-range stale", []string{"Range", "bytes=500-", "If-Range", `"zip-old"`}, 200, body},
+		{"past the end", []string{"Range", fmt.Sprintf("bytes=%d-", size)}, 416, nil},
+	}
+	for _, test := range tests {
+		rec := download(test.header...)
+		if rec.Code != test.code {
+			t.Errorf("%s: status %d, expected %d", test.name, rec.Code, test.code)
+			continue
+		}
+		if test.content != nil && !bytes.Equal(rec.Body.Bytes(), test.content) {
+			t.Errorf("%s: got %d bytes that differ from the expected %d", test.name, rec.Body.Len(), len(test.content))
+		}
+		if test.code == 206 && rec.Header().Get("Content-Length") != fmt.Sprint(len(test.content)) {
+			t.Errorf("%s: Content-Length %q, expected %d", test.name, rec.Header().Get("Content-Length"), len(test.content))
+		}
+	}
+
+	fs.zipStore = false
+	deflated := download("Range", "bytes=100-")
+	if deflated.Code != 200 || deflated.Header().Get("Accept-Ranges") != "none" {
+		t.Errorf("Deflated archive: status %d, Accept-Ranges %q, expected a full response", deflated.Code, deflated.Header().Get("Accept-Ranges"))
+	}
+	if again := download(); !bytes.Equal(again.Body.Bytes(), deflated.Body.Bytes()) {
+		t.Errorf("Generating the deflated archive twice gave different results")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Method    string    `json:"method"`
+	Endpoint  string    `json:"endpoint"`
+	Status    int       `json:"status"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+}
+
+type auditLog struct {
+	mu  sync.Mutex
+	f   *rotatingFile
+	enc *json.Encoder
+}
+
+func openAuditLog(path string, rotate logRotation, clock Clock) (*auditLog, error) {
+	f, err := openRotatingFile(path, rotate, clock)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (a *auditLog) write(entry *auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enc.Encode(entry)
+}
+
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+type auditKey struct{}
+
+// auditNote attaches a human-readable reason to the audit record of r, e.g.
+// why a request was rejected.
+func auditNote(r *http.Request, reason string) {
+	if entry, ok := r.Context().Value(auditKey{}).(*auditEntry); ok {
+		entry.Reason = reason
+	}
+}
+
+// auditHash records the checksum of a completed transfer in the audit
+// record of r, so the log doubles as a verifiable transfer history.
+func auditHash(r *http.Request, sum string) {
+	if entry, ok := r.Context().Value(auditKey{}).(*auditEntry); ok {
+		entry.SHA256 = sum
+	}
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests:
+		return "busy"
+	case status >= 500:
+		return "error"
+	case status >= 400:
+		return "rejected"
+	default:
+		return "ok"
+	}
+}
+
+func (fs *FileServer) withAudit(next http.Handler) http.Handler {
+	if fs.audit == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &auditEntry{
+			Time:      time.Now(),
+			RequestID: requestID(r),
+			ClientIP:  fs.getClientIP(r),
+			UserAgent: r.UserAgent(),
+			Method:    r.Method,
+			Endpoint:  r.URL.Path,
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+
+		// The UI polls the log endpoint every second; successful polls are noise.
+		if r.URL.Path == "/api/log" && rec.status < 400 {
+			return
+		}
+		entry.Status = rec.status
+		entry.Outcome = auditOutcome(rec.status)
+		entry.Bytes = rec.bytes
+		entry.Duration = float64(time.Since(entry.Time).Microseconds()) / 1000
+		fs.audit.write(entry)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
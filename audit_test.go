@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test audit log records accepted and rejected requests
+func TestAuditLog(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_audit_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	auditPath := filepath.Join(tempDir, "audit.log")
+	audit, err := openAuditLog(auditPath, logRotation{}, systemClock{})
+	if err != nil {
+		t.Fatalf("openAuditLog error: %v", err)
+	}
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	fs.audit = audit
+	fs.acquireClient("10.0.0.9")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/info", fs.handleInfo)
+	mux.HandleFunc("/api/download", fs.handleDownload)
+	mux.HandleFunc("/api/log", fs.handleLog)
+	handler := withRequestID(fs.withAudit(mux))
+
+	for _, path := range []string{"/api/info", "/api/download", "/api/log"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "192.168.1.20:5555"
+		req.Header.Set("User-Agent", "curl/8.0")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	audit.Close()
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// /api/log polls are not recorded when successful.
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit entries, got %d", len(entries))
+	}
+	if entries[0].Endpoint != "/api/info" || entries[0].Outcome != "ok" || entries[0].UserAgent != "curl/8.0" {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	busy := entries[1]
+	if busy.Status != http.StatusServiceUnavailable || busy.Outcome != "busy" || busy.Reason == "" {
+		t.Errorf("Rejected download should be audited as busy with a reason: %+v", busy)
+	}
+	if busy.ClientIP != "192.168.1.20" || busy.RequestID == "" {
+		t.Errorf("Audit entry should carry client IP and request ID: %+v", busy)
+	}
+}
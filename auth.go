@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionCookie keeps a browser signed in after it has given -password or
+// a -token once; the page, SSE, uploads and downloads all send it along.
+const (
+	sessionCookie = "fileshare-session"
+	sessionTTL    = 30 * 24 * time.Hour
+)
+
+type session struct {
+	grant   accessGrant
+	expires time.Time
+}
+
+// sessionStore holds the sessions issued since startup.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+func (s *sessionStore) create(grant accessGrant, now time.Time) string {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]session)
+	}
+	for sid, sess := range s.sessions {
+		if !now.Before(sess.expires) {
+			delete(s.sessions, sid)
+		}
+	}
+	sid := hex.EncodeToString(id)
+	s.sessions[sid] = session{grant: grant, expires: now.Add(sessionTTL)}
+	return sid
+}
+
+func (s *sessionStore) lookup(sid string, now time.Time) (accessGrant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[sid]
+	return sess.grant, ok && now.Before(sess.expires)
+}
+
+func (s *sessionStore) remove(sid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sid)
+}
+
+// authEnabled reports whether requests must be signed in.
+func (fs *FileServer) authEnabled() bool {
+	return fs.password != "" || len(fs.tokens) > 0
+}
+
+// startSession signs the browser in with a new session cookie.
+func (fs *FileServer) startSession(w http.ResponseWriter, r *http.Request, grant accessGrant) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    fs.sessions.create(grant, fs.clock.Now()),
+		Path:     fs.basePath + "/",
+		MaxAge:   int(sessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// withAuth requires -password or a -token for everything but the login
+// page: a session cookie, or the secret as a bearer token or ?token= for
+// scripts. Opening the page with ?token= also starts a session. Requests
+// beyond the scope they were let in with are refused.
+func (fs *FileServer) withAuth(next http.Handler) http.Handler {
+	if !fs.authEnabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The logo, icons and theme assets may be needed by the login page.
+		if r.URL.Path == "/api/logo" || r.URL.Path == "/favicon.ico" ||
+			strings.HasPrefix(r.URL.Path, staticPrefix) || strings.HasPrefix(r.URL.Path, uiAssetPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.URL.Path {
+		case "/login":
+			fs.handleLogin(w, r)
+			return
+		case "/logout":
+			fs.handleLogout(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if grant, ok := fs.sessions.lookup(cookie.Value, fs.clock.Now()); ok {
+				// The page sends the admin token along for file management.
+				if token, ok := fs.credential(requestToken(r)); ok && token.scope > grant.scope {
+					grant = token
+				}
+				fs.serveGranted(w, r, grant, next)
+				return
+			}
+		}
+		if token := requestToken(r); token != "" {
+			if fs.authLocked(w, r) {
+				return
+			}
+			grant, ok := fs.credential(token)
+			if !ok {
+				fs.authFailed(r, "wrong password")
+				if r.URL.Path == "/" {
+					fs.writeLogin(w, http.StatusUnauthorized, "Wrong password")
+				} else {
+					httpError(w, r, "Wrong password", http.StatusUnauthorized)
+				}
+				return
+			}
+			fs.authLimit.succeed(fs.getClientIP(r))
+			if r.URL.Path == "/" {
+				fs.startSession(w, r, grant)
+			}
+			fs.serveGranted(w, r, grant, next)
+			return
+		}
+		if r.URL.Path == "/" {
+			fs.writeLogin(w, http.StatusOK, "")
+			return
+		}
+		auditNote(r, "not signed in")
+		httpError(w, r, "Login required", http.StatusUnauthorized)
+	})
+}
+
+// serveGranted passes r on if grant's scope allows it.
+func (fs *FileServer) serveGranted(w http.ResponseWriter, r *http.Request, grant accessGrant, next http.Handler) {
+	if need := requiredScope(r); grant.scope < need {
+		auditNote(r, fmt.Sprintf("%s has %s access, %s needed", grant.name, grant.scope, need))
+		httpError(w, r, fmt.Sprintf("This token only allows %s access", grant.scope), http.StatusForbidden)
+		return
+	}
+	next.ServeHTTP(w, withGrant(r, grant))
+}
+
+// handleLogin checks the password or token posted by the login form.
+func (fs *FileServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		fs.writeLogin(w, http.StatusOK, "")
+		return
+	}
+	if fs.authLocked(w, r) {
+		return
+	}
+	grant, ok := fs.credential(r.FormValue("password"))
+	if !ok {
+		fs.authFailed(r, "wrong password")
+		fs.logRequest(r, "Failed login from "+fs.clientLabel(fs.getClientIP(r)))
+		fs.writeLogin(w, http.StatusUnauthorized, "Wrong password")
+		return
+	}
+	fs.authLimit.succeed(fs.getClientIP(r))
+	fs.startSession(w, r, grant)
+	fs.logRequest(r, fmt.Sprintf("Signed in: %s (%s, %s access)", fs.clientLabel(fs.getClientIP(r)), grant.name, grant.scope))
+	http.Redirect(w, r, fs.basePath+"/", http.StatusSeeOther)
+}
+
+func (fs *FileServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		fs.sessions.remove(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: fs.basePath + "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, fs.basePath+"/", http.StatusSeeOther)
+}
+
+func (fs *FileServer) writeLogin(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	page := struct {
+		Action, Error, Title string
+		Accent               template.CSS
+		Logo                 bool
+	}{fs.basePath + "/login", message, defaultTitle, "#007bff", false}
+	if fs.brand != nil {
+		page.Title, page.Logo = fs.brand.title, fs.brand.logo != nil
+		if fs.brand.accent != "" {
+			page.Accent = template.CSS(fs.brand.accent)
+		}
+	}
+	fs.uiTemplate("login.html").Execute(w, page)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the password is asked for once and the session cookie covers
+// every request after that
+func TestPasswordSessions(t *testing.T) {
+	clock := &tickClock{now: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)}
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.clock = fastClock{}
+	fs.password = "open sesame"
+	fs.adminToken = "s3cret"
+	handler := fs.withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := login("guess"); rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 || !strings.Contains(rec.Body.String(), "Wrong password") {
+		t.Errorf("Wrong password = %d with cookies %v, expected 401 and none", rec.Code, rec.Result().Cookies())
+	}
+	rec := login("open sesame")
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Login = %d with cookies %v, expected a redirect and a session cookie", rec.Code, cookies)
+	}
+	session := cookies[0]
+
+	tests := []struct {
+		target   string
+		cookie   bool
+		header   string
+		expected int
+		body     string
+	}{
+		{"/", false, "", http.StatusOK, "Sign in"},
+		{"/api/info", false, "", http.StatusUnauthorized, "Login required"},
+		{"/api/events", true, "", http.StatusOK, ""},
+		{"/api/download", true, "", http.StatusOK, ""},
+		{"/api/download", false, "Bearer open sesame", http.StatusOK, ""},
+		{"/api/download", false, "Bearer wrong", http.StatusUnauthorized, ""},
+		{"/api/info", false, "Bearer s3cret", http.StatusOK, ""},
+		{"/api/download?token=open+sesame", false, "", http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.target, nil)
+		if test.cookie {
+			req.AddCookie(session)
+		}
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.expected || !strings.Contains(rec.Body.String(), test.body) {
+			t.Errorf("GET %s (cookie %v) = %d %q, expected %d", test.target, test.cookie, rec.Code, rec.Body.String(), test.expected)
+		}
+	}
+
+	// Opening the page with the password in the URL starts a session.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?token=open+sesame", nil))
+	if len(rec.Result().Cookies()) != 1 {
+		t.Errorf("Page with ?token= should set a session cookie")
+	}
+
+	// Sessions end on logout and after sessionTTL.
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(session)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if _, ok := fs.sessions.lookup(session.Value, fastClock{}.Now()); ok {
+		t.Errorf("Session still valid after logout")
+	}
+	sid := fs.sessions.create(accessGrant{name: "password", scope: scopeWrite}, clock.now)
+	_, before := fs.sessions.lookup(sid, clock.now.Add(sessionTTL-time.Minute))
+	_, after := fs.sessions.lookup(sid, clock.now.Add(sessionTTL))
+	if !before || after {
+		t.Errorf("Session should last %v", sessionTTL)
+	}
+}
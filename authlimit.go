@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// After authFreeAttempts wrong passwords or tokens, a client IP is locked
+// out for a second, doubling with each further failure up to
+// authMaxLockout. Failures are forgotten after authForgetAfter without one.
+const (
+	authFreeAttempts = 3
+	authMaxLockout   = 15 * time.Minute
+	authForgetAfter  = time.Hour
+)
+
+type authFailures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// authLimiter tracks failed authentication attempts per client IP.
+type authLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*authFailures
+}
+
+// lockedFor returns how long ip must still wait before trying again.
+func (l *authLimiter) lockedFor(ip string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f := l.clients[ip]; f != nil && now.Before(f.until) {
+		return f.until.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt and returns the number of failures so far
+// and the lockout it starts, if any.
+func (l *authLimiter) fail(ip string, now time.Time) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients == nil {
+		l.clients = make(map[string]*authFailures)
+	}
+	for client, f := range l.clients {
+		if now.Sub(f.last) >= authForgetAfter && !now.Before(f.until) {
+			delete(l.clients, client)
+		}
+	}
+	f := l.clients[ip]
+	if f == nil {
+		f = &authFailures{}
+		l.clients[ip] = f
+	}
+	f.count++
+	f.last = now
+	if f.count <= authFreeAttempts {
+		return f.count, 0
+	}
+	lockout := authMaxLockout
+	if shift := f.count - authFreeAttempts - 1; shift < 20 {
+		lockout = min(time.Second<<shift, authMaxLockout)
+	}
+	f.until = now.Add(lockout)
+	return f.count, lockout
+}
+
+func (l *authLimiter) succeed(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.clients, ip)
+}
+
+// authLocked answers a client that is still locked out with 429 and
+// reports true.
+func (fs *FileServer) authLocked(w http.ResponseWriter, r *http.Request) bool {
+	wait := fs.authLimit.lockedFor(fs.getClientIP(r), fs.clock.Now())
+	if wait <= 0 {
+		return false
+	}
+	seconds := int((wait + time.Second - 1) / time.Second)
+	auditNote(r, "locked out after failed attempts")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	message := fmt.Sprintf("Too many failed attempts, try again in %v", time.Duration(seconds)*time.Second)
+	if r.URL.Path == "/" || r.URL.Path == "/login" {
+		fs.writeLogin(w, http.StatusTooManyRequests, message)
+		return true
+	}
+	httpError(w, r, message, http.StatusTooManyRequests)
+	return true
+}
+
+// authFailed records a wrong password or token from r, noting reason in the
+// audit log and the transfer log once a lockout starts.
+func (fs *FileServer) authFailed(r *http.Request, reason string) {
+	clientIP := fs.getClientIP(r)
+	count, lockout := fs.authLimit.fail(clientIP, fs.clock.Now())
+	auditNote(r, fmt.Sprintf("%s (failed attempt %d)", reason, count))
+	if lockout > 0 {
+		fs.addLog(fmt.Sprintf("%s locked out for %v after %d failed attempts", fs.clientLabel(clientIP), lockout, count))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test lockouts grow exponentially and are per client
+func TestAuthLimiter(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	var l authLimiter
+
+	tests := []struct {
+		count   int
+		lockout time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+	}
+	for _, test := range tests {
+		count, lockout := l.fail("10.0.0.5", now)
+		if count != test.count || lockout != test.lockout {
+			t.Errorf("Failure %d: got count %d lockout %v, expected %v", test.count, count, lockout, test.lockout)
+		}
+	}
+	if wait := l.lockedFor("10.0.0.5", now.Add(time.Second)); wait != 3*time.Second {
+		t.Errorf("lockedFor = %v, expected 3s", wait)
+	}
+	if wait := l.lockedFor("10.0.0.6", now); wait != 0 {
+		t.Errorf("Other client locked for %v", wait)
+	}
+	for i := 0; i < 30; i++ {
+		l.fail("10.0.0.7", now)
+	}
+	if wait := l.lockedFor("10.0.0.7", now); wait != authMaxLockout {
+		t.Errorf("Lockout %v, expected the %v cap", wait, authMaxLockout)
+	}
+
+	l.succeed("10.0.0.5")
+	if count, _ := l.fail("10.0.0.5", now); count != 1 {
+		t.Errorf("Count after success = %d, expected a fresh start", count)
+	}
+	later := now.Add(authForgetAfter)
+	if count, _ := l.fail("10.0.0.5", later); count != 1 {
+		t.Errorf("Count after %v = %d, expected old failures forgotten", authForgetAfter, count)
+	}
+}
+
+// Test repeated wrong passwords get 429 with Retry-After, even for the
+// right password, until the lockout ends
+func TestAuthLockout(t *testing.T) {
+	clock := &tickClock{now: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)}
+	fs := NewFileServer("recv", "/tmp", 8080, false)
+	fs.clock = clock
+	fs.password = "open sesame"
+	handler := fs.withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/info", nil)
+		req.Header.Set("Authorization", "Bearer "+password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < authFreeAttempts+1; i++ {
+		if rec := get("guess"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d = %d, expected 401", i+1, rec.Code)
+		}
+	}
+	rec := get("open sesame")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Locked out = %d with Retry-After %q, expected 429 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if log := strings.Join(fs.transferLog, "\n"); !strings.Contains(log, "locked out for 1s after 4 failed attempts") {
+		t.Errorf("Log should record the lockout:\n%s", log)
+	}
+	clock.now = clock.now.Add(time.Second)
+	if rec := get("open sesame"); rec.Code != http.StatusOK {
+		t.Errorf("After the lockout = %d, expected 200", rec.Code)
+	}
+
+	fs.adminToken = "s3cret"
+	for i := 0; i < authFreeAttempts+1; i++ {
+		req := httptest.NewRequest("DELETE", "/api/file?name=a", nil)
+		req.Header.Set("Authorization", "Bearer nope")
+		fs.requireAdmin(httptest.NewRecorder(), req)
+	}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("DELETE", "/api/file?name=a", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if fs.requireAdmin(rec, req) || rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Admin token during lockout = %d, expected 429", rec.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// exitOutcomes are the final transfer states -auto-exit can stop on.
+var exitOutcomes = []string{"completed", "cancelled", "error"}
+
+// autoExitFlag lets -auto-exit be used as a plain switch, which exits on
+// any outcome, or as -auto-exit=on=completed,error to pick the outcomes.
+type autoExitFlag struct {
+	enabled *bool
+	on      *string
+}
+
+func (f autoExitFlag) String() string {
+	if f.enabled == nil || !*f.enabled {
+		return "false"
+	}
+	if *f.on != "" {
+		return "on=" + *f.on
+	}
+	return "true"
+}
+
+func (f autoExitFlag) IsBoolFlag() bool { return true }
+
+func (f autoExitFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*f.enabled, *f.on = true, ""
+	case "false":
+		*f.enabled, *f.on = false, ""
+	default:
+		on, ok := strings.CutPrefix(value, "on=")
+		if !ok {
+			return fmt.Errorf("expected on=<outcomes>, e.g. on=completed,error")
+		}
+		*f.enabled, *f.on = true, on
+	}
+	return nil
+}
+
+// parseExitOutcomes reads a comma-separated list of exitOutcomes. An empty
+// list means every outcome.
+func parseExitOutcomes(list string) (map[string]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	outcomes := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, outcome := range exitOutcomes {
+			found = found || name == outcome
+		}
+		if !found {
+			return nil, fmt.Errorf("-auto-exit: unknown outcome '%s' (use %s)", name, strings.Join(exitOutcomes, ", "))
+		}
+		outcomes[name] = true
+	}
+	return outcomes, nil
+}
+
+// exitsOn reports whether -auto-exit stops the server once a transfer ends
+// with status.
+func (fs *FileServer) exitsOn(status string) bool {
+	if fs.exitOn != nil {
+		return fs.exitOn[status]
+	}
+	for _, outcome := range exitOutcomes {
+		if status == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// exitPolicy describes the outcomes -auto-exit stops on, for the banner.
+func (fs *FileServer) exitPolicy() string {
+	if fs.exitOn == nil {
+		return ""
+	}
+	var names []string
+	for _, outcome := range exitOutcomes {
+		if fs.exitOn[outcome] {
+			names = append(names, outcome)
+		}
+	}
+	return " (on " + strings.Join(names, ", ") + ")"
+}
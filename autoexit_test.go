@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+// Test -auto-exit as a switch and as a policy
+func TestAutoExitFlag(t *testing.T) {
+	tests := []struct {
+		args    []string
+		enabled bool
+		on      string
+		err     bool
+	}{
+		{nil, false, "", false},
+		{[]string{"-auto-exit"}, true, "", false},
+		{[]string{"-auto-exit=false"}, false, "", false},
+		{[]string{"-auto-exit=on=completed"}, true, "completed", false},
+		{[]string{"-auto-exit=on=completed,error"}, true, "completed,error", false},
+		{[]string{"-auto-exit=completed"}, false, "", true},
+	}
+	for _, test := range tests {
+		var opts Options
+		set := flag.NewFlagSet("fileshare", flag.ContinueOnError)
+		set.SetOutput(io.Discard)
+		set.Var(autoExitFlag{&opts.AutoExit, &opts.AutoExitOn}, "auto-exit", "")
+		err := set.Parse(test.args)
+		if (err != nil) != test.err || opts.AutoExit != test.enabled || opts.AutoExitOn != test.on {
+			t.Errorf("Parse(%q) = %t, %q, %v, expected %t, %q (error %t)", test.args, opts.AutoExit, opts.AutoExitOn, err, test.enabled, test.on, test.err)
+		}
+	}
+}
+
+// Test which outcomes stop the server
+func TestExitsOn(t *testing.T) {
+	tests := []struct {
+		policy   string
+		status   string
+		expected bool
+	}{
+		{"", "completed", true},
+		{"", "cancelled", true},
+		{"", "error", true},
+		{"", "transferring", false},
+		{"completed", "completed", true},
+		{"completed", "cancelled", false},
+		{"completed", "error", false},
+		{"completed, error", "error", true},
+		{"completed,error", "cancelled", false},
+		{"completed,error", "waiting", false},
+	}
+	for _, test := range tests {
+		exitOn, err := parseExitOutcomes(test.policy)
+		if err != nil {
+			t.Errorf("parseExitOutcomes(%q) error: %v", test.policy, err)
+			continue
+		}
+		fs := &FileServer{exitOn: exitOn}
+		if result := fs.exitsOn(test.status); result != test.expected {
+			t.Errorf("exitsOn(%q) with policy %q = %t, expected %t", test.status, test.policy, result, test.expected)
+		}
+	}
+	if _, err := parseExitOutcomes("completed,success"); err == nil {
+		t.Errorf("parseExitOutcomes should reject unknown outcomes")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errBenchDone stops a benchmark once it has read its share of the data.
+var errBenchDone = errors.New("benchmark limit reached")
+
+// benchResult is one stage of 'bench': how many bytes of input it got
+// through, and how many it produced where that differs.
+type benchResult struct {
+	name    string
+	bytes   int64
+	out     int64
+	elapsed time.Duration
+}
+
+// rate is the stage's throughput in bytes per second.
+func (b benchResult) rate() float64 {
+	if b.elapsed <= 0 {
+		return 0
+	}
+	return float64(b.bytes) / b.elapsed.Seconds()
+}
+
+func (b benchResult) String() string {
+	s := fmt.Sprintf("%-14s %s/s (%s in %.2fs)", b.name+":", formatSize(int64(b.rate())), formatSize(b.bytes), b.elapsed.Seconds())
+	if b.out > 0 {
+		s += fmt.Sprintf(", %.2fx smaller", float64(b.bytes)/float64(b.out))
+	}
+	return s
+}
+
+// limitedWriter passes writes on, failing the first one after stop says
+// the benchmark has seen enough input.
+type limitedWriter struct {
+	w    io.Writer
+	stop func() bool
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	n, err := l.w.Write(p)
+	if err == nil && l.stop() {
+		err = errBenchDone
+	}
+	return n, err
+}
+
+// benchRead reads up to limit bytes of the files of sources.
+func benchRead(sources []archiveSource, limit int64) (benchResult, error) {
+	start := time.Now()
+	n, err := copyFiles(io.Discard, sources, limit)
+	return benchResult{name: "Disk read", bytes: n, elapsed: time.Since(start)}, err
+}
+
+// benchGzip compresses up to limit bytes of the files of sources the way
+// -compress gzip does.
+func benchGzip(sources []archiveSource, limit int64) (benchResult, error) {
+	out := &countingWriter{w: io.Discard}
+	gz, _ := gzip.NewWriterLevel(out, gzip.BestSpeed)
+	start := time.Now()
+	n, err := copyFiles(gz, sources, limit)
+	if err == nil {
+		err = gz.Close()
+	}
+	return benchResult{name: "gzip", bytes: n, out: out.n, elapsed: time.Since(start)}, err
+}
+
+// benchZip builds the archive of sources with method, stopping after
+// limit bytes of file data.
+func benchZip(sources []archiveSource, method uint16, limit int64) (benchResult, error) {
+	var raw int64
+	out := &countingWriter{w: io.Discard}
+	w := &limitedWriter{w: out, stop: func() bool { return raw >= limit }}
+	start := time.Now()
+	err := writeZipArchive(w, sources, method, func(n int64) { raw += n })
+	if errors.Is(err, errBenchDone) {
+		err = nil
+	}
+	name := "zip deflate"
+	if method == zip.Store {
+		name = "zip store"
+	}
+	return benchResult{name: name, bytes: raw, out: out.n, elapsed: time.Since(start)}, err
+}
+
+// benchLoopback downloads size bytes from a speedtest endpoint on
+// 127.0.0.1, the ceiling the HTTP stack allows without a network.
+func benchLoopback(size int64) (benchResult, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return benchResult{}, err
+	}
+	server := &http.Server{Handler: http.HandlerFunc((&FileServer{}).handleSpeedtest)}
+	go server.Serve(ln)
+	defer server.Close()
+	result, err := speedtestDownload(fmt.Sprintf("http://%s/api/speedtest", ln.Addr()), size)
+	return benchResult{name: "Loopback HTTP", bytes: result.Bytes, elapsed: time.Duration(result.Seconds * float64(time.Second))}, err
+}
+
+// copyFiles writes the contents of the files of sources to w, up to limit
+// bytes.
+func copyFiles(w io.Writer, sources []archiveSource, limit int64) (int64, error) {
+	var n int64
+	buf := make([]byte, 256*1024)
+	err := walkSources(sources, func(file, _ string, fi os.FileInfo) error {
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		copied, err := io.CopyBuffer(w, io.LimitReader(f, limit-n), buf)
+		n += copied
+		if err == nil && n >= limit {
+			return errBenchDone
+		}
+		return err
+	})
+	if errors.Is(err, errBenchDone) {
+		err = nil
+	}
+	return n, err
+}
+
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	maxRead := flags.String("max", "1GB", "Read at most this much of <path> in each test")
+	loopback := flags.String("loopback", "256MB", "Bytes to send over loopback HTTP (at most 1GB)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bench [-max size] [-loopback size] <path>\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 1
+	}
+	limit, err := parseSize(*maxRead)
+	if err != nil || limit <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -max: invalid size '%s'\n", *maxRead)
+		return 1
+	}
+	loopbackSize, err := parseSize(*loopback)
+	if err != nil || loopbackSize <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -loopback: invalid size '%s'\n", *loopback)
+		return 1
+	}
+	loopbackSize = min(loopbackSize, maxSpeedtestBytes)
+
+	path := targetPath(flags.Arg(0))
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot access '%s': %v\n", path, err)
+		return 1
+	}
+	sources := []archiveSource{{path: path, name: filepath.Base(path)}}
+	fmt.Printf("Benchmarking %s (up to %s per test)\n", path, formatSize(limit))
+
+	// The first read warms the page cache, so the codecs after it measure
+	// the CPU rather than the disk.
+	stages := []struct {
+		name string
+		run  func() (benchResult, error)
+	}{
+		{"Disk read", func() (benchResult, error) { return benchRead(sources, limit) }},
+		{"gzip", func() (benchResult, error) { return benchGzip(sources, limit) }},
+		{"zip deflate", func() (benchResult, error) { return benchZip(sources, zip.Deflate, limit) }},
+		{"zip store", func() (benchResult, error) { return benchZip(sources, zip.Store, limit) }},
+		{"Loopback HTTP", func() (benchResult, error) { return benchLoopback(loopbackSize) }},
+	}
+	results := map[string]benchResult{}
+	for _, stage := range stages {
+		result, err := stage.run()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", stage.name, err)
+			return 1
+		}
+		results[stage.name] = result
+		fmt.Printf("  %s\n", result)
+	}
+
+	ceiling := min(results["Disk read"].rate(), results["zip store"].rate(), results["Loopback HTTP"].rate())
+	fmt.Printf("\n%sTransfers slower than %s/s are limited by the network\n", icon("🔎 "), formatSize(int64(ceiling)))
+	if deflate := results["zip deflate"].rate(); deflate < ceiling {
+		fmt.Printf("%sFolders are zipped at only %s/s; -zip-store skips the compression\n", icon("💡 "), formatSize(int64(deflate)))
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that each bench stage measures its share of the data
+func TestBenchStages(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "c.log"} {
+		os.WriteFile(filepath.Join(tempDir, name), bytes.Repeat([]byte("GET /index.html 200\n"), 50000), 0644)
+	}
+	sources := []archiveSource{{path: tempDir, name: "logs"}}
+	const limit = 1500000
+
+	tests := []struct {
+		name       string
+		run        func() (benchResult, error)
+		bytes      int64
+		compressed bool
+	}{
+		{"Disk read", func() (benchResult, error) { return benchRead(sources, limit) }, limit, false},
+		{"gzip", func() (benchResult, error) { return benchGzip(sources, limit) }, limit, true},
+		{"zip deflate", func() (benchResult, error) { return benchZip(sources, zip.Deflate, limit) }, limit, true},
+		{"zip store", func() (benchResult, error) { return benchZip(sources, zip.Store, limit) }, limit, false},
+		{"Loopback HTTP", func() (benchResult, error) { return benchLoopback(1 << 20) }, 1 << 20, false},
+	}
+	for _, test := range tests {
+		result, err := test.run()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		// Archives stop at the first write past the limit, which comes
+		// later when the compressor buffers.
+		if result.name != test.name || result.bytes < test.bytes || result.bytes > 2*test.bytes {
+			t.Errorf("%s: measured %s over %d bytes, expected %d", test.name, result.name, result.bytes, test.bytes)
+		}
+		if compressed := result.out > 0 && result.out < result.bytes/10; compressed != test.compressed {
+			t.Errorf("%s: %d bytes in, %d out", test.name, result.bytes, result.out)
+		}
+		if result.rate() <= 0 {
+			t.Errorf("%s: no rate", test.name)
+		}
+	}
+}
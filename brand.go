@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxLogoSize keeps -logo to something sensible to hold in memory.
+const maxLogoSize = 512 * 1024
+
+// The embedded page's own title, heading and accent colors, replaced by
+// -title and -accent.
+const (
+	defaultTitle     = "FileShare"
+	defaultAccent    = "#667eea"
+	defaultAccentEnd = "#764ba2"
+)
+
+// branding customizes the share page.
+type branding struct {
+	title    string
+	accent   string // #rrggbb, or "" for the default colors
+	logo     []byte
+	logoType string
+}
+
+// parseAccent accepts #rgb or #rrggbb (the # is optional) and returns the
+// #rrggbb form.
+func parseAccent(value string) (string, error) {
+	hex := strings.ToLower(strings.TrimPrefix(value, "#"))
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return "", errors.New("-accent must be a color like #0a7 or #00aa77")
+	}
+	if _, err := strconv.ParseUint(hex, 16, 32); err != nil {
+		return "", errors.New("-accent must be a color like #0a7 or #00aa77")
+	}
+	return "#" + hex, nil
+}
+
+// shade scales a #rrggbb color's channels by factor (below 1 darkens).
+func shade(color string, factor float64) string {
+	v, _ := strconv.ParseUint(color[1:], 16, 32)
+	channel := func(shift uint) uint64 {
+		return uint64(min(255, float64((v>>shift)&0xff)*factor))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", channel(16), channel(8), channel(0))
+}
+
+// rgbList returns "r, g, b" for use in rgba().
+func rgbList(color string) string {
+	v, _ := strconv.ParseUint(color[1:], 16, 32)
+	return fmt.Sprintf("%d, %d, %d", v>>16&0xff, v>>8&0xff, v&0xff)
+}
+
+// loadLogo reads an image for the page heading.
+func loadLogo(path string) ([]byte, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.Size() > maxLogoSize {
+		return nil, "", fmt.Errorf("%s is larger than %s", filepath.Base(path), formatSize(maxLogoSize))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("%s is not an image", filepath.Base(path))
+	}
+	return data, contentType, nil
+}
+
+// apply brands a page, stylesheet or icon that uses the built-in title and
+// colors.
+func (b *branding) apply(page string) string {
+	title := html.EscapeString(b.title)
+	heading := "📤 " + title
+	if b.logo != nil {
+		heading = `<img src="api/logo" alt="" style="height: 1.2em; vertical-align: middle; margin-right: 8px;">` + title
+	}
+	replacements := []string{
+		"<title>" + defaultTitle + "</title>", "<title>" + title + "</title>",
+		"<h1>📤 " + defaultTitle + "</h1>", "<h1>" + heading + "</h1>",
+	}
+	if b.accent != "" {
+		replacements = append(replacements,
+			defaultAccent, b.accent,
+			defaultAccentEnd, shade(b.accent, 0.75),
+			"rgba(102, 126, 234,", "rgba("+rgbList(b.accent)+",",
+		)
+	}
+	return strings.NewReplacer(replacements...).Replace(page)
+}
+
+func (fs *FileServer) handleLogo(w http.ResponseWriter, r *http.Request) {
+	if fs.brand == nil || fs.brand.logo == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", fs.brand.logoType)
+	// An SVG opened directly must not run scripts on this origin.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(fs.brand.logo)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test accent colors are validated and normalized
+func TestParseAccent(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		ok       bool
+	}{
+		{"#0a7", "#00aa77", true},
+		{"00AA77", "#00aa77", true},
+		{"#123456", "#123456", true},
+		{"teal", "", false},
+		{"#12345", "", false},
+		{"#00aa77;}body{x", "", false},
+	}
+	for _, test := range tests {
+		got, err := parseAccent(test.value)
+		if (err == nil) != test.ok || got != test.expected {
+			t.Errorf("parseAccent(%q) = %q, %v, expected %q", test.value, got, err, test.expected)
+		}
+	}
+	if got := shade("#80ff40", 0.5); got != "#407f20" {
+		t.Errorf("shade = %s, expected #407f20", got)
+	}
+}
+
+// Test the branded page carries the title, logo and accent
+func TestBrandedPage(t *testing.T) {
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.brand = &branding{title: "Acme <Files>", accent: "#00aa77", logo: []byte("<svg/>"), logoType: "image/svg+xml"}
+
+	rec := httptest.NewRecorder()
+	fs.handleIndex(rec, httptest.NewRequest("GET", "/", nil))
+	page := rec.Body.String()
+	for _, want := range []string{"<title>Acme &lt;Files&gt;</title>", `<img src="api/logo"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Page should contain %q", want)
+		}
+	}
+	if strings.Contains(page, "<title>FileShare</title>") {
+		t.Errorf("Page should not have the default title")
+	}
+	for _, name := range []string{"style.css", "favicon.svg"} {
+		rec := httptest.NewRecorder()
+		fs.handleStatic(rec, httptest.NewRequest("GET", "/static/"+name, nil))
+		body := rec.Body.String()
+		if !strings.Contains(body, "#00aa77") || !strings.Contains(body, shade("#00aa77", 0.75)) ||
+			strings.Contains(body, defaultAccent) || strings.Contains(body, defaultAccentEnd) {
+			t.Errorf("%s should use the accent colors only", name)
+		}
+	}
+	if css, _ := fs.webFile("style.css"); !strings.Contains(css, "rgba(0, 170, 119, 0.4)") {
+		t.Errorf("Stylesheet shadows should use the accent")
+	}
+
+	rec = httptest.NewRecorder()
+	fs.handleLogo(rec, httptest.NewRequest("GET", "/api/logo", nil))
+	if rec.Body.String() != "<svg/>" || rec.Header().Get("Content-Type") != "image/svg+xml" || rec.Header().Get("Content-Security-Policy") == "" {
+		t.Errorf("Logo = %q %v, expected the SVG with a content security policy", rec.Body.String(), rec.Header())
+	}
+
+	// A title alone keeps the default colors.
+	fs.brand = &branding{title: "Acme"}
+	page, _ = fs.webFile("index.html")
+	css, _ := fs.webFile("style.css")
+	if !strings.Contains(css, defaultAccentEnd) || !strings.Contains(page, "<h1>📤 Acme</h1>") {
+		t.Errorf("Title-only branding should keep the default colors and icon")
+	}
+}
+
+// Test -logo accepts images only
+func TestLoadLogo(t *testing.T) {
+	dir := t.TempDir()
+	png := filepath.Join(dir, "logo.png")
+	os.WriteFile(png, []byte("\x89PNG\r\n\x1a\n"), 0644)
+	text := filepath.Join(dir, "notes.txt")
+	os.WriteFile(text, []byte("hello"), 0644)
+	big := filepath.Join(dir, "big.png")
+	os.WriteFile(big, make([]byte, maxLogoSize+1), 0644)
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{png, "image/png"},
+		{text, ""},
+		{big, ""},
+		{filepath.Join(dir, "missing.png"), ""},
+	}
+	for _, test := range tests {
+		_, contentType, err := loadLogo(test.path)
+		if contentType != test.contentType || (err == nil) != (test.contentType != "") {
+			t.Errorf("loadLogo(%s) = %q, %v, expected %q", filepath.Base(test.path), contentType, err, test.contentType)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewFileServer("send", "/tmp", 8080, false).handleLogo(rec, httptest.NewRequest("GET", "/api/logo", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Logo without -logo = %d, expected 404", rec.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// statusBandwidthExceeded is the unofficial 509 hosting panels answer
+// with once a site's traffic allowance is used up.
+const statusBandwidthExceeded = 509
+
+// budgetGated lists the endpoints, besides the downloads of termsGated,
+// that start a transfer and are refused once -bandwidth-budget is spent.
+var budgetGated = map[string]bool{
+	"/api/upload":            true,
+	"/api/upload/init":       true,
+	"/api/upload/part":       true,
+	"/api/fetch":             true,
+	"/api/object":            true,
+	"/api/speedtest":         true,
+	"/api/relay":             true,
+	grpcService + "Download": true,
+	grpcService + "Upload":   true,
+}
+
+// meteredListener counts the traffic of its connections against
+// -bandwidth-budget. With refuse, where every connection is a transfer,
+// it also turns new ones away once the budget is spent.
+type meteredListener struct {
+	net.Listener
+	fs     *FileServer
+	refuse bool
+}
+
+// meterListener wraps l when there is a bandwidth budget.
+func (fs *FileServer) meterListener(l net.Listener, refuse bool) net.Listener {
+	if fs.bandwidthBudget <= 0 {
+		return l
+	}
+	return &meteredListener{Listener: l, fs: fs, refuse: refuse}
+}
+
+func (l *meteredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.refuse && l.fs.budgetSpent() {
+			conn.Close()
+			continue
+		}
+		return &meteredConn{Conn: conn, fs: l.fs}, nil
+	}
+}
+
+type meteredConn struct {
+	net.Conn
+	fs *FileServer
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.fs.spend(n)
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.fs.spend(n)
+	return n, err
+}
+
+// spend counts n bytes of traffic, announcing when they use up the
+// budget.
+func (fs *FileServer) spend(n int) {
+	if n <= 0 {
+		return
+	}
+	used := fs.budgetUsed.Add(int64(n))
+	if used >= fs.bandwidthBudget && used-int64(n) < fs.bandwidthBudget {
+		// Off the connection's goroutine: reporting takes locks a
+		// handler writing on it may hold.
+		go fs.budgetReached()
+	}
+}
+
+// budgetSpent reports whether -bandwidth-budget is used up.
+func (fs *FileServer) budgetSpent() bool {
+	return fs.bandwidthBudget > 0 && fs.budgetUsed.Load() >= fs.bandwidthBudget
+}
+
+func (fs *FileServer) budgetReached() {
+	fs.addLog(fmt.Sprintf("Bandwidth budget of %s used up, new transfers are refused", formatSize(fs.bandwidthBudget)))
+	fs.report(outputEvent{Event: "budget_reached", Size: fs.bandwidthBudget},
+		fmt.Sprintf("\n%sBandwidth budget of %s used up, new transfers are refused\n", icon("⛔ "), formatSize(fs.bandwidthBudget)))
+}
+
+// withBudget refuses new transfers once -bandwidth-budget is spent. The
+// page and status endpoints still answer so visitors can see why, and
+// transfers already running are left to finish.
+func (fs *FileServer) withBudget(next http.Handler) http.Handler {
+	if fs.bandwidthBudget <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if fs.budgetSpent() && (termsGated[path] || budgetGated[path] || strings.HasPrefix(path, "/dlna/media/")) {
+			auditNote(r, "bandwidth budget used up")
+			httpError(w, r, "Bandwidth budget used up", statusBandwidthExceeded)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test transfers are refused once the bandwidth budget is spent
+func TestBandwidthBudget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 64*1024)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFileServer("send", path, 8080, false)
+	fs.bandwidthBudget = 32 * 1024
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/download", fs.handleDownload)
+	mux.HandleFunc("/api/info", fs.handleInfo)
+	server := httptest.NewUnstartedServer(fs.withBudget(mux))
+	server.Listener = fs.meterListener(server.Listener, false)
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/api/download", http.StatusOK}, // in budget when it starts, so it finishes
+		{"/api/download", statusBandwidthExceeded},
+		{"/api/info", http.StatusOK},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(server.URL + test.path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", test.path, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("GET %s: status %d, expected %d", test.path, resp.StatusCode, test.code)
+		}
+	}
+	if used := fs.budgetUsed.Load(); used < 64*1024 {
+		t.Errorf("Budget used %d, expected at least the file's 65536 bytes", used)
+	}
+}
+
+// Test a listener that refuses connections once the budget is spent
+func TestMeteredListenerRefuse(t *testing.T) {
+	fs := NewFileServer("send", "/tmp/test.txt", 8080, false)
+	fs.bandwidthBudget = 10
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener := fs.meterListener(inner, true)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	c1, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c1.Close()
+	first := <-accepted
+	first.Write([]byte("0123456789"))
+	first.Close()
+	if !fs.budgetSpent() {
+		t.Fatalf("Budget used %d of 10, expected it spent", fs.budgetUsed.Load())
+	}
+
+	c2, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c2.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Errorf("Connection after the budget is spent should be closed, got %v", err)
+	}
+}
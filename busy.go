@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// busyRetryAfter is how long, in seconds, a client turned away by the
+// single-client lock is told to wait before trying again.
+const busyRetryAfter = 10
+
+// rejectBusy answers a request turned away because another client holds
+// the lock. Browsers navigating to the URL get a page that retries by
+// itself; everything else gets a plain 503, both with Retry-After.
+func (fs *FileServer) rejectBusy(w http.ResponseWriter, r *http.Request) {
+	auditNote(r, "another client is active")
+	w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fs.uiTemplate("busy.html").Execute(w, struct{ RetryAfter int }{busyRetryAfter})
+		return
+	}
+	httpError(w, r, "Another client is already connected", http.StatusServiceUnavailable)
+}
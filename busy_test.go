@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test busy responses carry Retry-After and a retrying page for browsers
+func TestRejectBusy(t *testing.T) {
+	fs := NewFileServer("send", ".", 8080, false)
+	fs.acquireClient("10.0.0.9")
+
+	tests := []struct {
+		accept   string
+		htmlPage bool
+	}{
+		{"text/html,application/xhtml+xml,*/*;q=0.8", true},
+		{"*/*", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/api/download", nil)
+		req.RemoteAddr = "192.168.1.20:5555"
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rec := httptest.NewRecorder()
+		fs.handleDownload(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Accept %q: status = %d, expected 503", test.accept, rec.Code)
+		}
+		if rec.Header().Get("Retry-After") != strconv.Itoa(busyRetryAfter) {
+			t.Errorf("Accept %q: Retry-After = %q, expected %d", test.accept, rec.Header().Get("Retry-After"), busyRetryAfter)
+		}
+		isPage := strings.Contains(rec.Body.String(), "location.reload()")
+		if isPage != test.htmlPage {
+			t.Errorf("Accept %q: retrying page = %t, expected %t", test.accept, isPage, test.htmlPage)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/api/upload", nil)
+	req.RemoteAddr = "192.168.1.20:5555"
+	rec := httptest.NewRecorder()
+	recv := NewFileServer("recv", ".", 8080, false)
+	recv.acquireClient("10.0.0.9")
+	recv.handleUpload(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Busy upload = %d with Retry-After %q, expected 503 with a delay", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
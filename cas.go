@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// casDirName holds the content-addressed store inside the receive
+// directory: objects/<2 hex>/<sha256> plus index.tsv, one line per received
+// file (time, hash, size, name) that can be read with any text tool.
+const casDirName = ".fileshare"
+
+type casRecord struct {
+	Time   time.Time `json:"time"`
+	SHA256 string    `json:"sha256"`
+	Size   int64     `json:"size"`
+	Name   string    `json:"name"`
+}
+
+func validSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (fs *FileServer) casRoot() string {
+	return filepath.Join(fs.inboxPath(), casDirName)
+}
+
+func (fs *FileServer) casObject(sum string) string {
+	return filepath.Join(fs.casRoot(), "objects", sum[:2], sum)
+}
+
+// casLookup returns the index records for sum, oldest first.
+func (fs *FileServer) casLookup(sum string) []casRecord {
+	fs.casMu.Lock()
+	defer fs.casMu.Unlock()
+	f, err := os.Open(filepath.Join(fs.casRoot(), "index.tsv"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var records []casRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 4)
+		if len(fields) != 4 || fields[1] != sum {
+			continue
+		}
+		t, _ := time.Parse(time.RFC3339, fields[0])
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		records = append(records, casRecord{Time: t, SHA256: sum, Size: size, Name: fields[3]})
+	}
+	return records
+}
+
+func (fs *FileServer) casAppend(rec casRecord) error {
+	fs.casMu.Lock()
+	defer fs.casMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(fs.casRoot(), "index.tsv"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\t%s\t%d\t%s\n", rec.Time.UTC().Format(time.RFC3339), rec.SHA256, rec.Size, rec.Name)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// casHas reports whether an object with this hash and size is stored.
+func (fs *FileServer) casHas(sum string, size int64) bool {
+	info, err := os.Stat(fs.casObject(sum))
+	return err == nil && info.Size() == size
+}
+
+// casLink replaces path with a hard link to the stored object.
+func (fs *FileServer) casLink(sum, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".link")
+	os.Remove(tmp)
+	if err := os.Link(fs.casObject(sum), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// storeObject files a received upload in the store. A repeat of stored
+// content becomes another link to the same object instead of a copy.
+func (fs *FileServer) storeObject(path, sum string) {
+	info, err := os.Stat(path)
+	if err != nil || !validSHA256(sum) {
+		return
+	}
+	name := filepath.Base(path)
+	if rel, err := filepath.Rel(fs.inboxPath(), path); err == nil {
+		name = filepath.ToSlash(rel)
+	}
+	obj := fs.casObject(sum)
+	prior := fs.casLookup(sum)
+
+	if objInfo, err := os.Stat(obj); err == nil && objInfo.Size() == info.Size() {
+		if !os.SameFile(objInfo, info) {
+			if err := fs.casLink(sum, path); err != nil {
+				fs.addLog(fmt.Sprintf("Could not deduplicate %s: %v", name, err))
+			}
+		}
+	} else {
+		err := os.MkdirAll(filepath.Dir(obj), 0755)
+		if err == nil {
+			err = os.Link(path, obj)
+		}
+		if err != nil {
+			fs.addLog(fmt.Sprintf("Could not add %s to the content store: %v", name, err))
+			return
+		}
+	}
+
+	if err := fs.casAppend(casRecord{Time: fs.clock.Now(), SHA256: sum, Size: info.Size(), Name: name}); err != nil {
+		fs.addLog(fmt.Sprintf("Could not update the content index: %v", err))
+	}
+	if len(prior) > 0 {
+		first := prior[0]
+		fs.addLog(fmt.Sprintf("%s has the same content as %s (received %s), stored once", name, first.Name, first.Time.Local().Format("2006-01-02 15:04")))
+	}
+}
+
+// finishKnownUpload completes a multi-part init whose content is already
+// stored: the reserved name is linked to the object and no data is sent.
+func (fs *FileServer) finishKnownUpload(w http.ResponseWriter, r *http.Request, clientIP, savePath, sum string, size int64) {
+	if err := fs.casLink(sum, savePath); err != nil {
+		os.Remove(savePath)
+		httpError(w, r, "Failed to create file", http.StatusInternalServerError)
+		return
+	}
+	savedName := filepath.Base(savePath)
+	clientLabel := fs.clientLabel(clientIP)
+	fs.startUpload(clientIP, size)
+	fs.completeTransfer(sum)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("Upload from %s already stored: %s (%s)%s", clientLabel, savedName, formatSize(size), hashSuffix(sum)))
+	fs.report(outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP),
+		Name: savedName, Path: savePath, Size: size, SHA256: sum},
+		fmt.Sprintf("\n%sReceived '%s' from %s (%s, already stored)\n%s", icon("✓ "), savedName, clientLabel, formatSize(size), hashLine(sum)))
+	fs.afterReceive(savePath, sum)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"exists","name":"%s","size":%d,"sha256":"%s"}`, savedName, size, sum)
+}
+
+// handleObject answers GET /api/object?sha256= with what is known about
+// that content, so clients can skip sending files the server already has.
+func (fs *FileServer) handleObject(w http.ResponseWriter, r *http.Request) {
+	if !fs.cas {
+		httpError(w, r, "Content store is not enabled (-cas)", http.StatusNotFound)
+		return
+	}
+	sum := strings.ToLower(r.URL.Query().Get("sha256"))
+	if !validSHA256(sum) {
+		httpError(w, r, "Invalid sha256", http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(fs.casObject(sum))
+	if err != nil {
+		httpError(w, r, "Not stored", http.StatusNotFound)
+		return
+	}
+	records := fs.casLookup(sum)
+	if records == nil {
+		records = []casRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"sha256": sum, "size": info.Size(), "received": records})
+}
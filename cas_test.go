@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that repeated uploads are stored once and indexed
+func TestContentStore(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_cas_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	fs.clock = fastClock{}
+	fs.cas = true
+	fs.onConflict = conflictRename
+	hash := sha256.Sum256([]byte("report body"))
+	sum := hex.EncodeToString(hash[:])
+
+	for _, name := range []string{"report.pdf", "copy.pdf", "report.pdf"} {
+		rec := httptest.NewRecorder()
+		fs.handleUpload(rec, newUploadRequest(t, name, "report body", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Upload %s = %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+	object, err := os.Stat(fs.casObject(sum))
+	if err != nil {
+		t.Fatalf("Object not stored: %v", err)
+	}
+	for _, name := range []string{"report.pdf", "copy.pdf", "report_2024-05-01_12-00-00.pdf"} {
+		info, err := os.Stat(filepath.Join(tempDir, name))
+		if err != nil || !os.SameFile(info, object) {
+			t.Errorf("%s should be a link to the stored object (%v)", name, err)
+		}
+	}
+	if records := fs.casLookup(sum); len(records) != 3 || records[1].Name != "copy.pdf" || records[1].Size != 11 {
+		t.Errorf("Index records %+v, expected three uploads", records)
+	}
+	if log := strings.Join(fs.transferLog, "\n"); !strings.Contains(log, "copy.pdf has the same content as report.pdf") {
+		t.Errorf("Log should mention the duplicate:\n%s", log)
+	}
+
+	listing, _ := listFiles([]archiveSource{{path: tempDir}})
+	if len(listing.Files) != 3 {
+		t.Errorf("Listing should hide the store: %+v", listing.Files)
+	}
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"sha256=" + sum, http.StatusOK},
+		{"sha256=" + strings.ToUpper(sum), http.StatusOK},
+		{"sha256=" + strings.Repeat("0", 64), http.StatusNotFound},
+		{"sha256=abc", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleObject(rec, httptest.NewRequest("GET", "/api/object?"+test.query, nil))
+		if rec.Code != test.expected {
+			t.Errorf("GET /api/object?%s = %d, expected %d", test.query, rec.Code, test.expected)
+		}
+	}
+	rec := httptest.NewRecorder()
+	fs.handleObject(rec, httptest.NewRequest("GET", "/api/object?sha256="+sum, nil))
+	var reply struct {
+		Size     int64       `json:"size"`
+		Received []casRecord `json:"received"`
+	}
+	json.NewDecoder(rec.Body).Decode(&reply)
+	if reply.Size != 11 || len(reply.Received) != 3 || reply.Received[0].Name != "report.pdf" {
+		t.Errorf("Object reply %+v", reply)
+	}
+}
+
+// Test that a multi-part upload of stored content finishes at init
+func TestContentStoreInstantUpload(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_cas_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	fs.clock = fastClock{}
+	fs.cas = true
+	hash := sha256.Sum256([]byte("video"))
+	sum := hex.EncodeToString(hash[:])
+	rec := httptest.NewRecorder()
+	fs.handleUpload(rec, newUploadRequest(t, "clip.mp4", "video", nil))
+
+	tests := []struct {
+		form     url.Values
+		expected string
+	}{
+		{url.Values{"name": {"again.mp4"}, "size": {"5"}, "sha256": {sum}}, `"status":"exists"`},
+		{url.Values{"name": {"other.mp4"}, "size": {"6"}, "sha256": {sum}}, `"id":`},
+		{url.Values{"name": {"new.mp4"}, "size": {"5"}, "sha256": {strings.Repeat("1", 64)}}, `"id":`},
+		{url.Values{"name": {"bad.mp4"}, "size": {"5"}, "sha256": {"xyz"}}, "Invalid sha256"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/api/upload/init", strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		fs.handleUploadInit(rec, req)
+		if !strings.Contains(rec.Body.String(), test.expected) {
+			t.Errorf("init %v = %q, expected %s", test.form, rec.Body.String(), test.expected)
+		}
+		if test.expected == `"status":"exists"` && fs.status.Status != "completed" {
+			t.Errorf("Instant upload left status %q, expected completed", fs.status.Status)
+		}
+		// Abandon any session that was started.
+		for _, session := range fs.uploads {
+			fs.endUploadSession(session, false)
+		}
+	}
+	info, err := os.Stat(filepath.Join(tempDir, "again.mp4"))
+	object, _ := os.Stat(fs.casObject(sum))
+	if err != nil || !os.SameFile(info, object) {
+		t.Errorf("again.mp4 should link to the stored clip (%v)", err)
+	}
+	if records := fs.casLookup(sum); len(records) != 2 || records[1].Name != "again.mp4" {
+		t.Errorf("Index records %+v, expected clip.mp4 and again.mp4", records)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chromecast support: find devices with mDNS and ask them to play the
+// shared media through the Default Media Receiver, speaking the Cast v2
+// protocol (length-prefixed CastMessage protobufs over TLS) directly.
+
+const (
+	castPort        = 8009
+	castService     = "_googlecast._tcp.local"
+	castMediaApp    = "CC1AD845" // Default Media Receiver
+	castNSConnect   = "urn:x-cast:com.google.cast.tp.connection"
+	castNSHeartbeat = "urn:x-cast:com.google.cast.tp.heartbeat"
+	castNSReceiver  = "urn:x-cast:com.google.cast.receiver"
+	castNSMedia     = "urn:x-cast:com.google.cast.media"
+	castTimeout     = 20 * time.Second
+)
+
+type castDevice struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+// discoverCastDevices browses for Chromecasts with a legacy unicast mDNS
+// query, collecting answers until timeout.
+func discoverCastDevices(timeout time.Duration) ([]castDevice, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	idBytes := make([]byte, 2)
+	rand.Read(idBytes)
+	id := binary.BigEndian.Uint16(idBytes)
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	if _, err := conn.WriteToUDP(buildPTRQuery(id, castService), group); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]castDevice)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		for _, d := range parseCastResponse(buf[:n], id) {
+			seen[d.Addr] = d
+		}
+	}
+
+	devices := make([]castDevice, 0, len(seen))
+	for _, d := range seen {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+// parseCastResponse assembles devices from the PTR, SRV, TXT and A records
+// of one mDNS response.
+func parseCastResponse(msg []byte, id uint16) []castDevice {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[0:]) != id || msg[2]&0x80 == 0 {
+		return nil
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil
+		}
+		off = next + 4
+	}
+
+	var instances []string
+	targets := make(map[string]string)
+	ports := make(map[string]int)
+	names := make(map[string]string)
+	addrs := make(map[string]string)
+	for i := 0; i < rrcount; i++ {
+		owner, next, err := readDNSName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			break
+		}
+		rrType := binary.BigEndian.Uint16(msg[next:])
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdlen > len(msg) {
+			break
+		}
+		owner = strings.ToLower(owner)
+		switch rrType {
+		case 12: // PTR
+			if owner == castService {
+				if instance, _, err := readDNSName(msg, rdata); err == nil {
+					instances = append(instances, strings.ToLower(instance))
+				}
+			}
+		case 33: // SRV
+			if rdlen >= 6 {
+				if target, _, err := readDNSName(msg, rdata+6); err == nil {
+					targets[owner] = strings.ToLower(target)
+					ports[owner] = int(binary.BigEndian.Uint16(msg[rdata+4:]))
+				}
+			}
+		case 16: // TXT
+			for p := rdata; p < rdata+rdlen; {
+				l := int(msg[p])
+				if p+1+l > rdata+rdlen {
+					break
+				}
+				if kv := string(msg[p+1 : p+1+l]); strings.HasPrefix(kv, "fn=") {
+					names[owner] = kv[3:]
+				}
+				p += 1 + l
+			}
+		case 1: // A
+			if rdlen == 4 {
+				addrs[owner] = net.IP(msg[rdata : rdata+4]).String()
+			}
+		}
+		off = rdata + rdlen
+	}
+
+	var devices []castDevice
+	for _, instance := range instances {
+		ip, ok := addrs[targets[instance]]
+		if !ok {
+			continue
+		}
+		port := ports[instance]
+		if port == 0 {
+			port = castPort
+		}
+		name := names[instance]
+		if name == "" {
+			name = strings.TrimSuffix(instance, "."+castService)
+		}
+		devices = append(devices, castDevice{Name: name, Addr: net.JoinHostPort(ip, strconv.Itoa(port))})
+	}
+	return devices
+}
+
+// resolveCastDevice accepts an address ("192.168.1.20" or with a port) or
+// a friendly name, which is looked up on the LAN.
+func resolveCastDevice(target string, timeout time.Duration) (castDevice, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		return castDevice{Name: target, Addr: net.JoinHostPort(target, strconv.Itoa(castPort))}, nil
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil && net.ParseIP(host) != nil {
+		return castDevice{Name: target, Addr: target}, nil
+	}
+
+	devices, err := discoverCastDevices(timeout)
+	if err != nil {
+		return castDevice{}, err
+	}
+	for _, d := range devices {
+		if strings.EqualFold(d.Name, target) {
+			return d, nil
+		}
+	}
+	for _, d := range devices {
+		if strings.Contains(strings.ToLower(d.Name), strings.ToLower(target)) {
+			return d, nil
+		}
+	}
+	return castDevice{}, fmt.Errorf("no Chromecast named '%s' found on the local network", target)
+}
+
+type castMessage struct {
+	source      string
+	destination string
+	namespace   string
+	payload     string
+}
+
+// encodeCastMessage serializes a CastMessage. protocol_version and
+// payload_type are required proto2 fields, so their zero values
+// (CASTV2_1_0 and STRING) are written explicitly.
+func encodeCastMessage(m castMessage) []byte {
+	var b []byte
+	b = appendTag(b, 1, wireVarint)
+	b = append(b, 0)
+	b = appendStringField(b, 2, m.source)
+	b = appendStringField(b, 3, m.destination)
+	b = appendStringField(b, 4, m.namespace)
+	b = appendTag(b, 5, wireVarint)
+	b = append(b, 0)
+	b = appendStringField(b, 6, m.payload)
+	return b
+}
+
+func decodeCastMessage(b []byte) (castMessage, error) {
+	fields, err := parseProto(b)
+	if err != nil {
+		return castMessage{}, err
+	}
+	var m castMessage
+	for _, f := range fields {
+		switch f.num {
+		case 2:
+			m.source = string(f.bytes)
+		case 3:
+			m.destination = string(f.bytes)
+		case 4:
+			m.namespace = string(f.bytes)
+		case 6:
+			m.payload = string(f.bytes)
+		}
+	}
+	return m, nil
+}
+
+type castConn struct {
+	conn net.Conn
+}
+
+func (c *castConn) send(destination, namespace string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := encodeCastMessage(castMessage{source: "sender-0", destination: destination, namespace: namespace, payload: string(data)})
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(msg)))
+	_, err = c.conn.Write(append(frame, msg...))
+	return err
+}
+
+// receive returns the next message that isn't a heartbeat, answering
+// PINGs along the way.
+func (c *castConn) receive() (castMessage, map[string]interface{}, error) {
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+			return castMessage{}, nil, err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > 1<<20 {
+			return castMessage{}, nil, errors.New("cast message too large")
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(c.conn, buf); err != nil {
+			return castMessage{}, nil, err
+		}
+		msg, err := decodeCastMessage(buf)
+		if err != nil {
+			return castMessage{}, nil, err
+		}
+		var payload map[string]interface{}
+		json.Unmarshal([]byte(msg.payload), &payload)
+		if msg.namespace == castNSHeartbeat {
+			if payload["type"] == "PING" {
+				c.send(msg.source, castNSHeartbeat, map[string]string{"type": "PONG"})
+			}
+			continue
+		}
+		return msg, payload, nil
+	}
+}
+
+// castMedia launches the Default Media Receiver on the device at addr and
+// has it load mediaURL. It returns once the device accepts the media.
+func castMedia(addr, mediaURL, title string) error {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(title)))
+	if contentType == "" {
+		contentType = "video/mp4"
+	}
+
+	// Chromecasts present device certificates that don't chain to a public
+	// root, so there is nothing meaningful to verify against.
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(castTimeout))
+	c := &castConn{conn: conn}
+
+	if err := c.send("receiver-0", castNSConnect, map[string]string{"type": "CONNECT"}); err != nil {
+		return err
+	}
+	if err := c.send("receiver-0", castNSReceiver, map[string]interface{}{"type": "LAUNCH", "appId": castMediaApp, "requestId": 1}); err != nil {
+		return err
+	}
+
+	transportID := ""
+	for transportID == "" {
+		msg, payload, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if msg.namespace != castNSReceiver {
+			continue
+		}
+		switch payload["type"] {
+		case "LAUNCH_ERROR":
+			return fmt.Errorf("device refused to launch the media receiver: %v", payload["reason"])
+		case "RECEIVER_STATUS":
+			transportID = castTransportID(payload)
+		}
+	}
+
+	if err := c.send(transportID, castNSConnect, map[string]string{"type": "CONNECT"}); err != nil {
+		return err
+	}
+	load := map[string]interface{}{
+		"type":      "LOAD",
+		"requestId": 2,
+		"autoplay":  true,
+		"media": map[string]interface{}{
+			"contentId":   mediaURL,
+			"contentType": contentType,
+			"streamType":  "BUFFERED",
+			"metadata":    map[string]interface{}{"metadataType": 0, "title": title},
+		},
+	}
+	if err := c.send(transportID, castNSMedia, load); err != nil {
+		return err
+	}
+	for {
+		msg, payload, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if msg.namespace != castNSMedia {
+			continue
+		}
+		switch payload["type"] {
+		case "MEDIA_STATUS":
+			return nil
+		case "LOAD_FAILED", "LOAD_CANCELLED", "INVALID_REQUEST":
+			return fmt.Errorf("device could not play the media (%v)", payload["type"])
+		}
+	}
+}
+
+// castTransportID finds the running Default Media Receiver in a
+// RECEIVER_STATUS payload.
+func castTransportID(payload map[string]interface{}) string {
+	status, _ := payload["status"].(map[string]interface{})
+	apps, _ := status["applications"].([]interface{})
+	for _, a := range apps {
+		app, _ := a.(map[string]interface{})
+		if app["appId"] == castMediaApp {
+			id, _ := app["transportId"].(string)
+			return id
+		}
+	}
+	return ""
+}
+
+// castShare plays the shared media file on device, serving it from the
+// local address the device can reach.
+func (fs *FileServer) castShare(device castDevice) error {
+	if fs.shareMediaKind() == "" {
+		return errors.New("the shared target is not a media file")
+	}
+	remote, err := net.ResolveUDPAddr("udp4", device.Addr)
+	if err != nil {
+		return err
+	}
+	ip, err := localIPFor(remote)
+	if err != nil {
+		return err
+	}
+	mediaURL := fmt.Sprintf("http://%s:%d%s/api/stream", ip, fs.port, fs.basePath)
+	return castMedia(device.Addr, mediaURL, fs.shareName())
+}
+
+// castAtStartup handles -cast once the server is listening.
+func (fs *FileServer) castAtStartup(target string) {
+	device, err := resolveCastDevice(target, 5*time.Second)
+	if err == nil {
+		err = fs.castShare(device)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cast failed: %v\n", err)
+		return
+	}
+	fs.addLog(fmt.Sprintf("Casting %s to %s", fs.shareName(), device.Name))
+	fs.report(outputEvent{Event: "cast", Name: fs.shareName(), Client: device.Name}, fmt.Sprintf("\n%sCasting to %s\n", icon("📺 "), device.Name))
+}
+
+// handleCast lists Chromecasts on GET and starts playback on the chosen
+// one on POST.
+func (fs *FileServer) handleCast(w http.ResponseWriter, r *http.Request) {
+	if fs.shareMediaKind() == "" {
+		httpError(w, r, "The shared target is not a media file", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		devices, err := discoverCastDevices(2 * time.Second)
+		if err != nil {
+			httpError(w, r, "Device discovery failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(devices)
+	case http.MethodPost:
+		target := r.FormValue("device")
+		if target == "" {
+			httpError(w, r, "Missing device", http.StatusBadRequest)
+			return
+		}
+		device, err := resolveCastDevice(target, 3*time.Second)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := fs.castShare(device); err != nil {
+			httpError(w, r, "Cast failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		fs.logRequest(r, fmt.Sprintf("%s cast %s to %s", fs.clientLabel(fs.getClientIP(r)), fs.shareName(), device.Name))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "casting", "device": device.Name})
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendDNSRecord(b []byte, name string, rrType uint16, rdata []byte) []byte {
+	b = appendDNSName(b, name)
+	b = binary.BigEndian.AppendUint16(b, rrType)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint32(b, 120)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// Test parsing mDNS answers for Chromecasts
+func TestParseCastResponse(t *testing.T) {
+	instance := "Chromecast-abc123._googlecast._tcp.local"
+	msg := []byte{0x12, 0x34, 0x84, 0x00, 0, 0, 0, 1, 0, 0, 0, 3}
+	msg = appendDNSRecord(msg, castService, 12, appendDNSName(nil, instance))
+	srv := []byte{0, 0, 0, 0, 0x1f, 0x49}
+	msg = appendDNSRecord(msg, instance, 33, appendDNSName(srv, "abc123.local"))
+	txt := append([]byte{byte(len("id=abc123"))}, "id=abc123"...)
+	txt = append(txt, byte(len("fn=Living Room TV")))
+	txt = append(txt, "fn=Living Room TV"...)
+	msg = appendDNSRecord(msg, instance, 16, txt)
+	msg = appendDNSRecord(msg, "abc123.local", 1, []byte{192, 168, 1, 20})
+
+	devices := parseCastResponse(msg, 0x1234)
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(devices))
+	}
+	if devices[0].Name != "Living Room TV" || devices[0].Addr != "192.168.1.20:8009" {
+		t.Errorf("Device = %+v, expected Living Room TV at 192.168.1.20:8009", devices[0])
+	}
+	if devices := parseCastResponse(msg, 0x9999); len(devices) != 0 {
+		t.Errorf("Response with another query ID should be ignored, got %v", devices)
+	}
+}
+
+// Test resolving cast targets given as addresses
+func TestResolveCastDeviceAddress(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"192.168.1.20", "192.168.1.20:8009"},
+		{"192.168.1.20:8010", "192.168.1.20:8010"},
+	}
+	for _, test := range tests {
+		device, err := resolveCastDevice(test.target, 0)
+		if err != nil || device.Addr != test.expected {
+			t.Errorf("resolveCastDevice(%s) = %v, %v, expected %s", test.target, device, err, test.expected)
+		}
+	}
+}
+
+func readCastFrame(r io.Reader) (castMessage, map[string]interface{}, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return castMessage{}, nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return castMessage{}, nil, err
+	}
+	msg, err := decodeCastMessage(buf)
+	var payload map[string]interface{}
+	json.Unmarshal([]byte(msg.payload), &payload)
+	return msg, payload, err
+}
+
+func writeCastFrame(w io.Writer, source, namespace, payload string) {
+	msg := encodeCastMessage(castMessage{source: source, destination: "sender-0", namespace: namespace, payload: payload})
+	w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...))
+}
+
+// Test the cast handshake against a fake device
+func TestCastMedia(t *testing.T) {
+	certServer := httptest.NewUnstartedServer(nil)
+	certServer.StartTLS()
+	config := certServer.TLS
+	certServer.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	loaded := make(chan map[string]interface{}, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		writeCastFrame(conn, "receiver-0", castNSHeartbeat, `{"type":"PING"}`)
+		for {
+			msg, payload, err := readCastFrame(conn)
+			if err != nil {
+				return
+			}
+			switch payload["type"] {
+			case "LAUNCH":
+				writeCastFrame(conn, "receiver-0", castNSReceiver,
+					`{"type":"RECEIVER_STATUS","status":{"applications":[{"appId":"CC1AD845","transportId":"web-7"}]}}`)
+			case "LOAD":
+				if msg.destination != "web-7" {
+					return
+				}
+				loaded <- payload
+				writeCastFrame(conn, "web-7", castNSMedia, `{"type":"MEDIA_STATUS","status":[]}`)
+			}
+		}
+	}()
+
+	if err := castMedia(listener.Addr().String(), "http://192.168.1.5:8080/api/stream", "movie.mp4"); err != nil {
+		t.Fatalf("castMedia failed: %v", err)
+	}
+	payload := <-loaded
+	media, _ := payload["media"].(map[string]interface{})
+	if media["contentId"] != "http://192.168.1.5:8080/api/stream" || media["contentType"] != "video/mp4" {
+		t.Errorf("LOAD media = %v, expected the stream URL as video/mp4", media)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Client activities, busiest first: a client shows the busiest of its
+// connections.
+const (
+	activityUploading   = "uploading"
+	activityDownloading = "downloading"
+	activityStreaming   = "streaming"
+	activityViewing     = "viewing"
+)
+
+var activityRank = map[string]int{activityUploading: 4, activityDownloading: 3, activityStreaming: 2, activityViewing: 1}
+
+type clientConn struct {
+	ip        string
+	userAgent string
+	activity  string
+	since     time.Time
+	conn      net.Conn // nil when not known
+}
+
+// clientRegistry tracks open connections: pages listening for events and
+// running transfers. Each client IP keeps its ID while it has any open.
+// The zero value is ready.
+type clientRegistry struct {
+	mu         sync.Mutex
+	next       int
+	conns      map[int]clientConn
+	nextClient int
+	clientIDs  map[string]int
+}
+
+// connectedClient is one client IP in /api/clients.
+type connectedClient struct {
+	ID        int       `json:"id"`
+	IP        string    `json:"ip"`
+	Host      string    `json:"host"`
+	UserAgent string    `json:"user_agent"`
+	Activity  string    `json:"activity"`
+	Pages     int       `json:"pages"`
+	Transfers int       `json:"transfers"`
+	Since     time.Time `json:"since"`
+}
+
+func (c *clientRegistry) open(conn clientConn) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil {
+		c.conns = make(map[int]clientConn)
+		c.clientIDs = make(map[string]int)
+	}
+	if _, ok := c.clientIDs[conn.ip]; !ok {
+		c.nextClient++
+		c.clientIDs[conn.ip] = c.nextClient
+	}
+	c.next++
+	c.conns[c.next] = conn
+	return c.next
+}
+
+func (c *clientRegistry) close(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn, ok := c.conns[id]
+	if !ok {
+		return
+	}
+	delete(c.conns, id)
+	for _, other := range c.conns {
+		if other.ip == conn.ip {
+			return
+		}
+	}
+	delete(c.clientIDs, conn.ip)
+}
+
+// lookup returns the IP of the client with id and its open connections.
+func (c *clientRegistry) lookup(id int) (string, []net.Conn, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ip, clientID := range c.clientIDs {
+		if clientID != id {
+			continue
+		}
+		var conns []net.Conn
+		for _, conn := range c.conns {
+			if conn.ip == ip && conn.conn != nil {
+				conns = append(conns, conn.conn)
+			}
+		}
+		return ip, conns, true
+	}
+	return "", nil, false
+}
+
+// list merges the connections per IP, longest connected first. The user
+// agent is the one of the client's latest connection.
+func (c *clientRegistry) list() []connectedClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byIP := map[string]*connectedClient{}
+	latest := map[string]time.Time{}
+	for _, conn := range c.conns {
+		client := byIP[conn.ip]
+		if client == nil {
+			client = &connectedClient{ID: c.clientIDs[conn.ip], IP: conn.ip, Since: conn.since}
+			byIP[conn.ip] = client
+		}
+		if conn.activity == activityViewing {
+			client.Pages++
+		} else {
+			client.Transfers++
+		}
+		if activityRank[conn.activity] > activityRank[client.Activity] {
+			client.Activity = conn.activity
+		}
+		if conn.since.Before(client.Since) {
+			client.Since = conn.since
+		}
+		if !conn.since.Before(latest[conn.ip]) {
+			latest[conn.ip] = conn.since
+			client.UserAgent = conn.userAgent
+		}
+	}
+	clients := make([]connectedClient, 0, len(byIP))
+	for _, client := range byIP {
+		clients = append(clients, *client)
+	}
+	slices.SortFunc(clients, func(a, b connectedClient) int {
+		return cmp.Or(a.Since.Compare(b.Since), cmp.Compare(a.IP, b.IP))
+	})
+	return clients
+}
+
+// trackClient registers r's connection as activity until the returned
+// function is called, and tells open pages about the change.
+func (fs *FileServer) trackClient(r *http.Request, activity string) func() {
+	id := fs.clients.open(clientConn{
+		ip:        fs.getClientIP(r),
+		userAgent: r.UserAgent(),
+		activity:  activity,
+		since:     fs.clock.Now(),
+		conn:      requestConn(r),
+	})
+	fs.broadcastClients()
+	return func() {
+		fs.clients.close(id)
+		fs.broadcastClients()
+	}
+}
+
+// connectedClients lists the clients with their hostnames filled in.
+func (fs *FileServer) connectedClients() []connectedClient {
+	clients := fs.clients.list()
+	for i := range clients {
+		clients[i].Host = fs.clientHost(clients[i].IP)
+	}
+	return clients
+}
+
+func (fs *FileServer) broadcastClients() {
+	data, _ := json.Marshal(fs.connectedClients())
+	fs.broadcast("clients", string(data))
+}
+
+func (fs *FileServer) handleClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Clients []connectedClient `json:"clients"`
+	}{fs.connectedClients()})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test merging connections into one entry per client
+func TestClientRegistry(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var registry clientRegistry
+	registry.open(clientConn{ip: "192.168.1.20", userAgent: "Firefox/126.0", activity: activityViewing, since: start})
+	registry.open(clientConn{ip: "192.168.1.10", userAgent: "Chrome/125.0", activity: activityViewing, since: start.Add(time.Second)})
+	transfer := registry.open(clientConn{ip: "192.168.1.20", userAgent: "curl/8.0", activity: activityDownloading, since: start.Add(2 * time.Second)})
+	registry.open(clientConn{ip: "192.168.1.20", userAgent: "Firefox/126.0", activity: activityStreaming, since: start.Add(time.Second)})
+
+	check := func(when string, expected []connectedClient) {
+		clients := registry.list()
+		if len(clients) != len(expected) {
+			t.Errorf("%s: got %+v, expected %+v", when, clients, expected)
+			return
+		}
+		for i := range expected {
+			if clients[i] != expected[i] {
+				t.Errorf("%s: client %d = %+v, expected %+v", when, i, clients[i], expected[i])
+			}
+		}
+	}
+	check("While downloading", []connectedClient{
+		{ID: 1, IP: "192.168.1.20", UserAgent: "curl/8.0", Activity: activityDownloading, Pages: 1, Transfers: 2, Since: start},
+		{ID: 2, IP: "192.168.1.10", UserAgent: "Chrome/125.0", Activity: activityViewing, Pages: 1, Since: start.Add(time.Second)},
+	})
+	registry.close(transfer)
+	check("After the download", []connectedClient{
+		{ID: 1, IP: "192.168.1.20", UserAgent: "Firefox/126.0", Activity: activityStreaming, Pages: 1, Transfers: 1, Since: start},
+		{ID: 2, IP: "192.168.1.10", UserAgent: "Chrome/125.0", Activity: activityViewing, Pages: 1, Since: start.Add(time.Second)},
+	})
+}
+
+// Test that /api/clients follows tracked connections
+func TestHandleClients(t *testing.T) {
+	fs := NewFileServer("send", "/tmp/file.txt", 8080, false)
+	fs.clock = fastClock{}
+	list := func() []connectedClient {
+		rec := httptest.NewRecorder()
+		fs.handleClients(rec, httptest.NewRequest("GET", "/api/clients", nil))
+		var body struct {
+			Clients []connectedClient `json:"clients"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid /api/clients JSON: %v", err)
+		}
+		return body.Clients
+	}
+
+	if clients := list(); len(clients) != 0 {
+		t.Errorf("Expected no clients at startup, got %+v", clients)
+	}
+	req := httptest.NewRequest("GET", "/api/events", nil)
+	req.Header.Set("User-Agent", "Firefox/126.0")
+	done := fs.trackClient(req, activityViewing)
+	clients := list()
+	if len(clients) != 1 || clients[0].IP != "192.0.2.1" || clients[0].UserAgent != "Firefox/126.0" || clients[0].Activity != activityViewing {
+		t.Errorf("Unexpected clients while viewing: %+v", clients)
+	}
+	done()
+	if clients := list(); len(clients) != 0 {
+		t.Errorf("Expected no clients after the page closed, got %+v", clients)
+	}
+}
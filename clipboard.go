@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const maxClipboardSize = 1 << 20
+
+// clipboardBackend reads and writes the host clipboard.
+type clipboardBackend interface {
+	Read() (string, error)
+	Write(text string) error
+}
+
+// systemClipboard shells out to the platform clipboard tools.
+type systemClipboard struct{}
+
+func clipboardCommands() (read, write []string, err error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"pbpaste"}, []string{"pbcopy"}, nil
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"},
+			[]string{"powershell", "-NoProfile", "-Command", "$input | Set-Clipboard"}, nil
+	}
+
+	candidates := [][2][]string{
+		{{"xclip", "-selection", "clipboard", "-o"}, {"xclip", "-selection", "clipboard", "-i"}},
+		{{"xsel", "--clipboard", "--output"}, {"xsel", "--clipboard", "--input"}},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		wayland := [2][]string{{"wl-paste", "--no-newline"}, {"wl-copy"}}
+		candidates = append([][2][]string{wayland}, candidates...)
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0][0]); err == nil {
+			return c[0], c[1], nil
+		}
+	}
+	return nil, nil, errors.New("no clipboard tool found (install wl-clipboard, xclip or xsel)")
+}
+
+func (systemClipboard) Read() (string, error) {
+	read, _, err := clipboardCommands()
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command(read[0], read[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", read[0], err)
+	}
+	return string(out), nil
+}
+
+func (systemClipboard) Write(text string) error {
+	_, write, err := clipboardCommands()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(write[0], write[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v %s", write[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (fs *FileServer) getClipboard() string {
+	fs.clipMu.Lock()
+	defer fs.clipMu.Unlock()
+	return fs.clipText
+}
+
+// setClipboard records text as the current clipboard and pushes it to
+// connected browsers. It reports whether the text changed.
+func (fs *FileServer) setClipboard(text string) bool {
+	fs.clipMu.Lock()
+	if text == fs.clipText {
+		fs.clipMu.Unlock()
+		return false
+	}
+	fs.clipText = text
+	fs.clipMu.Unlock()
+
+	data, _ := json.Marshal(map[string]string{"text": text})
+	fs.broadcast("clipboard", string(data))
+	return true
+}
+
+// watchClipboard polls the host clipboard and publishes changes until the
+// server shuts down.
+func (fs *FileServer) watchClipboard(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-ticker.C:
+			text, err := fs.clipboard.Read()
+			if err != nil || len(text) > maxClipboardSize {
+				continue
+			}
+			if fs.setClipboard(text) {
+				fs.addLog(fmt.Sprintf("Host clipboard changed (%d chars)", len([]rune(text))))
+			}
+		}
+	}
+}
+
+// handleClipboard returns the current clipboard on GET and replaces the
+// host clipboard with the posted text on POST.
+func (fs *FileServer) handleClipboard(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "clipboard" {
+		httpError(w, r, "Server is not in clipboard mode", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxClipboardSize)
+		var text string
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") ||
+			strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.ParseMultipartForm(maxClipboardSize)
+			text = r.FormValue("text")
+		} else {
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				httpError(w, r, "Clipboard text too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			text = string(data)
+		}
+		if err := fs.clipboard.Write(text); err != nil {
+			fs.logRequest(r, fmt.Sprintf("Failed to set host clipboard: %v", err))
+			httpError(w, r, "Failed to set host clipboard", http.StatusInternalServerError)
+			return
+		}
+		fs.setClipboard(text)
+		fs.logRequest(r, fmt.Sprintf("%s set the host clipboard (%d chars)", fs.clientLabel(fs.getClientIP(r)), len([]rune(text))))
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"text": fs.getClipboard()})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClipboard struct {
+	mu   sync.Mutex
+	text string
+}
+
+func (c *fakeClipboard) Read() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.text, nil
+}
+
+func (c *fakeClipboard) Write(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.text = text
+	return nil
+}
+
+// Test reading and setting the clipboard over HTTP
+func TestHandleClipboard(t *testing.T) {
+	clip := &fakeClipboard{text: "from host"}
+	fs := NewFileServer("clipboard", "", 8080, false)
+	fs.clipboard = clip
+	fs.clipText = clip.text
+
+	w := httptest.NewRecorder()
+	fs.handleClipboard(w, httptest.NewRequest(http.MethodGet, "/api/clipboard", nil))
+	if !strings.Contains(w.Body.String(), `"text":"from host"`) {
+		t.Errorf("GET should return the host clipboard, got %s", w.Body.String())
+	}
+
+	form := url.Values{"text": {"from phone"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/clipboard", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	fs.handleClipboard(w, req)
+	if w.Code != http.StatusOK || clip.text != "from phone" || fs.getClipboard() != "from phone" {
+		t.Errorf("Form POST should set the host clipboard, got %d %q", w.Code, clip.text)
+	}
+
+	w = httptest.NewRecorder()
+	fs.handleClipboard(w, httptest.NewRequest(http.MethodPost, "/api/clipboard", strings.NewReader("raw body")))
+	if clip.text != "raw body" {
+		t.Errorf("Raw POST should set the host clipboard, got %q", clip.text)
+	}
+
+	w = httptest.NewRecorder()
+	fs.handleClipboard(w, httptest.NewRequest(http.MethodPost, "/api/clipboard", strings.NewReader(strings.Repeat("x", maxClipboardSize+1))))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized clipboard should be rejected, got %d", w.Code)
+	}
+
+	send := NewFileServer("send", "file.txt", 8080, false)
+	w = httptest.NewRecorder()
+	send.handleClipboard(w, httptest.NewRequest(http.MethodGet, "/api/clipboard", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Clipboard API outside clipboard mode should fail, got %d", w.Code)
+	}
+}
+
+// Test that host clipboard changes are pushed to browsers
+func TestWatchClipboard(t *testing.T) {
+	clip := &fakeClipboard{text: "old"}
+	fs := NewFileServer("clipboard", "", 8080, false)
+	fs.clipboard = clip
+	fs.clipText = clip.text
+
+	events := newSSEClient(sseQueueLimit)
+	fs.sseClients[events] = true
+	go fs.watchClipboard(10 * time.Millisecond)
+	defer fs.shutdown()
+
+	clip.Write("new")
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case <-events.wake:
+			for _, frame := range events.take() {
+				if strings.HasPrefix(frame, "event: clipboard\n") {
+					if !strings.Contains(frame, `"text":"new"`) {
+						t.Errorf("Unexpected clipboard frame: %q", frame)
+					}
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatal("Host clipboard change was not broadcast")
+		}
+	}
+}
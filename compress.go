@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	compressOff  = "off"
+	compressGzip = "gzip"
+)
+
+func validCompression(mode string) bool {
+	return mode == compressOff || mode == compressGzip
+}
+
+// precompressedExts are formats that gain nothing from another pass of
+// compression: archives, media and zip-based documents.
+var precompressedExts = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true, ".m4v": true,
+	".mp3": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true, ".m4a": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true,
+	".apk": true, ".jar": true, ".dmg": true, ".woff2": true,
+}
+
+// compressible reports whether downloads of name are worth compressing.
+func (fs *FileServer) compressible(name string) bool {
+	return fs.compress == compressGzip && !precompressedExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// gzipDownload reports whether the download of file in response to r is
+// sent gzipped. Range requests are served as is so offsets stay valid.
+func (fs *FileServer) gzipDownload(r *http.Request, file string) bool {
+	return r.Header.Get("Range") == "" && fs.compressible(file) && acceptsGzip(r.Header.Get("Accept-Encoding"))
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// packing is how a finished download was packed: the file bytes read
+// against the bytes that went out. The zero value means it was sent as is.
+type packing struct {
+	method string // "gzip", or "deflate" or "store" for zip archives
+	raw    int64
+	wire   int64
+}
+
+// zipPacking describes an archive built with method.
+func zipPacking(method uint16, raw, wire int64) packing {
+	if method == zip.Store {
+		return packing{"store", raw, wire}
+	}
+	return packing{"deflate", raw, wire}
+}
+
+// ratio is how many times smaller the download was than its files.
+func (p packing) ratio() float64 {
+	if p.wire == 0 {
+		return 0
+	}
+	return float64(p.raw) / float64(p.wire)
+}
+
+// summary is the console line reporting the packing, if any.
+func (p packing) summary() string {
+	if p.method == "" {
+		return ""
+	}
+	return fmt.Sprintf("  Compression: %s → %s (%.2fx, %s)\n", formatSize(p.raw), formatSize(p.wire), p.ratio(), p.method)
+}
+
+// recordPacking adds a finished download's packing to /api/stats and
+// returns the console line for it.
+func (fs *FileServer) recordPacking(p packing) string {
+	if p.method != "" {
+		fs.stats.packed(p)
+	}
+	return p.summary()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test Accept-Encoding parsing
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"gzip, deflate, br", true},
+		{"deflate, GZIP;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"br, zstd", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if result := acceptsGzip(test.header); result != test.expected {
+			t.Errorf("acceptsGzip(%q) = %t, expected %t", test.header, result, test.expected)
+		}
+	}
+}
+
+// Test gzip negotiation for downloads
+func TestCompressedDownload(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_compress_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	content := strings.Repeat("2024-05-01 12:00:00 INFO request served\n", 2000)
+	os.WriteFile(filepath.Join(tempDir, "app.log"), []byte(content), 0644)
+	os.WriteFile(filepath.Join(tempDir, "photo.jpg"), []byte(content), 0644)
+
+	tests := []struct {
+		file     string
+		compress string
+		accept   string
+		rangeHdr string
+		gzipped  bool
+	}{
+		{"app.log", compressGzip, "gzip, deflate", "", true},
+		{"app.log", compressGzip, "", "", false},
+		{"app.log", compressGzip, "gzip", "bytes=0-99", false},
+		{"app.log", compressOff, "gzip", "", false},
+		{"photo.jpg", compressGzip, "gzip", "", false},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", filepath.Join(tempDir, test.file), 8080, false)
+		fs.compress = test.compress
+		req := httptest.NewRequest("GET", "/api/download", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept-Encoding", test.accept)
+		}
+		if test.rangeHdr != "" {
+			req.Header.Set("Range", test.rangeHdr)
+		}
+		rec := httptest.NewRecorder()
+		fs.handleDownload(rec, req)
+
+		gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != test.gzipped {
+			t.Errorf("%s (%s, Accept-Encoding %q, Range %q): gzipped = %t, expected %t", test.file, test.compress, test.accept, test.rangeHdr, gzipped, test.gzipped)
+			continue
+		}
+		if !gzipped {
+			continue
+		}
+		if rec.Header().Get("Content-Length") != "" || rec.Body.Len() >= len(content) {
+			t.Errorf("%s: gzipped response should be smaller and unsized, got %d bytes", test.file, rec.Body.Len())
+		}
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("%s: invalid gzip stream: %v", test.file, err)
+		}
+		data, _ := io.ReadAll(zr)
+		if string(data) != content {
+			t.Errorf("%s: decompressed download differs from the file", test.file)
+		}
+		if fs.status.SHA256 == "" || fs.status.Transferred != int64(len(content)) {
+			t.Errorf("%s: status should track the file's bytes, got %d with hash %q", test.file, fs.status.Transferred, fs.status.SHA256)
+		}
+	}
+}
+
+// Test that compressed downloads report their ratio in the stats
+func TestCompressionStats(t *testing.T) {
+	tempDir := t.TempDir()
+	content := strings.Repeat("2024-05-01 12:00:00 INFO request served\n", 2000)
+	os.MkdirAll(filepath.Join(tempDir, "logs"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "logs", "app.log"), []byte(content), 0644)
+
+	tests := []struct {
+		path     string
+		zipStore bool
+		method   string
+	}{
+		{filepath.Join(tempDir, "logs", "app.log"), false, compressGzip},
+		{filepath.Join(tempDir, "logs"), false, "deflate"},
+		{filepath.Join(tempDir, "logs"), true, "store"},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", test.path, 8080, false)
+		fs.compress = compressGzip
+		fs.zipStore = test.zipStore
+		req := httptest.NewRequest("GET", "/api/download", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		fs.handleDownload(rec, req)
+
+		snap := fs.stats.snapshot(fs.clock.Now())
+		if len(snap.Compression) != 1 {
+			t.Fatalf("%s: expected one compression entry, got %+v", test.method, snap.Compression)
+		}
+		c := snap.Compression[0]
+		if c.Method != test.method || c.Downloads != 1 || c.RawBytes != int64(len(content)) || c.WireBytes != int64(rec.Body.Len()) {
+			t.Errorf("%s: got %+v, expected %d raw and %d wire bytes", test.method, c, len(content), rec.Body.Len())
+		}
+		if compressed := c.Ratio > 1; compressed != (test.method != "store") {
+			t.Errorf("%s: unexpected ratio %.2f", test.method, c.Ratio)
+		}
+	}
+}
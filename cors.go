@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+type corsPolicy struct {
+	origins []string
+	methods string
+	headers string
+}
+
+func newCORSPolicy(origins, methods, headers string) *corsPolicy {
+	var list []string
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			list = append(list, origin)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return &corsPolicy{origins: list, methods: methods, headers: headers}
+}
+
+func (c *corsPolicy) allowOrigin(origin string) string {
+	for _, allowed := range c.origins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (fs *FileServer) withCORS(next http.Handler) http.Handler {
+	if fs.cors == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := fs.cors.allowOrigin(origin)
+		if allowed == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, Content-Length, X-FileShare-Version")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", fs.cors.methods)
+			w.Header().Set("Access-Control-Allow-Headers", fs.cors.headers)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test CORS headers and preflight handling
+func TestCORS(t *testing.T) {
+	if newCORSPolicy("", "GET", "") != nil {
+		t.Error("Empty origin list should disable CORS")
+	}
+
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.cors = newCORSPolicy("https://app.example.com/, http://localhost:3000", "GET, POST", "Content-Type")
+	handler := fs.withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		method    string
+		path      string
+		origin    string
+		preflight bool
+		code      int
+		allow     string
+	}{
+		{"GET", "/api/info", "https://app.example.com", false, http.StatusOK, "https://app.example.com"},
+		{"GET", "/api/info", "https://evil.example.com", false, http.StatusOK, ""},
+		{"GET", "/", "https://app.example.com", false, http.StatusOK, ""},
+		{"OPTIONS", "/api/upload", "http://localhost:3000", true, http.StatusNoContent, "http://localhost:3000"},
+		{"GET", "/api/info", "", false, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if test.preflight {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Errorf("%s %s from %q = %d, expected %d", test.method, test.path, test.origin, rec.Code, test.code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != test.allow {
+			t.Errorf("%s %s from %q: Allow-Origin = %q, expected %q", test.method, test.path, test.origin, got, test.allow)
+		}
+		if test.preflight && rec.Header().Get("Access-Control-Allow-Methods") != "GET, POST" {
+			t.Errorf("Preflight should advertise allowed methods, got %q", rec.Header().Get("Access-Control-Allow-Methods"))
+		}
+	}
+
+	fs.cors = newCORSPolicy("*", "GET", "")
+	req := httptest.NewRequest("GET", "/api/info", nil)
+	req.Header.Set("Origin", "https://anything.example")
+	rec := httptest.NewRecorder()
+	fs.withCORS(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("Wildcard origin should allow any origin")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+type ctlRequest struct {
+	Cmd  string `json:"cmd"`
+	Path string `json:"path,omitempty"`
+}
+
+type ctlResponse struct {
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Status *TransferStatus `json:"status,omitempty"`
+}
+
+func defaultCtlSocket() string {
+	if sock := os.Getenv("FILESHARE_CTL_SOCKET"); sock != "" {
+		return sock
+	}
+	return filepath.Join(os.TempDir(), "fileshare.sock")
+}
+
+func (fs *FileServer) listenCtl(socketPath string) (net.Listener, error) {
+	// A socket file left behind by a crashed instance would make Listen fail.
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another instance", socketPath)
+	}
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(socketPath, 0600)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fs.serveCtl(conn)
+		}
+	}()
+	return listener, nil
+}
+
+func (fs *FileServer) serveCtl(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req ctlRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			encoder.Encode(ctlResponse{Error: "invalid request: " + err.Error()})
+			continue
+		}
+		encoder.Encode(fs.handleCtl(req))
+		if req.Cmd == "shutdown" {
+			return
+		}
+	}
+}
+
+func (fs *FileServer) handleCtl(req ctlRequest) ctlResponse {
+	switch req.Cmd {
+	case "status":
+		fs.statusMu.RLock()
+		status := *fs.status
+		fs.statusMu.RUnlock()
+		fs.activeMu.Lock()
+		status.ClientIP = fs.activeClient
+		fs.activeMu.Unlock()
+		return ctlResponse{OK: true, Status: &status}
+	case "cancel":
+		fs.activeMu.Lock()
+		active := fs.activeClient
+		fs.activeMu.Unlock()
+		if active != "" {
+			fs.dropClient(active)
+		}
+		fs.cancel("control socket")
+		return ctlResponse{OK: true}
+	case "change-path":
+		if req.Path == "" {
+			return ctlResponse{Error: "change-path requires a path"}
+		}
+		if err := fs.setPath(req.Path); err != nil {
+			return ctlResponse{Error: err.Error()}
+		}
+		return ctlResponse{OK: true}
+	case "shutdown":
+		fs.addLog("Shutdown requested via control socket")
+		fs.shutdown()
+		return ctlResponse{OK: true}
+	default:
+		return ctlResponse{Error: fmt.Sprintf("unknown command '%s'", req.Cmd)}
+	}
+}
+
+func runCtl(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socketPath := flags.String("socket", defaultCtlSocket(), "Control socket of the running instance")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ctl [-socket path] <status|cancel|change-path <path>|shutdown>\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 1
+	}
+
+	req := ctlRequest{Cmd: flags.Arg(0)}
+	if req.Cmd == "change-path" {
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return 1
+		}
+		abs, err := filepath.Abs(flags.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		req.Path = abs
+	}
+
+	resp, err := sendCtl(*socketPath, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "  ")
+	fmt.Println(string(out))
+	if !resp.OK {
+		return 1
+	}
+	return 0
+}
+
+func sendCtl(socketPath string, req ctlRequest) (*ctlResponse, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp ctlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
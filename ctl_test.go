@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test control socket commands
+func TestCtlCommands(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fsctl")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	file1 := filepath.Join(tempDir, "a.txt")
+	file2 := filepath.Join(tempDir, "b.txt")
+	os.WriteFile(file1, []byte("a"), 0644)
+	os.WriteFile(file2, []byte("b"), 0644)
+
+	fs := NewFileServer("send", file1, 0, false)
+	socketPath := filepath.Join(tempDir, "ctl.sock")
+	listener, err := fs.listenCtl(socketPath)
+	if err != nil {
+		t.Fatalf("listenCtl error: %v", err)
+	}
+	defer listener.Close()
+
+	resp, err := sendCtl(socketPath, ctlRequest{Cmd: "status"})
+	if err != nil {
+		t.Fatalf("status error: %v", err)
+	}
+	if !resp.OK || resp.Status == nil || resp.Status.Path != "a.txt" {
+		t.Errorf("Unexpected status response: %+v", resp)
+	}
+
+	resp, err = sendCtl(socketPath, ctlRequest{Cmd: "change-path", Path: file2})
+	if err != nil || !resp.OK {
+		t.Fatalf("change-path failed: %v %+v", err, resp)
+	}
+	if fs.getPath() != file2 {
+		t.Errorf("Path should be %s, got %s", file2, fs.getPath())
+	}
+
+	resp, _ = sendCtl(socketPath, ctlRequest{Cmd: "change-path", Path: filepath.Join(tempDir, "missing")})
+	if resp.OK {
+		t.Error("change-path to a missing file should fail")
+	}
+
+	fs.acquireClient("10.0.0.1")
+	fs.acquireClient("10.0.0.1")
+	resp, _ = sendCtl(socketPath, ctlRequest{Cmd: "cancel"})
+	if !resp.OK || fs.status.Status != "cancelled" {
+		t.Errorf("cancel should set status to cancelled, got %s", fs.status.Status)
+	}
+	if !fs.acquireClient("10.0.0.2") {
+		t.Fatal("cancel should free the single-client lock")
+	}
+	fs.releaseClient("10.0.0.2")
+	if !fs.acquireClient("10.0.0.3") {
+		t.Error("The next client should get the lock once the previous one released it")
+	}
+	fs.releaseClient("10.0.0.3")
+
+	resp, _ = sendCtl(socketPath, ctlRequest{Cmd: "bogus"})
+	if resp.OK {
+		t.Error("Unknown command should fail")
+	}
+
+	resp, _ = sendCtl(socketPath, ctlRequest{Cmd: "shutdown"})
+	if !resp.OK {
+		t.Error("shutdown should succeed")
+	}
+	select {
+	case <-fs.done:
+	default:
+		t.Error("shutdown should close the done channel")
+	}
+}
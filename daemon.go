@@ -0,0 +1,341 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// daemonSharePrefix is where the daemon mounts each share, by id.
+const daemonSharePrefix = "/s/"
+
+const maxShareIDLength = 64
+
+// shareRequest is the body of POST /admin/shares. Id is optional; a share
+// code like "blue-tiger-42" is picked without one.
+type shareRequest struct {
+	ID       string `json:"id"`
+	Mode     string `json:"mode"`
+	Path     string `json:"path"`
+	RecvDir  string `json:"recv"`
+	Password string `json:"password"`
+}
+
+// daemonShare is one share hosted by the daemon, a FileServer of its own
+// mounted under /s/<id>/.
+type daemonShare struct {
+	ID      string    `json:"id"`
+	Mode    string    `json:"mode"`
+	Path    string    `json:"path"`
+	RecvDir string    `json:"recv,omitempty"`
+	URLs    []string  `json:"urls"`
+	Created time.Time `json:"created"`
+
+	fs      *FileServer
+	handler http.Handler
+}
+
+// close stops the share: its event streams end and background work is
+// waited for. Downloads and uploads already running finish on their own.
+func (s *daemonShare) close() {
+	s.fs.shutdown()
+	if s.fs.sizeCache != nil {
+		s.fs.sizeCache.Close()
+	}
+	s.fs.mirrorWG.Wait()
+	s.fs.notifyWG.Wait()
+}
+
+// daemon keeps one process serving shares that are added and removed at
+// runtime through an admin API, instead of a process per transfer. Shares
+// get the daemon's options, apart from what the request sets.
+type daemon struct {
+	opts        Options
+	basePath    string
+	port        int
+	audit       *auditLog
+	logFile     *rotatingFile
+	trustedNets []*net.IPNet
+	authLimit   authLimiter
+
+	mu     sync.RWMutex
+	shares map[string]*daemonShare
+}
+
+func newDaemon(opts Options) (*daemon, error) {
+	trustedNets, err := parseTrustedProxies(opts.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	return &daemon{
+		opts:        opts,
+		basePath:    normalizeBasePath(opts.BasePath),
+		port:        opts.Port,
+		trustedNets: trustedNets,
+		shares:      make(map[string]*daemonShare),
+	}, nil
+}
+
+func validShareID(id string) bool {
+	if id == "" || len(id) > maxShareIDLength || id[0] == '.' || id[0] == '-' {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// addShare starts serving req. Its options are checked as for a server of
+// that mode started from the command line.
+func (d *daemon) addShare(req shareRequest) (*daemonShare, error) {
+	switch req.Mode {
+	case "send", "recv", "serve", "both":
+	default:
+		return nil, errors.New("mode must be 'send', 'recv', 'serve' or 'both'")
+	}
+	if req.Path == "-" || req.RecvDir == "-" {
+		return nil, errors.New("a daemon share cannot use standard input or output")
+	}
+	id := req.ID
+	if id == "" {
+		id = newShareCode()
+	} else if !validShareID(id) {
+		return nil, fmt.Errorf("invalid id '%s': use up to %d letters, digits, '-', '_' or '.'", id, maxShareIDLength)
+	}
+
+	opts := d.opts
+	opts.Mode, opts.Path, opts.RecvDir, opts.MorePaths = req.Mode, req.Path, req.RecvDir, nil
+	opts.BasePath = d.basePath + daemonSharePrefix + id
+	opts.Password = cmp.Or(req.Password, d.opts.Password)
+	// The daemon keeps the logs open for all its shares.
+	opts.AuditLog, opts.LogFile = "", ""
+	server, _, err := newServer(opts)
+	if err != nil {
+		return nil, err
+	}
+	server.port = d.port
+	server.audit = d.audit
+	server.logFile = d.logFile
+
+	share := &daemonShare{ID: id, Mode: req.Mode, Path: server.getPath(), RecvDir: server.recvDir, Created: server.clock.Now(), fs: server}
+	for _, ip := range getLocalIPs() {
+		share.URLs = append(share.URLs, fmt.Sprintf("http://%s:%d%s/", ip, d.port, opts.BasePath))
+	}
+
+	d.mu.Lock()
+	if _, taken := d.shares[id]; taken {
+		d.mu.Unlock()
+		return nil, errShareExists
+	}
+	share.handler = server.handler()
+	d.shares[id] = share
+	d.mu.Unlock()
+
+	server.refreshSizeCache()
+	return share, nil
+}
+
+var errShareExists = errors.New("a share with this id already exists")
+
+func (d *daemon) removeShare(id string) bool {
+	d.mu.Lock()
+	share, ok := d.shares[id]
+	delete(d.shares, id)
+	d.mu.Unlock()
+	if ok {
+		share.close()
+	}
+	return ok
+}
+
+func (d *daemon) list() []*daemonShare {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	shares := make([]*daemonShare, 0, len(d.shares))
+	for _, s := range d.shares {
+		shares = append(shares, s)
+	}
+	slices.SortFunc(shares, func(a, b *daemonShare) int { return strings.Compare(a.ID, b.ID) })
+	return shares
+}
+
+// requireToken reports whether r carries the daemon's admin token. Wrong
+// tokens lock the client out like wrong passwords do on a share.
+func (d *daemon) requireToken(w http.ResponseWriter, r *http.Request) bool {
+	clientIP := forwardedClientIP(r, d.trustedNets)
+	now := d.opts.Clock.Now()
+	if wait := d.authLimit.lockedFor(clientIP, now); wait > 0 {
+		seconds := int((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		httpError(w, r, fmt.Sprintf("Too many failed attempts, try again in %v", time.Duration(seconds)*time.Second), http.StatusTooManyRequests)
+		return false
+	}
+	token := requestToken(r)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(d.opts.AdminToken)) != 1 {
+		if count, lockout := d.authLimit.fail(clientIP, now); lockout > 0 {
+			fmt.Printf("%s%s locked out of the admin API for %v after %d failed attempts\n", icon("⛔ "), clientIP, lockout, count)
+		}
+		httpError(w, r, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	d.authLimit.succeed(clientIP)
+	return true
+}
+
+// handleShares lists the shares on GET and adds one on POST.
+func (d *daemon) handleShares(w http.ResponseWriter, r *http.Request) {
+	if !d.requireToken(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.list())
+	case http.MethodPost:
+		var req shareRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			httpError(w, r, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		share, err := d.addShare(req)
+		if errors.Is(err, errShareExists) {
+			httpError(w, r, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("%sAdded share %s (%s %s)\n", icon("➕ "), share.ID, share.Mode, share.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(share)
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleShare removes a share on DELETE.
+func (d *daemon) handleShare(w http.ResponseWriter, r *http.Request) {
+	if !d.requireToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	if !d.removeShare(id) {
+		httpError(w, r, "Share not found", http.StatusNotFound)
+		return
+	}
+	fmt.Printf("%sRemoved share %s\n", icon("➖ "), id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "removed", "id": id})
+}
+
+// routeShare hands a request under /s/<id> to that share, which strips
+// its own prefix.
+func (d *daemon) routeShare(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, d.basePath+daemonSharePrefix)
+	id, _, _ := strings.Cut(rest, "/")
+	d.mu.RLock()
+	share, ok := d.shares[id]
+	d.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	share.handler.ServeHTTP(w, r)
+}
+
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(d.basePath+"/admin/shares", withRequestID(http.HandlerFunc(d.handleShares)))
+	mux.Handle(d.basePath+"/admin/shares/{id}", withRequestID(http.HandlerFunc(d.handleShare)))
+	mux.HandleFunc(d.basePath+daemonSharePrefix, d.routeShare)
+	return mux
+}
+
+// runDaemon serves the admin API and the shares added through it until
+// ctx is cancelled, then stops every share.
+func runDaemon(ctx context.Context, opts Options) error {
+	if opts.FS == nil {
+		opts.FS = osFS{}
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	d, err := newDaemon(opts)
+	if err != nil {
+		return err
+	}
+	rotate := logRotation{maxAge: opts.LogMaxAge, keep: opts.LogKeep}
+	if opts.LogMaxSize != "" {
+		rotate.maxSize, _ = parseSize(opts.LogMaxSize)
+	}
+	if opts.AuditLog != "" {
+		audit, err := openAuditLog(opts.AuditLog, rotate, opts.Clock)
+		if err != nil {
+			return fmt.Errorf("cannot open audit log: %v", err)
+		}
+		defer audit.Close()
+		d.audit = audit
+	}
+	if opts.LogFile != "" {
+		logFile, err := openRotatingFile(opts.LogFile, rotate, opts.Clock)
+		if err != nil {
+			return fmt.Errorf("cannot open log file: %v", err)
+		}
+		defer logFile.Close()
+		d.logFile = logFile
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.Port))
+	if err != nil {
+		return err
+	}
+	d.port = listener.Addr().(*net.TCPAddr).Port
+	if opts.MaxConns > 0 {
+		listener = newLimitListener(listener, opts.MaxConns)
+	}
+	server := &http.Server{Handler: d.handler(), ConnContext: connContext}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		}
+	}()
+
+	fmt.Printf("\n%sMode: DAEMON\n", icon("🛰️  "))
+	fmt.Printf("%sVersion: %s\n", icon("🏷️  "), versionString())
+	fmt.Printf("\n%sAdmin API (needs the -admin-token):\n", icon("🔑 "))
+	for _, ip := range getLocalIPs() {
+		fmt.Printf("   http://%s:%d%s/admin/shares\n", ip, d.port, d.basePath)
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, share := range d.list() {
+		d.removeShare(share.ID)
+	}
+	server.Shutdown(shutdownCtx)
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test adding, using and removing shares through the daemon's admin API
+func TestDaemonShares(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "report.pdf")
+	os.WriteFile(report, []byte("quarterly report"), 0644)
+
+	d, err := newDaemon(Options{AdminToken: "secret"})
+	if err != nil {
+		t.Fatalf("newDaemon error: %v", err)
+	}
+	server := httptest.NewServer(d.handler())
+	defer server.Close()
+
+	call := func(method, path, token, body string) (int, string) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+		expect string
+	}{
+		{"POST", "/admin/shares", "", `{"id":"q3","mode":"send","path":"` + report + `"}`, http.StatusUnauthorized, ""},
+		{"POST", "/admin/shares", "wrong", `{"id":"q3","mode":"send","path":"` + report + `"}`, http.StatusUnauthorized, ""},
+		{"POST", "/admin/shares", "secret", `{"id":"q3","mode":"send","path":"` + report + `"}`, http.StatusCreated, `/s/q3/"`},
+		{"POST", "/admin/shares", "secret", `{"id":"q3","mode":"serve","path":"` + dir + `"}`, http.StatusConflict, ""},
+		{"POST", "/admin/shares", "secret", `{"id":"inbox","mode":"recv","path":"` + filepath.Join(dir, "in") + `"}`, http.StatusCreated, `"mode":"recv"`},
+		{"POST", "/admin/shares", "secret", `{"id":"x","mode":"clipboard"}`, http.StatusBadRequest, "mode must be"},
+		{"POST", "/admin/shares", "secret", `{"id":"../up","mode":"send","path":"` + report + `"}`, http.StatusBadRequest, "invalid id"},
+		{"POST", "/admin/shares", "secret", `{"id":"gone","mode":"send","path":"` + filepath.Join(dir, "missing") + `"}`, http.StatusBadRequest, "cannot access"},
+		{"GET", "/admin/shares", "secret", "", http.StatusOK, `"id":"inbox"`},
+		{"GET", "/s/q3/api/download", "", "", http.StatusOK, "quarterly report"},
+		{"GET", "/s/q3", "", "", http.StatusOK, "<html"},
+		{"GET", "/s/nope/api/download", "", "", http.StatusNotFound, ""},
+		{"DELETE", "/admin/shares/q3", "secret", "", http.StatusOK, `"removed"`},
+		{"DELETE", "/admin/shares/q3", "secret", "", http.StatusNotFound, ""},
+		{"GET", "/s/q3/api/download", "", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		status, body := call(test.method, test.path, test.token, test.body)
+		if status != test.status {
+			t.Errorf("%s %s = %d, expected %d: %s", test.method, test.path, status, test.status, body)
+			continue
+		}
+		if !strings.Contains(body, test.expect) {
+			t.Errorf("%s %s body = %q, expected it to contain %q", test.method, test.path, body, test.expect)
+		}
+	}
+
+	_, body := call("GET", "/admin/shares", "secret", "")
+	var shares []daemonShare
+	json.Unmarshal([]byte(body), &shares)
+	if len(shares) != 1 || shares[0].ID != "inbox" {
+		t.Errorf("Shares after removal = %+v, expected only inbox", shares)
+	}
+}
+
+// Test share ids are safe to put in a URL path
+func TestValidShareID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"blue-tiger-42", true},
+		{"Q3_report.v2", true},
+		{"", false},
+		{"..", false},
+		{"-flag", false},
+		{"a/b", false},
+		{"with space", false},
+		{strings.Repeat("a", maxShareIDLength+1), false},
+	}
+	for _, test := range tests {
+		if got := validShareID(test.id); got != test.valid {
+			t.Errorf("validShareID(%q) = %t, expected %t", test.id, got, test.valid)
+		}
+	}
+}
+
+// Test wrong admin tokens lock the client out of the admin API
+func TestDaemonLockout(t *testing.T) {
+	clock := &tickClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	d, err := newDaemon(Options{AdminToken: "secret", Clock: clock})
+	if err != nil {
+		t.Fatalf("newDaemon error: %v", err)
+	}
+	handler := d.handler()
+
+	tests := []struct {
+		token    string
+		wait     time.Duration
+		expected int
+	}{
+		{"wrong", 0, http.StatusUnauthorized},
+		{"wrong", 0, http.StatusUnauthorized},
+		{"wrong", 0, http.StatusUnauthorized},
+		{"wrong", 0, http.StatusUnauthorized},
+		{"secret", 0, http.StatusTooManyRequests},
+		{"secret", time.Second, http.StatusOK},
+		{"wrong", 0, http.StatusUnauthorized},
+	}
+	for i, test := range tests {
+		clock.mu.Lock()
+		clock.now = clock.now.Add(test.wait)
+		clock.mu.Unlock()
+		req := httptest.NewRequest("GET", "/admin/shares", nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("Attempt %d with %q = %d, expected %d", i+1, test.token, rec.Code, test.expected)
+		}
+	}
+}
+
+// Test daemon mode refuses options that belong to a single server
+func TestDaemonOptions(t *testing.T) {
+	const message = "daemon cannot be combined with -auto-exit, -code, -copy, -ctl-socket, -grpc-addr, -dlna, -cast, -torrent, -bandwidth-budget or -signed-ttl"
+	tests := []struct {
+		flag string
+		opts Options
+	}{
+		{"-code", Options{Mode: "daemon", AdminToken: "secret", ShareCode: true}},
+		{"-copy", Options{Mode: "daemon", AdminToken: "secret", CopyURL: true}},
+		{"-grpc-addr", Options{Mode: "daemon", AdminToken: "secret", GRPCAddr: ":50051"}},
+		{"-signed-ttl", Options{Mode: "daemon", AdminToken: "secret", SignedTTL: time.Hour}},
+	}
+	for _, test := range tests {
+		if err := test.opts.validate(); err == nil || err.Error() != message {
+			t.Errorf("daemon with %s: got %v, expected %q", test.flag, err, message)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// registerDebug mounts pprof and /debug/vars. The command line is left out
+// of both, as it can hold the password and tokens.
+func (fs *FileServer) registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", fs.debugAccess(pprof.Index))
+	mux.HandleFunc("/debug/pprof/profile", fs.debugAccess(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", fs.debugAccess(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", fs.debugAccess(pprof.Trace))
+	mux.HandleFunc("/debug/vars", fs.debugAccess(fs.handleDebugVars))
+}
+
+// debugAccess lets clients on this machine use the debug endpoints, and
+// others only with admin access.
+func (fs *FileServer) debugAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if directLoopback(r) {
+			next(w, r)
+			return
+		}
+		if !fs.canManage() {
+			auditNote(r, "debug from another machine")
+			httpError(w, r, "Debug endpoints are only available from this machine (or with -admin-token)", http.StatusForbidden)
+			return
+		}
+		if fs.requireAdmin(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// directLoopback reports whether r comes from this machine itself. A proxy
+// on the same host connects from loopback too, so anything it forwarded,
+// trusted or not, doesn't count.
+func directLoopback(r *http.Request) bool {
+	for _, header := range []string{"X-Forwarded-For", "X-Real-IP", "Forwarded"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleDebugVars mirrors the expvar output format so existing tooling can
+// scrape it, with fileshare's own counters added alongside memstats.
+func (fs *FileServer) handleDebugVars(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	fs.statusMu.RLock()
+	status := *fs.status
+	fs.statusMu.RUnlock()
+
+	fs.sseMu.RLock()
+	sseClients := len(fs.sseClients)
+	fs.sseMu.RUnlock()
+
+	vars := map[string]interface{}{
+		"memstats": mem,
+		"runtime": map[string]interface{}{
+			"goroutines": runtime.NumGoroutine(),
+			"num_cpu":    runtime.NumCPU(),
+			"gomaxprocs": runtime.GOMAXPROCS(0),
+			"go_version": runtime.Version(),
+		},
+		"fileshare": map[string]interface{}{
+			"version":     versionString(),
+			"uptime_secs": int64(time.Since(fs.started).Seconds()),
+			"sse_clients": sseClients,
+			"sse_dropped": fs.sseDropped.Load(),
+			"status":      status.Status,
+			"transferred": status.Transferred,
+			"size":        status.Size,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(vars)
+}
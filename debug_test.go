@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test debug endpoints registration
+func TestDebugVars(t *testing.T) {
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	mux := http.NewServeMux()
+	fs.registerDebug(mux)
+
+	local := func(target string) *http.Request {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = "127.0.0.1:40000"
+		return req
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, local("/debug/vars"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/vars = %d, expected 200", rec.Code)
+	}
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("Invalid JSON from /debug/vars: %v", err)
+	}
+	for _, key := range []string{"memstats", "runtime", "fileshare"} {
+		if _, ok := vars[key]; !ok {
+			t.Errorf("/debug/vars missing %q", key)
+		}
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("/debug/vars should not expose the command line")
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, local("/debug/pprof/"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /debug/pprof/ = %d, expected 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, local("/debug/pprof/cmdline"))
+	if rec.Code == http.StatusOK {
+		t.Error("GET /debug/pprof/cmdline should not serve the command line")
+	}
+}
+
+// Test debug endpoints refuse other machines without admin access
+func TestDebugAccess(t *testing.T) {
+	tests := []struct {
+		remote     string
+		forwarded  string
+		adminToken string
+		token      string
+		expected   int
+	}{
+		{"127.0.0.1:40000", "", "", "", http.StatusOK},
+		{"[::1]:40000", "", "", "", http.StatusOK},
+		{"127.0.0.1:40000", "X-Forwarded-For: 192.168.1.20", "", "", http.StatusForbidden},
+		{"127.0.0.1:40000", "Forwarded: for=192.168.1.20", "", "", http.StatusForbidden},
+		{"127.0.0.1:40000", "X-Real-IP: 192.168.1.20", "s3cret", "", http.StatusUnauthorized},
+		{"127.0.0.1:40000", "X-Forwarded-For: 192.168.1.20", "s3cret", "s3cret", http.StatusOK},
+		{"192.168.1.20:40000", "", "", "", http.StatusForbidden},
+		{"192.168.1.20:40000", "", "s3cret", "", http.StatusUnauthorized},
+		{"192.168.1.20:40000", "", "s3cret", "wrong", http.StatusUnauthorized},
+		{"192.168.1.20:40000", "", "s3cret", "s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		fs := NewFileServer("send", "/tmp", 8080, false)
+		fs.adminToken = tt.adminToken
+		mux := http.NewServeMux()
+		fs.registerDebug(mux)
+		req := httptest.NewRequest("GET", "/debug/vars", nil)
+		req.RemoteAddr = tt.remote
+		if header, value, ok := strings.Cut(tt.forwarded, ": "); ok {
+			req.Header.Set(header, value)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.expected {
+			t.Errorf("/debug/vars from %s %s with token %q = %d, expected %d", tt.remote, tt.forwarded, tt.token, rec.Code, tt.expected)
+		}
+	}
+}
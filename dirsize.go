@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// dirSizeCache keeps the size of a watched directory tree up to date from
+// filesystem notifications, so directory downloads don't re-walk the whole
+// tree on every request. Sizes are tracked per file and aggregated per
+// subtree.
+type dirSizeCache struct {
+	root    string
+	mu      sync.RWMutex
+	files   map[string]int64
+	dirs    map[string]int64
+	watcher *fsnotify.Watcher
+	stale   bool
+	done    chan struct{}
+
+	// onChange, if set, is called after every create/write/remove/rename
+	// below root has been applied.
+	onChange func(path string)
+}
+
+func newDirSizeCache(root string, onChange func(path string)) (*dirSizeCache, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	c := &dirSizeCache{
+		root:     filepath.Clean(root),
+		files:    make(map[string]int64),
+		dirs:     make(map[string]int64),
+		watcher:  watcher,
+		done:     make(chan struct{}),
+		onChange: onChange,
+	}
+	if err := c.scan(c.root); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *dirSizeCache) Close() error {
+	close(c.done)
+	return c.watcher.Close()
+}
+
+// Size returns the cached total, falling back to a fresh walk if
+// notifications were lost.
+func (c *dirSizeCache) Size() int64 {
+	c.mu.RLock()
+	stale := c.stale
+	total := c.dirs[c.root]
+	c.mu.RUnlock()
+	if !stale {
+		return total
+	}
+
+	c.mu.Lock()
+	c.files = make(map[string]int64)
+	c.dirs = make(map[string]int64)
+	c.stale = false
+	c.mu.Unlock()
+	c.scan(c.root)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dirs[c.root]
+}
+
+// SubtreeSize returns the cached size of a directory inside the share.
+func (c *dirSizeCache) SubtreeSize(dir string) int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dirs[filepath.Clean(dir)]
+}
+
+func (c *dirSizeCache) scan(dir string) error {
+	return walkParallel(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := c.watcher.Add(p); err != nil {
+				c.mu.Lock()
+				c.stale = true
+				c.mu.Unlock()
+			}
+			return nil
+		}
+		c.setFile(p, info.Size())
+		return nil
+	})
+}
+
+func (c *dirSizeCache) setFile(p string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delta := size - c.files[p]
+	c.files[p] = size
+	c.addToAncestors(p, delta)
+}
+
+func (c *dirSizeCache) remove(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size, ok := c.files[p]; ok {
+		delete(c.files, p)
+		c.addToAncestors(p, -size)
+		return
+	}
+
+	// A removed directory takes its whole subtree with it.
+	prefix := p + string(filepath.Separator)
+	for file, size := range c.files {
+		if strings.HasPrefix(file, prefix) {
+			delete(c.files, file)
+			c.addToAncestors(file, -size)
+		}
+	}
+	for dir := range c.dirs {
+		if dir == p || strings.HasPrefix(dir, prefix) {
+			delete(c.dirs, dir)
+		}
+	}
+}
+
+func (c *dirSizeCache) addToAncestors(p string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		c.dirs[dir] += delta
+		if dir == c.root || len(dir) < len(c.root) {
+			return
+		}
+	}
+}
+
+func (c *dirSizeCache) run() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case event, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+			c.apply(event)
+		case _, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Most likely an event queue overflow; rebuild on next read.
+			c.mu.Lock()
+			c.stale = true
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *dirSizeCache) apply(event fsnotify.Event) {
+	p := filepath.Clean(event.Name)
+	switch {
+	case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+		info, err := os.Lstat(p)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			c.scan(p)
+		} else {
+			c.setFile(p, info.Size())
+		}
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		c.remove(p)
+	default:
+		return
+	}
+	if c.onChange != nil {
+		c.onChange(p)
+	}
+}
+
+// walkedSizeTTL is how long the walked size of a shared directory is
+// reused when it isn't watched, so downloads started together walk once.
+const walkedSizeTTL = 5 * time.Second
+
+// walkedSize is the last walked size of a shared directory.
+type walkedSize struct {
+	root string
+	size int64
+	at   time.Time
+}
+
+// targetSize sums the sizes of sources, using the cache for the shared
+// directory when it is watched and a recent walk otherwise.
+func (fs *FileServer) targetSize(sources []archiveSource) int64 {
+	if len(sources) != 1 || sources[0].filtered() {
+		return sourcesSize(sources)
+	}
+	root := filepath.Clean(sources[0].path)
+	fs.pathMu.RLock()
+	cache := fs.sizeCache
+	fs.pathMu.RUnlock()
+	if cache != nil && cache.root == root {
+		return cache.Size()
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return sourcesSize(sources)
+	}
+
+	now := fs.clock.Now()
+	fs.walkedMu.Lock()
+	last := fs.walked
+	fs.walkedMu.Unlock()
+	if last.root == root && now.Sub(last.at) < walkedSizeTTL {
+		return last.size
+	}
+	size := sourcesSize(sources)
+	fs.walkedMu.Lock()
+	fs.walked = walkedSize{root: root, size: size, at: now}
+	fs.walkedMu.Unlock()
+	return size
+}
+
+// refreshSizeCache (re)builds the size cache when a shared directory is
+// watched with -watch. Without it no notifications are set up, as a large
+// tree can use up the system's inotify watches; sizes are walked on demand.
+// Failure to set up notifications is not fatal either.
+func (fs *FileServer) refreshSizeCache() {
+	target := fs.getPath()
+	var cache *dirSizeCache
+	if info, err := os.Stat(target); err == nil && info.IsDir() && fs.servesDownloads() && fs.watch {
+		var err error
+		cache, err = newDirSizeCache(target, fs.notifyFilesChanged)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot watch %s: %v\n", target, err)
+		}
+	}
+
+	fs.pathMu.Lock()
+	old := fs.sizeCache
+	fs.sizeCache = cache
+	fs.pathMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
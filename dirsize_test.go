@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForSize(c *dirSizeCache, expected int64) int64 {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if size := c.Size(); size == expected {
+			return size
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c.Size()
+}
+
+// Test incremental directory size tracking
+func TestDirSizeCache(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_dirsize_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	os.WriteFile(filepath.Join(tempDir, "a.txt"), make([]byte, 100), 0644)
+	sub := filepath.Join(tempDir, "sub")
+	os.Mkdir(sub, 0755)
+	os.WriteFile(filepath.Join(sub, "b.txt"), make([]byte, 50), 0644)
+
+	cache, err := newDirSizeCache(tempDir, nil)
+	if err != nil {
+		t.Fatalf("newDirSizeCache error: %v", err)
+	}
+	defer cache.Close()
+
+	if size := cache.Size(); size != 150 {
+		t.Errorf("Initial size = %d, expected 150", size)
+	}
+	if size := cache.SubtreeSize(sub); size != 50 {
+		t.Errorf("Subtree size = %d, expected 50", size)
+	}
+
+	os.WriteFile(filepath.Join(sub, "c.txt"), make([]byte, 25), 0644)
+	if size := waitForSize(cache, 175); size != 175 {
+		t.Errorf("Size after create = %d, expected 175", size)
+	}
+
+	os.WriteFile(filepath.Join(tempDir, "a.txt"), make([]byte, 10), 0644)
+	if size := waitForSize(cache, 85); size != 85 {
+		t.Errorf("Size after shrink = %d, expected 85", size)
+	}
+
+	newDir := filepath.Join(tempDir, "new")
+	os.Mkdir(newDir, 0755)
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(filepath.Join(newDir, "d.txt"), make([]byte, 15), 0644)
+	if size := waitForSize(cache, 100); size != 100 {
+		t.Errorf("Size after nested create = %d, expected 100", size)
+	}
+
+	os.RemoveAll(sub)
+	if size := waitForSize(cache, 25); size != 25 {
+		t.Errorf("Size after removing subtree = %d, expected 25", size)
+	}
+
+	cache.mu.Lock()
+	cache.stale = true
+	cache.mu.Unlock()
+	if size := cache.Size(); size != 25 {
+		t.Errorf("Size after forced rescan = %d, expected 25", size)
+	}
+}
+
+// Test a directory share without -watch is walked on demand, not watched
+func TestTargetSizeWithoutWatch(t *testing.T) {
+	tempDir := t.TempDir()
+	os.WriteFile(filepath.Join(tempDir, "a.txt"), make([]byte, 100), 0644)
+
+	clock := &tickClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	fs := NewFileServer("send", tempDir, 8080, false)
+	fs.clock = clock
+	fs.refreshSizeCache()
+	if fs.sizeCache != nil {
+		fs.sizeCache.Close()
+		t.Fatal("Expected no watches without -watch")
+	}
+
+	sources := []archiveSource{{path: tempDir}}
+	if size := fs.targetSize(sources); size != 100 {
+		t.Errorf("Size = %d, expected 100", size)
+	}
+	os.WriteFile(filepath.Join(tempDir, "b.txt"), make([]byte, 50), 0644)
+	if size := fs.targetSize(sources); size != 100 {
+		t.Errorf("Size within %s = %d, expected the walked 100", walkedSizeTTL, size)
+	}
+	clock.mu.Lock()
+	clock.now = clock.now.Add(walkedSizeTTL)
+	clock.mu.Unlock()
+	if size := fs.targetSize(sources); size != 150 {
+		t.Errorf("Size after %s = %d, expected 150", walkedSizeTTL, size)
+	}
+}
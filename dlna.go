@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A minimal DLNA/UPnP MediaServer: SSDP announcements plus a read-only
+// ContentDirectory over the shared files, enough for TVs and media players
+// to browse and play them.
+
+const (
+	ssdpAddr       = "239.255.255.250:1900"
+	ssdpMaxAge     = 1800
+	dlnaDeviceType = "urn:schemas-upnp-org:device:MediaServer:1"
+	dlnaCDSType    = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	dlnaCMSType    = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	dlnaFeatures   = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+)
+
+// dlnaUUID derives a stable device UUID so renderers recognize the server
+// across restarts.
+func dlnaUUID(host, root string) string {
+	sum := md5.Sum([]byte(host + "\x00" + root))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func (fs *FileServer) dlnaFriendlyName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("FileShare: %s (%s)", fs.shareName(), host)
+}
+
+// dlnaClass maps a file to its UPnP item class, or "" if renderers can't
+// play it.
+func dlnaClass(name string) string {
+	switch mediaKind(name) {
+	case "video":
+		return "object.item.videoItem"
+	case "audio":
+		return "object.item.audioItem.musicTrack"
+	}
+	if isImage(name) {
+		return "object.item.imageItem.photo"
+	}
+	return ""
+}
+
+// dlnaRoots returns the shared paths. A single shared directory is browsed
+// directly; otherwise each shared file or directory is a top-level object.
+func (fs *FileServer) dlnaRoots() (paths []string, dirShare bool) {
+	paths = fs.getSources()
+	if len(paths) == 0 {
+		paths = []string{fs.getPath()}
+	}
+	if len(paths) == 1 {
+		if info, err := os.Stat(paths[0]); err == nil && info.IsDir() {
+			return paths, true
+		}
+	}
+	return paths, false
+}
+
+// dlnaResolve maps an object ID (a slash-separated path below the share;
+// "0" is the root) to a filesystem path.
+func (fs *FileServer) dlnaResolve(id string) (string, error) {
+	roots, dirShare := fs.dlnaRoots()
+	if dirShare {
+		if id == "0" {
+			return roots[0], nil
+		}
+		return resolveInside(roots[0], id)
+	}
+	first, rest, _ := strings.Cut(id, "/")
+	for _, root := range roots {
+		if filepath.Base(root) != first {
+			continue
+		}
+		if rest == "" {
+			return root, nil
+		}
+		return resolveInside(root, rest)
+	}
+	return "", os.ErrNotExist
+}
+
+type dlnaObject struct {
+	id       string
+	parentID string
+	title    string
+	path     string
+	isDir    bool
+	size     int64
+}
+
+func dlnaParentID(id string) string {
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		return id[:i]
+	}
+	return "0"
+}
+
+// dlnaChildren lists the folders and playable files inside object id.
+func (fs *FileServer) dlnaChildren(id string) ([]dlnaObject, error) {
+	var paths []string
+	prefix := ""
+	if roots, dirShare := fs.dlnaRoots(); id == "0" && !dirShare {
+		paths = roots
+	} else {
+		dir, err := fs.dlnaResolve(id)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+		if id != "0" {
+			prefix = id + "/"
+		}
+	}
+
+	var objects []dlnaObject
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if !info.IsDir() && dlnaClass(p) == "" {
+			continue
+		}
+		objects = append(objects, dlnaObject{
+			id:       prefix + info.Name(),
+			parentID: id,
+			title:    info.Name(),
+			path:     p,
+			isDir:    info.IsDir(),
+			size:     info.Size(),
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].isDir != objects[j].isDir {
+			return objects[i].isDir
+		}
+		return strings.ToLower(objects[i].title) < strings.ToLower(objects[j].title)
+	})
+	return objects, nil
+}
+
+func (fs *FileServer) dlnaMetadata(id string) (dlnaObject, error) {
+	if id == "0" {
+		return dlnaObject{id: "0", parentID: "-1", title: fs.shareName(), isDir: true}, nil
+	}
+	p, err := fs.dlnaResolve(id)
+	if err != nil {
+		return dlnaObject{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return dlnaObject{}, err
+	}
+	return dlnaObject{id: id, parentID: dlnaParentID(id), title: info.Name(), path: p, isDir: info.IsDir(), size: info.Size()}, nil
+}
+
+func (fs *FileServer) dlnaMediaURL(host, id string) string {
+	segments := strings.Split(id, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return "http://" + host + fs.basePath + "/dlna/media/" + strings.Join(segments, "/")
+}
+
+func (fs *FileServer) didl(host string, objects []dlnaObject) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
+	for _, o := range objects {
+		id, parent, title := html.EscapeString(o.id), html.EscapeString(o.parentID), html.EscapeString(o.title)
+		if o.isDir {
+			fmt.Fprintf(&b, `<container id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title><upnp:class>object.container.storageFolder</upnp:class></container>`,
+				id, parent, title)
+			continue
+		}
+		ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(o.title)))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		ctype, _, _ = strings.Cut(ctype, ";")
+		fmt.Fprintf(&b, `<item id="%s" parentID="%s" restricted="1"><dc:title>%s</dc:title><upnp:class>%s</upnp:class><res protocolInfo="http-get:*:%s:%s" size="%d">%s</res></item>`,
+			id, parent, title, dlnaClass(o.title), ctype, dlnaFeatures, o.size, html.EscapeString(fs.dlnaMediaURL(host, o.id)))
+	}
+	b.WriteString(`</DIDL-Lite>`)
+	return b.String()
+}
+
+type soapBrowse struct {
+	ObjectID       string `xml:"ObjectID"`
+	BrowseFlag     string `xml:"BrowseFlag"`
+	StartingIndex  int    `xml:"StartingIndex"`
+	RequestedCount int    `xml:"RequestedCount"`
+}
+
+type soapEnvelope struct {
+	Body struct {
+		Browse soapBrowse `xml:"Browse"`
+	} `xml:"Body"`
+}
+
+func writeSOAP(w http.ResponseWriter, service, action, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Ext", "")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`,
+		action, service, body, action)
+}
+
+func writeSOAPFault(w http.ResponseWriter, code int, desc string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, code, html.EscapeString(desc))
+}
+
+// soapAction returns the action name from a SOAPACTION header such as
+// "urn:schemas-upnp-org:service:ContentDirectory:1#Browse".
+func soapAction(r *http.Request) string {
+	action := strings.Trim(r.Header.Get("SOAPACTION"), `"`)
+	if i := strings.LastIndex(action, "#"); i >= 0 {
+		return action[i+1:]
+	}
+	return action
+}
+
+func (fs *FileServer) handleDLNAContentDirectory(w http.ResponseWriter, r *http.Request) {
+	switch soapAction(r) {
+	case "Browse":
+	case "GetSystemUpdateID":
+		writeSOAP(w, dlnaCDSType, "GetSystemUpdateID", "<Id>1</Id>")
+		return
+	case "GetSearchCapabilities":
+		writeSOAP(w, dlnaCDSType, "GetSearchCapabilities", "<SearchCaps></SearchCaps>")
+		return
+	case "GetSortCapabilities":
+		writeSOAP(w, dlnaCDSType, "GetSortCapabilities", "<SortCaps></SortCaps>")
+		return
+	default:
+		writeSOAPFault(w, 401, "Invalid Action")
+		return
+	}
+
+	var env soapEnvelope
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&env); err != nil {
+		writeSOAPFault(w, 402, "Invalid Args")
+		return
+	}
+	req := env.Body.Browse
+
+	var objects []dlnaObject
+	total := 0
+	if req.BrowseFlag == "BrowseMetadata" {
+		obj, err := fs.dlnaMetadata(req.ObjectID)
+		if err != nil {
+			writeSOAPFault(w, 701, "No such object")
+			return
+		}
+		objects, total = []dlnaObject{obj}, 1
+	} else {
+		children, err := fs.dlnaChildren(req.ObjectID)
+		if err != nil {
+			writeSOAPFault(w, 701, "No such object")
+			return
+		}
+		total = len(children)
+		start := req.StartingIndex
+		if start > total {
+			start = total
+		}
+		end := total
+		if req.RequestedCount > 0 && start+req.RequestedCount < end {
+			end = start + req.RequestedCount
+		}
+		objects = children[start:end]
+	}
+
+	writeSOAP(w, dlnaCDSType, "Browse", fmt.Sprintf(
+		"<Result>%s</Result><NumberReturned>%d</NumberReturned><TotalMatches>%d</TotalMatches><UpdateID>1</UpdateID>",
+		html.EscapeString(fs.didl(r.Host, objects)), len(objects), total))
+}
+
+func (fs *FileServer) handleDLNAConnectionManager(w http.ResponseWriter, r *http.Request) {
+	switch soapAction(r) {
+	case "GetProtocolInfo":
+		writeSOAP(w, dlnaCMSType, "GetProtocolInfo", "<Source>http-get:*:*:*</Source><Sink></Sink>")
+	case "GetCurrentConnectionIDs":
+		writeSOAP(w, dlnaCMSType, "GetCurrentConnectionIDs", "<ConnectionIDs>0</ConnectionIDs>")
+	default:
+		writeSOAPFault(w, 401, "Invalid Action")
+	}
+}
+
+// handleDLNAEvents accepts event subscriptions without ever sending
+// events; the content is static, but some renderers refuse servers that
+// reject SUBSCRIBE.
+func (fs *FileServer) handleDLNAEvents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		w.Header().Set("SID", "uuid:"+dlnaUUID(r.RemoteAddr, time.Now().String()))
+		w.Header().Set("TIMEOUT", fmt.Sprintf("Second-%d", ssdpMaxAge))
+	case "UNSUBSCRIBE":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (fs *FileServer) handleDLNAMedia(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/dlna/media/")
+	p, err := fs.dlnaResolve(id)
+	if err != nil || dlnaClass(p) == "" {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Header.Get("Range") == "" || strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+		fs.logRequest(r, fmt.Sprintf("%s started playing %s via DLNA", fs.clientLabel(fs.getClientIP(r)), info.Name()))
+	}
+	if ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(p))); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", dlnaFeatures)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+func (fs *FileServer) handleDLNADescription(w http.ResponseWriter, r *http.Request) {
+	base := fs.basePath + "/dlna/"
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0" xmlns:dlna="urn:schemas-dlna-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>%s</deviceType>
+    <friendlyName>%s</friendlyName>
+    <manufacturer>FileShare</manufacturer>
+    <modelName>FileShare</modelName>
+    <modelNumber>%s</modelNumber>
+    <UDN>uuid:%s</UDN>
+    <dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+    <serviceList>
+      <service>
+        <serviceType>%s</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ContentDirectory</serviceId>
+        <SCPDURL>%scds.xml</SCPDURL>
+        <controlURL>%scontrol/cds</controlURL>
+        <eventSubURL>%sevents/cds</eventSubURL>
+      </service>
+      <service>
+        <serviceType>%s</serviceType>
+        <serviceId>urn:upnp-org:serviceId:ConnectionManager</serviceId>
+        <SCPDURL>%scms.xml</SCPDURL>
+        <controlURL>%scontrol/cms</controlURL>
+        <eventSubURL>%sevents/cms</eventSubURL>
+      </service>
+    </serviceList>
+  </device>
+</root>
+`, dlnaDeviceType, html.EscapeString(fs.dlnaFriendlyName()), html.EscapeString(versionString()), fs.dlnaID,
+		dlnaCDSType, base, base, base, dlnaCMSType, base, base, base)
+}
+
+const dlnaCDSDescription = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action><name>Browse</name><argumentList>
+      <argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+      <argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+      <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+      <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+      <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+      <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+      <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+      <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSearchCapabilities</name><argumentList>
+      <argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSortCapabilities</name><argumentList>
+      <argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetSystemUpdateID</name><argumentList>
+      <argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+    </argumentList></action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>
+`
+
+const dlnaCMSDescription = `<?xml version="1.0" encoding="utf-8"?>
+<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action><name>GetProtocolInfo</name><argumentList>
+      <argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+      <argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+    </argumentList></action>
+    <action><name>GetCurrentConnectionIDs</name><argumentList>
+      <argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+    </argumentList></action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>
+`
+
+// registerDLNA mounts the UPnP description, control and media endpoints.
+func (fs *FileServer) registerDLNA(mux *http.ServeMux) {
+	mux.HandleFunc("/dlna/device.xml", fs.handleDLNADescription)
+	mux.HandleFunc("/dlna/cds.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.Write([]byte(dlnaCDSDescription))
+	})
+	mux.HandleFunc("/dlna/cms.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.Write([]byte(dlnaCMSDescription))
+	})
+	mux.HandleFunc("/dlna/control/cds", fs.handleDLNAContentDirectory)
+	mux.HandleFunc("/dlna/control/cms", fs.handleDLNAConnectionManager)
+	mux.HandleFunc("/dlna/events/", fs.handleDLNAEvents)
+	mux.HandleFunc("/dlna/media/", fs.handleDLNAMedia)
+}
+
+// SSDP discovery.
+
+func dlnaNotificationTypes(uuid string) [][2]string {
+	udn := "uuid:" + uuid
+	return [][2]string{
+		{"upnp:rootdevice", udn + "::upnp:rootdevice"},
+		{udn, udn},
+		{dlnaDeviceType, udn + "::" + dlnaDeviceType},
+		{dlnaCDSType, udn + "::" + dlnaCDSType},
+		{dlnaCMSType, udn + "::" + dlnaCMSType},
+	}
+}
+
+// ssdpResponses builds the unicast replies to an M-SEARCH for st.
+func ssdpResponses(st, uuid, location string) []string {
+	var replies []string
+	for _, nt := range dlnaNotificationTypes(uuid) {
+		if st != "ssdp:all" && st != nt[0] {
+			continue
+		}
+		replies = append(replies, fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+			"CACHE-CONTROL: max-age=%d\r\n"+
+			"DATE: %s\r\n"+
+			"EXT:\r\n"+
+			"LOCATION: %s\r\n"+
+			"SERVER: FileShare/%s UPnP/1.0 DLNADOC/1.50\r\n"+
+			"ST: %s\r\n"+
+			"USN: %s\r\n\r\n",
+			ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), location, version, nt[0], nt[1]))
+	}
+	return replies
+}
+
+func ssdpNotify(uuid, location, nts string) []string {
+	var messages []string
+	for _, nt := range dlnaNotificationTypes(uuid) {
+		messages = append(messages, fmt.Sprintf("NOTIFY * HTTP/1.1\r\n"+
+			"HOST: %s\r\n"+
+			"CACHE-CONTROL: max-age=%d\r\n"+
+			"LOCATION: %s\r\n"+
+			"NT: %s\r\n"+
+			"NTS: %s\r\n"+
+			"SERVER: FileShare/%s UPnP/1.0 DLNADOC/1.50\r\n"+
+			"USN: %s\r\n\r\n",
+			ssdpAddr, ssdpMaxAge, location, nt[0], nts, version, nt[1]))
+	}
+	return messages
+}
+
+// localIPFor returns the local address used to reach remote.
+func localIPFor(remote *net.UDPAddr) (string, error) {
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+func (fs *FileServer) dlnaLocation(ip string) string {
+	return fmt.Sprintf("http://%s:%d%s/dlna/device.xml", ip, fs.port, fs.basePath)
+}
+
+// startSSDP answers M-SEARCH requests and announces the server until the
+// server shuts down, then says goodbye.
+func (fs *FileServer) startSSDP() error {
+	group, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+			if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+				continue
+			}
+			ip, err := localIPFor(from)
+			if err != nil {
+				continue
+			}
+			for _, reply := range ssdpResponses(req.Header.Get("ST"), fs.dlnaID, fs.dlnaLocation(ip)) {
+				conn.WriteToUDP([]byte(reply), from)
+			}
+		}
+	}()
+
+	go func() {
+		defer conn.Close()
+		announce := func(nts string) {
+			ip, err := localIPFor(group)
+			if err != nil {
+				return
+			}
+			for _, msg := range ssdpNotify(fs.dlnaID, fs.dlnaLocation(ip), nts) {
+				conn.WriteToUDP([]byte(msg), group)
+			}
+		}
+		announce("ssdp:alive")
+		ticker := time.NewTicker(ssdpMaxAge / 2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				announce("ssdp:alive")
+			case <-fs.done:
+				announce("ssdp:byebye")
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func browseRequest(objectID, flag string) *http.Request {
+	body := `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` +
+		`<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">` +
+		`<ObjectID>` + objectID + `</ObjectID><BrowseFlag>` + flag + `</BrowseFlag>` +
+		`<Filter>*</Filter><StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount><SortCriteria></SortCriteria>` +
+		`</u:Browse></s:Body></s:Envelope>`
+	req := httptest.NewRequest("POST", "/dlna/control/cds", strings.NewReader(body))
+	req.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+	req.Host = "192.168.1.5:8080"
+	return req
+}
+
+// Test ContentDirectory browsing
+func TestDLNABrowse(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_dlna_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.MkdirAll(filepath.Join(tempDir, "Shows"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "Shows", "pilot.mp4"), []byte("video"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "song one.mp3"), []byte("audio"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("text"), 0644)
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	fs.dlna = true
+
+	tests := []struct {
+		objectID string
+		flag     string
+		contains []string
+		excludes []string
+	}{
+		{"0", "BrowseDirectChildren",
+			[]string{`<container id="Shows"`, `<item id="song one.mp3"`, "object.item.audioItem.musicTrack",
+				"http://192.168.1.5:8080/dlna/media/song%20one.mp3", "<TotalMatches>2</TotalMatches>"},
+			[]string{"notes.txt"}},
+		{"Shows", "BrowseDirectChildren",
+			[]string{`<item id="Shows/pilot.mp4" parentID="Shows"`, "video/mp4", "object.item.videoItem"},
+			nil},
+		{"0", "BrowseMetadata",
+			[]string{`<container id="0" parentID="-1"`, "<NumberReturned>1</NumberReturned>"},
+			nil},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleDLNAContentDirectory(rec, browseRequest(test.objectID, test.flag))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Browse(%s, %s) = %d, expected 200", test.objectID, test.flag, rec.Code)
+			continue
+		}
+		body := html.UnescapeString(rec.Body.String())
+		for _, want := range test.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("Browse(%s, %s) missing %q", test.objectID, test.flag, want)
+			}
+		}
+		for _, unwanted := range test.excludes {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("Browse(%s, %s) should not list %q", test.objectID, test.flag, unwanted)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	fs.handleDLNAContentDirectory(rec, browseRequest("../..", "BrowseDirectChildren"))
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "<errorCode>701</errorCode>") {
+		t.Errorf("Browse outside the share = %d, expected a 701 fault", rec.Code)
+	}
+}
+
+// Test DLNA media serving
+func TestDLNAMedia(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_dlna_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.WriteFile(filepath.Join(tempDir, "movie.mp4"), []byte("0123456789"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "secret.txt"), []byte("secret"), 0644)
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/dlna/media/movie.mp4", http.StatusOK},
+		{"/dlna/media/secret.txt", http.StatusNotFound},
+		{"/dlna/media/../../etc/passwd", http.StatusNotFound},
+		{"/dlna/media/missing.mp4", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = test.path
+		rec := httptest.NewRecorder()
+		fs.handleDLNAMedia(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("GET %s = %d, expected %d", test.path, rec.Code, test.expected)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/dlna/media/movie.mp4", nil)
+	rec := httptest.NewRecorder()
+	fs.handleDLNAMedia(rec, req)
+	if rec.Header().Get("contentFeatures.dlna.org") == "" || rec.Header().Get("Content-Type") != "video/mp4" {
+		t.Errorf("Media response missing DLNA headers: %v", rec.Header())
+	}
+}
+
+// Test SSDP search responses
+func TestSSDPResponses(t *testing.T) {
+	tests := []struct {
+		st       string
+		expected int
+	}{
+		{"ssdp:all", 5},
+		{"upnp:rootdevice", 1},
+		{dlnaDeviceType, 1},
+		{dlnaCDSType, 1},
+		{"urn:schemas-upnp-org:device:MediaRenderer:1", 0},
+	}
+	location := "http://192.168.1.5:8080/dlna/device.xml"
+	for _, test := range tests {
+		replies := ssdpResponses(test.st, "abcd", location)
+		if len(replies) != test.expected {
+			t.Errorf("ssdpResponses(%s) returned %d replies, expected %d", test.st, len(replies), test.expected)
+			continue
+		}
+		for _, reply := range replies {
+			if !strings.Contains(reply, "LOCATION: "+location+"\r\n") || !strings.Contains(reply, "USN: uuid:abcd") {
+				t.Errorf("ssdpResponses(%s) reply malformed: %q", test.st, reply)
+			}
+		}
+	}
+
+	if a, b := dlnaUUID("host", "/media"), dlnaUUID("host", "/media"); a != b || len(a) != 36 {
+		t.Errorf("dlnaUUID should be stable and 36 characters, got %s and %s", a, b)
+	}
+}
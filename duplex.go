@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// receivingMode reports whether mode accepts uploads: recv, or both, which
+// also shares a file or directory for download.
+func receivingMode(mode string) bool {
+	return mode == "recv" || mode == "both"
+}
+
+func (fs *FileServer) receivesUploads() bool {
+	return receivingMode(fs.mode)
+}
+
+// inboxPath is the directory uploads are saved in.
+func (fs *FileServer) inboxPath() string {
+	if fs.mode == "both" {
+		return fs.recvDir
+	}
+	return fs.getPath()
+}
+
+// wantsInbox reports whether a request to the files API is about received
+// files: always in recv mode, and in both mode when it says ?received=1,
+// as there the same endpoints also list and serve the share.
+func (fs *FileServer) wantsInbox(r *http.Request) bool {
+	return fs.mode == "recv" || fs.mode == "both" && r.URL.Query().Get("received") == "1"
+}
+
+// startUpload is startTransfer for a file coming in, so throughput and
+// notifications can tell the directions apart in both mode.
+func (fs *FileServer) startUpload(clientIP string, size int64) {
+	fs.startTransfer(clientIP, size)
+	fs.statusMu.Lock()
+	fs.receiving = true
+	fs.statusMu.Unlock()
+}
+
+// lastReceived reports whether the transfer in the status was an upload.
+func (fs *FileServer) lastReceived() bool {
+	fs.statusMu.RLock()
+	defer fs.statusMu.RUnlock()
+	return fs.receiving
+}
+
+// parseBothArgs reads the arguments after "both": -send <path> for the
+// share and -recv <dir> for uploads (--send and --recv work too).
+func parseBothArgs(args []string, opts *Options) error {
+	flags := flag.NewFlagSet("both", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&opts.Path, "send", "", "File or directory offered for download")
+	flags.StringVar(&opts.RecvDir, "recv", "", "Directory uploads are saved in")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("both: %v", err)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("both: unexpected argument '%s'; use -send <path> -recv <dir>", flags.Arg(0))
+	}
+	return nil
+}
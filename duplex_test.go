@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test both mode offers the share and saves uploads in the inbox
+func TestBothMode(t *testing.T) {
+	share := filepath.Join(t.TempDir(), "report.pdf")
+	os.WriteFile(share, []byte("quarterly report"), 0644)
+	inbox := t.TempDir()
+
+	fs := NewFileServer("both", share, 8080, false)
+	fs.recvDir = inbox
+
+	rec := httptest.NewRecorder()
+	fs.handleUpload(rec, newUploadRequest(t, "reply.txt", "thanks", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Upload = %d, expected 200: %s", rec.Code, rec.Body.String())
+	}
+	if data, err := os.ReadFile(filepath.Join(inbox, "reply.txt")); err != nil || string(data) != "thanks" {
+		t.Errorf("Upload saved %q (%v), expected it in the inbox", data, err)
+	}
+	if !fs.lastReceived() {
+		t.Errorf("Upload not recorded as received")
+	}
+
+	rec = httptest.NewRecorder()
+	fs.handleDownload(rec, httptest.NewRequest("GET", "/api/download", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "quarterly report" {
+		t.Errorf("Download = %d %q, expected the share", rec.Code, rec.Body.String())
+	}
+	if fs.lastReceived() {
+		t.Errorf("Download recorded as received")
+	}
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"/api/files", "report.pdf"},
+		{"/api/files?received=1", "reply.txt"},
+		{"/api/file?name=report.pdf", "quarterly report"},
+		{"/api/file?received=1&name=reply.txt", "thanks"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.url, nil)
+		if strings.HasPrefix(test.url, "/api/files") {
+			fs.handleFiles(rec, req)
+			var listing fileListing
+			json.NewDecoder(rec.Body).Decode(&listing)
+			if len(listing.Files) != 1 || listing.Files[0].Name != test.expected {
+				t.Errorf("%s = %+v, expected %s", test.url, listing.Files, test.expected)
+			}
+			continue
+		}
+		fs.handleFile(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != test.expected {
+			t.Errorf("%s = %d %q, expected %q", test.url, rec.Code, rec.Body.String(), test.expected)
+		}
+	}
+}
+
+// Test parsing the arguments of both mode
+func TestParseBothArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		path    string
+		recvDir string
+		errText string
+	}{
+		{[]string{"--send", "report.pdf", "--recv", "./inbox"}, "report.pdf", "./inbox", ""},
+		{[]string{"-recv=in", "-send=out"}, "out", "in", ""},
+		{[]string{"-send", "a", "extra"}, "", "", "unexpected argument 'extra'"},
+		{[]string{"-upload", "in"}, "", "", "not defined"},
+	}
+	for _, test := range tests {
+		var opts Options
+		err := parseBothArgs(test.args, &opts)
+		if test.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errText) {
+				t.Errorf("%v: error = %v, expected %q", test.args, err, test.errText)
+			}
+			continue
+		}
+		if err != nil || opts.Path != test.path || opts.RecvDir != test.recvDir {
+			t.Errorf("%v = %q, %q (%v), expected %q, %q", test.args, opts.Path, opts.RecvDir, err, test.path, test.recvDir)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Files written with -encrypt-at-rest start with encMagic, a PBKDF2 salt
+// and a per-file nonce, followed by the content in AES-256-GCM sealed
+// chunks of encChunkSize bytes. The last chunk is always shorter than a
+// full one (possibly empty) and is sealed with a final flag, so a file cut
+// at any point fails to decrypt instead of coming out short.
+const (
+	encMagic      = "fileshare/enc/v1\n"
+	encSuffix     = ".enc"
+	encChunkSize  = 64 * 1024
+	encIterations = 600000
+	encSaltSize   = 16
+	encNonceSize  = 16
+)
+
+var (
+	errWrongPassphrase = errors.New("wrong passphrase or damaged file")
+	errNotEncrypted    = errors.New("not a fileshare encrypted file")
+	errTruncated       = errors.New("file is truncated")
+)
+
+// atRestKey is the passphrase-derived key used for one server run. Each
+// file still gets its own key from its nonce.
+type atRestKey struct {
+	salt []byte
+	key  []byte
+}
+
+func newAtRestKey(passphrase string) *atRestKey {
+	salt := make([]byte, encSaltSize)
+	rand.Read(salt)
+	return &atRestKey{salt: salt, key: derivePassphraseKey(passphrase, salt)}
+}
+
+func derivePassphraseKey(passphrase string, salt []byte) []byte {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, encIterations, 32)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func fileAEAD(key, nonce []byte) cipher.AEAD {
+	fileKey, err := hkdf.Key(sha256.New, key, nonce, "fileshare chunk key", 32)
+	if err != nil {
+		panic(err)
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// chunkNonce is the chunk counter followed by a byte marking the last one.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// sealWriter encrypts everything written to it onto w. Close writes the
+// final chunk but leaves w open.
+type sealWriter struct {
+	w       io.Writer
+	header  []byte
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+func (k *atRestKey) seal(w io.Writer) *sealWriter {
+	nonce := make([]byte, encNonceSize)
+	rand.Read(nonce)
+	header := append([]byte(encMagic), k.salt...)
+	header = append(header, nonce...)
+	return &sealWriter{w: w, header: header, aead: fileAEAD(k.key, nonce), buf: make([]byte, 0, encChunkSize)}
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), encChunkSize-len(s.buf))
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		// A full chunk is never the last one, so it can go out now.
+		if len(s.buf) == encChunkSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (s *sealWriter) Close() error {
+	return s.flush(true)
+}
+
+func (s *sealWriter) flush(last bool) error {
+	out := s.header
+	s.header = nil
+	out = s.aead.Seal(out, chunkNonce(s.counter, last), s.buf, nil)
+	s.counter++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(out)
+	return err
+}
+
+// openReader decrypts a file written by sealWriter.
+type openReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+// openSealed reads the header from r. keyFor turns the file's salt into
+// the passphrase key, so callers can cache it across files.
+func openSealed(r io.Reader, keyFor func(salt []byte) []byte) (*openReader, error) {
+	header := make([]byte, len(encMagic)+encSaltSize+encNonceSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encMagic)]) != encMagic {
+		return nil, errNotEncrypted
+	}
+	salt := header[len(encMagic) : len(encMagic)+encSaltSize]
+	nonce := header[len(encMagic)+encSaltSize:]
+	aead := fileAEAD(keyFor(salt), nonce)
+	return &openReader{r: r, aead: aead, buf: make([]byte, encChunkSize+aead.Overhead())}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(o.r, o.buf)
+		last := false
+		switch {
+		case err == io.ErrUnexpectedEOF || err == io.EOF:
+			if n < o.aead.Overhead() {
+				return 0, errTruncated
+			}
+			last = true
+		case err != nil:
+			return 0, err
+		}
+		plain, err := o.aead.Open(o.buf[:0], chunkNonce(o.counter, last), o.buf[:n], nil)
+		if err != nil {
+			return 0, errWrongPassphrase
+		}
+		o.counter++
+		o.plain = plain
+		o.done = last
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+// uploadWriter wraps a new upload file so its content is encrypted with
+// -encrypt-at-rest. finish must be called once the whole upload is written.
+func (fs *FileServer) uploadWriter(dst *os.File) (w io.Writer, finish func() error) {
+	if fs.atRest == nil {
+		return dst, func() error { return nil }
+	}
+	sw := fs.atRest.seal(dst)
+	return sw, sw.Close
+}
+
+// storedName is the name an upload is saved under.
+func (fs *FileServer) storedName(name string) string {
+	if fs.atRest == nil {
+		return name
+	}
+	return name + encSuffix
+}
+
+// readPassphrase takes the passphrase from FILESHARE_PASSPHRASE or asks
+// for it on the terminal without echo.
+func readPassphrase(prompt string) (string, error) {
+	if p := os.Getenv("FILESHARE_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		p, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(p), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// decryptFile writes the plaintext of src into dir under its name without
+// .enc, returning the new path and size. A partial result is removed.
+func decryptFile(src, dir string, keyFor func(salt []byte) []byte) (string, int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+	plain, err := openSealed(bufio.NewReader(in), keyFor)
+	if err != nil {
+		return "", 0, err
+	}
+	name := strings.TrimSuffix(filepath.Base(src), encSuffix)
+	if name == filepath.Base(src) {
+		name += ".dec"
+	}
+	dst, savePath, err := createUploadFile(dir, name, conflictReject, time.Now())
+	if os.IsExist(err) {
+		return "", 0, fmt.Errorf("%s already exists", savePath)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := io.Copy(dst, plain)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(savePath)
+		return "", 0, err
+	}
+	return savePath, n, nil
+}
+
+func runDecrypt(args []string) int {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	outDir := flags.String("o", "", "Directory to write decrypted files to (default: next to each file)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s decrypt [-o dir] <file.enc>...\n\nDecrypts files received with -encrypt-at-rest. The passphrase is read from\nFILESHARE_PASSPHRASE or asked for.\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+	passphrase, err := readPassphrase("Passphrase: ")
+	if err != nil || passphrase == "" {
+		fmt.Fprintln(os.Stderr, "Error: no passphrase given")
+		return 1
+	}
+
+	// Files from one server run share a salt; derive each key once.
+	keys := map[string][]byte{}
+	keyFor := func(salt []byte) []byte {
+		key, ok := keys[string(salt)]
+		if !ok {
+			key = derivePassphraseKey(passphrase, salt)
+			keys[string(salt)] = key
+		}
+		return key
+	}
+	failed := 0
+	for _, src := range flags.Args() {
+		dir := *outDir
+		if dir == "" {
+			dir = filepath.Dir(src)
+		}
+		savePath, n, err := decryptFile(src, dir, keyFor)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s: %v\n", icon("✗ "), src, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%sDecrypted %s to %s (%s)\n", icon("✓ "), src, savePath, formatSize(n))
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
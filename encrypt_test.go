@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that sealed content decrypts back and damage is detected
+func TestSealOpen(t *testing.T) {
+	key := &atRestKey{salt: bytes.Repeat([]byte{1}, encSaltSize), key: bytes.Repeat([]byte{2}, 32)}
+	keyFor := func([]byte) []byte { return key.key }
+	wrongKey := func([]byte) []byte { return bytes.Repeat([]byte{3}, 32) }
+
+	tests := []struct {
+		size int
+	}{
+		{0},
+		{1},
+		{encChunkSize - 1},
+		{encChunkSize},
+		{3*encChunkSize + 17},
+	}
+	for _, test := range tests {
+		plain := bytes.Repeat([]byte("0123456789"), test.size/10+1)[:test.size]
+		var sealed bytes.Buffer
+		w := key.seal(&sealed)
+		// Odd write sizes cross chunk boundaries.
+		for rest := plain; len(rest) > 0; {
+			n := min(len(rest), 7777)
+			w.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		data := sealed.Bytes()
+		if bytes.Contains(data, []byte("0123456789")) {
+			t.Errorf("size %d: plaintext visible in sealed data", test.size)
+		}
+
+		r, err := openSealed(bytes.NewReader(data), keyFor)
+		if err != nil {
+			t.Fatalf("size %d: openSealed: %v", test.size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted %d bytes (%v), expected %d", test.size, len(got), err, len(plain))
+		}
+
+		r, _ = openSealed(bytes.NewReader(data), wrongKey)
+		if _, err := io.ReadAll(r); !errors.Is(err, errWrongPassphrase) {
+			t.Errorf("size %d: wrong key gave %v, expected %v", test.size, err, errWrongPassphrase)
+		}
+
+		header := len(encMagic) + encSaltSize + encNonceSize
+		for _, cut := range []int{header, header + encChunkSize + 16, len(data) - 1} {
+			if cut >= len(data) || cut < header {
+				continue
+			}
+			r, _ = openSealed(bytes.NewReader(data[:cut]), keyFor)
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("size %d: file cut at %d decrypted without error", test.size, cut)
+			}
+		}
+	}
+
+	if _, err := openSealed(strings.NewReader("plain text, not encrypted at all........."), keyFor); err != errNotEncrypted {
+		t.Errorf("openSealed on plaintext = %v, expected %v", err, errNotEncrypted)
+	}
+}
+
+// Test that uploads are stored encrypted and decrypt with the passphrase
+func TestEncryptAtRestUpload(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_encrypt_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	fs.clock = fastClock{}
+	fs.atRest = newAtRestKey("correct horse")
+
+	content := strings.Repeat("confidential ", 10000)
+	rec := httptest.NewRecorder()
+	fs.handleUpload(rec, newUploadRequest(t, "contract.pdf", content, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Upload = %d: %s", rec.Code, rec.Body.String())
+	}
+	hash := sha256.Sum256([]byte(content))
+	if !strings.Contains(rec.Body.String(), `"name":"contract.pdf.enc"`) || !strings.Contains(rec.Body.String(), hex.EncodeToString(hash[:])) {
+		t.Errorf("Response %s should name the .enc file and hash the plaintext", rec.Body.String())
+	}
+	stored, err := os.ReadFile(filepath.Join(tempDir, "contract.pdf.enc"))
+	if err != nil {
+		t.Fatalf("Encrypted file not saved: %v", err)
+	}
+	if bytes.Contains(stored, []byte("confidential")) {
+		t.Errorf("Stored file contains plaintext")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "contract.pdf")); !os.IsNotExist(err) {
+		t.Errorf("Plaintext file should not exist (%v)", err)
+	}
+
+	tests := []struct {
+		passphrase string
+		ok         bool
+	}{
+		{"wrong", false},
+		{"correct horse", true},
+	}
+	for _, test := range tests {
+		out := t.TempDir()
+		keyFor := func(salt []byte) []byte { return derivePassphraseKey(test.passphrase, salt) }
+		savePath, n, err := decryptFile(filepath.Join(tempDir, "contract.pdf.enc"), out, keyFor)
+		if (err == nil) != test.ok {
+			t.Errorf("decrypt with %q: error %v", test.passphrase, err)
+			continue
+		}
+		if !test.ok {
+			if entries, _ := os.ReadDir(out); len(entries) != 0 {
+				t.Errorf("decrypt with %q left %d files behind", test.passphrase, len(entries))
+			}
+			continue
+		}
+		got, _ := os.ReadFile(savePath)
+		if filepath.Base(savePath) != "contract.pdf" || n != int64(len(content)) || string(got) != content {
+			t.Errorf("decrypted %s (%d bytes), expected contract.pdf with the original content", savePath, n)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/upload/init?name=big.iso&size=100", nil)
+	fs.handleUploadInit(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("Multi-part init = %d, expected %d", rec.Code, http.StatusNotImplemented)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// extractReserve is disk space an extraction leaves free.
+const extractReserve = 64 << 20
+
+// maxExtractEntries caps the files and folders taken from one archive;
+// lowered in tests.
+var maxExtractEntries = 100000
+
+// archiveStem returns name without its archive extension, or "" if name
+// isn't an archive -auto-extract understands.
+func archiveStem(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return ""
+}
+
+// archiveEntry is a file or directory inside a received archive.
+type archiveEntry struct {
+	name  string
+	isDir bool
+	data  io.Reader
+}
+
+// walkArchive calls fn for each regular file and directory in the archive
+// at file. Entries are read in order, so fn must consume data before
+// returning. Symlinks and hard links are skipped, so no entry can point a
+// later one outside the destination.
+func walkArchive(file string, fn func(archiveEntry) error) error {
+	if strings.HasSuffix(strings.ToLower(file), ".zip") {
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			mode := f.Mode()
+			if !mode.IsDir() && !mode.IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(archiveEntry{name: f.Name, isDir: mode.IsDir(), data: rc})
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(strings.ToLower(file), ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		if err := fn(archiveEntry{name: hdr.Name, isDir: hdr.Typeflag == tar.TypeDir, data: tr}); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanEntryName normalizes an entry name, returning "" for entries that
+// are skipped: macOS resource forks and Finder metadata. Names that could
+// land outside the destination (absolute paths, drive letters, ..
+// elements) are an error, failing the whole extraction.
+func cleanEntryName(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(slashed, "/") || winVolumeLen(slashed) > 0 || strings.ContainsRune(slashed, 0) ||
+		slices.Contains(strings.Split(slashed, "/"), "..") {
+		return "", fmt.Errorf("unsafe entry path %q", name)
+	}
+	name = path.Clean("/" + slashed)[1:]
+	if name == "" || name == "__MACOSX" || strings.HasPrefix(name, "__MACOSX/") || path.Base(name) == ".DS_Store" {
+		return "", nil
+	}
+	return name, nil
+}
+
+// wrapperDir returns the single top-level directory every entry lives in,
+// or "" if the archive has several top-level entries.
+func wrapperDir(names []string) string {
+	top := ""
+	nested := false
+	for _, name := range names {
+		first, rest, found := strings.Cut(name, "/")
+		if top != "" && first != top {
+			return ""
+		}
+		top = first
+		nested = nested || (found && rest != "")
+	}
+	if !nested {
+		return ""
+	}
+	return top
+}
+
+// extractUpload unpacks a received archive into a folder named after it,
+// next to it. With -flatten a folder wrapping the whole archive is
+// dropped. The archive itself is kept.
+func (fs *FileServer) extractUpload(archive string) {
+	stem := archiveStem(filepath.Base(archive))
+	if stem == "" {
+		return
+	}
+	name := filepath.Base(archive)
+
+	var names []string
+	err := walkArchive(archive, func(e archiveEntry) error {
+		clean, err := cleanEntryName(e.name)
+		if clean != "" {
+			names = append(names, clean)
+		}
+		return err
+	})
+	if err == nil && len(names) > maxExtractEntries {
+		err = fmt.Errorf("more than %d entries", maxExtractEntries)
+	}
+	if err != nil {
+		fs.extractFailed(name, err)
+		return
+	}
+	strip := ""
+	if fs.flatten {
+		if top := wrapperDir(names); top != "" {
+			strip = top + "/"
+		}
+	}
+
+	dest, err := fs.createExtractDir(filepath.Dir(archive), stem)
+	if err != nil {
+		fs.extractFailed(name, err)
+		return
+	}
+	// A few compressed bytes can expand without bound, so the contents get
+	// only what is left of the upload limit and the disk.
+	var budget io.Writer = io.Discard
+	if limit := fs.extractLimit(dest); limit >= 0 {
+		budget = &extractBudget{left: limit, limit: limit}
+	}
+	files := 0
+	err = walkArchive(archive, func(e archiveEntry) error {
+		clean, err := cleanEntryName(e.name)
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(clean, strip)
+		if rel == "" || rel+"/" == strip {
+			return nil
+		}
+		full, err := resolveInside(dest, rel)
+		if err != nil {
+			return fmt.Errorf("%s: %v", e.name, err)
+		}
+		if e.isDir {
+			return os.MkdirAll(full, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			return err
+		}
+		// Archives may repeat a name; keep every copy.
+		dst, _, err := createUploadFile(filepath.Dir(full), filepath.Base(full), conflictRename, fs.clock.Now())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.MultiWriter(budget, dst), e.data)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		files++
+		return err
+	})
+	if err != nil {
+		os.RemoveAll(dest)
+		fs.extractFailed(name, err)
+		return
+	}
+
+	folder := filepath.Base(dest)
+	fs.addLog(fmt.Sprintf("Extracted %s into %s/ (%d files)", name, folder, files))
+	fs.report(outputEvent{Event: "extracted", Name: name, Path: dest},
+		fmt.Sprintf("\n%sExtracted '%s' into %s/ (%d files)\n", icon("📦 "), name, folder, files))
+}
+
+// extractLimit is how much an archive extracted into dir may write: what
+// is left of -max-total and of the disk, keeping extractReserve free, or -1
+// when neither is known.
+func (fs *FileServer) extractLimit(dir string) int64 {
+	limit := fs.quotaLeft()
+	if free, ok := freeSpace(dir); ok {
+		free = max(free-extractReserve, 0)
+		if limit < 0 || free < limit {
+			limit = free
+		}
+	}
+	return limit
+}
+
+// extractBudget fails a write that would take an extraction past its
+// limit, before the data reaches the file.
+type extractBudget struct {
+	left  int64
+	limit int64
+}
+
+func (b *extractBudget) Write(p []byte) (int, error) {
+	if int64(len(p)) > b.left {
+		return 0, fmt.Errorf("contents are larger than the %s available", formatSize(b.limit))
+	}
+	b.left -= int64(len(p))
+	return len(p), nil
+}
+
+// createExtractDir makes a new folder for an archive's contents, following
+// -on-conflict when the name is taken.
+func (fs *FileServer) createExtractDir(dir, name string) (string, error) {
+	candidate := name
+	for attempt := 0; attempt < 100; attempt++ {
+		if attempt > 0 {
+			candidate = timestampedName(name, fs.clock.Now(), attempt)
+		}
+		full := filepath.Join(dir, candidate)
+		err := os.Mkdir(full, 0755)
+		if err == nil {
+			return full, nil
+		}
+		if !os.IsExist(err) || fs.onConflict != conflictRename {
+			if os.IsExist(err) {
+				return "", fmt.Errorf("folder '%s' already exists", candidate)
+			}
+			return "", err
+		}
+	}
+	return "", errors.New("too many folders named like '" + name + "'")
+}
+
+func (fs *FileServer) extractFailed(name string, err error) {
+	fs.addLog(fmt.Sprintf("Could not extract %s: %v", name, err))
+	fs.report(outputEvent{Event: "extract_failed", Name: name, Error: err.Error()},
+		fmt.Sprintf("\n%sCould not extract '%s': %v\n", icon("✗ "), name, err))
+}
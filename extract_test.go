@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Test recognizing archive names
+func TestArchiveStem(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"photos.zip", "photos"},
+		{"Backup.TAR.GZ", "Backup"},
+		{"src-1.2.tgz", "src-1.2"},
+		{"logs.tar", "logs"},
+		{"notes.txt", ""},
+		{".zip", ""},
+		{"archive.gz", ""},
+	}
+	for _, test := range tests {
+		if result := archiveStem(test.name); result != test.expected {
+			t.Errorf("archiveStem(%q) = %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}
+
+// Test detecting a folder that wraps a whole archive
+func TestWrapperDir(t *testing.T) {
+	tests := []struct {
+		names    []string
+		expected string
+	}{
+		{[]string{"photos", "photos/a.jpg", "photos/sub/b.jpg"}, "photos"},
+		{[]string{"photos/a.jpg"}, "photos"},
+		{[]string{"photos/a.jpg", "readme.txt"}, ""},
+		{[]string{"a.jpg"}, ""},
+		{[]string{"empty"}, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		if result := wrapperDir(test.names); result != test.expected {
+			t.Errorf("wrapperDir(%v) = %q, expected %q", test.names, result, test.expected)
+		}
+	}
+}
+
+func writeTestZip(t *testing.T, file string, entries map[string]string) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatalf("Create %s: %v", file, err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w, _ := zw.Create(name)
+		w.Write([]byte(entries[name]))
+	}
+	zw.Close()
+}
+
+// listTree returns the files under dir as slash-separated paths.
+func listTree(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+// Test extracting received archives
+func TestExtractUpload(t *testing.T) {
+	wrapped := map[string]string{
+		"photos/":              "",
+		"photos/a.jpg":         "a",
+		"photos/trip/b.jpg":    "b",
+		"__MACOSX/photos/._a":  "junk",
+		"photos/.DS_Store":     "junk",
+		"photos/./c.jpg":       "c",
+		"photos/trip\\win.jpg": "w",
+		"photos/trip/dup.jpg":  "1",
+		"photos/trip//dup.jpg": "2",
+	}
+	loose := map[string]string{
+		"a.jpg":           "a",
+		"docs/readme.txt": "r",
+	}
+	tests := []struct {
+		entries  map[string]string
+		flatten  bool
+		expected string
+	}{
+		{wrapped, false, "photos/a.jpg,photos/c.jpg,photos/trip/b.jpg,photos/trip/dup.jpg,photos/trip/dup_2024-05-01_12-00-00.jpg,photos/trip/win.jpg"},
+		{wrapped, true, "a.jpg,c.jpg,trip/b.jpg,trip/dup.jpg,trip/dup_2024-05-01_12-00-00.jpg,trip/win.jpg"},
+		{loose, false, "a.jpg,docs/readme.txt"},
+		{loose, true, "a.jpg,docs/readme.txt"},
+	}
+	for _, test := range tests {
+		tempDir, err := os.MkdirTemp("", "fileshare_extract_*")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+		archive := filepath.Join(tempDir, "photos.zip")
+		writeTestZip(t, archive, test.entries)
+
+		fs := NewFileServer("recv", tempDir, 8080, false)
+		fs.clock = fastClock{}
+		fs.flatten = test.flatten
+		fs.extractUpload(archive)
+		if result := strings.Join(listTree(filepath.Join(tempDir, "photos")), ","); result != test.expected {
+			t.Errorf("flatten=%v extracted %s, expected %s", test.flatten, result, test.expected)
+		}
+		if _, err := os.Stat(archive); err != nil {
+			t.Errorf("The archive should be kept: %v", err)
+		}
+	}
+}
+
+// Test tar.gz archives and folder name collisions
+func TestExtractCollision(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_extract_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	archive := filepath.Join(tempDir, "logs.tar.gz")
+	f, _ := os.Create(archive)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "app.log", Mode: 0644, Size: 3, Typeflag: tar.TypeReg})
+	tw.Write([]byte("log"))
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink})
+	tw.Close()
+	gz.Close()
+	f.Close()
+	os.Mkdir(filepath.Join(tempDir, "logs"), 0755)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	fs.clock = fastClock{}
+	fs.onConflict = conflictReject
+	fs.extractUpload(archive)
+	if last := fs.transferLog[len(fs.transferLog)-1]; !strings.Contains(last, "folder 'logs' already exists") {
+		t.Errorf("Extracting onto an existing folder should fail under reject: %q", last)
+	}
+
+	fs.onConflict = conflictRename
+	fs.extractUpload(archive)
+	if result := listTree(filepath.Join(tempDir, "logs_2024-05-01_12-00-00")); strings.Join(result, ",") != "app.log" {
+		t.Errorf("Renamed extraction contains %v, expected only app.log", result)
+	}
+
+	os.WriteFile(filepath.Join(tempDir, "broken.zip"), []byte("not a zip"), 0644)
+	fs.extractUpload(filepath.Join(tempDir, "broken.zip"))
+	if _, err := os.Stat(filepath.Join(tempDir, "broken")); !os.IsNotExist(err) {
+		t.Errorf("A failed extraction should not leave a folder behind")
+	}
+}
+
+// Test that archives with entries escaping the destination are refused
+func TestExtractUnsafe(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{"parent", "../../escape.txt"},
+		{"nested parent", "docs/../../escape.txt"},
+		{"inner parent", "docs/../readme.txt"},
+		{"backslash parent", `..\..\escape.txt`},
+		{"absolute", "/tmp/escape.txt"},
+		{"absolute backslash", `\tmp\escape.txt`},
+		{"drive letter", `C:\Windows\escape.dll`},
+		{"drive relative", "C:escape.txt"},
+		{"UNC", `\\server\share\escape.txt`},
+	}
+	for _, test := range tests {
+		tempDir := t.TempDir()
+		recv := filepath.Join(tempDir, "inbox")
+		os.Mkdir(recv, 0755)
+		archive := filepath.Join(recv, "photos.zip")
+		writeTestZip(t, archive, map[string]string{"a.jpg": "a", test.entry: "evil"})
+
+		fs := NewFileServer("recv", recv, 8080, false)
+		fs.clock = fastClock{}
+		fs.extractUpload(archive)
+		if last := fs.transferLog[len(fs.transferLog)-1]; !strings.Contains(last, "unsafe entry path") {
+			t.Errorf("%s: extraction should be refused, log says %q", test.name, last)
+		}
+		if _, err := os.Stat(filepath.Join(recv, "photos")); !os.IsNotExist(err) {
+			t.Errorf("%s: a refused archive should not leave a folder behind", test.name)
+		}
+		if files := listTree(tempDir); strings.Join(files, ",") != "inbox/photos.zip" {
+			t.Errorf("%s: files written %v", test.name, files)
+		}
+	}
+}
+
+// Test that archives expanding past the limits are removed again
+func TestExtractLimits(t *testing.T) {
+	defer func(n int) { maxExtractEntries = n }(maxExtractEntries)
+	maxExtractEntries = 3
+
+	tests := []struct {
+		name     string
+		entries  map[string]string
+		maxTotal int64
+		message  string
+	}{
+		{"zip bomb", map[string]string{"zeros.bin": strings.Repeat("\x00", 1<<20)}, 64 << 10, "contents are larger than the 64.00 KB available"},
+		{"too many entries", map[string]string{"a": "a", "b": "b", "c": "c", "d": "d"}, 0, "more than 3 entries"},
+		{"within limits", map[string]string{"a": "a", "b": "b"}, 64 << 10, ""},
+	}
+	for _, test := range tests {
+		recv := t.TempDir()
+		archive := filepath.Join(recv, "bomb.zip")
+		writeTestZip(t, archive, test.entries)
+
+		fs := NewFileServer("recv", recv, 8080, false)
+		fs.clock = fastClock{}
+		fs.maxTotal = test.maxTotal
+		fs.extractUpload(archive)
+		last := fs.transferLog[len(fs.transferLog)-1]
+		if test.message == "" {
+			if files := listTree(filepath.Join(recv, "bomb")); len(files) != len(test.entries) {
+				t.Errorf("%s: extracted %v, expected %d files (log %q)", test.name, files, len(test.entries), last)
+			}
+			continue
+		}
+		if !strings.Contains(last, test.message) {
+			t.Errorf("%s: log says %q, expected %q", test.name, last, test.message)
+		}
+		if _, err := os.Stat(filepath.Join(recv, "bomb")); !os.IsNotExist(err) {
+			t.Errorf("%s: a refused archive should not leave a folder behind", test.name)
+		}
+	}
+}
+
+// Test that a symlink entry can't redirect later entries
+func TestExtractSymlinkEscape(t *testing.T) {
+	tempDir := t.TempDir()
+	outside := filepath.Join(tempDir, "outside")
+	recv := filepath.Join(tempDir, "inbox")
+	os.Mkdir(outside, 0755)
+	os.Mkdir(recv, 0755)
+	archive := filepath.Join(recv, "logs.tar")
+	f, _ := os.Create(archive)
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "out", Linkname: outside, Typeflag: tar.TypeSymlink})
+	tw.WriteHeader(&tar.Header{Name: "hard", Linkname: "/etc/passwd", Typeflag: tar.TypeLink})
+	tw.WriteHeader(&tar.Header{Name: "out/pwned.txt", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("evil"))
+	tw.Close()
+	f.Close()
+
+	fs := NewFileServer("recv", recv, 8080, false)
+	fs.clock = fastClock{}
+	fs.extractUpload(archive)
+	if files := listTree(outside); len(files) != 0 {
+		t.Errorf("Files were written outside the destination: %v", files)
+	}
+	if info, err := os.Lstat(filepath.Join(recv, "logs", "out")); err != nil || !info.IsDir() {
+		t.Errorf("out should be a plain folder inside the destination: %v, %v", info, err)
+	}
+	if files := listTree(filepath.Join(recv, "logs")); strings.Join(files, ",") != "out/pwned.txt" {
+		t.Errorf("Extracted %v, expected only out/pwned.txt", files)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// fetchRequest is the body of POST /api/fetch.
+type fetchRequest struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
+
+// handleFetch downloads a URL into the receive directory, for senders
+// that only have a link. It runs like an upload from the requesting
+// client: it holds the transfer slot, reports progress and counts against
+// -max-total, and the request returns when the file is saved. Off unless
+// -allow-fetch, as it lets anyone who may upload make the server request
+// any URL it can reach.
+func (fs *FileServer) handleFetch(w http.ResponseWriter, r *http.Request) {
+	if !fs.receivesUploads() || !fs.allowFetch {
+		httpError(w, r, "Fetching URLs is not enabled", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req fetchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxUploadFieldSize)).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		httpError(w, r, "Only http and https URLs can be fetched", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := fs.getClientIP(r)
+	if !fs.acquireClient(clientIP) {
+		fs.rejectBusy(w, r)
+		return
+	}
+	defer fs.releaseClient(clientIP)
+	defer fs.trackClient(r, activityUploading)()
+	auditNote(r, "fetch "+u.Redacted())
+
+	get, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		httpError(w, r, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	get.Header.Set("User-Agent", "fileshare/"+version)
+	resp, err := http.DefaultClient.Do(get)
+	if err != nil {
+		fs.logRequest(r, fmt.Sprintf("Fetching %s failed: %v", u.Redacted(), err))
+		httpError(w, r, "Failed to fetch URL", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		httpError(w, r, "Remote server returned "+resp.Status, http.StatusBadGateway)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = fetchedName(resp)
+	}
+	dir, filename, ok := fs.uploadTarget(w, r, clientIP, name, req.Dir)
+	if !ok {
+		return
+	}
+	size := max(resp.ContentLength, 0)
+	fs.receiveFile(w, r, resp.Body, size, resp.ContentLength, dir, filename, clientIP, u.Redacted())
+}
+
+// fetchedName names a fetched file after its Content-Disposition, else
+// the last element of its final URL, after any redirects.
+func fetchedName(resp *http.Response) string {
+	if name, err := responseFilename(resp.Header); err == nil && name != "download" {
+		return name
+	}
+	if base := path.Base(resp.Request.URL.Path); base != "/" && base != "." {
+		return base
+	}
+	return "download"
+}
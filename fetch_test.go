@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test downloading a URL into the receive directory
+func TestHandleFetch(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/report.pdf":
+			w.Write([]byte("report contents"))
+		case "/attachment":
+			w.Header().Set("Content-Disposition", contentDisposition("notes.txt"))
+			w.Write([]byte("notes"))
+		case "/moved":
+			http.Redirect(w, r, "/files/report.pdf", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer remote.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		disabled bool
+		code     int
+		saved    string // file expected in the receive directory
+		content  string
+	}{
+		{"named from the path", `{"url":"` + remote.URL + `/files/report.pdf"}`, false, http.StatusOK, "report.pdf", "report contents"},
+		{"named from Content-Disposition", `{"url":"` + remote.URL + `/attachment"}`, false, http.StatusOK, "notes.txt", "notes"},
+		{"named after the redirect", `{"url":"` + remote.URL + `/moved"}`, false, http.StatusOK, "report.pdf", "report contents"},
+		{"name and dir given", `{"url":"` + remote.URL + `/attachment","name":"n.md","dir":"docs"}`, false, http.StatusOK, "docs/n.md", "notes"},
+		{"remote error", `{"url":"` + remote.URL + `/missing"}`, false, http.StatusBadGateway, "", ""},
+		{"not http", `{"url":"file:///etc/passwd"}`, false, http.StatusBadRequest, "", ""},
+		{"invalid body", `{"url":`, false, http.StatusBadRequest, "", ""},
+		{"disabled", `{"url":"` + remote.URL + `/files/report.pdf"}`, true, http.StatusForbidden, "", ""},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		fs := NewFileServer("recv", dir, 8080, false)
+		fs.allowFetch = !test.disabled
+		rec := httptest.NewRecorder()
+		fs.handleFetch(rec, httptest.NewRequest("POST", "/api/fetch", strings.NewReader(test.body)))
+		if rec.Code != test.code {
+			t.Errorf("%s: status %d, expected %d (%s)", test.name, rec.Code, test.code, strings.TrimSpace(rec.Body.String()))
+			continue
+		}
+		if test.saved == "" {
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%s: expected nothing saved, found %d entries", test.name, len(entries))
+			}
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, test.saved))
+		if err != nil || string(data) != test.content {
+			t.Errorf("%s: saved %q (%v), expected %q in %s", test.name, data, err, test.content, test.saved)
+		}
+		if fs.status.Status != "completed" || fs.status.Transferred != int64(len(test.content)) {
+			t.Errorf("%s: status %s with %d transferred, expected completed with %d", test.name, fs.status.Status, fs.status.Transferred, len(test.content))
+		}
+	}
+}
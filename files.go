@@ -0,0 +1,318 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxListEntries = 5000
+
+type fileEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type fileListing struct {
+	Files     []fileEntry `json:"files"`
+	TotalSize int64       `json:"total_size"`
+	Truncated bool        `json:"truncated"`
+}
+
+// listFiles lists the files of sources in the order an archive of them
+// has. The trees are walked in parallel and sorted back into that order,
+// keeping no more than twice maxListEntries at a time.
+func listFiles(sources []archiveSource) (*fileListing, error) {
+	type listed struct {
+		src int
+		fileEntry
+	}
+	var (
+		mu    sync.Mutex
+		files []listed
+		total int64
+		more  bool
+	)
+	order := func(a, b listed) int {
+		return cmp.Or(cmp.Compare(a.src, b.src), walkOrder(a.Name, b.Name))
+	}
+	for i, src := range sources {
+		err := walkSourcesParallel([]archiveSource{src}, func(file, name string, fi os.FileInfo) error {
+			if fi.IsDir() {
+				if fi.Name() == casDirName {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// The listing shows what a client would fetch one by one.
+			if link, ok := fi.(linkedFile); ok {
+				fi = link.FileInfo
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			total += fi.Size()
+			files = append(files, listed{i, fileEntry{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}})
+			if len(files) >= 2*maxListEntries {
+				slices.SortFunc(files, order)
+				files, more = files[:maxListEntries], true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.SortFunc(files, order)
+	if len(files) > maxListEntries {
+		files, more = files[:maxListEntries], true
+	}
+	listing := &fileListing{Files: make([]fileEntry, len(files)), TotalSize: total, Truncated: more}
+	for i, f := range files {
+		listing.Files[i] = f.fileEntry
+	}
+	return listing, nil
+}
+
+func (fs *FileServer) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if !modeNeedsPath(fs.mode) {
+		httpError(w, r, "Server is not sharing files", http.StatusBadRequest)
+		return
+	}
+	var sources []archiveSource
+	var err error
+	if fs.receivesToStdout() {
+		// Nothing is kept on disk.
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fileListing{Files: []fileEntry{}})
+		return
+	}
+	if fs.wantsInbox(r) {
+		sources = []archiveSource{{path: fs.inboxPath()}}
+	} else {
+		sources, _, err = fs.shareSources()
+	}
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	listing, err := listFiles(sources)
+	if err != nil {
+		httpError(w, r, "Failed to list files", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// notifyFilesChanged coalesces bursts of filesystem events (e.g. a folder
+// being copied in) into a single SSE notification.
+func (fs *FileServer) notifyFilesChanged(path string) {
+	fs.watchMu.Lock()
+	defer fs.watchMu.Unlock()
+	fs.watchChanges++
+	if fs.watchTimer != nil {
+		return
+	}
+	fs.watchTimer = time.AfterFunc(300*time.Millisecond, func() {
+		fs.watchMu.Lock()
+		changes := fs.watchChanges
+		fs.watchChanges = 0
+		fs.watchTimer = nil
+		fs.watchMu.Unlock()
+
+		var size int64
+		fs.pathMu.RLock()
+		if fs.sizeCache != nil {
+			size = fs.sizeCache.Size()
+		}
+		fs.pathMu.RUnlock()
+
+		fs.addLog(fmt.Sprintf("Shared directory updated (%d changes, now %s)", changes, formatSize(size)))
+		fs.broadcast("files", fmt.Sprintf(`{"changes":%d,"size":%d}`, changes, size))
+	})
+}
+
+// resolveInside maps a slash-separated path relative to root onto the
+// filesystem, refusing anything that would end up outside root, including
+// via symlinks.
+func resolveInside(root, rel string) (string, error) {
+	rel = strings.TrimPrefix(filepath.FromSlash(rel), string(filepath.Separator))
+	if rel == "" || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return "", errors.New("invalid path")
+	}
+	cleaned := filepath.Clean(rel)
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", errors.New("path escapes the shared directory")
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	full := filepath.Join(root, cleaned)
+	// For paths that don't exist yet, check the nearest existing ancestor so
+	// a symlinked parent can't redirect newly created entries outside root.
+	existing := full
+	realFull, err := filepath.EvalSymlinks(existing)
+	for os.IsNotExist(err) && existing != root {
+		existing = filepath.Dir(existing)
+		realFull, err = filepath.EvalSymlinks(existing)
+	}
+	if err != nil {
+		return "", err
+	}
+	if realFull != realRoot && !strings.HasPrefix(realFull, realRoot+string(filepath.Separator)) {
+		return "", errors.New("path escapes the shared directory")
+	}
+	return full, nil
+}
+
+// handleFile serves a single file from the receive directory so received
+// files can be downloaded again, and deletes it on DELETE. In send mode it
+// serves one entry of the share, with ranges, for clients like mount, and
+// in serve mode any file of the directory. Both mode does either.
+func (fs *FileServer) handleFile(w http.ResponseWriter, r *http.Request) {
+	reading := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if !fs.receivesUploads() && (!fs.servesDownloads() || !reading) {
+		httpError(w, r, "Server is not in receive mode", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case reading:
+	case r.Method == http.MethodDelete:
+		fs.handleFileDelete(w, r)
+		return
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	inbox := fs.wantsInbox(r)
+	var full string
+	var err error
+	switch {
+	case inbox:
+		full, err = resolveInside(fs.inboxPath(), name)
+	case fs.mode == "serve":
+		full, err = resolveInside(fs.getPath(), name)
+	default:
+		full, err = fs.resolveShareEntry(name)
+	}
+	if err != nil {
+		auditNote(r, "rejected path: "+name)
+		httpError(w, r, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if inbox && rangeHeader == "" {
+		fs.logRequest(r, fmt.Sprintf("%s downloading received file %s", fs.clientLabel(fs.getClientIP(r)), name))
+	} else if !inbox && (rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")) {
+		fs.logRequest(r, fmt.Sprintf("%s opened %s", fs.clientLabel(fs.getClientIP(r)), name))
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(info.Name()))
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(rec, r, info.Name(), info.ModTime(), f)
+	// Whole files only, so resumed or seeking requests aren't counted again.
+	if !inbox && r.Method == http.MethodGet && rangeHeader == "" && rec.status == http.StatusOK && rec.bytes == info.Size() {
+		fs.countDownload(name, fs.getClientIP(r))
+	}
+}
+
+func (fs *FileServer) handleFileDelete(w http.ResponseWriter, r *http.Request) {
+	if !fs.requireAdmin(w, r) {
+		return
+	}
+	name := r.URL.Query().Get("name")
+	full, err := resolveInside(fs.inboxPath(), name)
+	if err != nil {
+		httpError(w, r, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+	info, err := os.Lstat(full)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	if info.IsDir() {
+		httpError(w, r, "Refusing to delete a directory", http.StatusBadRequest)
+		return
+	}
+	if err := os.Remove(full); err != nil {
+		httpError(w, r, "Failed to delete file", http.StatusInternalServerError)
+		return
+	}
+
+	fs.logRequest(r, fmt.Sprintf("Deleted %s", name))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted", "name": name})
+}
+
+func (fs *FileServer) handleFileRename(w http.ResponseWriter, r *http.Request) {
+	if !fs.receivesUploads() {
+		httpError(w, r, "Server is not in receive mode", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !fs.requireAdmin(w, r) {
+		return
+	}
+
+	name, to := r.FormValue("name"), r.FormValue("to")
+	root := fs.inboxPath()
+	from, err := resolveInside(root, name)
+	if err != nil {
+		httpError(w, r, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+	dest, err := resolveInside(root, to)
+	if err != nil {
+		httpError(w, r, "Invalid new name", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Lstat(from); err != nil || info.IsDir() {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		httpError(w, r, "A file with the new name already exists", http.StatusConflict)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		httpError(w, r, "Failed to create directory", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(from, dest); err != nil {
+		httpError(w, r, "Failed to rename file", http.StatusInternalServerError)
+		return
+	}
+
+	fs.logRequest(r, fmt.Sprintf("Renamed %s to %s", name, to))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "renamed", "name": to})
+}
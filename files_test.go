@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test listing of shared files
+func TestListFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_list_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	os.MkdirAll(filepath.Join(tempDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "sub", "b.txt"), []byte("world!"), 0644)
+
+	listing, err := listFiles([]archiveSource{{path: tempDir}})
+	if err != nil {
+		t.Fatalf("listFiles error: %v", err)
+	}
+	if len(listing.Files) != 2 || listing.TotalSize != 11 {
+		t.Fatalf("Unexpected listing: %+v", listing)
+	}
+	if listing.Files[1].Name != "sub/b.txt" {
+		t.Errorf("Nested entries should use slash-separated relative paths, got %s", listing.Files[1].Name)
+	}
+
+	single, err := listFiles([]archiveSource{{path: filepath.Join(tempDir, "a.txt"), name: "a.txt"}})
+	if err != nil || len(single.Files) != 1 || single.Files[0].Name != "a.txt" {
+		t.Errorf("Single file listing unexpected: %+v, %v", single, err)
+	}
+}
+
+// Test watch mode pushes SSE notifications
+func TestWatchNotifications(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_watch_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	fs.watch = true
+	fs.refreshSizeCache()
+	defer fs.sizeCache.Close()
+
+	client := newSSEClient(sseQueueLimit)
+	fs.sseMu.Lock()
+	fs.sseClients[client] = true
+	fs.sseMu.Unlock()
+
+	os.WriteFile(filepath.Join(tempDir, "new.txt"), []byte("12345"), 0644)
+
+	deadline := time.After(3 * time.Second)
+	for {
+		select {
+		case <-client.wake:
+			for _, frame := range client.take() {
+				if strings.HasPrefix(frame, "event: files\n") {
+					if !strings.Contains(frame, `"size":5`) {
+						t.Errorf("files event should carry the new size, got %q", frame)
+					}
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatal("Expected a files event after adding a file")
+		}
+	}
+}
+
+// Test path resolution inside the shared root
+func TestResolveInside(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_resolve_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	root := filepath.Join(tempDir, "root")
+	os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	os.WriteFile(filepath.Join(tempDir, "secret.txt"), []byte("x"), 0644)
+	os.Symlink(filepath.Join(tempDir, "secret.txt"), filepath.Join(root, "escape"))
+	os.Symlink(tempDir, filepath.Join(root, "escapedir"))
+
+	tests := []struct {
+		rel string
+		ok  bool
+	}{
+		{"sub", true},
+		{"sub/new.txt", true},
+		{"/sub/new.txt", true},
+		{"", false},
+		{"..", false},
+		{"../secret.txt", false},
+		{"sub/../../secret.txt", false},
+		{"escape", false},
+		{"escapedir/new/file.txt", false},
+	}
+	for _, test := range tests {
+		_, err := resolveInside(root, test.rel)
+		if (err == nil) != test.ok {
+			t.Errorf("resolveInside(%q) error = %v, expected ok=%v", test.rel, err, test.ok)
+		}
+	}
+}
+
+// Test re-downloading received files
+func TestHandleFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_file_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.WriteFile(filepath.Join(tempDir, "got.txt"), []byte("received"), 0644)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	tests := []struct {
+		name string
+		code int
+		body string
+	}{
+		{"got.txt", http.StatusOK, "received"},
+		{"missing.txt", http.StatusNotFound, ""},
+		{"../etc/passwd", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleFile(rec, httptest.NewRequest("GET", "/api/file?name="+url.QueryEscape(test.name), nil))
+		if rec.Code != test.code {
+			t.Errorf("GET %s = %d, expected %d", test.name, rec.Code, test.code)
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("GET %s body = %q", test.name, rec.Body.String())
+		}
+	}
+}
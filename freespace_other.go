@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// freeSpace can't tell how much room is left here.
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	thumbSize       = 240
+	maxCachedThumbs = 500
+)
+
+// isImage reports whether name looks like a picture by its extension.
+func isImage(name string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))), "image/")
+}
+
+type galleryListing struct {
+	Gallery bool        `json:"gallery"`
+	Images  []fileEntry `json:"images"`
+}
+
+// galleryImages picks the images out of listing and reports whether they
+// make up most of it, which is when the page shows a gallery instead of a
+// plain file list.
+func galleryImages(listing *fileListing) galleryListing {
+	result := galleryListing{Images: []fileEntry{}}
+	for _, f := range listing.Files {
+		if isImage(f.Name) {
+			result.Images = append(result.Images, f)
+		}
+	}
+	result.Gallery = len(result.Images) > 0 && len(result.Images)*2 >= len(listing.Files)
+	return result
+}
+
+// resolveShareEntry maps a listing name (as returned by /api/files) back to
+// a file inside the send target.
+func (fs *FileServer) resolveShareEntry(name string) (string, error) {
+	sources, _, err := fs.shareSources()
+	if err != nil {
+		return "", err
+	}
+	for _, s := range sources {
+		var full string
+		rest, ok := strings.CutPrefix(name, s.name+"/")
+		switch {
+		case s.name == "":
+			full, err = resolveInside(s.path, name)
+		case name == s.name:
+			return s.path, nil
+		case ok:
+			full, err = resolveInside(s.path, rest)
+		default:
+			continue
+		}
+		if err == nil && s.skipsBelow(full) {
+			return "", os.ErrNotExist
+		}
+		return full, err
+	}
+	return "", os.ErrNotExist
+}
+
+// thumbCache keeps encoded thumbnails keyed by path, size and mtime so
+// edited files get a fresh one.
+type thumbCache struct {
+	mu     sync.Mutex
+	thumbs map[string][]byte
+}
+
+func (c *thumbCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.thumbs[key]
+	return data, ok
+}
+
+func (c *thumbCache) put(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.thumbs == nil || len(c.thumbs) >= maxCachedThumbs {
+		c.thumbs = make(map[string][]byte)
+	}
+	c.thumbs[key] = data
+}
+
+// scaleImage shrinks src to fit within max×max by averaging each block of
+// source pixels. Images already small enough are returned as is.
+func scaleImage(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return src
+	}
+	dw, dh := max, h*max/w
+	if h > w {
+		dw, dh = w*max/h, max
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+func makeThumbnail(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(src, thumbSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleGallery lists the images of a send target.
+func (fs *FileServer) handleGallery(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "send" {
+		httpError(w, r, "Server is not in send mode", http.StatusBadRequest)
+		return
+	}
+	sources, isArchive, err := fs.shareSources()
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	result := galleryListing{Images: []fileEntry{}}
+	if isArchive {
+		listing, err := listFiles(sources)
+		if err != nil {
+			httpError(w, r, "Failed to list files", http.StatusInternalServerError)
+			return
+		}
+		result = galleryImages(listing)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleGalleryImage serves one image inline (or as an attachment with
+// download=1), or its thumbnail with thumb=1. Like /api/stream it doesn't
+// take the client lock; a gallery page loads many images at once.
+func (fs *FileServer) handleGalleryImage(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "send" {
+		httpError(w, r, "Server is not in send mode", http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	full, err := fs.resolveShareEntry(name)
+	if err != nil || !isImage(full) {
+		auditNote(r, "rejected path: "+name)
+		httpError(w, r, "Invalid image name", http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(full)
+	if err != nil || info.IsDir() {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Decoding a camera JPEG takes tens of megabytes, so low-memory mode
+	// leaves scaling to the browser.
+	if r.URL.Query().Get("thumb") == "1" && !fs.lowMem {
+		key := fmt.Sprintf("%s|%d|%d", full, info.Size(), info.ModTime().UnixNano())
+		thumb, ok := fs.thumbs.get(key)
+		if !ok {
+			if thumb, err = makeThumbnail(full); err == nil {
+				fs.thumbs.put(key, thumb)
+			}
+		}
+		if thumb != nil {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Cache-Control", "max-age=3600")
+			http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(thumb))
+			return
+		}
+		// Formats the standard library can't decode go to the browser as is.
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	if r.URL.Query().Get("download") == "1" {
+		fs.logRequest(r, fmt.Sprintf("%s downloading image %s", fs.clientLabel(fs.getClientIP(r)), name))
+		w.Header().Set("Content-Disposition", contentDisposition(info.Name()))
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// handleGalleryZip sends the selected images as one zip archive.
+func (fs *FileServer) handleGalleryZip(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "send" {
+		httpError(w, r, "Server is not in send mode", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	names := r.PostForm["name"]
+	if len(names) == 0 {
+		httpError(w, r, "No images selected", http.StatusBadRequest)
+		return
+	}
+
+	var sources []archiveSource
+	for _, name := range names {
+		full, err := fs.resolveShareEntry(name)
+		if err != nil || !isImage(full) {
+			auditNote(r, "rejected path: "+name)
+			httpError(w, r, "Invalid image name", http.StatusBadRequest)
+			return
+		}
+		sources = append(sources, archiveSource{path: full, name: name})
+	}
+
+	fs.logRequest(r, fmt.Sprintf("%s downloading %d selected images", fs.clientLabel(fs.getClientIP(r)), len(sources)))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition(strings.TrimSuffix(fs.downloadFilename(true), ".zip")+"-selection.zip"))
+	if err := writeZipArchive(w, sources, fs.zipMethod(), func(int64) {}); err != nil {
+		fs.logRequest(r, fmt.Sprintf("Selection download failed: %v", err))
+		return
+	}
+	for _, name := range names {
+		fs.countDownload(name, fs.getClientIP(r))
+	}
+}
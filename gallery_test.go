@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test deciding when a listing is shown as a gallery
+func TestGalleryImages(t *testing.T) {
+	tests := []struct {
+		files    []string
+		images   int
+		expected bool
+	}{
+		{[]string{"a.jpg", "b.png", "c.gif"}, 3, true},
+		{[]string{"a.jpg", "notes.txt"}, 1, true},
+		{[]string{"a.jpg", "notes.txt", "b.pdf"}, 1, false},
+		{[]string{"notes.txt"}, 0, false},
+		{nil, 0, false},
+	}
+	for _, test := range tests {
+		listing := &fileListing{}
+		for _, name := range test.files {
+			listing.Files = append(listing.Files, fileEntry{Name: name})
+		}
+		result := galleryImages(listing)
+		if result.Gallery != test.expected || len(result.Images) != test.images {
+			t.Errorf("galleryImages(%v) = %t with %d images, expected %t with %d", test.files, result.Gallery, len(result.Images), test.expected, test.images)
+		}
+	}
+}
+
+// Test thumbnail scaling
+func TestScaleImage(t *testing.T) {
+	tests := []struct {
+		w, h             int
+		expectW, expectH int
+	}{
+		{1200, 800, 240, 160},
+		{800, 1200, 160, 240},
+		{100, 50, 100, 50},
+		{5000, 10, 240, 1},
+	}
+	for _, test := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, test.w, test.h))
+		b := scaleImage(src, 240).Bounds()
+		if b.Dx() != test.expectW || b.Dy() != test.expectH {
+			t.Errorf("scaleImage(%dx%d) = %dx%d, expected %dx%d", test.w, test.h, b.Dx(), b.Dy(), test.expectW, test.expectH)
+		}
+	}
+}
+
+func writeTestPNG(t *testing.T, path string, w, h int) {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{200, 100, 50, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+}
+
+// Test serving gallery images and thumbnails
+func TestHandleGalleryImage(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_gallery_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.MkdirAll(filepath.Join(tempDir, "album"), 0755)
+	writeTestPNG(t, filepath.Join(tempDir, "album", "big.png"), 600, 300)
+	os.WriteFile(filepath.Join(tempDir, "notes.txt"), []byte("text"), 0644)
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"name=album/big.png", http.StatusOK},
+		{"name=album/big.png&thumb=1", http.StatusOK},
+		{"name=notes.txt", http.StatusBadRequest},
+		{"name=../../etc/passwd.png", http.StatusBadRequest},
+		{"name=missing.png", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleGalleryImage(rec, httptest.NewRequest("GET", "/api/gallery/image?"+test.query, nil))
+		if rec.Code != test.expected {
+			t.Errorf("GET image?%s = %d, expected %d", test.query, rec.Code, test.expected)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	fs.handleGalleryImage(rec, httptest.NewRequest("GET", "/api/gallery/image?name=album/big.png&thumb=1", nil))
+	thumb, err := jpeg.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("Thumbnail is not a JPEG: %v", err)
+	}
+	if b := thumb.Bounds(); b.Dx() != 240 || b.Dy() != 120 {
+		t.Errorf("Thumbnail is %dx%d, expected 240x120", b.Dx(), b.Dy())
+	}
+
+	rec = httptest.NewRecorder()
+	fs.handleGalleryImage(rec, httptest.NewRequest("GET", "/api/gallery/image?name=album/big.png&download=1", nil))
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), "big.png") {
+		t.Errorf("Download should be an attachment, got %q", rec.Header().Get("Content-Disposition"))
+	}
+}
+
+// Test downloading a selection of images
+func TestHandleGalleryZip(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_gallery_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	writeTestPNG(t, filepath.Join(tempDir, "one.png"), 2, 2)
+	writeTestPNG(t, filepath.Join(tempDir, "two.png"), 2, 2)
+	writeTestPNG(t, filepath.Join(tempDir, "three.png"), 2, 2)
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	form := url.Values{"name": {"one.png", "three.png"}}
+	req := httptest.NewRequest("POST", "/api/gallery/zip", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	fs.handleGalleryZip(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Selection download = %d, expected 200", rec.Code)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("Response is not a zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "one.png,three.png" {
+		t.Errorf("Zip contains %v, expected one.png and three.png", names)
+	}
+
+	form = url.Values{"name": {"one.png", "../secret.png"}}
+	req = httptest.NewRequest("POST", "/api/gallery/zip", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	fs.handleGalleryZip(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Selection outside the share = %d, expected 400", rec.Code)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const progressBarWidth = 30
+
+// progressBar draws a download's progress on one terminal line. A nil bar
+// draws nothing.
+type progressBar struct {
+	w       io.Writer
+	total   atomic.Int64 // -1 when unknown
+	done    atomic.Int64
+	started time.Time
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	b := &progressBar{w: w, stop: make(chan struct{})}
+	b.total.Store(-1)
+	return b
+}
+
+// begin starts drawing a download of total bytes, from done already
+// there.
+func (b *progressBar) begin(done, total int64) {
+	if b == nil {
+		return
+	}
+	b.done.Store(done)
+	b.total.Store(total)
+	b.started = time.Now()
+	b.stopped.Add(1)
+	go func() {
+		defer b.stopped.Done()
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		from := done
+		for {
+			select {
+			case <-ticker.C:
+				b.draw(from)
+			case <-b.stop:
+				b.draw(from)
+				fmt.Fprintln(b.w)
+				return
+			}
+		}
+	}()
+}
+
+func (b *progressBar) add(n int64) {
+	if b != nil {
+		b.done.Add(n)
+	}
+}
+
+func (b *progressBar) end() {
+	if b == nil || b.started.IsZero() {
+		return
+	}
+	close(b.stop)
+	b.stopped.Wait()
+}
+
+// draw shows the bar, the amounts and the speed since begin, which only
+// counts what this run fetched.
+func (b *progressBar) draw(from int64) {
+	done, total := b.done.Load(), b.total.Load()
+	line := formatSize(done)
+	if total > 0 {
+		filled := int(min(done, total) * progressBarWidth / total)
+		full, empty := "█", "░"
+		if plainOutput {
+			full, empty = "#", "-"
+		}
+		line = fmt.Sprintf("[%s%s] %5.1f%%  %s / %s", strings.Repeat(full, filled), strings.Repeat(empty, progressBarWidth-filled),
+			float64(done)*100/float64(total), formatSize(done), formatSize(total))
+	}
+	if elapsed := time.Since(b.started).Seconds(); elapsed > 0 {
+		line += fmt.Sprintf("  %s/s", formatSize(int64(float64(done-from)/elapsed)))
+	}
+	fmt.Fprintf(b.w, "\r%s\033[K", line)
+}
+
+// progressWriter counts what is written through it on a bar.
+type progressWriter struct {
+	w   io.Writer
+	bar *progressBar
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.bar.add(int64(n))
+	return n, err
+}
+
+// progressWriterAt is progressWriter for parallel range downloads.
+type progressWriterAt struct {
+	w   io.WriterAt
+	bar *progressBar
+}
+
+func (p progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := p.w.WriteAt(b, off)
+	p.bar.add(int64(n))
+	return n, err
+}
+
+// downloadValidator is what identifies this version of a download for
+// If-Range: a strong ETag, else the modification time.
+func downloadValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// partialName is where a download in progress is kept.
+func partialName(name, validator string) string {
+	sum := sha256.Sum256([]byte(validator))
+	return name + "." + hex.EncodeToString(sum[:4]) + ".part"
+}
+
+// fetchResumable downloads url, described by head, into dir. What has
+// arrived is kept in a .part file named after the download and its
+// validator, so running again after an interruption asks only for the
+// rest, and a download that has changed since starts over under a
+// different name.
+func fetchResumable(url, dir string, head *http.Response, bar *progressBar) (string, int64, error) {
+	name, err := responseFilename(head.Header)
+	if err != nil {
+		return "", 0, err
+	}
+	validator := downloadValidator(head.Header)
+	if head.Header.Get("Accept-Ranges") != "bytes" {
+		validator = ""
+	}
+	partial := filepath.Join(dir, partialName(name, validator))
+
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if offset > 0 && validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		fmt.Printf("Resuming %s at %s\n", name, formatSize(offset))
+	case http.StatusOK:
+		if err := f.Truncate(0); err != nil {
+			return "", 0, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", 0, err
+		}
+		offset = 0
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", 0, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	bar.begin(offset, total)
+	n, err := io.Copy(progressWriter{f, bar}, resp.Body)
+	bar.end()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && total >= 0 && offset+n < total {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		if validator == "" {
+			os.Remove(partial)
+			return "", 0, err
+		}
+		return "", 0, fmt.Errorf("%v (%s kept in %s, run again to resume)", err, formatSize(offset+n), partial)
+	}
+
+	// Claim the final name the way an upload would, then move the
+	// download over it.
+	dst, savePath, err := createUploadFile(dir, name, conflictRename, time.Now())
+	if err != nil {
+		return "", 0, err
+	}
+	dst.Close()
+	if err := os.Rename(partial, savePath); err != nil {
+		os.Remove(savePath)
+		return "", 0, err
+	}
+	return savePath, offset + n, nil
+}
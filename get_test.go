@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test an interrupted download resumes where it stopped, unless it changed
+func TestFetchResumable(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10000))
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	validator := modTime.Format(http.TimeFormat)
+	var cut bool
+	var ranged string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", contentDisposition("backup.bin"))
+		if r.Method == http.MethodGet {
+			ranged = r.Header.Get("Range")
+		}
+		if cut && r.Method == http.MethodGet {
+			w.Header().Set("Content-Length", "100000")
+			w.Write(content[:30000])
+			return
+		}
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		partial   string // name of a .part file left by an earlier run
+		kept      int    // its length
+		cut       bool
+		errText   string
+		wantRange string
+	}{
+		{"interrupted", "", 0, true, "run again to resume", ""},
+		{"resumed", partialName("backup.bin", validator), 30000, false, "", "bytes=30000-"},
+		{"changed since", partialName("backup.bin", "Tue, 30 Apr 2024 12:00:00 GMT"), 50000, false, "", ""},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		cut = test.cut
+		if test.partial != "" {
+			os.WriteFile(filepath.Join(dir, test.partial), content[:test.kept], 0644)
+		}
+		head, err := http.Head(server.URL)
+		if err != nil {
+			t.Fatalf("%s: HEAD error: %v", test.name, err)
+		}
+		head.Body.Close()
+		savePath, n, err := fetchResumable(server.URL, dir, head, nil)
+		if test.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errText) {
+				t.Errorf("%s: error %v, expected %q", test.name, err, test.errText)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error %v", test.name, err)
+			continue
+		}
+		data, _ := os.ReadFile(savePath)
+		if !bytes.Equal(data, content) || n != int64(len(content)) || ranged != test.wantRange {
+			t.Errorf("%s: saved %d bytes (%d reported) with Range %q; expected %d with %q", test.name, len(data), n, ranged, len(content), test.wantRange)
+		}
+		if _, err := os.Stat(filepath.Join(dir, partialName("backup.bin", validator))); !os.IsNotExist(err) {
+			t.Errorf("%s: .part file expected to be gone, stat error %v", test.name, err)
+		}
+		os.Remove(savePath)
+	}
+}
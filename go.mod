@@ -1,3 +1,10 @@
 module fileshare
 
 go 1.25.0
+
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/hanwen/go-fuse/v2 v2.9.0
+	golang.org/x/sys v0.28.0
+	golang.org/x/term v0.27.0
+)
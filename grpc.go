@@ -0,0 +1,569 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A small gRPC server on top of net/http's plaintext HTTP/2 support, with
+// the messages from fileshare.proto encoded by hand so the build doesn't
+// pull in protoc output or the grpc-go dependency tree.
+
+const (
+	grpcService        = "/fileshare.FileShare/"
+	maxGRPCMessageSize = 16 << 20
+	grpcChunkSize      = 64 * 1024
+)
+
+// Status codes from https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcOK                 = 0
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.msg)
+}
+
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// Protobuf wire encoding.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	if len(data) == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendInt64Field(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+func appendDoubleField(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+type protoField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// parseProto splits a message into its fields, skipping fixed-width ones
+// since none of the request messages use them.
+func parseProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("malformed field tag")
+		}
+		b = b[n:]
+		field := protoField{num: int(tag >> 3)}
+		switch tag & 7 {
+		case wireVarint:
+			field.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("malformed varint")
+			}
+			b = b[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, errors.New("malformed length-delimited field")
+			}
+			field.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, errors.New("truncated fixed64")
+			}
+			b = b[8:]
+			continue
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, errors.New("truncated fixed32")
+			}
+			b = b[4:]
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", tag&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func encodeStatus(status TransferStatus, clientIP string) []byte {
+	var b []byte
+	b = appendStringField(b, 1, status.Mode)
+	b = appendStringField(b, 2, status.Path)
+	b = appendInt64Field(b, 3, status.Size)
+	b = appendInt64Field(b, 4, status.Transferred)
+	b = appendDoubleField(b, 5, status.Progress)
+	b = appendStringField(b, 6, status.Status)
+	b = appendStringField(b, 7, status.Error)
+	b = appendStringField(b, 8, clientIP)
+	b = appendStringField(b, 9, status.SHA256)
+	b = appendStringField(b, 10, status.Phase)
+	b = appendInt64Field(b, 11, status.Sent)
+	return b
+}
+
+func encodeChunk(name string, data []byte, size int64) []byte {
+	var b []byte
+	b = appendStringField(b, 1, name)
+	b = appendBytesField(b, 2, data)
+	b = appendInt64Field(b, 3, size)
+	return b
+}
+
+func decodeChunk(msg []byte) (name string, data []byte, size int64, err error) {
+	fields, err := parseProto(msg)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			name = string(f.bytes)
+		case 2:
+			data = f.bytes
+		case 3:
+			size = int64(f.varint)
+		}
+	}
+	return name, data, size, nil
+}
+
+func encodeUploadResult(path, name string, size int64, sum string) []byte {
+	var b []byte
+	b = appendStringField(b, 1, path)
+	b = appendStringField(b, 2, name)
+	b = appendInt64Field(b, 3, size)
+	b = appendStringField(b, 4, sum)
+	return b
+}
+
+// gRPC length-prefixed framing.
+
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, grpcErrorf(grpcInvalidArgument, "truncated message header")
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxGRPCMessageSize {
+		return nil, grpcErrorf(grpcInvalidArgument, "message of %d bytes exceeds the %d byte limit", size, maxGRPCMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "truncated message")
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	header := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	if _, err := w.Write(append(header, msg...)); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// chunkWriter turns a byte stream into Download response messages.
+type chunkWriter struct {
+	w    http.ResponseWriter
+	name string
+	size int64
+	sent bool
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > grpcChunkSize {
+			n = grpcChunkSize
+		}
+		var msg []byte
+		if c.sent {
+			msg = encodeChunk("", p[:n], 0)
+		} else {
+			msg = encodeChunk(c.name, p[:n], c.size)
+			c.sent = true
+		}
+		if err := writeGRPCMessage(c.w, msg); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// listenGRPC serves the gRPC API on addr until the returned server is
+// closed.
+func (fs *FileServer) listenGRPC(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return fs.serveGRPC(listener), nil
+}
+
+// serveGRPC serves the gRPC API on listener. Clients sign in like scripts
+// do on the web API: the password or a token as "authorization: Bearer"
+// metadata, which arrives as the Authorization header.
+func (fs *FileServer) serveGRPC(listener net.Listener) *http.Server {
+	listener = fs.meterListener(listener, false)
+	handler := fs.withAuth(fs.withBudget(http.HandlerFunc(fs.handleGRPC)))
+	server := &http.Server{Handler: withRequestID(fs.withAudit(fs.withBans(handler))), ConnContext: connContext}
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	go server.Serve(listener)
+	return server
+}
+
+func (fs *FileServer) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		httpError(w, r, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	methods := map[string]func(http.ResponseWriter, *http.Request) error{
+		"GetStatus":   fs.grpcGetStatus,
+		"WatchStatus": fs.grpcWatchStatus,
+		"Cancel":      fs.grpcCancel,
+		"Download":    fs.grpcDownload,
+		"Upload":      fs.grpcUpload,
+	}
+	method, ok := methods[strings.TrimPrefix(r.URL.Path, grpcService)]
+
+	w.Header().Set("Content-Type", "application/grpc")
+	var err error
+	if !ok || !strings.HasPrefix(r.URL.Path, grpcService) {
+		err = grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+	} else {
+		err = method(w, r)
+	}
+
+	code, msg := grpcOK, ""
+	if err != nil {
+		var gerr *grpcError
+		if errors.As(err, &gerr) {
+			code, msg = gerr.code, gerr.msg
+		} else {
+			code, msg = grpcUnknown, err.Error()
+		}
+		auditNote(r, msg)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", msg)
+	}
+}
+
+func (fs *FileServer) currentStatus() []byte {
+	fs.statusMu.RLock()
+	status := *fs.status
+	fs.statusMu.RUnlock()
+	fs.activeMu.Lock()
+	activeClient := fs.activeClient
+	fs.activeMu.Unlock()
+	return encodeStatus(status, activeClient)
+}
+
+func (fs *FileServer) grpcGetStatus(w http.ResponseWriter, r *http.Request) error {
+	if _, err := readGRPCMessage(r.Body); err != nil && err != io.EOF {
+		return err
+	}
+	return writeGRPCMessage(w, fs.currentStatus())
+}
+
+func (fs *FileServer) grpcWatchStatus(w http.ResponseWriter, r *http.Request) error {
+	if _, err := readGRPCMessage(r.Body); err != nil && err != io.EOF {
+		return err
+	}
+
+	// Piggyback on the SSE fan-out: any frame means the status may have
+	// changed.
+	updates := newSSEClient(sseQueueLimit)
+	if err := fs.addSSEClient(updates); err != nil {
+		return grpcErrorf(grpcUnavailable, "%v", err)
+	}
+	defer fs.removeSSEClient(updates)
+
+	last := fs.currentStatus()
+	if err := writeGRPCMessage(w, last); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-updates.wake:
+			updates.take()
+			current := fs.currentStatus()
+			if string(current) == string(last) {
+				continue
+			}
+			if err := writeGRPCMessage(w, current); err != nil {
+				return err
+			}
+			last = current
+		case <-r.Context().Done():
+			return nil
+		case <-fs.done:
+			return nil
+		}
+	}
+}
+
+func (fs *FileServer) grpcCancel(w http.ResponseWriter, r *http.Request) error {
+	if _, err := readGRPCMessage(r.Body); err != nil && err != io.EOF {
+		return err
+	}
+	clientIP := fs.getClientIP(r)
+	fs.dropClient(clientIP)
+	fs.cancel(clientIP)
+	return writeGRPCMessage(w, nil)
+}
+
+func (fs *FileServer) grpcDownload(w http.ResponseWriter, r *http.Request) error {
+	if _, err := readGRPCMessage(r.Body); err != nil && err != io.EOF {
+		return err
+	}
+	if !fs.servesDownloads() {
+		return grpcErrorf(grpcFailedPrecondition, "server has nothing to download")
+	}
+
+	clientIP := fs.getClientIP(r)
+	if !fs.acquireClient(clientIP) {
+		return grpcErrorf(grpcUnavailable, "another client is already connected")
+	}
+	defer fs.releaseClient(clientIP)
+	defer fs.trackClient(r, activityDownloading)()
+	clientLabel := fs.clientLabel(clientIP)
+
+	sources, isArchive, err := fs.shareSources()
+	if err != nil {
+		return grpcErrorf(grpcNotFound, "file not found")
+	}
+	if isArchive {
+		fs.beginTransfer(clientIP, 0, phaseScanning)
+	}
+	size := fs.targetSize(sources)
+	fs.beginTransfer(clientIP, size, fs.downloadPhase(isArchive))
+	fs.logRequest(r, fmt.Sprintf("Started gRPC download from %s", clientLabel))
+
+	cw := &chunkWriter{w: w, name: fs.downloadFilename(isArchive), size: size}
+	counted := &countingWriter{w: cw}
+	hasher := sha256.New()
+	out := io.MultiWriter(counted, hasher)
+	var transferred int64
+	progress := func(n int64) {
+		transferred += n
+		fs.setSent(counted.n)
+		fs.updateProgress(transferred)
+	}
+	if isArchive {
+		err = writeZipArchive(out, sources, fs.zipMethod(), progress)
+		fs.setPhase(phaseSending)
+	} else {
+		var f *os.File
+		f, err = os.Open(sources[0].path)
+		if err == nil {
+			_, err = io.Copy(out, &progressReader{r: f, progress: progress})
+			f.Close()
+		}
+	}
+	if err != nil {
+		fs.failTransfer(err)
+		return grpcErrorf(grpcInternal, "transfer failed: %v", err)
+	}
+	if !cw.sent {
+		// Empty file: still tell the client its name.
+		if err := writeGRPCMessage(w, encodeChunk(cw.name, nil, 0)); err != nil {
+			return err
+		}
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	fs.completeTransfer(sum)
+	fs.countDownload(cw.name, clientIP)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("gRPC download completed for %s%s", clientLabel, hashSuffix(sum)))
+	completed := outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP), Name: cw.name, Size: transferred, SHA256: sum}
+	var packed packing
+	if isArchive {
+		packed = zipPacking(fs.zipMethod(), transferred, counted.n)
+		completed.Compression, completed.WireSize = packed.method, packed.wire
+	}
+	fs.report(completed, fmt.Sprintf("\n%sTransfer completed to %s\n%s%s", icon("✓ "), clientLabel, hashLine(sum), fs.recordPacking(packed)))
+	return nil
+}
+
+func (fs *FileServer) grpcUpload(w http.ResponseWriter, r *http.Request) error {
+	if !fs.receivesUploads() {
+		return grpcErrorf(grpcFailedPrecondition, "server is not in receive mode")
+	}
+
+	clientIP := fs.getClientIP(r)
+	if !fs.acquireClient(clientIP) {
+		return grpcErrorf(grpcUnavailable, "another client is already connected")
+	}
+	defer fs.releaseClient(clientIP)
+	defer fs.trackClient(r, activityUploading)()
+	clientLabel := fs.clientLabel(clientIP)
+
+	msg, err := readGRPCMessage(r.Body)
+	if err == io.EOF {
+		return grpcErrorf(grpcInvalidArgument, "no chunks received")
+	}
+	if err != nil {
+		return err
+	}
+	name, data, size, err := decodeChunk(msg)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	filename, err := sanitizeFilename(name)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "the first chunk must carry a valid file name")
+	}
+
+	if size <= 0 {
+		size = -1
+	}
+	if err := fs.checkQuota(size); err != nil {
+		return grpcErrorf(grpcResourceExhausted, "%v", err)
+	}
+	left := fs.quotaLeft()
+
+	dir, err := fs.uploadDir(clientIP)
+	if err != nil {
+		fs.failTransfer(err)
+		return grpcErrorf(grpcInternal, "failed to create directory")
+	}
+	filename = fs.storedName(filename)
+	dst, savePath, err := createUploadFile(dir, filename, fs.onConflict, fs.clock.Now())
+	if os.IsExist(err) {
+		return grpcErrorf(grpcAlreadyExists, "file '%s' already exists", filename)
+	}
+	if err != nil {
+		fs.failTransfer(err)
+		return grpcErrorf(grpcInternal, "failed to create file")
+	}
+	defer dst.Close()
+	savedName := filepath.Base(savePath)
+
+	fs.startUpload(clientIP, size)
+	fs.logRequest(r, fmt.Sprintf("Started gRPC upload from %s: %s", clientLabel, savedName))
+
+	var transferred int64
+	hasher := sha256.New()
+	sink, finish := fs.uploadWriter(dst)
+	out := io.MultiWriter(sink, hasher)
+	for {
+		if left >= 0 && transferred+int64(len(data)) > left {
+			fs.discardUpload(dst, savePath, errQuotaExceeded)
+			return grpcErrorf(grpcResourceExhausted, "%v", errQuotaExceeded)
+		}
+		if _, err := out.Write(data); err != nil {
+			fs.discardUpload(dst, savePath, err)
+			return grpcErrorf(grpcInternal, "write failed: %v", err)
+		}
+		transferred += int64(len(data))
+		fs.updateProgress(transferred)
+
+		msg, err = readGRPCMessage(r.Body)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, data, _, err = decodeChunk(msg)
+		}
+		if err != nil {
+			fs.discardUpload(dst, savePath, err)
+			return err
+		}
+	}
+	if err := finish(); err != nil {
+		fs.discardUpload(dst, savePath, err)
+		return grpcErrorf(grpcInternal, "write failed: %v", err)
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	fs.completeTransfer(sum)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("gRPC upload completed from %s: %s (%s)%s", clientLabel, savedName, formatSize(transferred), hashSuffix(sum)))
+	fs.report(outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP), Name: savedName, Path: savePath, Size: transferred, SHA256: sum},
+		fmt.Sprintf("\n%sReceived '%s' from %s (%s)\n%s", icon("✓ "), savedName, clientLabel, formatSize(transferred), hashLine(sum)))
+	fs.addReceived(transferred)
+	fs.afterReceive(savePath, sum)
+	return writeGRPCMessage(w, encodeUploadResult(savePath, savedName, transferred, sum))
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// grpcCall makes a unary or streaming gRPC call over plaintext HTTP/2 and
+// returns the response messages and grpc-status trailer
+func grpcCall(t *testing.T, url, method string, requests ...[]byte) ([][]byte, string) {
+	t.Helper()
+	return grpcCallAs(t, url, "", method, requests...)
+}
+
+// grpcCallAs is grpcCall with token as the authorization metadata. HTTP
+// errors from before the gRPC handler are mapped to the status a gRPC
+// client reports for them.
+func grpcCallAs(t *testing.T, url, token, method string, requests ...[]byte) ([][]byte, string) {
+	t.Helper()
+	var body bytes.Buffer
+	for _, msg := range requests {
+		header := make([]byte, 5)
+		binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+		body.Write(header)
+		body.Write(msg)
+	}
+
+	client := &http.Client{Transport: &http.Transport{Protocols: new(http.Protocols)}}
+	client.Transport.(*http.Transport).Protocols.SetUnencryptedHTTP2(true)
+	req, _ := http.NewRequest(http.MethodPost, url+grpcService+method, &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s call failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, "16"
+	case http.StatusForbidden:
+		return nil, "7"
+	}
+
+	var messages [][]byte
+	for {
+		msg, err := readGRPCMessage(resp.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s: bad response frame: %v", method, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, resp.Trailer.Get("Grpc-Status")
+}
+
+func newGRPCTestServer(fs *FileServer) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(fs.handleGRPC))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	return server
+}
+
+// Test protobuf field encoding round trips
+func TestProtoChunk(t *testing.T) {
+	msg := encodeChunk("a.txt", []byte("data"), 300)
+	name, data, size, err := decodeChunk(msg)
+	if err != nil || name != "a.txt" || string(data) != "data" || size != 300 {
+		t.Errorf("decodeChunk = %q, %q, %d, %v", name, data, size, err)
+	}
+	if _, _, _, err := decodeChunk([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Error("Truncated field should fail to decode")
+	}
+}
+
+// Test status, download and upload over gRPC
+func TestGRPCTransfers(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_grpc_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	target := filepath.Join(tempDir, "data.bin")
+	os.WriteFile(target, content, 0644)
+
+	send := NewFileServer("send", target, 0, false)
+	server := newGRPCTestServer(send)
+	defer server.Close()
+
+	messages, code := grpcCall(t, server.URL, "GetStatus", nil)
+	if code != "0" || len(messages) != 1 {
+		t.Fatalf("GetStatus returned %d messages, status %s", len(messages), code)
+	}
+	fields, _ := parseProto(messages[0])
+	if len(fields) == 0 || fields[0].num != 1 || string(fields[0].bytes) != "send" {
+		t.Errorf("Status should start with mode 'send', got %+v", fields)
+	}
+
+	messages, code = grpcCall(t, server.URL, "Download", nil)
+	if code != "0" {
+		t.Fatalf("Download failed with status %s", code)
+	}
+	var got []byte
+	for i, msg := range messages {
+		name, data, size, _ := decodeChunk(msg)
+		if i == 0 && (name != "data.bin" || size != int64(len(content))) {
+			t.Errorf("First chunk should carry name and size, got %q %d", name, size)
+		}
+		got = append(got, data...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Downloaded %d bytes, expected %d", len(got), len(content))
+	}
+
+	if _, code := grpcCall(t, server.URL, "Upload", encodeChunk("x", nil, 0)); code != "9" {
+		t.Errorf("Upload in send mode should be FAILED_PRECONDITION, got %s", code)
+	}
+	if _, code := grpcCall(t, server.URL, "Bogus", nil); code != "12" {
+		t.Errorf("Unknown method should be UNIMPLEMENTED, got %s", code)
+	}
+
+	recvDir := filepath.Join(tempDir, "recv")
+	os.Mkdir(recvDir, 0755)
+	recv := NewFileServer("recv", recvDir, 0, false)
+	recvServer := newGRPCTestServer(recv)
+	defer recvServer.Close()
+
+	messages, code = grpcCall(t, recvServer.URL, "Upload",
+		encodeChunk("up.txt", []byte("hello "), 11), encodeChunk("", []byte("world"), 0))
+	if code != "0" || len(messages) != 1 {
+		t.Fatalf("Upload returned %d messages, status %s", len(messages), code)
+	}
+	saved, _ := os.ReadFile(filepath.Join(recvDir, "up.txt"))
+	if string(saved) != "hello world" {
+		t.Errorf("Uploaded file contains %q, expected %q", saved, "hello world")
+	}
+	fields, _ = parseProto(messages[0])
+	sum := sha256.Sum256([]byte("hello world"))
+	var uploadSum string
+	for _, f := range fields {
+		if f.num == 4 {
+			uploadSum = string(f.bytes)
+		}
+	}
+	if uploadSum != hex.EncodeToString(sum[:]) {
+		t.Errorf("UploadResult sha256 = %q, expected %x", uploadSum, sum)
+	}
+
+	if _, code := grpcCall(t, recvServer.URL, "Upload", encodeChunk("../up.txt", []byte("x"), 1)); code != "6" {
+		t.Errorf("Uploading an existing name should be ALREADY_EXISTS, got %s", code)
+	}
+}
+
+// Test the gRPC listener asks for the password like the web API
+func TestGRPCAuth(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "secret.txt")
+	os.WriteFile(target, []byte("top secret"), 0644)
+
+	fs := NewFileServer("send", target, 0, false)
+	fs.password = "pw"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := fs.serveGRPC(listener)
+	defer server.Close()
+	url := "http://" + listener.Addr().String()
+
+	tests := []struct {
+		method string
+		token  string
+		code   string
+	}{
+		{"Download", "", "16"},
+		{"Download", "wrong", "16"},
+		{"GetStatus", "", "16"},
+		{"Cancel", "", "16"},
+		{"Upload", "", "16"},
+		{"GetStatus", "pw", "0"},
+		{"Download", "pw", "0"},
+	}
+	for _, tt := range tests {
+		messages, code := grpcCallAs(t, url, tt.token, tt.method, nil)
+		if code != tt.code {
+			t.Errorf("%s with token %q: expected status %s, got %s", tt.method, tt.token, tt.code, code)
+		}
+		if tt.method == "Download" && tt.code != "0" && len(messages) > 0 {
+			t.Errorf("%s with token %q: expected no data, got %d messages", tt.method, tt.token, len(messages))
+		}
+	}
+}
+
+// Test tokens reach only the gRPC methods their scope allows
+func TestGRPCTokenScopes(t *testing.T) {
+	recvDir := t.TempDir()
+	fs := NewFileServer("recv", recvDir, 0, false)
+	fs.tokens, _ = parseAccessTokens([]string{"viewer:read:r-secret", "uploader:write:w-secret", "owner:admin:a-secret"})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := fs.serveGRPC(listener)
+	defer server.Close()
+	url := "http://" + listener.Addr().String()
+
+	tests := []struct {
+		method string
+		token  string
+		code   string
+	}{
+		{"Upload", "", "16"},
+		{"Cancel", "", "16"},
+		{"GetStatus", "r-secret", "0"},
+		{"Upload", "r-secret", "7"},
+		{"Cancel", "r-secret", "7"},
+		{"Upload", "w-secret", "0"},
+		{"Cancel", "w-secret", "0"},
+		{"Cancel", "a-secret", "0"},
+	}
+	for _, tt := range tests {
+		var msg []byte
+		if tt.method == "Upload" {
+			msg = encodeChunk("from-"+tt.token+".txt", []byte("data"), 4)
+		}
+		if _, code := grpcCallAs(t, url, tt.token, tt.method, msg); code != tt.code {
+			t.Errorf("%s with token %q: expected status %s, got %s", tt.method, tt.token, tt.code, code)
+		}
+	}
+	if entries, _ := os.ReadDir(recvDir); len(entries) != 1 {
+		t.Errorf("Expected only the write token's upload to be saved, got %d files", len(entries))
+	}
+}
+
+// Test both mode downloads the share and saves uploads in the inbox
+func TestGRPCBothMode(t *testing.T) {
+	share := filepath.Join(t.TempDir(), "report.pdf")
+	os.WriteFile(share, []byte("quarterly report"), 0644)
+	inbox := t.TempDir()
+
+	fs := NewFileServer("both", share, 0, false)
+	fs.recvDir = inbox
+	server := newGRPCTestServer(fs)
+	defer server.Close()
+
+	messages, code := grpcCall(t, server.URL, "Download", nil)
+	if code != "0" {
+		t.Fatalf("Download failed with status %s", code)
+	}
+	var got []byte
+	for _, msg := range messages {
+		_, data, _, _ := decodeChunk(msg)
+		got = append(got, data...)
+	}
+	if string(got) != "quarterly report" {
+		t.Errorf("Downloaded %q, expected the share", got)
+	}
+
+	if _, code := grpcCall(t, server.URL, "Upload", encodeChunk("reply.txt", []byte("thanks"), 6)); code != "0" {
+		t.Fatalf("Upload failed with status %s", code)
+	}
+	if data, err := os.ReadFile(filepath.Join(inbox, "reply.txt")); err != nil || string(data) != "thanks" {
+		t.Errorf("Upload saved %q (%v), expected it in the inbox", data, err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// fileID identifies a file on disk, whatever name it is reached by.
+type fileID struct {
+	dev, ino uint64
+}
+
+// linkedFile stands for another hard link to a file already in the
+// archive. It is stored as a symlink to the first name, so the data is
+// only sent once.
+type linkedFile struct {
+	os.FileInfo
+	target string // relative to the link's folder
+}
+
+func (l linkedFile) Size() int64 {
+	return int64(len(l.target))
+}
+
+func (l linkedFile) Mode() os.FileMode {
+	return l.FileInfo.Mode().Perm() | os.ModeSymlink
+}
+
+// relativeEntry returns the path from the folder of entry name to entry
+// target, both slash-separated archive names.
+func relativeEntry(name, target string) string {
+	from := strings.Split(name, "/")
+	from = from[:len(from)-1]
+	to := strings.Split(target, "/")
+	common := 0
+	for common < len(from) && common < len(to)-1 && from[common] == to[common] {
+		common++
+	}
+	up := strings.Repeat("../", len(from)-common)
+	return up + strings.Join(to[common:], "/")
+}
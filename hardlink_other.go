@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// hardLinkID never finds links here: the walk's file info has no file
+// identity, so every name is archived as a copy.
+func hardLinkID(fi os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
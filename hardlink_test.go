@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test relative paths between archive entries
+func TestRelativeEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{"b.bin", "a.bin", "a.bin"},
+		{"snap2/big.bin", "snap1/big.bin", "../snap1/big.bin"},
+		{"snap2/docs/x.pdf", "snap2/x.pdf", "../x.pdf"},
+		{"backup/2024-05-02/home/a.txt", "backup/2024-05-01/home/a.txt", "../../2024-05-01/home/a.txt"},
+		{"copy.txt", "docs/orig.txt", "docs/orig.txt"},
+		{"docs/copy.txt", "docs/orig.txt", "orig.txt"},
+	}
+	for _, test := range tests {
+		if got := relativeEntry(test.name, test.target); got != test.expected {
+			t.Errorf("relativeEntry(%q, %q) = %q, expected %q", test.name, test.target, got, test.expected)
+		}
+	}
+}
+
+// Test that hard-linked files are archived once
+func TestHardLinkArchive(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "snap1"), 0755)
+	os.MkdirAll(filepath.Join(root, "snap2"), 0755)
+	data := bytes.Repeat([]byte("backup"), 10000)
+	first := filepath.Join(root, "snap1", "big.bin")
+	os.WriteFile(first, data, 0644)
+	if err := os.Link(first, filepath.Join(root, "snap2", "big.bin")); err != nil {
+		return
+	}
+	fi, err := os.Lstat(first)
+	if err != nil {
+		t.Fatalf("Lstat error: %v", err)
+	}
+	if _, ok := hardLinkID(fi); !ok {
+		// No file identities on this platform.
+		return
+	}
+
+	for _, hardLinks := range []bool{false, true} {
+		sources := []archiveSource{{path: root, hardLinks: hardLinks}}
+		var buf bytes.Buffer
+		if err := writeZipArchive(&buf, sources, zip.Store, nil); err != nil {
+			t.Fatalf("hardLinks=%t: writeZipArchive error: %v", hardLinks, err)
+		}
+		if size, err := storedArchiveSize(sources); err != nil || size != int64(buf.Len()) {
+			t.Errorf("hardLinks=%t: storedArchiveSize = %d (%v), expected %d", hardLinks, size, err, buf.Len())
+		}
+		expectedSize := int64(2 * len(data))
+		if hardLinks {
+			expectedSize = int64(len(data) + len("../snap1/big.bin"))
+		}
+		if size := sourcesSize(sources); size != expectedSize {
+			t.Errorf("hardLinks=%t: sourcesSize = %d, expected %d", hardLinks, size, expectedSize)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("hardLinks=%t: invalid zip: %v", hardLinks, err)
+		}
+		for _, f := range zr.File {
+			if f.Name != "snap2/big.bin" {
+				continue
+			}
+			rc, _ := f.Open()
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			isLink := f.Mode()&os.ModeSymlink != 0
+			if isLink != hardLinks {
+				t.Errorf("hardLinks=%t: snap2/big.bin has mode %v", hardLinks, f.Mode())
+			}
+			if hardLinks && string(content) != "../snap1/big.bin" {
+				t.Errorf("Link points at %q, expected ../snap1/big.bin", content)
+			}
+			if !hardLinks && !bytes.Equal(content, data) {
+				t.Errorf("Copy has %d bytes, expected %d", len(content), len(data))
+			}
+		}
+
+		listing, _ := listFiles(sources)
+		if listing == nil || len(listing.Files) != 2 || listing.TotalSize != int64(2*len(data)) {
+			t.Errorf("hardLinks=%t: listing should show both files in full, got %+v", hardLinks, listing)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// isHidden reports whether a file is one -skip-hidden leaves out: a
+// dotfile, a Windows thumbnail cache or folder settings file, or a file
+// marked hidden or system on Windows.
+func isHidden(fi os.FileInfo) bool {
+	name := fi.Name()
+	if strings.HasPrefix(name, ".") || strings.EqualFold(name, "Thumbs.db") || strings.EqualFold(name, "desktop.ini") {
+		return true
+	}
+	return hiddenAttr(fi)
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+func hiddenAttr(fi os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Test leaving hidden files out of shared folders
+func TestSkipHidden(t *testing.T) {
+	tempDir := t.TempDir()
+	share := filepath.Join(tempDir, ".dotfiles")
+	for name, data := range map[string]string{
+		"notes.txt":          "notes",
+		".DS_Store":          "finder",
+		"Thumbs.db":          "thumbs",
+		".git/config":        "[core]",
+		"photos/a.jpg":       "jpeg",
+		"photos/desktop.ini": "[.ShellClassInfo]",
+	} {
+		path := filepath.Join(share, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(data), 0644)
+	}
+
+	tests := []struct {
+		skipHidden bool
+		expected   []string
+		size       int64
+	}{
+		{false, []string{".DS_Store", ".git/config", "Thumbs.db", "notes.txt", "photos/a.jpg", "photos/desktop.ini"}, 44},
+		{true, []string{"notes.txt", "photos/a.jpg"}, 9},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", share, 8080, false)
+		fs.skipHidden = test.skipHidden
+		sources, _, err := fs.shareSources()
+		if err != nil {
+			t.Fatalf("shareSources error: %v", err)
+		}
+		listing, err := listFiles(sources)
+		if err != nil {
+			t.Fatalf("listFiles error: %v", err)
+		}
+		var names []string
+		for _, f := range listing.Files {
+			names = append(names, f.Name)
+		}
+		if !slices.Equal(names, test.expected) {
+			t.Errorf("skipHidden=%t: listed %v, expected %v", test.skipHidden, names, test.expected)
+		}
+		if size := sourcesSize(sources); size != test.size {
+			t.Errorf("skipHidden=%t: size %d, expected %d", test.skipHidden, size, test.size)
+		}
+		_, err = fs.resolveShareEntry(".git/config")
+		if test.skipHidden != (err != nil) {
+			t.Errorf("skipHidden=%t: resolving .git/config gave %v", test.skipHidden, err)
+		}
+	}
+}
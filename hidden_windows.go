@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func hiddenAttr(fi os.FileInfo) bool {
+	data, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	return ok && data.FileAttributes&(syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_SYSTEM) != 0
+}
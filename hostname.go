@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const hostCacheTTL = 10 * time.Minute
+
+type hostEntry struct {
+	name    string
+	expires time.Time
+}
+
+// hostResolver maps client IPs to hostnames via reverse DNS and, optionally,
+// an mDNS PTR query for machines that only announce themselves on .local.
+type hostResolver struct {
+	mu         sync.Mutex
+	cache      map[string]hostEntry
+	mdns       bool
+	timeout    time.Duration
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+}
+
+func newHostResolver(mdns bool) *hostResolver {
+	return &hostResolver{
+		cache:      make(map[string]hostEntry),
+		mdns:       mdns,
+		timeout:    500 * time.Millisecond,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+	}
+}
+
+func (h *hostResolver) lookup(ip string) string {
+	h.mu.Lock()
+	if entry, ok := h.cache[ip]; ok && time.Now().Before(entry.expires) {
+		h.mu.Unlock()
+		return entry.name
+	}
+	h.mu.Unlock()
+
+	name := h.resolve(ip)
+
+	h.mu.Lock()
+	h.cache[ip] = hostEntry{name: name, expires: time.Now().Add(hostCacheTTL)}
+	h.mu.Unlock()
+	return name
+}
+
+func (h *hostResolver) cached(ip string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cache[ip].name
+}
+
+func (h *hostResolver) resolve(ip string) string {
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	if names, err := h.lookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		if name := cleanHostname(names[0]); name != "" {
+			return name
+		}
+	}
+	if h.mdns {
+		if name, err := mdnsLookupAddr(ip, h.timeout); err == nil {
+			return cleanHostname(name)
+		}
+	}
+	return ""
+}
+
+// cleanHostname shortens a resolved name for display. Anyone on the LAN can
+// answer the lookup, so only hostname characters are kept: the name ends up
+// in JSON and HTML.
+func cleanHostname(name string) string {
+	name = strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' {
+			return c
+		}
+		return -1
+	}, name)
+	name = strings.TrimSuffix(name, ".")
+	name = strings.TrimSuffix(name, ".local")
+	if name == "localhost" {
+		return ""
+	}
+	return name
+}
+
+func (fs *FileServer) clientHost(ip string) string {
+	if fs.resolver == nil || ip == "" {
+		return ""
+	}
+	return fs.resolver.cached(ip)
+}
+
+func (fs *FileServer) clientLabel(ip string) string {
+	if fs.resolver == nil || ip == "" {
+		return ip
+	}
+	if name := fs.resolver.lookup(ip); name != "" {
+		return fmt.Sprintf("%s (%s)", name, ip)
+	}
+	return ip
+}
+
+// mdnsLookupAddr sends a one-shot ("legacy unicast") mDNS PTR query; responders
+// answer directly to our ephemeral port, so no multicast membership is needed.
+func mdnsLookupAddr(ip string, timeout time.Duration) (string, error) {
+	v4 := net.ParseIP(ip).To4()
+	if v4 == nil {
+		return "", errors.New("mdns lookup supports IPv4 only")
+	}
+	qname := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", v4[3], v4[2], v4[1], v4[0])
+
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	idBytes := make([]byte, 2)
+	rand.Read(idBytes)
+	id := binary.BigEndian.Uint16(idBytes)
+
+	if _, err := conn.Write(buildPTRQuery(id, qname)); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		if name, err := parsePTRResponse(buf[:n], id); err == nil {
+			return name, nil
+		}
+	}
+}
+
+func buildPTRQuery(id uint16, name string) []byte {
+	msg := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = append(msg, 0, 12) // TYPE PTR
+	msg = append(msg, 0, 1)  // CLASS IN
+	return msg
+}
+
+func parsePTRResponse(msg []byte, id uint16) (string, error) {
+	if len(msg) < 12 {
+		return "", errors.New("short dns message")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id || msg[2]&0x80 == 0 {
+		return "", errors.New("not a response to our query")
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return "", err
+		}
+		off = next + 4
+	}
+	for i := 0; i < ancount; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return "", err
+		}
+		if next+10 > len(msg) {
+			return "", errors.New("truncated answer")
+		}
+		rrType := binary.BigEndian.Uint16(msg[next:])
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdlen > len(msg) {
+			return "", errors.New("truncated answer")
+		}
+		if rrType == 12 {
+			name, _, err := readDNSName(msg, rdata)
+			return name, err
+		}
+		off = rdata + rdlen
+	}
+	return "", errors.New("no PTR answer")
+}
+
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; jumps < 16; {
+		if off >= len(msg) {
+			return "", 0, errors.New("name out of bounds")
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("bad compression pointer")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errors.New("label out of bounds")
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+	return "", 0, errors.New("too many compression pointers")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Test hostname resolution and caching
+func TestHostResolver(t *testing.T) {
+	calls := 0
+	h := newHostResolver(false)
+	h.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		calls++
+		if addr == "192.168.1.42" {
+			return []string{"daves-macbook.local."}, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	if name := h.lookup("192.168.1.42"); name != "daves-macbook" {
+		t.Errorf("lookup = %q, expected daves-macbook", name)
+	}
+	h.lookup("192.168.1.42")
+	if calls != 1 {
+		t.Errorf("Expected 1 resolver call thanks to caching, got %d", calls)
+	}
+	if name := h.lookup("192.168.1.7"); name != "" {
+		t.Errorf("Unresolvable address should return empty name, got %q", name)
+	}
+
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	if label := fs.clientLabel("192.168.1.42"); label != "192.168.1.42" {
+		t.Errorf("Without a resolver the label should be the bare IP, got %q", label)
+	}
+	fs.resolver = h
+	if label := fs.clientLabel("192.168.1.42"); label != "daves-macbook (192.168.1.42)" {
+		t.Errorf("clientLabel = %q, expected 'daves-macbook (192.168.1.42)'", label)
+	}
+}
+
+// Test resolved names are trimmed and stripped to hostname characters
+func TestCleanHostname(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"daves-macbook.local.", "daves-macbook"},
+		{"nas.example.com.", "nas.example.com"},
+		{"localhost.", ""},
+		{`evil","client_ip":"1.2.3.4`, "evilclientip1.2.3.4"},
+		{`back\slash.local`, "backslash"},
+		{"<script>.local.", "script"},
+	}
+	for _, tt := range tests {
+		if got := cleanHostname(tt.name); got != tt.expected {
+			t.Errorf("cleanHostname(%q) = %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+// Test mDNS PTR message encoding and decoding
+func TestPTRMessage(t *testing.T) {
+	query := buildPTRQuery(0x1234, "42.1.168.192.in-addr.arpa")
+
+	// Build a response by echoing the question and adding a compressed answer.
+	resp := append([]byte{}, query...)
+	resp[2] = 0x84
+	binary.BigEndian.PutUint16(resp[6:], 1)
+	resp = append(resp, 0xC0, 12)     // NAME -> question name
+	resp = append(resp, 0, 12, 0, 1)  // TYPE PTR, CLASS IN
+	resp = append(resp, 0, 0, 0, 120) // TTL
+	rdata := []byte{5, 'd', 'a', 'v', 'e', 's', 5, 'l', 'o', 'c', 'a', 'l', 0}
+	resp = append(resp, 0, byte(len(rdata)))
+	resp = append(resp, rdata...)
+
+	name, err := parsePTRResponse(resp, 0x1234)
+	if err != nil {
+		t.Fatalf("parsePTRResponse error: %v", err)
+	}
+	if name != "daves.local" {
+		t.Errorf("parsePTRResponse = %q, expected daves.local", name)
+	}
+
+	if _, err := parsePTRResponse(resp, 0x9999); err == nil {
+		t.Error("Response with a different ID should be rejected")
+	}
+	if _, err := parsePTRResponse(resp[:20], 0x1234); err == nil {
+		t.Error("Truncated response should be rejected")
+	}
+}
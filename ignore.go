@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile in a shared folder lists what to leave out of it, in
+// .gitignore syntax.
+const ignoreFile = ".fileshareignore"
+
+// ignoreRule is one line of an ignore file.
+type ignoreRule struct {
+	segments []string // the pattern split at slashes
+	negate   bool     // a leading ! re-includes what earlier rules left out
+	dirOnly  bool     // a trailing / matches folders only
+	anchored bool     // a slash at the start or middle matches from the root
+}
+
+// ignoreRules applies its rules in order, the last match deciding, as git
+// does. Rules only come from the top of the shared folder; ignore files in
+// subfolders are shared like any other file.
+type ignoreRules []ignoreRule
+
+func parseIgnoreRules(text string) ignoreRules {
+	var rules ignoreRules
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if rule.negate = strings.HasPrefix(line, "!"); rule.negate {
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if rule.dirOnly = strings.HasSuffix(line, "/"); rule.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// loadIgnoreRules reads the ignore file of dir, and with gitignore its
+// .gitignore first, so the ignore file can re-include what git skips. The
+// ignore file itself is never shared. It returns nil if there are none.
+func loadIgnoreRules(dir string, gitignore bool) (ignoreRules, error) {
+	var rules ignoreRules
+	names := []string{ignoreFile}
+	if gitignore {
+		names = []string{".gitignore", ignoreFile}
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, parseIgnoreRules(string(data))...)
+		if name == ignoreFile {
+			rules = append(rules, ignoreRule{segments: []string{ignoreFile}, anchored: true})
+		}
+	}
+	return rules, nil
+}
+
+// match reports whether rel, a slash-separated path below the shared
+// folder, is left out. Callers check folders before their contents, as
+// nothing inside an ignored folder can be re-included.
+func (rules ignoreRules) match(rel string, isDir bool) bool {
+	ignored := false
+	names := strings.Split(rel, "/")
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		var matched bool
+		if rule.anchored {
+			matched = matchSegments(rule.segments, names)
+		} else {
+			matched = matchSegments(rule.segments, names[len(names)-1:])
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path elements against pattern elements, where **
+// stands for any number of elements.
+func matchSegments(pattern, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(names); i >= 0; i-- {
+				if matchSegments(pattern[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], names[0]); !ok {
+			return false
+		}
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
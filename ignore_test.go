@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Test matching paths against .gitignore-style rules
+func TestIgnoreRules(t *testing.T) {
+	rules := parseIgnoreRules(`# build output
+node_modules/
+/dist
+*.log
+!keep.log
+docs/*.pdf
+**/cache/**
+\#notes
+trailing.txt   
+`)
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"node_modules", true, true},
+		{"web/node_modules", true, true},
+		{"node_modules", false, false},
+		{"dist", true, true},
+		{"web/dist", true, false},
+		{"debug.log", false, true},
+		{"logs/server.log", false, true},
+		{"keep.log", false, false},
+		{"docs/manual.pdf", false, true},
+		{"docs/en/manual.pdf", false, false},
+		{"manual.pdf", false, false},
+		{"a/cache/b/c.bin", false, true},
+		{"cache/x", false, true},
+		{"#notes", false, true},
+		{"trailing.txt", false, true},
+		{"src/main.go", false, false},
+	}
+	for _, test := range tests {
+		if got := rules.match(test.path, test.isDir); got != test.expected {
+			t.Errorf("match(%q, dir=%t) = %t, expected %t", test.path, test.isDir, got, test.expected)
+		}
+	}
+}
+
+// Test that a shared folder's ignore files shape what is shared
+func TestShareIgnoreFile(t *testing.T) {
+	share := t.TempDir()
+	for name, data := range map[string]string{
+		ignoreFile:          "*.tmp\n!build/\n",
+		".gitignore":        "build/\nsecret.env\n",
+		"main.go":           "package main",
+		"scratch.tmp":       "x",
+		"secret.env":        "KEY=1",
+		"build/app":         "binary",
+		"vendor/lib/lib.go": "package lib",
+	} {
+		path := filepath.Join(share, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(data), 0644)
+	}
+
+	tests := []struct {
+		gitignore bool
+		expected  []string
+	}{
+		{false, []string{".gitignore", "build/app", "main.go", "secret.env", "vendor/lib/lib.go"}},
+		{true, []string{".gitignore", "build/app", "main.go", "vendor/lib/lib.go"}},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", share, 8080, false)
+		fs.gitignore = test.gitignore
+		sources, _, err := fs.shareSources()
+		if err != nil {
+			t.Fatalf("shareSources error: %v", err)
+		}
+		listing, err := listFiles(sources)
+		if err != nil {
+			t.Fatalf("listFiles error: %v", err)
+		}
+		var names []string
+		for _, f := range listing.Files {
+			names = append(names, f.Name)
+		}
+		if !slices.Equal(names, test.expected) {
+			t.Errorf("gitignore=%t: listed %v, expected %v", test.gitignore, names, test.expected)
+		}
+		if _, err := fs.resolveShareEntry("scratch.tmp"); err == nil {
+			t.Errorf("gitignore=%t: an ignored file could be fetched", test.gitignore)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// kickBan is how long a kicked client is turned away unless ?ban= says
+// otherwise.
+const kickBan = 10 * time.Minute
+
+// banList holds client IPs that were kicked, until when.
+type banList struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func (b *banList) ban(ip string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.until == nil {
+		b.until = make(map[string]time.Time)
+	}
+	b.until[ip] = until
+}
+
+// bannedFor returns how long ip is still turned away.
+func (b *banList) bannedFor(ip string, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[ip]
+	if !ok {
+		return 0
+	}
+	if !now.Before(until) {
+		delete(b.until, ip)
+		return 0
+	}
+	return until.Sub(now)
+}
+
+type connKey struct{}
+
+// connContext makes each request's connection available to requestConn,
+// so a kick can close it.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+func requestConn(r *http.Request) net.Conn {
+	conn, _ := r.Context().Value(connKey{}).(net.Conn)
+	return conn
+}
+
+// withBans turns away kicked clients until their ban ends.
+func (fs *FileServer) withBans(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait := fs.bans.bannedFor(fs.getClientIP(r), fs.clock.Now())
+		if wait <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		seconds := int((wait + time.Second - 1) / time.Second)
+		auditNote(r, "kicked")
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		httpError(w, r, fmt.Sprintf("Disconnected by the host, try again in %v", time.Duration(seconds)*time.Second), http.StatusForbidden)
+	})
+}
+
+// handleKick aborts a client's transfers, closes its pages and bans its IP
+// for a while, for when the wrong machine took the single-client slot.
+func (fs *FileServer) handleKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !fs.requireAdmin(w, r) {
+		return
+	}
+	ban := kickBan
+	if value := r.URL.Query().Get("ban"); value != "" {
+		var err error
+		if ban, err = time.ParseDuration(value); err != nil || ban < 0 {
+			httpError(w, r, "Invalid ban", http.StatusBadRequest)
+			return
+		}
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		httpError(w, r, "No such client", http.StatusNotFound)
+		return
+	}
+	ip, conns, ok := fs.clients.lookup(id)
+	if !ok {
+		httpError(w, r, "No such client", http.StatusNotFound)
+		return
+	}
+	if ip == fs.getClientIP(r) {
+		httpError(w, r, "Cannot kick yourself", http.StatusBadRequest)
+		return
+	}
+
+	until := fs.clock.Now().Add(ban)
+	if ban > 0 {
+		fs.bans.ban(ip, until)
+	}
+	fs.activeMu.Lock()
+	active := fs.activeClient == ip
+	fs.activeMu.Unlock()
+	label := fs.clientLabel(ip)
+	if active {
+		fs.dropClient(ip)
+		fs.cancel("host (kicked " + label + ")")
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	auditNote(r, "kicked "+ip)
+	if ban > 0 {
+		fs.logRequest(r, fmt.Sprintf("Kicked %s, banned for %v", label, ban))
+	} else {
+		fs.logRequest(r, fmt.Sprintf("Kicked %s", label))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		IP          string    `json:"ip"`
+		BannedUntil time.Time `json:"banned_until"`
+	}{ip, until})
+}
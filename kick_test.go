@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test kicking a client off the single-client slot
+func TestHandleKick(t *testing.T) {
+	fs := NewFileServer("send", "/tmp/file.txt", 8080, false)
+	fs.clock = fastClock{}
+	fs.adminToken = "adm"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/clients/{id}/kick", fs.handleKick)
+	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {})
+	handler := fs.withBans(mux)
+
+	// The wrong machine grabbed the slot and is downloading.
+	server, client := net.Pipe()
+	defer client.Close()
+	download := httptest.NewRequest("GET", "/api/download", nil)
+	download.RemoteAddr = "192.168.1.66:5000"
+	fs.acquireClient("192.168.1.66")
+	fs.startTransfer("192.168.1.66", 100)
+	fs.trackClient(download.WithContext(connContext(download.Context(), server)), activityDownloading)
+
+	kick := func(target, token string) int {
+		req := httptest.NewRequest("POST", target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	tests := []struct {
+		target   string
+		token    string
+		expected int
+	}{
+		{"/api/clients/1/kick", "", http.StatusUnauthorized},
+		{"/api/clients/1/kick", "wrong", http.StatusUnauthorized},
+		{"/api/clients/9/kick", "adm", http.StatusNotFound},
+		{"/api/clients/x/kick", "adm", http.StatusNotFound},
+		{"/api/clients/1/kick?ban=soon", "adm", http.StatusBadRequest},
+		{"/api/clients/1/kick", "adm", http.StatusOK},
+	}
+	for _, test := range tests {
+		if code := kick(test.target, test.token); code != test.expected {
+			t.Errorf("POST %s with %q = %d, expected %d", test.target, test.token, code, test.expected)
+		}
+	}
+
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Errorf("Kicked client's connection should be closed")
+	}
+	if !fs.acquireClient("192.168.1.10") {
+		t.Errorf("Kicking should free the single-client slot")
+	}
+	if fs.status.Status != "cancelled" {
+		t.Errorf("Kicked transfer status = %q, expected cancelled", fs.status.Status)
+	}
+
+	// The kicked IP is turned away until the ban ends.
+	info := httptest.NewRequest("GET", "/api/info", nil)
+	info.RemoteAddr = "192.168.1.66:5001"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, info)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Retry-After") != "600" {
+		t.Errorf("Kicked client got %d (Retry-After %q), expected 403 for 600s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if wait := fs.bans.bannedFor("192.168.1.66", fastClock{}.Now().Add(kickBan)); wait != 0 {
+		t.Errorf("Ban should have ended, %v left", wait)
+	}
+}
+
+// Test that ban entries expire
+func TestBanList(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var bans banList
+	bans.ban("10.0.0.5", start.Add(time.Minute))
+	tests := []struct {
+		ip       string
+		at       time.Duration
+		expected time.Duration
+	}{
+		{"10.0.0.5", 0, time.Minute},
+		{"10.0.0.5", 45 * time.Second, 15 * time.Second},
+		{"10.0.0.6", 0, 0},
+		{"10.0.0.5", time.Minute, 0},
+	}
+	for _, test := range tests {
+		if wait := bans.bannedFor(test.ip, start.Add(test.at)); wait != test.expected {
+			t.Errorf("bannedFor(%s) after %v = %v, expected %v", test.ip, test.at, wait, test.expected)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener caps the number of open connections. Connections beyond the
+// limit are accepted and closed immediately instead of queueing, so a port
+// scan or a download manager can't pin file descriptors on small devices.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, n)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case l.sem <- struct{}{}:
+			return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+		default:
+			conn.Close()
+		}
+	}
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
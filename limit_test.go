@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Test connection limiting
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener := newLimitListener(inner, 1)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	c1, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c1.Close()
+	first := <-accepted
+
+	// Second connection exceeds the limit and should be closed by the server.
+	c2, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c2.Read(make([]byte, 1)); err == nil {
+		t.Error("Connection over the limit should be closed")
+	}
+
+	// Releasing the first slot allows a new connection.
+	first.Close()
+	c3, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c3.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Error("Connection should be accepted after a slot is released")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logRotation says when a log file is moved aside and how many old ones
+// are kept. The zero value never rotates.
+type logRotation struct {
+	maxSize int64         // bytes, 0 for no limit
+	maxAge  time.Duration // 0 for no limit
+	keep    int           // rotated files kept, 0 keeps all
+}
+
+// rotatedSuffix is appended to a rotated log's name; it sorts by time.
+const rotatedSuffix = "20060102-150405"
+
+// rotatingFile appends to a log file, moving it aside to name.<time> once
+// it grows past maxSize or has been written to for maxAge, and deleting
+// all but the newest keep of those.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	rotate  logRotation
+	clock   Clock
+	f       *os.File
+	size    int64
+	started time.Time
+}
+
+func openRotatingFile(path string, rotate logRotation, clock Clock) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, rotate: rotate, clock: clock}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.started = f, info.Size(), r.clock.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.due(int64(len(p))) {
+		if err := r.rotateNow(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// due reports whether writing n more bytes should go to a fresh file.
+func (r *rotatingFile) due(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.rotate.maxSize > 0 && r.size+n > r.rotate.maxSize {
+		return true
+	}
+	return r.rotate.maxAge > 0 && r.clock.Now().Sub(r.started) >= r.rotate.maxAge
+}
+
+func (r *rotatingFile) rotateNow() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	stamp := r.path + "." + r.clock.Now().Format(rotatedSuffix)
+	name := stamp
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", stamp, i)
+	}
+	if err := os.Rename(r.path, name); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	return r.prune()
+}
+
+// prune deletes the oldest rotated files beyond the ones to keep.
+func (r *rotatingFile) prune() error {
+	if r.rotate.keep <= 0 {
+		return nil
+	}
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(r.path)) + `\.\d{8}-\d{6}(-\d+)?$`)
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, entry := range entries {
+		if pattern.MatchString(entry.Name()) {
+			rotated = append(rotated, entry.Name())
+		}
+	}
+	stampEnd := len(filepath.Base(r.path)) + 1 + len(rotatedSuffix)
+	slices.SortFunc(rotated, func(a, b string) int {
+		// By time, then by the -N added to names rotated within a second.
+		return cmp.Or(strings.Compare(a[:stampEnd], b[:stampEnd]), len(a)-len(b), strings.Compare(a, b))
+	})
+	for len(rotated) > r.rotate.keep {
+		os.Remove(filepath.Join(filepath.Dir(r.path), rotated[0]))
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
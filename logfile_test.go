@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test size and age based log rotation with retention
+func TestRotatingFile(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		rotate   logRotation
+		writes   []time.Duration // when each 10-byte line is written
+		current  string          // lines left in the live file
+		expected []string        // rotated files left, oldest first
+	}{
+		{"no limits", logRotation{}, []time.Duration{0, time.Hour, 48 * time.Hour}, "012", nil},
+		{"by size", logRotation{maxSize: 25}, []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second},
+			"4", []string{"app.log.20240501-120002", "app.log.20240501-120004"}},
+		{"by size, keep 1", logRotation{maxSize: 25, keep: 1}, []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second},
+			"4", []string{"app.log.20240501-120004"}},
+		{"same second", logRotation{maxSize: 10}, []time.Duration{0, 0, 0},
+			"2", []string{"app.log.20240501-120000", "app.log.20240501-120000-1"}},
+		{"by age", logRotation{maxAge: 24 * time.Hour}, []time.Duration{0, time.Hour, 25 * time.Hour, 30 * time.Hour, 50 * time.Hour},
+			"4", []string{"app.log.20240502-130000", "app.log.20240503-140000"}},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		os.WriteFile(filepath.Join(dir, "app.log.bak"), []byte("unrelated"), 0644)
+		clock := &tickClock{now: start}
+		f, err := openRotatingFile(path, test.rotate, clock)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i, at := range test.writes {
+			clock.now = start.Add(at)
+			if _, err := f.Write([]byte(strings.Repeat(string(rune('0'+i)), 9) + "\n")); err != nil {
+				t.Fatalf("%s: write %d: %v", test.name, i, err)
+			}
+		}
+		f.Close()
+
+		data, _ := os.ReadFile(path)
+		var lines []string
+		for _, line := range strings.Fields(string(data)) {
+			lines = append(lines, line[:1])
+		}
+		if strings.Join(lines, "") != test.current {
+			t.Errorf("%s: live file has lines %q, expected %q", test.name, strings.Join(lines, ""), test.current)
+		}
+		var rotated []string
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.Name() != "app.log" && entry.Name() != "app.log.bak" {
+				rotated = append(rotated, entry.Name())
+			}
+		}
+		if !slices.Equal(rotated, test.expected) {
+			t.Errorf("%s: rotated files %v, expected %v", test.name, rotated, test.expected)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "app.log.bak")); err != nil {
+			t.Errorf("%s: unrelated file was removed", test.name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Tuning for -low-mem, which targets routers and single-board computers
+// with around 128 MB of RAM.
+const (
+	copyBufferSize         = 64 * 1024
+	lowMemCopyBufferSize   = 8 * 1024
+	lowMemMaxSSEClients    = 4
+	lowMemGCPercent        = 50
+	lowMemProgressInterval = 250 * time.Millisecond
+	maxUploadFieldSize     = 4 << 10
+)
+
+var errTooManyStreams = errors.New("too many event streams")
+
+// copyBuffer returns the buffer for one transfer's copy loop.
+func (fs *FileServer) copyBuffer() []byte {
+	if fs.lowMem {
+		return make([]byte, lowMemCopyBufferSize)
+	}
+	return make([]byte, copyBufferSize)
+}
+
+// addSSEClient registers an event stream, refusing new ones in low-memory
+// mode once the cap is reached.
+func (fs *FileServer) addSSEClient(client *sseClient) error {
+	fs.sseMu.Lock()
+	defer fs.sseMu.Unlock()
+	if fs.lowMem && len(fs.sseClients) >= lowMemMaxSSEClients {
+		return errTooManyStreams
+	}
+	fs.sseClients[client] = true
+	return nil
+}
+
+func (fs *FileServer) removeSSEClient(client *sseClient) {
+	fs.sseMu.Lock()
+	delete(fs.sseClients, client)
+	fs.sseMu.Unlock()
+}
+
+// streamUploadPart reads a multipart upload up to its "file" part without
+// buffering the file, collecting the small text fields that precede it.
+// Fields sent after the file are not seen, so clients must send name and
+// dir first (curl -F keeps the order given).
+func streamUploadPart(r *http.Request) (*multipart.Part, url.Values, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := url.Values{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errors.New("no file in upload")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() == "file" {
+			return part, fields, nil
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+		part.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		fields.Add(part.FormName(), string(value))
+	}
+}
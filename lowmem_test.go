@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test streamed uploads in low-memory mode
+func TestLowMemUpload(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_lowmem_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	fs.lowMem = true
+	tests := []struct {
+		filename string
+		fields   map[string]string
+		expected string
+	}{
+		{"plain.txt", nil, "plain.txt"},
+		{"orig.txt", map[string]string{"name": "renamed.txt"}, "renamed.txt"},
+		{"photo.jpg", map[string]string{"dir": "album/2024"}, "album/2024/photo.jpg"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleUpload(rec, newUploadRequest(t, test.filename, "streamed content", test.fields))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Upload %s = %d, expected 200: %s", test.filename, rec.Code, rec.Body.String())
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(tempDir, test.expected))
+		if err != nil || string(data) != "streamed content" {
+			t.Errorf("Upload %s: expected %s with the content, got %q (%v)", test.filename, test.expected, data, err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", nil)
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	fs.handleUpload(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Non-multipart upload = %d, expected 400", rec.Code)
+	}
+}
+
+// Test the event stream cap
+func TestSSEClientCap(t *testing.T) {
+	tests := []struct {
+		lowMem  bool
+		streams int
+		refused bool
+	}{
+		{false, lowMemMaxSSEClients + 1, false},
+		{true, lowMemMaxSSEClients, false},
+		{true, lowMemMaxSSEClients + 1, true},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", ".", 8080, false)
+		fs.lowMem = test.lowMem
+		var err error
+		for i := 0; i < test.streams; i++ {
+			err = fs.addSSEClient(newSSEClient(1))
+		}
+		if (err != nil) != test.refused {
+			t.Errorf("lowMem=%t with %d streams: err = %v, expected refused=%t", test.lowMem, test.streams, err, test.refused)
+		}
+	}
+}
@@ -2,15 +2,26 @@ package main
 
 import (
 	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -25,113 +36,401 @@ type TransferStatus struct {
 	Status         string    `json:"status"`
 	Error          string    `json:"error,omitempty"`
 	ClientIP       string    `json:"client_ip,omitempty"`
+	ClientHost     string    `json:"client_host,omitempty"`
+	SHA256         string    `json:"sha256,omitempty"`
+	Phase          string    `json:"phase,omitempty"`
+	Sent           int64     `json:"sent,omitempty"`
 	StartTime      time.Time `json:"start_time"`
 	LastUpdateTime time.Time `json:"last_update_time"`
 }
 
+// Transfer phases. Directory sends first scan the tree for its size, then
+// stream the archive while compressing it; "sending" covers plain file
+// transfers and the final flush of an archive.
+const (
+	phaseScanning    = "scanning"
+	phaseCompressing = "compressing"
+	phaseSending     = "sending"
+)
+
 type FileServer struct {
-	mode         string
-	path         string
-	port         int
-	status       *TransferStatus
-	statusMu     sync.RWMutex
-	sseClients   map[chan string]bool
-	sseMu        sync.RWMutex
-	autoExit     bool
-	server       *http.Server
-	activeClient string
-	activeMu     sync.Mutex
-	transferLog  []string
-	logMu        sync.RWMutex
+	mode          string
+	path          string
+	port          int
+	status        *TransferStatus
+	statusMu      sync.RWMutex
+	sseClients    map[*sseClient]bool
+	sseDropped    atomic.Int64
+	sseMu         sync.RWMutex
+	polls         pollLog
+	heartbeat     time.Duration
+	autoExit      bool
+	exitOn        map[string]bool
+	server        *http.Server
+	activeClient  string
+	activeRefs    int
+	ranged        *rangedDownload
+	rangedMu      sync.Mutex
+	metaHash      fileHash
+	torrent       *torrent
+	torrentPort   int
+	signKey       []byte
+	signTTL       time.Duration
+	signExpiry    time.Time
+	mirrors       []uploadMirror
+	autoExtract   bool
+	cas           bool
+	casMu         sync.Mutex
+	flatten       bool
+	password      string
+	sessions      sessionStore
+	authLimit     authLimiter
+	tokens        []accessToken
+	brand         *branding
+	uiDir         string
+	atRest        *atRestKey
+	mirrorWG      sync.WaitGroup
+	notifiers     []notifier
+	notifyWG      sync.WaitGroup
+	activeMu      sync.Mutex
+	transferLog   []string
+	logMu         sync.RWMutex
+	pathMu        sync.RWMutex
+	ctlSocket     string
+	trustedNets   []*net.IPNet
+	basePath      string
+	shareCode     string
+	grpcAddr      string
+	dlna          bool
+	dlnaID        string
+	castTo        string
+	thumbs        thumbCache
+	lowMem        bool
+	compress      string
+	zipStore      bool
+	skipHidden    bool
+	gitignore     bool
+	hardLinks     bool
+	allowFetch    bool
+	lastProgress  time.Time
+	progressEvery time.Duration // least time between progress updates, 0 for every chunk
+	fsys          FileSystem
+	clock         Clock
+	ready         func(*FileServer)
+	cors          *corsPolicy
+	maxConns      int
+	debug         bool
+	started       time.Time
+	resolver      *hostResolver
+	audit         *auditLog
+	logFile       *rotatingFile // -log-file, a copy of the transfer log
+	sizeCache     *dirSizeCache
+	walkedMu      sync.Mutex
+	walked        walkedSize // the share's size without -watch
+	sources       []string
+	stdin         io.Reader // the share with "send -", streamed once
+	stdinTaken    atomic.Bool
+	stdout        io.Writer // where uploads go with "recv -", taken once
+	stdoutTaken   atomic.Bool
+	stdoutErr     error
+	recvDir       string // where uploads go in both mode; path is the share
+	receiving     bool   // the transfer in status is an upload, under statusMu
+	downloadName  string
+	onConflict    string
+	perClientDir  bool
+	adminToken    string
+	message       string
+	motd          string
+	terms         string // rendered -terms, accepted before downloading
+	termsKey      []byte
+	copyURL       bool
+	events        *eventWriter
+	clipboard     clipboardBackend
+	clipText      string
+	clipMu        sync.Mutex
+	signals       *signalHub
+	watch         bool
+	watchMu       sync.Mutex
+	watchTimer    *time.Timer
+	watchChanges  int
+	uploads       map[string]*uploadSession
+	stats         throughputStats
+	clients       clientRegistry
+	bans          banList
+	maxTotal      int64
+	maxTotalExit  bool
+	receivedTotal int64
+	quotaMu       sync.Mutex
+	uploadsMu     sync.Mutex
+	done          chan struct{}
+	doneOnce      sync.Once
+
+	bandwidthBudget int64 // bytes in and out across all listeners, 0 for no limit
+	budgetUsed      atomic.Int64
 }
 
-var (
-	mode     string
-	path     string
-	autoExit bool
-	port     int
-	server   *FileServer
-)
-
 func main() {
+	var opts Options
+	var plain bool
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <send|recv> <path>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] send <path>...\n       %s [options] <recv|serve> <dir>\n       %s [options] <clipboard|p2p|daemon>\n\n", os.Args[0], os.Args[0], os.Args[0])
 		fmt.Fprintf(os.Stderr, "Commands:\n")
-		fmt.Fprintf(os.Stderr, "  send <path>     Send file or directory\n")
-		fmt.Fprintf(os.Stderr, "  recv <dir>      Receive files to directory\n")
+		fmt.Fprintf(os.Stderr, "  send <path>...  Send files, directories or quoted glob patterns (e.g. '*.log'); several are shared together, - streams stdin\n")
+		fmt.Fprintf(os.Stderr, "  -url <u> send   Download a remote file once and share it on the LAN\n")
+		fmt.Fprintf(os.Stderr, "  recv <dir>      Receive files to directory; - writes one upload to stdout\n")
+		fmt.Fprintf(os.Stderr, "  both -send <path> -recv <dir>\n                  Share a file or directory and receive uploads on the same page\n")
+		fmt.Fprintf(os.Stderr, "  daemon          Host shares added and removed at runtime through /admin/shares (needs -admin-token)\n")
+		fmt.Fprintf(os.Stderr, "  serve <dir>     Browse a directory read-only; files download one by one or all as a zip\n")
+		fmt.Fprintf(os.Stderr, "  clipboard       Sync text between the host clipboard and the web page\n")
+		fmt.Fprintf(os.Stderr, "  p2p             Let browsers on the page send files directly to each other\n")
+		fmt.Fprintf(os.Stderr, "  ctl <command>   Control a running instance (status, cancel, change-path, shutdown)\n")
+		fmt.Fprintf(os.Stderr, "  get -code <c>   Find a 'send -code' share on the LAN and download it\n")
+		fmt.Fprintf(os.Stderr, "  push <path> <u> Upload files and directories to a recv instance\n")
+		fmt.Fprintf(os.Stderr, "  speedtest <url> Measure throughput to another fileshare instance\n")
+		fmt.Fprintf(os.Stderr, "  bench <path>    Measure disk, compression and loopback HTTP speed on this machine\n")
+		fmt.Fprintf(os.Stderr, "  mount <url> <d> Mount a remote send share read-only on directory d via FUSE\n")
+		fmt.Fprintf(os.Stderr, "  decrypt <f>...  Decrypt files received with -encrypt-at-rest\n")
+		fmt.Fprintf(os.Stderr, "  version         Print version and build information\n")
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
 	}
 
-	flag.IntVar(&port, "p", DefaultPort, "Port to listen on (0 for random)")
-	flag.BoolVar(&autoExit, "auto-exit", false, "Auto exit after transfer complete")
+	flag.IntVar(&opts.Port, "p", DefaultPort, "Port to listen on (0 for random)")
+	flag.Var(autoExitFlag{&opts.AutoExit, &opts.AutoExitOn}, "auto-exit", "Auto exit after a transfer ends; -auto-exit=on=completed,error exits only on those outcomes (completed, cancelled, error)")
+	flag.StringVar(&opts.Compress, "compress", compressOff, "Compress single-file downloads for clients that accept it: off or gzip (already-compressed formats are sent as is)")
+	flag.BoolVar(&opts.SkipHidden, "skip-hidden", false, "Leave dotfiles, Thumbs.db, desktop.ini and Windows hidden or system files out of shared folders")
+	flag.BoolVar(&opts.GitIgnore, "gitignore", false, "Also leave out what the shared folder's .gitignore lists (a .fileshareignore there is always honored)")
+	flag.BoolVar(&opts.HardLinks, "hardlinks", false, "Archive files with several hard links once, storing their other names as symlinks (for backup snapshots)")
+	flag.BoolVar(&opts.AllowFetch, "allow-fetch", false, "Let uploaders have the server download a URL into the receive directory (POST /api/fetch); the server will request any URL it can reach")
+	flag.StringVar(&opts.URL, "url", "", "Share a remote http(s) file in send mode instead of a path: it is downloaded once into a cache and served from there, e.g. -url https://example.com/big.iso send")
+	flag.StringVar(&opts.URLCache, "url-cache", "", "Directory for -url downloads (default: fileshare/relay in the user cache directory)")
+	flag.BoolVar(&opts.ZipStore, "zip-store", false, "Send directories as uncompressed (stored) zips: faster for photos and videos, and browsers see the exact size")
+	flag.StringVar(&opts.MaxTotal, "max-total", "", "Stop accepting uploads once this much has been received in total, e.g. 50GB (recv mode)")
+	flag.StringVar(&opts.MirrorS3, "mirror-s3", "", "Copy each received file to s3://bucket/prefix or http(s)://host/bucket/prefix (MinIO); credentials from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY")
+	flag.StringVar(&opts.ForwardWebDAV, "forward-webdav", "", "Copy each received file into this WebDAV/Nextcloud folder URL; credentials from FILESHARE_WEBDAV_USER/FILESHARE_WEBDAV_PASSWORD or ~/.netrc")
+	flag.BoolVar(&opts.AutoExtract, "auto-extract", false, "Unpack received .zip, .tar and .tar.gz files into a folder next to them")
+	flag.BoolVar(&opts.Flatten, "flatten", false, "With -auto-extract, drop a folder that wraps an archive's whole contents")
+	flag.BoolVar(&opts.CAS, "cas", false, "Keep received files once per content under .fileshare/objects with a readable index; repeats are hard links and multi-part uploads of known content finish instantly")
+	flag.StringVar(&opts.EncryptAtRest, "encrypt-at-rest", "", "Encrypt received files with this passphrase (AES-256-GCM, saved as <name>.enc); '-' reads it from FILESHARE_PASSPHRASE or the terminal. Decrypt with 'fileshare decrypt'")
+	flag.StringVar(&opts.Password, "password", "", "Require this password to use the web UI; browsers sign in once and keep a session cookie, scripts can send it as a bearer token")
+	flag.Var(notifyFlags{&opts.Notify}, "notify", "Post a message on completed transfers and reached limits to ntfy:<topic URL>, telegram:<bot token>@<chat id> (or a chat id with FILESHARE_TELEGRAM_TOKEN) or slack:<webhook URL>; repeatable")
+	flag.Var(tokenFlags{&opts.Tokens}, "token", "Add an access token as name:scope:secret, scope being read (download), write (also upload) or admin (also manage files); repeatable")
+	flag.StringVar(&opts.Title, "title", "", "Title shown on the share page and login page instead of FileShare")
+	flag.StringVar(&opts.Logo, "logo", "", "Image file shown next to the title on the share page")
+	flag.StringVar(&opts.Accent, "accent", "", "Accent color of the share page, e.g. #0a7 or #00aa77")
+	flag.StringVar(&opts.UIDir, "ui-dir", "", "Directory whose index.html, login.html, busy.html and other files override the built-in page (missing files fall back to the built-ins)")
+	flag.StringVar(&opts.LogFile, "log-file", "", "Also append the transfer log to this file")
+	flag.StringVar(&opts.LogMaxSize, "log-max-size", "", "Rotate -log-file and -audit-log once they reach this size, e.g. 10MB")
+	flag.DurationVar(&opts.LogMaxAge, "log-max-age", 0, "Rotate -log-file and -audit-log after this long, e.g. 24h")
+	flag.IntVar(&opts.LogKeep, "log-keep", 7, "How many rotated log files to keep (0 keeps all)")
+	flag.StringVar(&opts.Budget, "bandwidth-budget", "", "Refuse new transfers once this much traffic, in and out, has gone through the server in total, e.g. 100GB (for metered uplinks)")
+	flag.BoolVar(&opts.MaxTotalExit, "max-total-exit", false, "Exit when the -max-total limit is reached")
+	flag.BoolVar(&opts.LowMem, "low-mem", false, "Tune for devices with little RAM (routers, SBCs): small buffers, streamed uploads, capped event streams")
+	flag.DurationVar(&opts.ProgressEvery, "progress-interval", defaultProgressInterval, "How often transfer progress is recalculated, sent to pages and printed; longer saves CPU on slow devices, 0 updates on every chunk")
+	flag.DurationVar(&opts.SSEHeartbeat, "sse-heartbeat", defaultSSEHeartbeat, "Interval between keep-alive comments on the live status stream; longer saves battery and bandwidth, shorter notices closed pages sooner")
+	flag.IntVar(&opts.MaxConns, "max-conns", 0, "Maximum simultaneous TCP connections (0 for unlimited)")
+	flag.StringVar(&opts.DownloadName, "name", "", "Download filename (and archive root folder) to use instead of the target's base name")
+	flag.StringVar(&opts.OnConflict, "on-conflict", conflictReject, "What to do when an upload's name already exists: reject (409) or rename (add a timestamp)")
+	flag.DurationVar(&opts.SignedTTL, "signed-ttl", 0, "Only accept HMAC-signed links that expire after this long, e.g. 1h; the printed URLs are signed at startup and -admin-token can issue more via /api/sign")
+	flag.StringVar(&opts.AdminToken, "admin-token", "", "Token that allows deleting/renaming received files from the UI and API, and managing shares in daemon mode")
+	flag.StringVar(&opts.Output, "output", "text", "Console output: text (banner) or json (newline-delimited events for scripts)")
+	flag.BoolVar(&plain, "plain", false, "Plain ASCII console output without emoji or box drawing (also enabled by NO_COLOR)")
+	flag.BoolVar(&opts.CopyURL, "copy", false, "Copy the share URL to the system clipboard at startup")
+	flag.StringVar(&opts.Message, "message", "", "Markdown file (or inline text) shown on the share page, e.g. instructions or checksums")
+	flag.StringVar(&opts.Motd, "motd", "", "One-line note shown at the top of the share page and in /api/info, e.g. \"Grab the Q3 report; checksum below\"")
+	flag.StringVar(&opts.Terms, "terms", "", "Markdown file (or inline text) the recipient must accept before downloading")
+	flag.BoolVar(&opts.PerClientDir, "per-client-dir", false, "Save uploads into a subdirectory per client (hostname or IP)")
+	flag.BoolVar(&opts.Watch, "watch", false, "Watch a shared directory and push changes to connected browsers")
+	flag.BoolVar(&opts.Debug, "debug", false, "Expose pprof and runtime stats under /debug/ to this machine, and to other machines with -admin-token")
+	flag.BoolVar(&opts.ResolveHosts, "resolve-hosts", false, "Show client hostnames (reverse DNS) in logs and the UI")
+	flag.BoolVar(&opts.MDNS, "mdns", false, "Also query mDNS for client hostnames (implies -resolve-hosts)")
+	flag.StringVar(&opts.AuditLog, "audit-log", "", "Append a JSON line for every HTTP request (including rejected ones) to this file")
+	flag.BoolVar(&opts.Torrent, "torrent", false, "Also seed the shared file over BitTorrent, with a built-in tracker, for downloads to many machines at once (send mode, single file)")
+	flag.IntVar(&opts.TorrentPort, "torrent-port", defaultTorrentPort, "Port for BitTorrent peer connections with -torrent")
+	flag.StringVar(&opts.GRPCAddr, "grpc-addr", "", "Also serve the gRPC API (see fileshare.proto) on this address, e.g. :50051")
+	flag.BoolVar(&opts.DLNA, "dlna", false, "Announce shared media via DLNA/UPnP so TVs and media players on the LAN can browse and play it (send mode)")
+	flag.StringVar(&opts.Cast, "cast", "", "Play the shared video or audio file on this Chromecast (friendly name or IP) once the server starts")
+	flag.StringVar(&opts.CtlSocket, "ctl-socket", "", "Listen for control commands on this unix socket")
+	flag.BoolVar(&opts.ShareCode, "code", false, "Require a short random word code in the URL path (e.g. /blue-tiger-42); receivers can find it with 'get -code'")
+	flag.StringVar(&opts.BasePath, "base-path", "", "Serve the UI and API under this URL prefix (e.g. /fileshare)")
+	flag.StringVar(&opts.CORSOrigins, "cors-origins", "", "Comma-separated origins allowed to call the API cross-origin ('*' for any)")
+	flag.StringVar(&opts.CORSMethods, "cors-methods", "GET, POST, OPTIONS", "Methods allowed in cross-origin API requests")
+	flag.StringVar(&opts.CORSHeaders, "cors-headers", "Content-Type, Range", "Request headers allowed in cross-origin API requests")
+	flag.StringVar(&opts.TrustedProxies, "trusted-proxies", "", "Comma-separated IPs/CIDRs whose X-Forwarded-For/X-Real-IP headers are honored")
 	flag.Parse()
+	plainOutput = plain || os.Getenv("NO_COLOR") != ""
 
 	args := flag.Args()
-	if len(args) < 2 {
-		flag.Usage()
-		os.Exit(1)
+	if len(args) > 0 {
+		switch args[0] {
+		case "ctl":
+			os.Exit(runCtl(args[1:]))
+		case "speedtest":
+			os.Exit(runSpeedtest(args[1:]))
+		case "bench":
+			os.Exit(runBench(args[1:]))
+		case "get":
+			os.Exit(runGet(args[1:]))
+		case "push":
+			os.Exit(runPush(args[1:]))
+		case "mount":
+			os.Exit(runMount(args[1:]))
+		case "decrypt":
+			os.Exit(runDecrypt(args[1:]))
+		case "version":
+			printVersion()
+			return
+		}
 	}
-
-	mode = args[0]
-	path = args[1]
-
-	if mode != "send" && mode != "recv" {
-		fmt.Fprintf(os.Stderr, "Error: mode must be 'send' or 'recv'\n")
+	if len(args) == 0 || (len(args) < 2 && modeNeedsPath(args[0]) && opts.URL == "") {
 		flag.Usage()
 		os.Exit(1)
 	}
-
-	if mode == "send" {
-		if _, err := os.Stat(path); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: cannot access '%s': %v\n", path, err)
-			os.Exit(1)
-		}
-	} else {
-		if err := os.MkdirAll(path, 0755); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: cannot create directory '%s': %v\n", path, err)
+	opts.Mode = args[0]
+	if opts.Mode == "both" {
+		if err := parseBothArgs(args[1:], &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			flag.Usage()
 			os.Exit(1)
 		}
+	} else if len(args) > 1 {
+		opts.Path = args[1]
+		opts.MorePaths = args[2:]
 	}
 
-	server = NewFileServer(mode, path, port, autoExit)
-	if err := server.Start(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := Run(ctx, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.As(err, new(usageError)) {
+			flag.Usage()
+		}
 		os.Exit(1)
 	}
 }
 
+// modeNeedsPath reports whether mode shares a file or directory, as
+// opposed to clipboard and p2p, which only relay between peers.
+func modeNeedsPath(mode string) bool {
+	return mode == "send" || mode == "recv" || mode == "serve" || mode == "both"
+}
+
+// prepareTarget validates the target path for mode. In send mode a glob
+// pattern may expand into several sources.
+func prepareTarget(fsys FileSystem, mode, path string) ([]string, error) {
+	if !modeNeedsPath(mode) {
+		return nil, nil
+	}
+	if mode == "send" || mode == "both" {
+		sources, err := expandSendTarget(fsys, path)
+		if err != nil {
+			if hasGlobMeta(path) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("cannot access '%s': %v", path, err)
+		}
+		return sources, nil
+	}
+	if mode == "serve" {
+		info, err := fsys.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot access '%s': %v", path, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("'%s' is not a directory; use send to share a single file", path)
+		}
+		return nil, nil
+	}
+	if err := fsys.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create directory '%s': %v", path, err)
+	}
+	return nil, nil
+}
+
 func NewFileServer(mode, path string, port int, autoExit bool) *FileServer {
 	return &FileServer{
-		mode:       mode,
-		path:       path,
-		port:       port,
-		autoExit:   autoExit,
-		sseClients: make(map[chan string]bool),
+		mode:        mode,
+		path:        path,
+		port:        port,
+		autoExit:    autoExit,
+		sseClients:  make(map[*sseClient]bool),
+		heartbeat:   defaultSSEHeartbeat,
+		uploads:     make(map[string]*uploadSession),
 		transferLog: make([]string, 0),
+		signals:     newSignalHub(),
+		fsys:        osFS{},
+		clock:       systemClock{},
+		done:        make(chan struct{}),
+		started:     time.Now(),
 		status: &TransferStatus{
 			Mode:      mode,
-			Path:      filepath.Base(path),
+			Path:      baseName(path),
 			Status:    "waiting",
 			StartTime: time.Now(),
 		},
 	}
 }
 
-func (fs *FileServer) Start() error {
+// handler routes the API and pages through the middleware chain.
+func (fs *FileServer) handler() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", fs.handleIndex)
 	mux.HandleFunc("/api/info", fs.handleInfo)
 	mux.HandleFunc("/api/events", fs.handleEvents)
+	mux.HandleFunc("/api/poll", fs.handlePoll)
 	mux.HandleFunc("/api/download", fs.handleDownload)
+	mux.HandleFunc("/api/download.meta4", fs.handleMetalink)
+	mux.HandleFunc("/api/download.torrent", fs.handleTorrentFile)
+	mux.HandleFunc("/api/download.extents", fs.handleExtents)
+	mux.HandleFunc("/api/announce", fs.handleAnnounce)
+	mux.HandleFunc("/api/sign", fs.handleSign)
+	mux.HandleFunc("/api/terms", fs.handleTerms)
 	mux.HandleFunc("/api/upload", fs.handleUpload)
+	mux.HandleFunc("/api/upload/init", fs.handleUploadInit)
+	mux.HandleFunc("/api/upload/part", fs.handleUploadPart)
+	mux.HandleFunc("/api/upload/complete", fs.handleUploadComplete)
+	mux.HandleFunc("/api/upload/abort", fs.handleUploadAbort)
+	mux.HandleFunc("/api/fetch", fs.handleFetch)
+	mux.HandleFunc("/api/object", fs.handleObject)
 	mux.HandleFunc("/api/cancel", fs.handleCancel)
 	mux.HandleFunc("/api/log", fs.handleLog)
+	mux.HandleFunc("/api/stats", fs.handleStats)
+	mux.HandleFunc("/api/clients", fs.handleClients)
+	mux.HandleFunc("/api/clients/{id}/kick", fs.handleKick)
+	mux.HandleFunc("/api/files", fs.handleFiles)
+	mux.HandleFunc("/api/browse", fs.handleBrowse)
+	mux.HandleFunc("/api/stream", fs.handleStream)
+	mux.HandleFunc("/api/file", fs.handleFile)
+	mux.HandleFunc("/api/file/rename", fs.handleFileRename)
+	mux.HandleFunc("/api/speedtest", fs.handleSpeedtest)
+	mux.HandleFunc("/api/clipboard", fs.handleClipboard)
+	mux.HandleFunc("/api/signal", fs.handleSignal)
+	mux.HandleFunc("/api/relay", fs.handleRelay)
+	mux.HandleFunc("/api/cast", fs.handleCast)
+	mux.HandleFunc("/api/gallery", fs.handleGallery)
+	mux.HandleFunc("/api/gallery/image", fs.handleGalleryImage)
+	mux.HandleFunc("/api/gallery/zip", fs.handleGalleryZip)
+	mux.HandleFunc("/api/logo", fs.handleLogo)
+	mux.HandleFunc(staticPrefix, fs.handleStatic)
+	mux.HandleFunc("/favicon.ico", fs.handleStatic)
+	if fs.uiDir != "" {
+		mux.HandleFunc(uiAssetPrefix, fs.handleUIAsset)
+	}
+	if fs.dlna {
+		fs.registerDLNA(mux)
+	}
+	if fs.debug {
+		fs.registerDebug(mux)
+	}
+	return withRequestID(fs.withAudit(fs.withBans(fs.withCommonHeaders(fs.mountBasePath(fs.withCORS(fs.withSignature(fs.withAuth(fs.withTerms(fs.withBudget(fs.withStdoutOnly(mux)))))))))))
+}
 
+func (fs *FileServer) Start(ctx context.Context) error {
 	fs.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", fs.port),
-		Handler: mux,
+		Addr:        fmt.Sprintf(":%d", fs.port),
+		Handler:     fs.handler(),
+		ConnContext: connContext,
 	}
 
 	listener, err := net.Listen("tcp", fs.server.Addr)
@@ -139,10 +438,81 @@ func (fs *FileServer) Start() error {
 		return err
 	}
 	fs.port = listener.Addr().(*net.TCPAddr).Port
+	if fs.maxConns > 0 {
+		listener = newLimitListener(listener, fs.maxConns)
+	}
+	listener = fs.meterListener(listener, false)
 
 	fs.statusMu.Lock()
-	fs.status.LastUpdateTime = time.Now()
+	fs.status.LastUpdateTime = fs.clock.Now()
 	fs.statusMu.Unlock()
+	if fs.signKey != nil {
+		fs.signExpiry = fs.clock.Now().Add(fs.signTTL)
+	}
+
+	if fs.ctlSocket != "" {
+		ctlListener, err := fs.listenCtl(fs.ctlSocket)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("control socket: %v", err)
+		}
+		defer ctlListener.Close()
+	}
+
+	if fs.mode == "clipboard" {
+		if fs.clipboard == nil {
+			fs.clipboard = systemClipboard{}
+		}
+		text, err := fs.clipboard.Read()
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("clipboard: %v", err)
+		}
+		fs.clipText = text
+		go fs.watchClipboard(time.Second)
+	}
+
+	if fs.grpcAddr != "" {
+		grpcServer, err := fs.listenGRPC(fs.grpcAddr)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("gRPC: %v", err)
+		}
+		defer grpcServer.Close()
+	}
+
+	if fs.torrentPort > 0 {
+		seeder, err := fs.startTorrent()
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("torrent: %v", err)
+		}
+		defer seeder.Close()
+	}
+
+	if fs.shareCode != "" {
+		conn, err := fs.listenDiscovery(fmt.Sprintf(":%d", discoveryPort))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: LAN discovery unavailable: %v\n", err)
+		} else {
+			defer conn.Close()
+		}
+	}
+
+	if fs.dlna {
+		host, _ := os.Hostname()
+		fs.dlnaID = dlnaUUID(host, fs.getPath())
+		if err := fs.startSSDP(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: DLNA announcements unavailable: %v\n", err)
+		}
+	}
+
+	fs.refreshSizeCache()
+	defer func() {
+		if fs.sizeCache != nil {
+			fs.sizeCache.Close()
+		}
+	}()
 
 	fs.printInfo()
 
@@ -152,48 +522,227 @@ func (fs *FileServer) Start() error {
 		}
 	}()
 
+	if fs.castTo != "" {
+		go fs.castAtStartup(fs.castTo)
+	}
+	if fs.events == nil {
+		go fs.showRate(os.Stdout, isTerminal(os.Stdout))
+	}
+
 	if fs.autoExit {
-		fs.waitForComplete()
-	} else {
-		select {}
+		go fs.waitForComplete()
+	}
+	if fs.ready != nil {
+		fs.ready(fs)
+	}
+
+	select {
+	case <-fs.done:
+	case <-ctx.Done():
+		fs.shutdown()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fs.server.Shutdown(shutdownCtx)
+	fs.mirrorWG.Wait()
+	fs.notifyWG.Wait()
+
+	return nil
+}
+
+func (fs *FileServer) withCommonHeaders(next http.Handler) http.Handler {
+	serverVersion := versionString()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-FileShare-Version", serverVersion)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func normalizeBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+func (fs *FileServer) mountBasePath(next http.Handler) http.Handler {
+	if fs.basePath == "" {
+		return next
+	}
+	stripped := http.StripPrefix(fs.basePath, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == fs.basePath:
+			// The page uses relative URLs, so it must be loaded with a trailing slash.
+			http.Redirect(w, r, fs.basePath+"/", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, fs.basePath+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (fs *FileServer) shutdown() {
+	fs.doneOnce.Do(func() { close(fs.done) })
+}
+
+func (fs *FileServer) getPath() string {
+	fs.pathMu.RLock()
+	defer fs.pathMu.RUnlock()
+	return fs.path
+}
+
+func (fs *FileServer) setPath(path string) error {
+	fs.statusMu.RLock()
+	status := fs.status.Status
+	fs.statusMu.RUnlock()
+	if status == "transferring" {
+		return fmt.Errorf("cannot change path during a transfer")
+	}
+	if fs.sharesStdin() {
+		return fmt.Errorf("cannot change the path of a share of standard input")
+	}
+
+	path = targetPath(path)
+	sources, err := prepareTarget(fs.fsys, fs.mode, path)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 1 {
+		path, sources = sources[0], nil
 	}
 
+	fs.pathMu.Lock()
+	fs.path = path
+	fs.sources = sources
+	fs.pathMu.Unlock()
+	fs.refreshSizeCache()
+
+	fs.statusMu.Lock()
+	fs.status.Path = fs.shareName()
+	fs.status.Size = 0
+	fs.status.Transferred = 0
+	fs.status.Progress = 0
+	fs.status.Status = "waiting"
+	fs.status.Error = ""
+	fs.statusMu.Unlock()
+	fs.broadcastStatus()
+	fs.addLog(fmt.Sprintf("Target changed to %s", fs.shareName()))
 	return nil
 }
 
 func (fs *FileServer) printInfo() {
-	fmt.Println("╔════════════════════════════════════╗")
-	fmt.Println("║        FileShare - Ready           ║")
-	fmt.Println("╚════════════════════════════════════╝")
-	fmt.Printf("\n📤 Mode: %s\n", strings.ToUpper(fs.mode))
+	if fs.events != nil {
+		fs.emitReady()
+		return
+	}
 
-	info, err := os.Stat(fs.path)
-	if err == nil {
+	if plainOutput {
+		fmt.Println("FileShare - Ready")
+	} else {
+		fmt.Println("╔════════════════════════════════════╗")
+		fmt.Println("║        FileShare - Ready           ║")
+		fmt.Println("╚════════════════════════════════════╝")
+	}
+	fmt.Printf("\n%sMode: %s\n", icon("📤 "), strings.ToUpper(fs.mode))
+	fmt.Printf("%sVersion: %s\n", icon("🏷️  "), versionString())
+
+	target := fs.getPath()
+	if fs.mode == "clipboard" {
+		fmt.Printf("%sTarget: host clipboard\n", icon("📋 "))
+	} else if fs.mode == "p2p" {
+		fmt.Printf("%sTarget: browser to browser (open the page on both devices)\n", icon("🔀 "))
+	} else if fs.receivesToStdout() {
+		fmt.Printf("%sTarget: standard output (takes one upload, then stops)\n", icon("📤 "))
+	} else if fs.sharesStdin() {
+		fmt.Printf("%sTarget: standard input as '%s' (streamed once, to the first download)\n", icon("📥 "), fs.downloadFilename(false))
+	} else if matches := fs.getSources(); len(matches) > 0 {
+		sources, _, _ := fs.shareSources()
+		fmt.Printf("%sTarget: %s (%d matches, %s)\n", icon("🗂️  "), target, len(matches), formatSize(fs.targetSize(sources)))
+	} else if info, err := os.Stat(target); err == nil {
 		if info.IsDir() {
-			size, _ := calculateDirSize(fs.path)
-			fmt.Printf("📁 Target: %s (directory, %s)\n", filepath.Base(fs.path), formatSize(size))
+			sources := []archiveSource{{path: target}}
+			if fs.servesDownloads() {
+				sources, _, _ = fs.shareSources()
+			}
+			size := fs.targetSize(sources)
+			fmt.Printf("%sTarget: %s (directory, %s)\n", icon("📁 "), baseName(target), formatSize(size))
 		} else {
-			fmt.Printf("📄 Target: %s (%s)\n", filepath.Base(fs.path), formatSize(info.Size()))
+			fmt.Printf("%sTarget: %s (%s)\n", icon("📄 "), baseName(target), formatSize(info.Size()))
 		}
 	}
+	if fs.mode == "both" {
+		fmt.Printf("%sInbox: %s\n", icon("📥 "), fs.recvDir)
+	}
+	if fs.motd != "" {
+		fmt.Printf("%sMessage: %s\n", icon("📣 "), fs.motd)
+	}
+	if fs.terms != "" {
+		fmt.Printf("%sTerms: must be accepted before downloading\n", icon("📜 "))
+	}
 
-	fmt.Printf("\n🔗 URLs:\n")
+	fmt.Printf("\n%sURLs:\n", icon("🔗 "))
 	ips := getLocalIPs()
 	for _, ip := range ips {
-		fmt.Printf("   http://%s:%d\n", ip, fs.port)
+		fmt.Printf("   %s\n", fs.signedURL(fmt.Sprintf("http://%s:%d%s", ip, fs.port, fs.basePath), "/"))
+	}
+	if fs.signKey != nil {
+		fmt.Printf("   (links expire at %s)\n", fs.signExpiry.Format("2006-01-02 15:04"))
+	}
+	if fs.password != "" {
+		fmt.Printf("   (password required)\n")
+	}
+	for _, token := range fs.tokens {
+		fmt.Printf("   (token '%s': %s access)\n", token.name, token.scope)
+	}
+	if fs.copyURL {
+		if url, err := fs.copyShareURL(ips); err == nil {
+			fmt.Printf("\n%sCopied %s to the clipboard\n", icon("📋 "), url)
+		}
 	}
 
+	if fs.shareCode != "" {
+		fmt.Printf("\n%sCode: %s (receive with: fileshare get -code %s)\n", icon("🔑 "), fs.shareCode, fs.shareCode)
+	}
+	for _, m := range fs.mirrors {
+		fmt.Printf("\n%sMirroring uploads to %s\n", icon("☁️  "), m.location(""))
+	}
+	if fs.dlna {
+		fmt.Printf("\n%sDLNA: %s\n", icon("📺 "), fs.dlnaFriendlyName())
+	}
+	if fs.torrent != nil {
+		base := strings.TrimSuffix(shareURL(ips, fs.port, fs.basePath), "/")
+		fmt.Printf("\n%sTorrent: %s (seeding on port %d)\n", icon("🧲 "), fs.signedURL(base, "/api/download.torrent"), fs.torrentPort)
+		fmt.Printf("   %s\n", fs.torrent.magnet(fs.signedURL(base, "/api/announce")))
+	}
+	if fs.grpcAddr != "" {
+		fmt.Printf("\n%sgRPC API: %s\n", icon("🛰️  "), fs.grpcAddr)
+	}
+	if fs.ctlSocket != "" {
+		fmt.Printf("\n%sControl socket: %s\n", icon("🔌 "), fs.ctlSocket)
+	}
+	if fs.watch {
+		fmt.Printf("\n%sWatching shared directory for changes\n", icon("👀 "))
+	}
+	if fs.debug {
+		fmt.Printf("\n%sDebug endpoints: http://127.0.0.1:%d%s/debug/pprof/\n", icon("🐞 "), fs.port, fs.basePath)
+	}
 	if fs.autoExit {
-		fmt.Println("\n⚡ Auto-exit enabled")
+		fmt.Printf("\n%sAuto-exit enabled%s\n", icon("⚡ "), fs.exitPolicy())
 	}
-	fmt.Println("\n⏹️  Press Ctrl+C to stop")
+	fmt.Printf("\n%sPress Ctrl+C to stop\n", icon("⏹️  "))
 	fmt.Println()
 }
 
 func (fs *FileServer) addLog(message string) {
 	fs.logMu.Lock()
-	timestamp := time.Now().Format("15:04:05")
-	logEntry := fmt.Sprintf("[%s] %s", timestamp, message)
+	now := fs.clock.Now()
+	logEntry := fmt.Sprintf("[%s] %s", now.Format("15:04:05"), message)
+	if fs.logFile != nil {
+		fmt.Fprintf(fs.logFile, "%s %s\n", now.Format("2006-01-02 15:04:05"), message)
+	}
 	fs.transferLog = append(fs.transferLog, logEntry)
 	if len(fs.transferLog) > 100 {
 		fs.transferLog = fs.transferLog[len(fs.transferLog)-100:]
@@ -203,13 +752,87 @@ func (fs *FileServer) addLog(message string) {
 }
 
 func (fs *FileServer) getClientIP(r *http.Request) string {
+	return forwardedClientIP(r, fs.trustedNets)
+}
+
+// forwardedClientIP is the address r came from, or the client behind it
+// when it came through one of the trusted proxies.
+func forwardedClientIP(r *http.Request, trusted []*net.IPNet) string {
 	ip := r.RemoteAddr
 	if idx := strings.LastIndex(ip, ":"); idx != -1 {
 		ip = ip[:idx]
 	}
-	return strings.Trim(ip, "[]")
+	ip = strings.Trim(ip, "[]")
+
+	if !isTrustedProxy(ip, trusted) {
+		return ip
+	}
+
+	// Walk X-Forwarded-For from the right: the first hop we don't trust is the
+	// real client, anything left of it may have been forged.
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !isTrustedProxy(hop, trusted) {
+				return hop
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
 }
 
+func isTrustedProxy(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s'", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s'", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// acquireClient takes the single-client lock for clientIP. The client
+// holding it may make several requests at once, as download managers do,
+// and keeps it until the last of them calls releaseClient.
 func (fs *FileServer) acquireClient(clientIP string) bool {
 	fs.activeMu.Lock()
 	defer fs.activeMu.Unlock()
@@ -220,25 +843,46 @@ func (fs *FileServer) acquireClient(clientIP string) bool {
 	if fs.activeClient == "" {
 		fs.activeClient = clientIP
 	}
+	fs.activeRefs++
 	return true
 }
 
 func (fs *FileServer) releaseClient(clientIP string) {
+	fs.activeMu.Lock()
+	if fs.activeClient == clientIP && fs.activeRefs > 1 {
+		fs.activeRefs--
+		fs.activeMu.Unlock()
+		return
+	}
+	fs.activeMu.Unlock()
+	fs.dropClient(clientIP)
+}
+
+// dropClient frees the single-client lock held by clientIP however many
+// requests it has running, as when the transfer is cancelled.
+func (fs *FileServer) dropClient(clientIP string) {
 	shouldLog := false
 	fs.activeMu.Lock()
 	if fs.activeClient == clientIP {
 		fs.activeClient = ""
+		fs.activeRefs = 0
 		shouldLog = true
 	}
 	fs.activeMu.Unlock()
 	if shouldLog {
-		fs.addLog(fmt.Sprintf("Client %s disconnected", clientIP))
+		fs.addLog(fmt.Sprintf("Client %s disconnected", fs.clientLabel(clientIP)))
+		fs.events.emit(outputEvent{Event: "client_disconnected", Client: clientIP, ClientHost: fs.clientHost(clientIP)})
 	}
 }
 
 func (fs *FileServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	page, _ := fs.webFile("index.html")
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(indexHTML))
+	w.Write([]byte(page))
 }
 
 func (fs *FileServer) handleInfo(w http.ResponseWriter, r *http.Request) {
@@ -250,9 +894,14 @@ func (fs *FileServer) handleInfo(w http.ResponseWriter, r *http.Request) {
 	activeClient := fs.activeClient
 	fs.activeMu.Unlock()
 
+	message, _ := json.Marshal(fs.message)
+	motd, _ := json.Marshal(fs.motd)
+	terms, _ := json.Marshal(fs.terms)
+
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"mode":"%s","path":"%s","size":%d,"transferred":%d,"progress":%.2f,"status":"%s","error":"%s","client_ip":"%s"}`,
-		status.Mode, status.Path, status.Size, status.Transferred, status.Progress, status.Status, status.Error, activeClient)
+	fmt.Fprintf(w, `{"mode":"%s","path":"%s","size":%d,"transferred":%d,"progress":%.2f,"status":"%s","error":"%s","client_ip":"%s","client_host":"%s","sha256":"%s","phase":"%s","sent":%d,"version":"%s","manage":%t,"media":"%s","message":%s,"motd":%s,"terms":%s,"accepted":%t,"login":%t,"scope":"%s","admin":%t,"fetch":%t}`,
+		status.Mode, status.Path, status.Size, status.Transferred, status.Progress, status.Status, status.Error, activeClient, fs.clientHost(activeClient), status.SHA256, status.Phase, status.Sent, versionString(),
+		fs.receivesUploads() && fs.canManage(), fs.shareMediaKind(), message, motd, terms, fs.termsAccepted(r), fs.authEnabled(), requestScope(r), fs.canManage(), fs.receivesUploads() && fs.allowFetch)
 }
 
 func (fs *FileServer) handleLog(w http.ResponseWriter, r *http.Request) {
@@ -275,19 +924,20 @@ func (fs *FileServer) handleLog(w http.ResponseWriter, r *http.Request) {
 func (fs *FileServer) handleEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	if r.ProtoMajor == 1 {
+		// Connection-specific headers are not allowed in HTTP/2.
+		w.Header().Set("Connection", "keep-alive")
+	}
 
-	clientChan := make(chan string, 10)
-	fs.sseMu.Lock()
-	fs.sseClients[clientChan] = true
-	fs.sseMu.Unlock()
+	client := newSSEClient(sseQueueLimit)
+	if err := fs.addSSEClient(client); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+		httpError(w, r, "Too many open pages, try again later", http.StatusServiceUnavailable)
+		return
+	}
 
-	defer func() {
-		fs.sseMu.Lock()
-		delete(fs.sseClients, clientChan)
-		fs.sseMu.Unlock()
-		close(clientChan)
-	}()
+	defer fs.removeSSEClient(client)
+	defer fs.trackClient(r, activityViewing)()
 
 	fs.statusMu.RLock()
 	status := *fs.status
@@ -297,32 +947,48 @@ func (fs *FileServer) handleEvents(w http.ResponseWriter, r *http.Request) {
 	activeClient := fs.activeClient
 	fs.activeMu.Unlock()
 
-	data := fmt.Sprintf(`{"status":"%s","progress":%.2f,"transferred":%d,"client_ip":"%s"}`,
-		status.Status, status.Progress, status.Transferred, activeClient)
-	fmt.Fprintf(w, "data: %s\n\n", data)
-	w.(http.Flusher).Flush()
+	data := fmt.Sprintf(`{"status":"%s","progress":%.2f,"transferred":%d,"size":%d,"client_ip":"%s","client_host":"%s","sha256":"%s","phase":"%s","sent":%d}`,
+		status.Status, status.Progress, status.Transferred, status.Size, activeClient, fs.clientHost(activeClient), status.SHA256, status.Phase, status.Sent)
+	defer endSSE(w)
+	if writeSSE(w, sseFrame("", data)) != nil {
+		return
+	}
 
-	ticker := time.NewTicker(500 * time.Millisecond)
+	ticker := time.NewTicker(fs.heartbeat)
 	defer ticker.Stop()
 
 	for {
+		var frames []string
 		select {
-		case data, ok := <-clientChan:
-			if !ok {
-				return
-			}
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			w.(http.Flusher).Flush()
+		case <-client.wake:
+			frames = client.take()
 		case <-ticker.C:
-			fmt.Fprintf(w, ":heartbeat\n\n")
-			w.(http.Flusher).Flush()
+			frames = []string{":heartbeat\n\n"}
 		case <-r.Context().Done():
 			return
+		case <-fs.done:
+			return
+		}
+		for _, frame := range frames {
+			if writeSSE(w, frame) != nil {
+				return
+			}
 		}
 	}
 }
 
 func (fs *FileServer) broadcastStatus() {
+	data := fs.statusJSON()
+	fs.polls.add("", data)
+	fs.sseMu.RLock()
+	defer fs.sseMu.RUnlock()
+	for client := range fs.sseClients {
+		client.push(sseFrame("", data), true)
+	}
+}
+
+// statusJSON is the status frame sent to pages.
+func (fs *FileServer) statusJSON() string {
 	fs.statusMu.RLock()
 	status := *fs.status
 	fs.statusMu.RUnlock()
@@ -331,295 +997,670 @@ func (fs *FileServer) broadcastStatus() {
 	activeClient := fs.activeClient
 	fs.activeMu.Unlock()
 
-	data := fmt.Sprintf(`{"status":"%s","progress":%.2f,"transferred":%d,"client_ip":"%s","error":"%s"}`,
-		status.Status, status.Progress, status.Transferred, activeClient, status.Error)
+	return fmt.Sprintf(`{"status":"%s","progress":%.2f,"transferred":%d,"size":%d,"client_ip":"%s","client_host":"%s","error":"%s","sha256":"%s","phase":"%s","sent":%d}`,
+		status.Status, status.Progress, status.Transferred, status.Size, activeClient, fs.clientHost(activeClient), status.Error, status.SHA256, status.Phase, status.Sent)
+}
 
+// broadcast sends a named event to every open page, counting those that
+// couldn't keep up.
+func (fs *FileServer) broadcast(event, data string) {
+	fs.polls.add(event, data)
+	frame := sseFrame(event, data)
 	fs.sseMu.RLock()
 	defer fs.sseMu.RUnlock()
 	for client := range fs.sseClients {
-		select {
-		case client <- data:
-		default:
+		if !client.push(frame, false) {
+			fs.sseDropped.Add(1)
 		}
 	}
 }
 
+func sseFrame(event, data string) string {
+	if event == "" {
+		return fmt.Sprintf("data: %s\n\n", data)
+	}
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+}
+
 func (fs *FileServer) handleDownload(w http.ResponseWriter, r *http.Request) {
-	if fs.mode != "send" {
-		http.Error(w, "Server is not in send mode", http.StatusBadRequest)
+	if !fs.servesDownloads() {
+		httpError(w, r, "Server is not in send mode", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		fs.handleDownloadHead(w, r)
 		return
 	}
 
 	clientIP := fs.getClientIP(r)
 
 	if !fs.acquireClient(clientIP) {
-		http.Error(w, "Another client is already connected", http.StatusServiceUnavailable)
+		fs.rejectBusy(w, r)
 		return
 	}
-	fs.addLog(fmt.Sprintf("Client %s connected", clientIP))
+	clientLabel := fs.clientLabel(clientIP)
+	fs.logRequest(r, fmt.Sprintf("Client %s connected", clientLabel))
+	fs.events.emit(outputEvent{Event: "client_connected", Client: clientIP, ClientHost: fs.clientHost(clientIP)})
 	defer fs.releaseClient(clientIP)
+	defer fs.trackClient(r, activityDownloading)()
 
-	info, err := os.Stat(fs.path)
+	if fs.sharesStdin() {
+		fs.serveStdin(w, r, clientIP, clientLabel)
+		return
+	}
+	sources, isArchive, err := fs.shareSources()
 	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+		httpError(w, r, "File not found", http.StatusNotFound)
 		return
 	}
-
-	fs.statusMu.Lock()
-	fs.status.Status = "transferring"
-	fs.status.ClientIP = clientIP
-	if info.IsDir() {
-		fs.status.Size, _ = calculateDirSize(fs.path)
-	} else {
-		fs.status.Size = info.Size()
+	target := sources[0].path
+	info, err := os.Stat(target)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
 	}
-	fs.statusMu.Unlock()
-	fs.broadcastStatus()
-	fs.addLog(fmt.Sprintf("Started download from %s", clientIP))
-
-	if info.IsDir() {
-		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", filepath.Base(fs.path)))
-
-		zipWriter := zip.NewWriter(w)
-		defer zipWriter.Close()
-
-		var transferred int64
-		basePath := fs.path
-
-		filepath.Walk(basePath, func(file string, fi os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
 
-			relPath, _ := filepath.Rel(basePath, file)
-			if relPath == "." {
-				return nil
-			}
-
-			header, _ := zip.FileInfoHeader(fi)
-			header.Name = relPath
-			if fi.IsDir() {
-				header.Name += "/"
-			}
-
-			writer, _ := zipWriter.CreateHeader(header)
-			if !fi.IsDir() {
-				f, err := os.Open(file)
-				if err != nil {
-					return err
-				}
-				n, _ := io.Copy(writer, f)
-				f.Close()
-				transferred += n
-
-				fs.statusMu.Lock()
-				fs.status.Transferred = transferred
-				if fs.status.Size > 0 {
-					fs.status.Progress = float64(transferred) / float64(fs.status.Size) * 100
-				}
-				fs.status.LastUpdateTime = time.Now()
-				fs.statusMu.Unlock()
-				fs.broadcastStatus()
+	if !isArchive && r.Header.Get("Range") != "" {
+		fs.setDownloadHeaders(w, sources, false, info.Size(), false)
+		fs.serveRanges(w, r, clientIP, target, info)
+		return
+	}
+	if isArchive {
+		// Walking a large tree for its size can take a while.
+		fs.beginTransfer(clientIP, 0, phaseScanning)
+	}
+	size := fs.targetSize(sources)
+	fs.beginTransfer(clientIP, size, fs.downloadPhase(isArchive))
+	fs.logRequest(r, fmt.Sprintf("Started download from %s", clientLabel))
+
+	hasher := sha256.New()
+	var sent int64
+	var packed packing
+	gzipped := !isArchive && fs.gzipDownload(r, target)
+	length := fs.downloadLength(sources, isArchive, info)
+	etag := fs.setDownloadHeaders(w, sources, isArchive, length, gzipped)
+	if isArchive {
+		// Progress follows the files read into the archive; sent counts
+		// what has actually gone out to the client.
+		out := &countingWriter{w: w}
+		var body io.Writer = io.MultiWriter(out, hasher)
+		if rng, ok := archiveRange(r, etag, length); ok {
+			if rng.start >= length {
+				w.Header().Del("Content-Length")
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", length))
+				fs.failTransfer(errors.New("requested range not satisfiable"))
+				httpError(w, r, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+				return
 			}
-			return nil
+			// The archive comes out the same as last time, so a dropped
+			// download resumes by generating it again and skipping ahead.
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.end, length))
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", rng.end-rng.start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			body = &rangeWriter{w: out, r: rng}
+			fs.logRequest(r, fmt.Sprintf("Resuming archive at %s", formatSize(rng.start)))
+			// Partial content; a hash of the range would be misleading.
+			hasher = nil
+		}
+		var transferred int64
+		err := writeZipArchive(body, sources, fs.zipMethod(), func(n int64) {
+			transferred += n
+			fs.setSent(out.n)
+			fs.updateProgress(transferred)
 		})
+		if err != nil && !errors.Is(err, errRangeDone) {
+			fs.failTransfer(err)
+			return
+		}
+		fs.setPhase(phaseSending)
+		if err := http.NewResponseController(w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			fs.failTransfer(err)
+			return
+		}
+		fs.setSent(out.n)
+		sent = transferred
+		if hasher != nil {
+			packed = zipPacking(fs.zipMethod(), transferred, out.n)
+		}
 	} else {
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(fs.path)))
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+		f, err := os.Open(target)
+		if err != nil {
+			httpError(w, r, "Failed to open file", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		var out io.Writer = w
+		var gz *gzip.Writer
+		wire := &countingWriter{w: w}
+		if gzipped {
+			gz, _ = gzip.NewWriterLevel(wire, gzip.BestSpeed)
+			out = gz
+		}
 
-		if r.Header.Get("Range") != "" {
-			http.ServeContent(w, r, filepath.Base(fs.path), info.ModTime(), mustOpen(fs.path))
-		} else {
-			f, err := os.Open(fs.path)
+		var transferred int64
+		src := io.TeeReader(f, hasher)
+		buf := fs.copyBuffer()
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				_, writeErr := out.Write(buf[:n])
+				if writeErr != nil {
+					// Client disconnected or write error
+					fs.failTransfer(writeErr)
+					return
+				}
+				transferred += int64(n)
+				fs.updateProgress(transferred)
+			}
+			if err == io.EOF {
+				break
+			}
 			if err != nil {
-				http.Error(w, "Failed to open file", http.StatusInternalServerError)
+				fs.failTransfer(err)
 				return
 			}
-			defer f.Close()
-
-			var transferred int64
-			buf := make([]byte, 64*1024)
-			for {
-				n, err := f.Read(buf)
-				if n > 0 {
-					_, writeErr := w.Write(buf[:n])
-					if writeErr != nil {
-						// Client disconnected or write error
-						fs.statusMu.Lock()
-						fs.status.Status = "error"
-						fs.status.Error = writeErr.Error()
-						fs.statusMu.Unlock()
-						fs.broadcastStatus()
-						return
-					}
-					transferred += int64(n)
-
-					fs.statusMu.Lock()
-					fs.status.Transferred = transferred
-					if fs.status.Size > 0 {
-						fs.status.Progress = float64(transferred) / float64(fs.status.Size) * 100
-					}
-					fs.status.LastUpdateTime = time.Now()
-					fs.statusMu.Unlock()
-					fs.broadcastStatus()
-				}
-				if err == io.EOF {
-					break
-				}
-				if err != nil {
-					fs.statusMu.Lock()
-					fs.status.Status = "error"
-					fs.status.Error = err.Error()
-					fs.statusMu.Unlock()
-					fs.broadcastStatus()
-					return
-				}
+		}
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				fs.failTransfer(err)
+				return
 			}
+			packed = packing{compressGzip, transferred, wire.n}
 		}
+		sent = transferred
+	}
+
+	sum := ""
+	if hasher != nil {
+		sum = hex.EncodeToString(hasher.Sum(nil))
+	}
+	fs.completeTransfer(sum)
+	fs.countDownload(fs.downloadFilename(isArchive), clientIP)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("Download completed for %s%s", clientLabel, hashSuffix(sum)))
+
+	completed := outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP),
+		Name: fs.downloadFilename(isArchive), Size: sent, SHA256: sum}
+	if packed.method != "" {
+		completed.Compression, completed.WireSize = packed.method, packed.wire
+	}
+	fs.report(completed, fmt.Sprintf("\n%sTransfer completed to %s\n%s%s", icon("✓ "), clientLabel, hashLine(sum), fs.recordPacking(packed)))
+}
+
+// downloadLength is the length of the download body before any transfer
+// compression, or -1 when it isn't known up front.
+func (fs *FileServer) downloadLength(sources []archiveSource, isArchive bool, info os.FileInfo) int64 {
+	if !isArchive {
+		return info.Size()
+	}
+	if !fs.zipStore {
+		return -1
+	}
+	size, err := storedArchiveSize(sources)
+	if err != nil {
+		return -1
 	}
+	return size
+}
 
+// setDownloadHeaders sets the headers describing the download and returns
+// the archive's ETag if it can be resumed. Archives are built on the fly,
+// identically each time, but their length is only known, and a range of
+// them can only be served, for stored (uncompressed) archives; a gzipped
+// response can't be resumed either.
+func (fs *FileServer) setDownloadHeaders(w http.ResponseWriter, sources []archiveSource, isArchive bool, size int64, gzipped bool) string {
+	if isArchive {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", contentDisposition(fs.downloadFilename(true)))
+		etag := ""
+		if size >= 0 {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+			etag, _ = archiveETag(sources, fs.zipMethod())
+		}
+		if etag == "" {
+			w.Header().Set("Accept-Ranges", "none")
+			return ""
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", etag)
+		return etag
+	}
+	target := sources[0].path
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", contentDisposition(fs.downloadFilename(false)))
+	if fs.compressible(target) {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	if gzipped {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Accept-Ranges", "none")
+		return ""
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	w.Header().Set("Accept-Ranges", "bytes")
+	// The validator a resuming client sends back in If-Range.
+	if info, err := os.Stat(target); err == nil {
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	return ""
+}
+
+// handleDownloadHead describes the download without starting a transfer
+// or taking the client lock, so download managers can inspect it first.
+func (fs *FileServer) handleDownloadHead(w http.ResponseWriter, r *http.Request) {
+	if fs.sharesStdin() {
+		if fs.stdinTaken.Load() {
+			httpError(w, r, "The piped data has already been sent", http.StatusGone)
+			return
+		}
+		fs.setStdinHeaders(w)
+		return
+	}
+	sources, isArchive, err := fs.shareSources()
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	info, err := os.Stat(sources[0].path)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	gzipped := !isArchive && fs.gzipDownload(r, sources[0].path)
+	fs.setDownloadHeaders(w, sources, isArchive, fs.downloadLength(sources, isArchive, info), gzipped)
+}
+
+func (fs *FileServer) startTransfer(clientIP string, size int64) {
+	fs.beginTransfer(clientIP, size, phaseSending)
+}
+
+// beginTransfer is startTransfer for transfers that start in another phase.
+func (fs *FileServer) beginTransfer(clientIP string, size int64, phase string) {
+	fs.statusMu.Lock()
+	fs.status.Status = "transferring"
+	fs.receiving = false
+	fs.status.ClientIP = clientIP
+	fs.status.ClientHost = fs.clientHost(clientIP)
+	fs.status.Size = size
+	fs.status.SHA256 = ""
+	fs.status.Phase = phase
+	fs.status.Sent = 0
+	fs.status.Transferred = 0
+	fs.status.Progress = 0
+	fs.statusMu.Unlock()
+	fs.stats.count("started")
+	fs.broadcastStatus()
+}
+
+// downloadPhase is the phase a download streams in.
+func (fs *FileServer) downloadPhase(isArchive bool) string {
+	if isArchive && fs.zipMethod() == zip.Deflate {
+		return phaseCompressing
+	}
+	return phaseSending
+}
+
+// setPhase records which step of the current transfer is running.
+func (fs *FileServer) setPhase(phase string) {
+	fs.statusMu.Lock()
+	fs.status.Phase = phase
+	fs.statusMu.Unlock()
+	fs.broadcastStatus()
+}
+
+// setSent records the bytes written to the client so far, for transfers
+// where that differs from the bytes read. The next progress update
+// broadcasts it.
+func (fs *FileServer) setSent(n int64) {
+	fs.statusMu.Lock()
+	fs.status.Sent = n
+	fs.statusMu.Unlock()
+}
+
+// completeTransfer marks the transfer done. sum is the SHA-256 of the
+// bytes transferred, or "" when it isn't known (e.g. a range request).
+func (fs *FileServer) completeTransfer(sum string) {
 	fs.statusMu.Lock()
 	fs.status.Status = "completed"
 	fs.status.Progress = 100
+	fs.status.SHA256 = sum
+	fs.statusMu.Unlock()
+	fs.stats.count("completed")
+	fs.broadcastStatus()
+}
+
+// hashSuffix and hashLine format a checksum for the transfer log and the
+// console summary.
+func hashSuffix(sum string) string {
+	if sum == "" {
+		return ""
+	}
+	return " (SHA-256 " + sum + ")"
+}
+
+func hashLine(sum string) string {
+	if sum == "" {
+		return ""
+	}
+	return "  SHA-256: " + sum + "\n"
+}
+
+func (fs *FileServer) updateProgress(transferred int64) {
+	fs.statusMu.Lock()
+	if delta := transferred - fs.status.Transferred; delta > 0 {
+		fs.stats.add(fs.clock.Now(), delta, fs.mode == "recv" || fs.receiving)
+	}
+	fs.status.Transferred = transferred
+	now := fs.clock.Now()
+	size := fs.status.Size
+	// Formatting and fanning out a status frame per chunk is most of the
+	// copy loop's garbage, so the rest waits for -progress-interval.
+	if now.Sub(fs.lastProgress) < fs.progressEvery && (transferred < size || size <= 0) {
+		fs.statusMu.Unlock()
+		return
+	}
+	if size > 0 {
+		fs.status.Progress = float64(transferred) / float64(size) * 100
+	}
+	fs.status.LastUpdateTime = now
+	progress := fs.status.Progress
+	fs.lastProgress = now
 	fs.statusMu.Unlock()
 	fs.broadcastStatus()
-	fs.addLog(fmt.Sprintf("Download completed for %s", clientIP))
+	fs.events.emit(outputEvent{Event: "progress", Size: size, Transferred: transferred, Progress: progress})
+}
 
-	fmt.Printf("\n✓ Transfer completed to %s\n", clientIP)
+func (fs *FileServer) failTransfer(err error) {
+	fs.statusMu.Lock()
+	if fs.status.Status == "cancelled" {
+		// The transfer's connection going away is how a cancel ends it.
+		fs.statusMu.Unlock()
+		return
+	}
+	fs.status.Status = "error"
+	fs.status.Error = err.Error()
+	fs.statusMu.Unlock()
+	fs.stats.count("error")
+	fs.broadcastStatus()
+	fs.events.emit(outputEvent{Event: "error", Error: err.Error()})
 }
 
 func (fs *FileServer) handleUpload(w http.ResponseWriter, r *http.Request) {
-	if fs.mode != "recv" {
-		http.Error(w, "Server is not in receive mode", http.StatusBadRequest)
+	if !fs.receivesUploads() {
+		httpError(w, r, "Server is not in receive mode", http.StatusBadRequest)
 		return
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	clientIP := fs.getClientIP(r)
 
 	if !fs.acquireClient(clientIP) {
-		http.Error(w, "Another client is already connected", http.StatusServiceUnavailable)
+		fs.rejectBusy(w, r)
 		return
 	}
 	defer fs.releaseClient(clientIP)
+	defer fs.trackClient(r, activityUploading)()
+	clientLabel := fs.clientLabel(clientIP)
+	fs.events.emit(outputEvent{Event: "client_connected", Client: clientIP, ClientHost: fs.clientHost(clientIP)})
+
+	var (
+		file          io.Reader
+		requestedName string
+		size          int64
+		fields        url.Values
+	)
+	if fs.lowMem {
+		part, partFields, err := streamUploadPart(r)
+		if err != nil {
+			httpError(w, r, "Failed to get file", http.StatusBadRequest)
+			return
+		}
+		defer part.Close()
+		file, requestedName, size, fields = part, part.FileName(), r.ContentLength, partFields
+	} else {
+		r.ParseMultipartForm(10 << 30)
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			httpError(w, r, "Failed to get file", http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		file, requestedName, size, fields = f, header.Filename, header.Size, r.Form
+	}
 
-	r.ParseMultipartForm(10 << 30)
-
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "Failed to get file", http.StatusBadRequest)
+	if override := fields.Get("name"); override != "" {
+		requestedName = override
+	}
+	dir, filename, ok := fs.uploadTarget(w, r, clientIP, requestedName, fields.Get("dir"))
+	if !ok {
 		return
 	}
-	defer file.Close()
 
-	savePath := filepath.Join(fs.path, header.Filename)
-	if _, err := os.Stat(savePath); err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintf(w, `{"error":"file_exists","message":"File '%s' already exists","path":"%s"}`,
-			header.Filename, savePath)
-		return
+	// With -low-mem the size is the whole request, form fields included,
+	// so only an exhausted limit is refused up front.
+	quotaSize := size
+	if fs.lowMem {
+		quotaSize = -1
 	}
+	fs.receiveFile(w, r, file, size, quotaSize, dir, filename, clientIP, clientLabel)
+}
 
-	fs.statusMu.Lock()
-	fs.status.Status = "transferring"
-	fs.status.ClientIP = clientIP
-	fs.status.Size = header.Size
-	fs.statusMu.Unlock()
-	fs.broadcastStatus()
-	fs.addLog(fmt.Sprintf("Started upload from %s: %s", clientIP, header.Filename))
+// receiveFile saves file as filename in dir, reporting progress and
+// writing the response, for uploads and fetches alike. size is the
+// expected size for progress, quotaSize the one checked against
+// -max-total up front (-1 when unknown), and from names the source in
+// the log.
+func (fs *FileServer) receiveFile(w http.ResponseWriter, r *http.Request, file io.Reader, size, quotaSize int64, dir, filename, clientIP, from string) {
+	if err := fs.checkQuota(quotaSize); err != nil {
+		fs.rejectQuota(w, r, err)
+		return
+	}
+	if fs.receivesToStdout() {
+		fs.receiveToStdout(w, r, file, size, filename, clientIP, from)
+		return
+	}
+	left := fs.quotaLeft()
 
-	dst, err := os.Create(savePath)
+	filename = fs.storedName(filename)
+	dst, savePath, err := createUploadFile(dir, filename, fs.onConflict, fs.clock.Now())
+	if os.IsExist(err) {
+		writeUploadConflict(w, r, filename, savePath)
+		return
+	}
 	if err != nil {
-		fs.statusMu.Lock()
-		fs.status.Status = "error"
-		fs.status.Error = err.Error()
-		fs.statusMu.Unlock()
-		fs.broadcastStatus()
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
+		fs.failTransfer(err)
+		httpError(w, r, "Failed to create file", http.StatusInternalServerError)
 		return
 	}
 	defer dst.Close()
+	out, finish := fs.uploadWriter(dst)
+
+	savedName := filepath.Base(savePath)
+	if savedName != filename {
+		fs.logRequest(r, fmt.Sprintf("'%s' already exists, saving as '%s'", filename, savedName))
+	}
+
+	fs.startUpload(clientIP, size)
+	fs.logRequest(r, fmt.Sprintf("Started upload from %s: %s", from, savedName))
 
 	var transferred int64
-	buf := make([]byte, 64*1024)
+	hasher := sha256.New()
+	src := io.TeeReader(file, hasher)
+	buf := fs.copyBuffer()
 	for {
-		n, err := file.Read(buf)
+		n, err := src.Read(buf)
 		if n > 0 {
-			dst.Write(buf[:n])
 			transferred += int64(n)
-
-			fs.statusMu.Lock()
-			fs.status.Transferred = transferred
-			if fs.status.Size > 0 {
-				fs.status.Progress = float64(transferred) / float64(fs.status.Size) * 100
+			if left >= 0 && transferred > left {
+				// Only checked here when the size wasn't known up front.
+				fs.discardUpload(dst, savePath, errQuotaExceeded)
+				fs.rejectQuota(w, r, errQuotaExceeded)
+				return
 			}
-			fs.status.LastUpdateTime = time.Now()
-			fs.statusMu.Unlock()
-			fs.broadcastStatus()
+			if _, err := out.Write(buf[:n]); err != nil {
+				fs.discardUpload(dst, savePath, err)
+				httpError(w, r, "Failed to save file", http.StatusInternalServerError)
+				return
+			}
+			fs.updateProgress(transferred)
 		}
-		if err != nil {
+		if err == io.EOF {
 			break
 		}
+		if err == nil {
+			err = r.Context().Err()
+		}
+		if err != nil {
+			// The client went away (tab closed, network dropped) before
+			// sending the whole file.
+			fs.discardUpload(dst, savePath, err)
+			fs.logRequest(r, fmt.Sprintf("Upload from %s interrupted: %s (%s received)", from, savedName, formatSize(transferred)))
+			httpError(w, r, "Upload interrupted", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := finish(); err != nil {
+		fs.discardUpload(dst, savePath, err)
+		httpError(w, r, "Failed to save file", http.StatusInternalServerError)
+		return
 	}
 
-	fs.statusMu.Lock()
-	fs.status.Status = "completed"
-	fs.status.Progress = 100
-	fs.statusMu.Unlock()
-	fs.broadcastStatus()
-	fs.addLog(fmt.Sprintf("Upload completed from %s: %s (%s)", clientIP, header.Filename, formatSize(transferred)))
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	fs.completeTransfer(sum)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("Upload completed from %s: %s (%s)%s", from, savedName, formatSize(transferred), hashSuffix(sum)))
 
-	fmt.Printf("\n✓ Received '%s' from %s (%s)\n", header.Filename, clientIP, formatSize(transferred))
+	fs.report(outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP),
+		Name: savedName, Path: savePath, Size: transferred, SHA256: sum},
+		fmt.Sprintf("\n%sReceived '%s' from %s (%s)\n%s", icon("✓ "), savedName, from, formatSize(transferred), hashLine(sum)))
+	fs.addReceived(transferred)
+	fs.afterReceive(savePath, sum)
 
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"status":"success","path":"%s","size":%d}`, savePath, transferred)
+	fmt.Fprintf(w, `{"status":"success","path":"%s","name":"%s","size":%d,"sha256":"%s"}`, savePath, savedName, transferred, sum)
+}
+
+// discardUpload removes a partially written upload and marks the
+// transfer failed.
+func (fs *FileServer) discardUpload(dst *os.File, savePath string, err error) {
+	dst.Close()
+	os.Remove(savePath)
+	fs.failTransfer(err)
+}
+
+// uploadTarget resolves the directory and sanitized file name for an
+// upload, writing the error response itself when they are invalid.
+func (fs *FileServer) uploadTarget(w http.ResponseWriter, r *http.Request, clientIP, requestedName, subdir string) (string, string, bool) {
+	filename, err := sanitizeFilename(requestedName)
+	if err != nil {
+		auditNote(r, "invalid file name: "+requestedName)
+		httpError(w, r, "Invalid file name", http.StatusBadRequest)
+		return "", "", false
+	}
+	if fs.receivesToStdout() {
+		return "", filename, true
+	}
+
+	dir, err := fs.uploadDir(clientIP)
+	if err == nil && subdir != "" {
+		if dir, err = resolveInside(dir, subdir); err != nil {
+			auditNote(r, "invalid upload dir: "+subdir)
+			httpError(w, r, "Invalid directory", http.StatusBadRequest)
+			return "", "", false
+		}
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		fs.failTransfer(err)
+		httpError(w, r, "Failed to create directory", http.StatusInternalServerError)
+		return "", "", false
+	}
+	return dir, filename, true
+}
+
+func writeUploadConflict(w http.ResponseWriter, r *http.Request, filename, savePath string) {
+	auditNote(r, "file exists: "+filename)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	fmt.Fprintf(w, `{"error":"file_exists","message":"File '%s' already exists","path":"%s","request_id":"%s"}`,
+		filename, savePath, requestID(r))
 }
 
 func (fs *FileServer) handleCancel(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	clientIP := fs.getClientIP(r)
-	fs.releaseClient(clientIP)
+	fs.dropClient(clientIP)
+	fs.cancel(clientIP)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"cancelled"}`)
+}
 
+func (fs *FileServer) cancel(by string) {
 	fs.statusMu.Lock()
 	fs.status.Status = "cancelled"
 	fs.statusMu.Unlock()
+	fs.stats.count("cancelled")
 	fs.broadcastStatus()
-	fs.addLog(fmt.Sprintf("Transfer cancelled by %s", clientIP))
+	fs.addLog(fmt.Sprintf("Transfer cancelled by %s", by))
 
-	fmt.Println("\n✗ Transfer cancelled")
-
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"status":"cancelled"}`)
+	fs.report(outputEvent{Event: "cancelled", Client: by}, "\n"+icon("✗ ")+"Transfer cancelled\n")
 }
 
 func (fs *FileServer) waitForComplete() {
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-fs.clock.After(100 * time.Millisecond):
+		}
 
-	for range ticker.C {
 		fs.statusMu.RLock()
 		status := fs.status.Status
 		fs.statusMu.RUnlock()
 
-		if status == "completed" || status == "cancelled" || status == "error" {
-			time.Sleep(500 * time.Millisecond)
-			fs.server.Shutdown(nil)
-			os.Exit(0)
+		if fs.exitsOn(status) {
+			// Give the browser a moment to receive the final status.
+			<-fs.clock.After(500 * time.Millisecond)
+			fs.shutdown()
+			return
 		}
 	}
 }
 
+// copyShareURL places the share URL on the system clipboard.
+func (fs *FileServer) copyShareURL(ips []string) (string, error) {
+	url := fs.signedURL(strings.TrimSuffix(shareURL(ips, fs.port, fs.basePath), "/"), "/")
+	err := (systemClipboard{}).Write(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot copy URL to clipboard: %v\n", err)
+	}
+	return url, err
+}
+
+// shareURL picks the URL to hand out: the first LAN address, or loopback
+// when there is no other interface.
+func shareURL(ips []string, port int, basePath string) string {
+	ip := "127.0.0.1"
+	for _, candidate := range ips {
+		if candidate != "127.0.0.1" {
+			ip = candidate
+			break
+		}
+	}
+	return fmt.Sprintf("http://%s:%d%s/", ip, port, basePath)
+}
+
 func getLocalIPs() []string {
 	var ips []string
 	ips = append(ips, "127.0.0.1")
@@ -655,17 +1696,17 @@ func getLocalIPs() []string {
 }
 
 func calculateDirSize(path string) (int64, error) {
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+	var size atomic.Int64
+	err := walkParallel(path, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if !info.IsDir() {
-			size += info.Size()
+			size.Add(info.Size())
 		}
 		return nil
 	})
-	return size, err
+	return size.Load(), err
 }
 
 func formatSize(size int64) string {
@@ -686,494 +1727,3 @@ func formatSize(size int64) string {
 		return fmt.Sprintf("%d B", size)
 	}
 }
-
-func mustOpen(path string) *os.File {
-	f, err := os.Open(path)
-	if err != nil {
-		panic(err)
-	}
-	return f
-}
-
-const indexHTML = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>FileShare</title>
-    <style>
-        * { box-sizing: border-box; margin: 0; padding: 0; }
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
-            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
-            min-height: 100vh;
-            display: flex;
-            align-items: center;
-            justify-content: center;
-            padding: 20px;
-        }
-        .container {
-            background: white;
-            border-radius: 16px;
-            box-shadow: 0 20px 60px rgba(0,0,0,0.3);
-            padding: 40px;
-            max-width: 500px;
-            width: 100%;
-        }
-        h1 {
-            text-align: center;
-            color: #333;
-            margin-bottom: 8px;
-            font-size: 28px;
-        }
-        .subtitle {
-            text-align: center;
-            color: #666;
-            margin-bottom: 30px;
-            font-size: 14px;
-        }
-        .info-box {
-            background: #f5f5f5;
-            border-radius: 8px;
-            padding: 15px;
-            margin-bottom: 20px;
-            font-size: 13px;
-        }
-        .info-box .label {
-            color: #666;
-            font-weight: 600;
-            margin-bottom: 4px;
-        }
-        .info-box .value {
-            color: #333;
-            word-break: break-all;
-        }
-        .drop-zone {
-            border: 3px dashed #ddd;
-            border-radius: 12px;
-            padding: 40px 20px;
-            text-align: center;
-            cursor: pointer;
-            transition: all 0.3s;
-            margin-bottom: 20px;
-        }
-        .drop-zone:hover, .drop-zone.dragover {
-            border-color: #667eea;
-            background: #f8f9ff;
-        }
-        .drop-zone .icon {
-            font-size: 48px;
-            margin-bottom: 10px;
-        }
-        .drop-zone .text {
-            color: #666;
-            font-size: 14px;
-        }
-        .progress-container {
-            display: none;
-            margin-bottom: 20px;
-        }
-        .progress-container.active {
-            display: block;
-        }
-        .progress-bar {
-            height: 8px;
-            background: #eee;
-            border-radius: 4px;
-            overflow: hidden;
-            margin-bottom: 10px;
-        }
-        .progress-fill {
-            height: 100%;
-            background: linear-gradient(90deg, #667eea, #764ba2);
-            width: 0%;
-            transition: width 0.3s;
-        }
-        .progress-text {
-            text-align: center;
-            font-size: 14px;
-            color: #666;
-        }
-        .status {
-            text-align: center;
-            padding: 10px;
-            border-radius: 8px;
-            margin-bottom: 15px;
-            font-size: 14px;
-            font-weight: 500;
-        }
-        .status.waiting {
-            background: #fff3cd;
-            color: #856404;
-        }
-        .status.transferring {
-            background: #d1ecf1;
-            color: #0c5460;
-        }
-        .status.completed {
-            background: #d4edda;
-            color: #155724;
-        }
-        .status.cancelled {
-            background: #f8d7da;
-            color: #721c24;
-        }
-        .status.error {
-            background: #f8d7da;
-            color: #721c24;
-        }
-        .log-container {
-            background: #1e1e1e;
-            border-radius: 8px;
-            padding: 15px;
-            margin-top: 20px;
-            max-height: 200px;
-            overflow-y: auto;
-        }
-        .log-title {
-            color: #fff;
-            font-size: 12px;
-            font-weight: 600;
-            margin-bottom: 10px;
-            text-transform: uppercase;
-            letter-spacing: 1px;
-        }
-        .log-entry {
-            color: #aaa;
-            font-size: 12px;
-            font-family: 'Courier New', monospace;
-            margin-bottom: 4px;
-            line-height: 1.4;
-        }
-        .log-entry:last-child {
-            color: #fff;
-        }
-        .btn {
-            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
-            color: white;
-            border: none;
-            padding: 12px 30px;
-            border-radius: 8px;
-            cursor: pointer;
-            font-size: 14px;
-            font-weight: 600;
-            width: 100%;
-            transition: transform 0.2s, box-shadow 0.2s;
-        }
-        .btn:hover {
-            transform: translateY(-2px);
-            box-shadow: 0 5px 20px rgba(102, 126, 234, 0.4);
-        }
-        .btn:disabled {
-            background: #ccc;
-            cursor: not-allowed;
-            transform: none;
-            box-shadow: none;
-        }
-        .btn-cancel {
-            background: #dc3545;
-            margin-top: 10px;
-        }
-        .btn-cancel:hover {
-            box-shadow: 0 5px 20px rgba(220, 53, 69, 0.4);
-        }
-        .hidden {
-            display: none !important;
-        }
-        .curl-help {
-            background: #f8f9fa;
-            border-left: 4px solid #667eea;
-            padding: 15px;
-            margin-top: 20px;
-            border-radius: 0 8px 8px 0;
-        }
-        .curl-help h3 {
-            font-size: 14px;
-            margin-bottom: 10px;
-            color: #333;
-        }
-        .curl-help code {
-            display: block;
-            background: #2d2d2d;
-            color: #f8f8f2;
-            padding: 10px;
-            border-radius: 4px;
-            font-size: 12px;
-            font-family: 'Courier New', monospace;
-            margin-bottom: 8px;
-            overflow-x: auto;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1>📤 FileShare</h1>
-        <p class="subtitle">LAN File Transfer Tool</p>
-        
-        <div class="info-box">
-            <div class="label">Mode</div>
-            <div class="value" id="mode">-</div>
-        </div>
-        
-        <div class="info-box">
-            <div class="label">Target</div>
-            <div class="value" id="target">-</div>
-        </div>
-        
-        <div class="info-box">
-            <div class="label">Connected Client</div>
-            <div class="value" id="client-ip">-</div>
-        </div>
-        
-        <div class="status waiting" id="status">Waiting for connection...</div>
-        
-        <div id="upload-section">
-            <div class="drop-zone" id="drop-zone">
-                <div class="icon">📁</div>
-                <div class="text">Drop files here or click to select</div>
-                <input type="file" id="file-input" style="display: none;">
-            </div>
-        </div>
-        
-        <div id="download-section" class="hidden">
-            <button class="btn" id="download-btn">Download File</button>
-        </div>
-        
-        <div class="progress-container" id="progress">
-            <div class="progress-bar">
-                <div class="progress-fill" id="progress-fill"></div>
-            </div>
-            <div class="progress-text" id="progress-text">0%</div>
-        </div>
-        
-        <button class="btn btn-cancel hidden" id="cancel-btn">Cancel Transfer</button>
-        
-        <div class="log-container">
-            <div class="log-title">Transfer Log</div>
-            <div id="log-entries"></div>
-        </div>
-        
-        <div class="curl-help">
-            <h3>🖥️ Command Line (curl)</h3>
-            <code id="curl-cmd"># Loading...</code>
-            <small style="color: #666;">Copy and run this in your terminal</small>
-        </div>
-    </div>
-
-    <script>
-        const dropZone = document.getElementById('drop-zone');
-        const fileInput = document.getElementById('file-input');
-        const progressContainer = document.getElementById('progress');
-        const progressFill = document.getElementById('progress-fill');
-        const progressText = document.getElementById('progress-text');
-        const statusEl = document.getElementById('status');
-        const cancelBtn = document.getElementById('cancel-btn');
-        const uploadSection = document.getElementById('upload-section');
-        const downloadSection = document.getElementById('download-section');
-        const downloadBtn = document.getElementById('download-btn');
-        const logEntries = document.getElementById('log-entries');
-        const curlCmd = document.getElementById('curl-cmd');
-        
-        let currentMode = '';
-        let eventSource = null;
-        
-        // Initialize
-        async function init() {
-            await updateInfo();
-            connectSSE();
-            fetchLogs();
-        }
-        
-        async function updateInfo() {
-            try {
-                const response = await fetch('/api/info');
-                const data = await response.json();
-                currentMode = data.mode;
-                
-                document.getElementById('mode').textContent = data.mode.toUpperCase();
-                document.getElementById('target').textContent = data.path + ' (' + formatSize(data.size) + ')';
-                document.getElementById('client-ip').textContent = data.client_ip || 'None';
-                
-                if (data.mode === 'send') {
-                    uploadSection.classList.add('hidden');
-                    downloadSection.classList.remove('hidden');
-                    curlCmd.textContent = 'curl -O -J "' + window.location.origin + '/api/download"';
-                } else {
-                    uploadSection.classList.remove('hidden');
-                    downloadSection.classList.add('hidden');
-                    curlCmd.textContent = 'curl -F "file=@YOUR_FILE" "' + window.location.origin + '/api/upload"';
-                }
-                
-                updateStatus(data.status, data.progress, data.error);
-            } catch (e) {
-                console.error('Failed to get info:', e);
-            }
-        }
-        
-        function connectSSE() {
-            if (eventSource) {
-                eventSource.close();
-            }
-            
-            eventSource = new EventSource('/api/events');
-            
-            eventSource.onmessage = (e) => {
-                if (e.data.startsWith(':heartbeat')) return;
-                
-                try {
-                    const data = JSON.parse(e.data);
-                    updateStatus(data.status, data.progress, data.error);
-                    document.getElementById('client-ip').textContent = data.client_ip || 'None';
-                    
-                    if (data.status === 'transferring') {
-                        progressContainer.classList.add('active');
-                        progressFill.style.width = data.progress + '%';
-                        progressText.textContent = data.progress.toFixed(1) + '% (' + formatSize(data.transferred) + ' / ' + formatSize(data.size) + ')';
-                        cancelBtn.classList.remove('hidden');
-                    } else if (data.status === 'completed') {
-                        progressFill.style.width = '100%';
-                        progressText.textContent = '100% - Complete!';
-                        cancelBtn.classList.add('hidden');
-                    }
-                } catch (e) {
-                    console.error('Failed to parse SSE data:', e);
-                }
-            };
-            
-            eventSource.onerror = () => {
-                console.log('SSE connection lost, retrying...');
-                setTimeout(connectSSE, 1000);
-            };
-        }
-        
-        async function fetchLogs() {
-            try {
-                const response = await fetch('/api/log');
-                const logs = await response.json();
-                renderLogs(logs);
-            } catch (e) {
-                console.error('Failed to fetch logs:', e);
-            }
-        }
-        
-        function renderLogs(logs) {
-            logEntries.innerHTML = logs.map(log => 
-                '<div class="log-entry">' + escapeHtml(log) + '</div>'
-            ).join('');
-            logEntries.scrollTop = logEntries.scrollHeight;
-        }
-        
-        function updateStatus(status, progress, error) {
-            statusEl.className = 'status ' + status;
-            
-            switch(status) {
-                case 'waiting':
-                    statusEl.textContent = '⏳ Waiting for connection...';
-                    break;
-                case 'transferring':
-                    statusEl.textContent = '📤 Transferring... ' + progress.toFixed(1) + '%';
-                    break;
-                case 'completed':
-                    statusEl.textContent = '✅ Transfer completed!';
-                    break;
-                case 'cancelled':
-                    statusEl.textContent = '❌ Transfer cancelled';
-                    break;
-                case 'error':
-                    statusEl.textContent = '⚠️ Error: ' + (error || 'Unknown error');
-                    break;
-            }
-        }
-        
-        function formatSize(bytes) {
-            if (bytes === 0) return '0 B';
-            const k = 1024;
-            const sizes = ['B', 'KB', 'MB', 'GB'];
-            const i = Math.floor(Math.log(bytes) / Math.log(k));
-            return parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + ' ' + sizes[i];
-        }
-        
-        function escapeHtml(text) {
-            const div = document.createElement('div');
-            div.textContent = text;
-            return div.innerHTML;
-        }
-        
-        // File upload
-        dropZone.addEventListener('click', () => fileInput.click());
-        
-        dropZone.addEventListener('dragover', (e) => {
-            e.preventDefault();
-            dropZone.classList.add('dragover');
-        });
-        
-        dropZone.addEventListener('dragleave', () => {
-            dropZone.classList.remove('dragover');
-        });
-        
-        dropZone.addEventListener('drop', (e) => {
-            e.preventDefault();
-            dropZone.classList.remove('dragover');
-            const files = e.dataTransfer.files;
-            if (files.length > 0) {
-                uploadFile(files[0]);
-            }
-        });
-        
-        fileInput.addEventListener('change', (e) => {
-            if (e.target.files.length > 0) {
-                uploadFile(e.target.files[0]);
-            }
-        });
-        
-        async function uploadFile(file) {
-            const formData = new FormData();
-            formData.append('file', file);
-            
-            progressContainer.classList.add('active');
-            cancelBtn.classList.remove('hidden');
-            
-            try {
-                const response = await fetch('/api/upload', {
-                    method: 'POST',
-                    body: formData
-                });
-                
-                if (response.status === 409) {
-                    const data = await response.json();
-                    if (confirm('File "' + file.name + '" already exists. Overwrite?')) {
-                        // TODO: Implement overwrite
-                        alert('Please rename the file or choose a different name');
-                    }
-                } else if (!response.ok) {
-                    const text = await response.text();
-                    throw new Error(text);
-                }
-            } catch (e) {
-                console.error('Upload failed:', e);
-                alert('Upload failed: ' + e.message);
-            }
-        }
-        
-        // Download
-        downloadBtn.addEventListener('click', () => {
-            window.location.href = '/api/download';
-        });
-        
-        // Cancel
-        cancelBtn.addEventListener('click', async () => {
-            try {
-                await fetch('/api/cancel', { method: 'POST' });
-            } catch (e) {
-                console.error('Cancel failed:', e);
-            }
-        });
-        
-        // Refresh logs periodically
-        setInterval(fetchLogs, 1000);
-        
-        // Start
-        init();
-    </script>
-</body>
-</html>`
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -113,6 +115,25 @@ func TestGetLocalIPs(t *testing.T) {
 	}
 }
 
+// Test choosing the URL to share
+func TestShareURL(t *testing.T) {
+	tests := []struct {
+		ips      []string
+		basePath string
+		expected string
+	}{
+		{[]string{"127.0.0.1", "192.168.1.10", "10.0.0.2"}, "", "http://192.168.1.10:8080/"},
+		{[]string{"127.0.0.1"}, "", "http://127.0.0.1:8080/"},
+		{[]string{"127.0.0.1", "192.168.1.10"}, "/fileshare", "http://192.168.1.10:8080/fileshare/"},
+	}
+	for _, test := range tests {
+		result := shareURL(test.ips, 8080, test.basePath)
+		if result != test.expected {
+			t.Errorf("shareURL(%v, %q) = %s, expected %s", test.ips, test.basePath, result, test.expected)
+		}
+	}
+}
+
 // Test FileServer acquire/release client
 func TestFileServerClientManagement(t *testing.T) {
 	fs := NewFileServer("send", "/tmp", 8080, false)
@@ -132,6 +153,12 @@ func TestFileServerClientManagement(t *testing.T) {
 		t.Error("Different client should not be able to acquire when active")
 	}
 
+	// The lock is held until each of the client's requests releases it
+	fs.releaseClient("192.168.1.1")
+	if fs.acquireClient("192.168.1.2") {
+		t.Error("Different client should not acquire while the first still has a request running")
+	}
+
 	// Test release and re-acquire
 	fs.releaseClient("192.168.1.1")
 
@@ -165,6 +192,50 @@ func TestGetClientIP(t *testing.T) {
 	}
 }
 
+// Test getClientIP behind trusted proxies
+func TestGetClientIPTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies("10.0.0.1, 172.16.0.0/12")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies error: %v", err)
+	}
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.trustedNets = nets
+
+	tests := []struct {
+		remoteAddr string
+		xff        string
+		realIP     string
+		expected   string
+	}{
+		{"192.168.1.5:1000", "1.2.3.4", "", "192.168.1.5"},
+		{"10.0.0.1:1000", "192.168.1.42", "", "192.168.1.42"},
+		{"10.0.0.1:1000", "6.6.6.6, 192.168.1.42, 172.16.3.3", "", "192.168.1.42"},
+		{"10.0.0.1:1000", "", "192.168.1.43", "192.168.1.43"},
+		{"10.0.0.1:1000", "", "", "10.0.0.1"},
+		{"10.0.0.1:1000", "garbage", "", "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if test.realIP != "" {
+			req.Header.Set("X-Real-IP", test.realIP)
+		}
+		result := fs.getClientIP(req)
+		if result != test.expected {
+			t.Errorf("getClientIP(%s, xff=%q, real=%q) = %s, expected %s",
+				test.remoteAddr, test.xff, test.realIP, result, test.expected)
+		}
+	}
+
+	if _, err := parseTrustedProxies("not-an-ip"); err == nil {
+		t.Error("parseTrustedProxies should reject invalid entries")
+	}
+}
+
 // Test TransferStatus updates
 func TestTransferStatus(t *testing.T) {
 	fs := NewFileServer("send", "/tmp/test.txt", 8080, false)
@@ -282,13 +353,43 @@ func TestProgressCalculation(t *testing.T) {
 	}
 }
 
+// Test progress is only republished once -progress-interval has passed
+func TestProgressInterval(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		every    time.Duration
+		expected []float64 // published progress after each update
+	}{
+		{"every chunk", 0, []float64{10, 20, 30, 100}},
+		{"250ms", 250 * time.Millisecond, []float64{10, 10, 30, 100}},
+	}
+	updates := []struct {
+		at          time.Duration
+		transferred int64
+	}{{0, 100}, {100 * time.Millisecond, 200}, {300 * time.Millisecond, 300}, {310 * time.Millisecond, 1000}}
+	for _, test := range tests {
+		clock := &tickClock{now: start}
+		fs := NewFileServer("send", "/tmp/test.txt", 8080, false)
+		fs.clock = clock
+		fs.progressEvery = test.every
+		fs.startTransfer("192.168.1.20", 1000)
+		for i, update := range updates {
+			clock.now = start.Add(update.at)
+			fs.updateProgress(update.transferred)
+			if fs.status.Progress != test.expected[i] {
+				t.Errorf("%s: progress after update %d = %v, expected %v", test.name, i, fs.status.Progress, test.expected[i])
+			}
+		}
+	}
+}
+
 // Test SSE client management
 func TestSSEClientManagement(t *testing.T) {
 	fs := NewFileServer("send", "/tmp", 8080, false)
 
-	// Create test channels
-	ch1 := make(chan string, 10)
-	ch2 := make(chan string, 10)
+	ch1 := newSSEClient(sseQueueLimit)
+	ch2 := newSSEClient(sseQueueLimit)
 
 	// Add clients
 	fs.sseMu.Lock()
@@ -313,9 +414,6 @@ func TestSSEClientManagement(t *testing.T) {
 		t.Errorf("Expected 1 SSE client after removal, got %d", len(fs.sseClients))
 	}
 	fs.sseMu.RUnlock()
-
-	close(ch1)
-	close(ch2)
 }
 
 // Benchmark for calculateDirSize
@@ -385,3 +483,177 @@ func TestConcurrentStatusUpdates(t *testing.T) {
 	}
 	fs.statusMu.RUnlock()
 }
+
+// Test serving under a base path
+func TestBasePath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"fileshare", "/fileshare"},
+		{"/fileshare/", "/fileshare"},
+		{"/a/b", "/a/b"},
+	}
+	for _, test := range tests {
+		if result := normalizeBasePath(test.input); result != test.expected {
+			t.Errorf("normalizeBasePath(%q) = %q, expected %q", test.input, result, test.expected)
+		}
+	}
+
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.basePath = "/fileshare"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", fs.handleIndex)
+	mux.HandleFunc("/api/info", fs.handleInfo)
+	handler := fs.mountBasePath(mux)
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/fileshare", http.StatusMovedPermanently},
+		{"/fileshare/", http.StatusOK},
+		{"/fileshare/api/info", http.StatusOK},
+		{"/api/info", http.StatusNotFound},
+		{"/fileshare/nope", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", c.path, nil))
+		if rec.Code != c.code {
+			t.Errorf("GET %s = %d, expected %d", c.path, rec.Code, c.code)
+		}
+	}
+}
+
+// Test HEAD on the download describes it without starting a transfer
+func TestDownloadHead(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_head_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.WriteFile(filepath.Join(tempDir, "report.pdf"), []byte("0123456789"), 0644)
+
+	tests := []struct {
+		path        string
+		contentType string
+		length      string
+		ranges      string
+	}{
+		{filepath.Join(tempDir, "report.pdf"), "application/octet-stream", "10", "bytes"},
+		{tempDir, "application/zip", "", "none"},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", test.path, 8080, false)
+		// Another client holds the lock; HEAD must not need it.
+		fs.acquireClient("10.0.0.9")
+		req := httptest.NewRequest("HEAD", "/api/download", nil)
+		req.RemoteAddr = "192.168.1.20:5555"
+		rec := httptest.NewRecorder()
+		fs.handleDownload(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("HEAD %s = %d, expected 200", test.path, rec.Code)
+		}
+		h := rec.Header()
+		if h.Get("Content-Type") != test.contentType || h.Get("Content-Length") != test.length || h.Get("Accept-Ranges") != test.ranges {
+			t.Errorf("HEAD %s headers = %v, expected %s, length %q, ranges %s", test.path, h, test.contentType, test.length, test.ranges)
+		}
+		if !strings.Contains(h.Get("Content-Disposition"), "attachment") {
+			t.Errorf("HEAD %s should carry Content-Disposition, got %q", test.path, h.Get("Content-Disposition"))
+		}
+		if rec.Body.Len() != 0 || fs.status.Status != "waiting" {
+			t.Errorf("HEAD %s should not transfer, got %d bytes and status %s", test.path, rec.Body.Len(), fs.status.Status)
+		}
+	}
+}
+
+// Test that the event stream is flushed through the middleware over HTTP/2
+func TestEventsHTTP2(t *testing.T) {
+	fs := NewFileServer("send", ".", 8080, false)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/events", fs.handleEvents)
+	server := httptest.NewUnstartedServer(withRequestID(fs.withAudit(fs.withCommonHeaders(fs.mountBasePath(fs.withCORS(mux))))))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("GET /api/events error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	if resp.Header.Get("Connection") != "" {
+		t.Errorf("HTTP/2 response should not carry Connection, got %q", resp.Header.Get("Connection"))
+	}
+
+	// The initial status must arrive before the stream ends.
+	buf := make([]byte, 512)
+	done := make(chan string, 1)
+	go func() {
+		n, _ := resp.Body.Read(buf)
+		done <- string(buf[:n])
+	}()
+	select {
+	case frame := <-done:
+		if !strings.Contains(frame, `"status":"waiting"`) {
+			t.Errorf("Expected an initial status frame, got %q", frame)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event frame was flushed over HTTP/2")
+	}
+}
+
+// Test the phases reported while sending a directory
+func TestTransferPhases(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_phases_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	for i := 0; i < 3; i++ {
+		os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("part%d.csv", i)), []byte(strings.Repeat("a,b,c\n", 5000)), 0644)
+	}
+
+	tests := []struct {
+		zipStore bool
+		expected []string
+	}{
+		{false, []string{phaseScanning, phaseCompressing, phaseSending}},
+		{true, []string{phaseScanning, phaseSending}},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", tempDir, 8080, false)
+		fs.zipStore = test.zipStore
+		frames := newSSEClient(1000)
+		fs.addSSEClient(frames)
+		rec := httptest.NewRecorder()
+		fs.handleDownload(rec, httptest.NewRequest("GET", "/api/download", nil))
+
+		var phases []string
+		for _, frame := range frames.take() {
+			var status struct {
+				Status string `json:"status"`
+				Phase  string `json:"phase"`
+			}
+			if !strings.HasPrefix(frame, "data:") || json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(frame, "data:"))), &status) != nil || status.Status != "transferring" {
+				continue
+			}
+			if len(phases) == 0 || phases[len(phases)-1] != status.Phase {
+				phases = append(phases, status.Phase)
+			}
+		}
+		if strings.Join(phases, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("zipStore=%t: phases %v, expected %v", test.zipStore, phases, test.expected)
+		}
+		if fs.status.Sent != int64(rec.Body.Len()) {
+			t.Errorf("zipStore=%t: sent = %d, expected the %d bytes written", test.zipStore, fs.status.Sent, rec.Body.Len())
+		}
+	}
+}
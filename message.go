@@ -0,0 +1,154 @@
+package main
+
+import (
+	"html"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// loadMessage returns the text for -message: the contents of the named file
+// if it exists, otherwise the argument itself.
+func loadMessage(arg string) (string, error) {
+	if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return arg, nil
+}
+
+// maxMotdLength keeps -motd to a line that fits above the page.
+const maxMotdLength = 200
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdBullet   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold     = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalic   = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	mdSafeLink = regexp.MustCompile(`^(?i:https?:|mailto:|[^:]*$)`)
+)
+
+// renderMarkdown converts the small Markdown subset useful for share notes
+// (headings, paragraphs, lists, code, emphasis and links) to HTML. All input
+// is escaped first, so raw HTML in the message is shown as text.
+func renderMarkdown(src string) string {
+	var out strings.Builder
+	var para []string
+	list := ""
+	inCode := false
+
+	flushPara := func() {
+		if len(para) > 0 {
+			out.WriteString("<p>" + renderInline(strings.Join(para, " ")) + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			out.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			out.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				out.WriteString("</code></pre>\n")
+			} else {
+				flushPara()
+				closeList()
+				out.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			out.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			flushPara()
+			closeList()
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			flushPara()
+			closeList()
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+			continue
+		}
+		if m := mdBullet.FindStringSubmatch(line); m != nil {
+			flushPara()
+			openList("ul")
+			out.WriteString("<li>" + renderInline(m[1]) + "</li>\n")
+			continue
+		}
+		if m := mdOrdered.FindStringSubmatch(line); m != nil {
+			flushPara()
+			openList("ol")
+			out.WriteString("<li>" + renderInline(m[1]) + "</li>\n")
+			continue
+		}
+		closeList()
+		para = append(para, strings.TrimSpace(line))
+	}
+	if inCode {
+		out.WriteString("</code></pre>\n")
+	}
+	flushPara()
+	closeList()
+	return out.String()
+}
+
+// renderInline formats code spans, links and emphasis within a line.
+// Text inside backticks is left alone.
+func renderInline(text string) string {
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		part = html.EscapeString(part)
+		if i%2 == 1 && i < len(parts)-1 {
+			parts[i] = "<code>" + part + "</code>"
+			continue
+		}
+		// Emphasis applies around links and to their labels, never to the
+		// URL, which often has underscores or asterisks of its own.
+		var b strings.Builder
+		last := 0
+		for _, m := range mdLink.FindAllStringSubmatchIndex(part, -1) {
+			b.WriteString(renderEmphasis(part[last:m[0]]))
+			label, href := renderEmphasis(part[m[2]:m[3]]), part[m[4]:m[5]]
+			if mdSafeLink.MatchString(html.UnescapeString(href)) {
+				label = `<a href="` + href + `" target="_blank" rel="noopener">` + label + "</a>"
+			}
+			b.WriteString(label)
+			last = m[1]
+		}
+		b.WriteString(renderEmphasis(part[last:]))
+		part = b.String()
+		if i%2 == 1 {
+			// Unmatched trailing backtick.
+			part = "`" + part
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "")
+}
+
+func renderEmphasis(text string) string {
+	text = mdBold.ReplaceAllString(text, "<strong>$1$2</strong>")
+	return mdItalic.ReplaceAllString(text, "<em>$1$2</em>")
+}
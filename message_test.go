@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test Markdown rendering of share notes
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"# Title", "<h1>Title</h1>\n"},
+		{"first line\nsecond line", "<p>first line second line</p>\n"},
+		{"- one\n- two", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"},
+		{"1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{"```\nsha256  <x>\n```", "<pre><code>sha256  &lt;x&gt;\n</code></pre>\n"},
+		{"run `ls *.txt` **now**", "<p>run <code>ls *.txt</code> <strong>now</strong></p>\n"},
+		{"*careful*", "<p><em>careful</em></p>\n"},
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"[docs](https://example.com/a_b)", `<p><a href="https://example.com/a_b" target="_blank" rel="noopener">docs</a></p>` + "\n"},
+		{"[bad](javascript:alert(1))", "<p>bad)</p>\n"},
+		{"[notes](https://example.com/_drafts_/a*b*c)", `<p><a href="https://example.com/_drafts_/a*b*c" target="_blank" rel="noopener">notes</a></p>` + "\n"},
+		{"_see_ [**the** docs](https://x.org/a__b__c) *now*", `<p><em>see</em> <a href="https://x.org/a__b__c" target="_blank" rel="noopener"><strong>the</strong> docs</a> <em>now</em></p>` + "\n"},
+	}
+	for _, test := range tests {
+		result := renderMarkdown(test.input)
+		if result != test.expected {
+			t.Errorf("renderMarkdown(%q) = %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+// Test loading the message from a file or inline text
+func TestLoadMessage(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_message_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	notes := filepath.Join(tempDir, "notes.md")
+	os.WriteFile(notes, []byte("# From file"), 0644)
+
+	if text, err := loadMessage(notes); err != nil || text != "# From file" {
+		t.Errorf("loadMessage(file) = %q, %v; expected file contents", text, err)
+	}
+	if text, _ := loadMessage("Unzip with 7z"); text != "Unzip with 7z" {
+		t.Errorf("loadMessage(inline) = %q, expected the text itself", text)
+	}
+	if text, _ := loadMessage(tempDir); !strings.HasPrefix(text, tempDir) {
+		t.Errorf("loadMessage(dir) should fall back to inline text, got %q", text)
+	}
+}
+
+// Test that -motd is reported as plain text in /api/info
+func TestInfoMotd(t *testing.T) {
+	tests := []struct {
+		motd     string
+		expected string
+	}{
+		{"", ""},
+		{"Grab the Q3 report; checksum below", "Grab the Q3 report; checksum below"},
+		{`<b>"quoted"</b>`, `<b>"quoted"</b>`},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", "/share/report.pdf", 8080, false)
+		fs.motd = test.motd
+		w := httptest.NewRecorder()
+		fs.handleInfo(w, httptest.NewRequest("GET", "/api/info", nil))
+		var info struct {
+			Motd string `json:"motd"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+			t.Fatalf("Invalid /api/info JSON for motd %q: %v", test.motd, err)
+		}
+		if info.Motd != test.expected {
+			t.Errorf("motd = %q, expected %q", info.Motd, test.expected)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Metalink 4 (RFC 5854) document for the shared file: every address the
+// host can be reached on, plus the size and hash, so aria2 can fetch
+// segments from all of them and verify the result.
+
+const metalinkNS = "urn:ietf:params:xml:ns:metalink"
+
+type metalink struct {
+	XMLName   xml.Name     `xml:"metalink"`
+	NS        string       `xml:"xmlns,attr"`
+	Generator string       `xml:"generator"`
+	Published string       `xml:"published"`
+	File      metalinkFile `xml:"file"`
+}
+
+type metalinkFile struct {
+	Name string        `xml:"name,attr"`
+	Size int64         `xml:"size"`
+	Hash metalinkHash  `xml:"hash"`
+	URLs []metalinkURL `xml:"url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkURL struct {
+	Priority int    `xml:"priority,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// fileHash caches the SHA-256 of the shared file, which can take a while
+// to compute, for as long as its size and modification time don't change.
+type fileHash struct {
+	mu      sync.Mutex
+	path    string
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+func (h *fileHash) get(path string, info os.FileInfo) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sum != "" && h.path == path && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.sum, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	h.path, h.size, h.modTime = path, info.Size(), info.ModTime()
+	h.sum = hex.EncodeToString(hasher.Sum(nil))
+	return h.sum, nil
+}
+
+// metalinkURLs lists path on host, the address the client used, first
+// and then on every other LAN address. Loopback is left out unless the
+// client used it, since elsewhere it means the client itself.
+func (fs *FileServer) metalinkURLs(host, path string) []string {
+	path = fs.basePath + path
+	urls := []string{"http://" + host + path}
+	for _, ip := range getLocalIPs() {
+		if ip == "127.0.0.1" {
+			continue
+		}
+		url := "http://" + net.JoinHostPort(ip, strconv.Itoa(fs.port)) + path
+		if url != urls[0] {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// handleMetalink serves /api/download.meta4. Archives are built on the
+// fly, so only a single shared file has a size and hash to publish.
+func (fs *FileServer) handleMetalink(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "send" {
+		httpError(w, r, "Server is not in send mode", http.StatusBadRequest)
+		return
+	}
+	sources, isArchive, err := fs.shareSources()
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	if isArchive {
+		httpError(w, r, "Metalinks are only available for single files", http.StatusNotFound)
+		return
+	}
+	target := sources[0].path
+	info, err := os.Stat(target)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	sum, err := fs.metaHash.get(target, info)
+	if err != nil {
+		httpError(w, r, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	doc := metalink{
+		NS:        metalinkNS,
+		Generator: "fileshare/" + buildInfo().Version,
+		Published: fs.started.UTC().Format(time.RFC3339),
+		File: metalinkFile{
+			Name: fs.downloadFilename(false),
+			Size: info.Size(),
+			Hash: metalinkHash{Type: "sha-256", Value: sum},
+		},
+	}
+	for i, url := range fs.metalinkURLs(r.Host, fs.resign(r, "/api/download")) {
+		doc.File.URLs = append(doc.File.URLs, metalinkURL{Priority: i + 1, Value: url})
+	}
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		httpError(w, r, "Failed to build metalink", http.StatusInternalServerError)
+		return
+	}
+	fs.logRequest(r, fmt.Sprintf("Metalink requested by %s", fs.clientLabel(fs.getClientIP(r))))
+
+	w.Header().Set("Content-Type", "application/metalink4+xml")
+	w.Header().Set("Content-Disposition", contentDisposition(fs.downloadFilename(false)+".meta4"))
+	io.WriteString(w, xml.Header)
+	w.Write(data)
+	io.WriteString(w, "\n")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test the metalink document for a shared file
+func TestMetalink(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_metalink_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	const content = "metalink me"
+	target := filepath.Join(tempDir, "report & notes.pdf")
+	os.WriteFile(target, []byte(content), 0644)
+	sum := sha256.Sum256([]byte(content))
+
+	tests := []struct {
+		mode     string
+		path     string
+		basePath string
+		code     int
+		firstURL string
+	}{
+		{"send", target, "", http.StatusOK, "http://192.168.1.5:8080/api/download"},
+		{"send", target, "/share/blue-tiger-42", http.StatusOK, "http://192.168.1.5:8080/share/blue-tiger-42/api/download"},
+		{"send", tempDir, "", http.StatusNotFound, ""},
+		{"recv", tempDir, "", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		fs := NewFileServer(test.mode, test.path, 8080, false)
+		fs.basePath = test.basePath
+		req := httptest.NewRequest("GET", "/api/download.meta4", nil)
+		req.Host = "192.168.1.5:8080"
+		rec := httptest.NewRecorder()
+		fs.handleMetalink(rec, req)
+		if rec.Code != test.code {
+			t.Errorf("%s %s: status %d, expected %d", test.mode, test.path, rec.Code, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var doc metalink
+		if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+			t.Errorf("Metalink is not valid XML: %v", err)
+			continue
+		}
+		if doc.XMLName.Space != metalinkNS || doc.File.Name != "report & notes.pdf" || doc.File.Size != int64(len(content)) {
+			t.Errorf("Metalink describes %q in %q (%d bytes), expected the shared file", doc.File.Name, doc.XMLName.Space, doc.File.Size)
+		}
+		if doc.File.Hash.Type != "sha-256" || doc.File.Hash.Value != hex.EncodeToString(sum[:]) {
+			t.Errorf("Metalink hash %s %s, expected sha-256 %x", doc.File.Hash.Type, doc.File.Hash.Value, sum)
+		}
+		if len(doc.File.URLs) == 0 || doc.File.URLs[0].Value != test.firstURL || doc.File.URLs[0].Priority != 1 {
+			t.Errorf("Metalink URLs %+v, expected %s first", doc.File.URLs, test.firstURL)
+		}
+		for _, url := range doc.File.URLs {
+			if strings.Contains(url.Value, "127.0.0.1") {
+				t.Errorf("Metalink should not list loopback when fetched over the LAN: %s", url.Value)
+			}
+		}
+		if !strings.Contains(rec.Header().Get("Content-Disposition"), ".meta4") {
+			t.Errorf("Content-Disposition %q, expected a .meta4 file name", rec.Header().Get("Content-Disposition"))
+		}
+	}
+}
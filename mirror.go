@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const mirrorAttempts = 3
+
+// uploadMirror is a place accepted uploads are copied to. name is the
+// upload's slash-separated path relative to the receive directory.
+type uploadMirror interface {
+	location(name string) string
+	put(path, name, sum string, now time.Time) error
+}
+
+// s3Mirror copies received files to an S3-compatible bucket (AWS, MinIO)
+// with path-style PUT requests signed with Signature Version 4.
+type s3Mirror struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newS3Mirror parses a target like s3://bucket/prefix (AWS) or
+// http://minio:9000/bucket/prefix, taking credentials from the usual AWS
+// environment variables.
+func newS3Mirror(target string, getenv func(string) string) (*s3Mirror, error) {
+	m := &s3Mirror{
+		region:       getenv("AWS_REGION"),
+		accessKey:    getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{},
+	}
+	if m.region == "" {
+		m.region = getenv("AWS_DEFAULT_REGION")
+	}
+	if m.region == "" {
+		m.region = "us-east-1"
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -mirror-s3 target: %v", err)
+	}
+	path := u.Path
+	switch u.Scheme {
+	case "s3":
+		path = u.Host + path
+		u = &url.URL{Scheme: "https", Host: "s3." + m.region + ".amazonaws.com"}
+	case "http", "https":
+		u = &url.URL{Scheme: u.Scheme, Host: u.Host}
+	default:
+		return nil, errors.New("-mirror-s3 must be s3://bucket/prefix or http(s)://host/bucket/prefix")
+	}
+	m.endpoint = u
+	m.bucket, m.prefix, _ = strings.Cut(strings.Trim(path, "/"), "/")
+	if m.bucket == "" {
+		return nil, errors.New("-mirror-s3 needs a bucket name")
+	}
+	if m.accessKey == "" || m.secretKey == "" {
+		return nil, errors.New("-mirror-s3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return m, nil
+}
+
+// objectKey places name (slash-separated, relative to the receive
+// directory) under the prefix.
+func (m *s3Mirror) objectKey(name string) string {
+	if m.prefix == "" {
+		return name
+	}
+	return m.prefix + "/" + name
+}
+
+func (m *s3Mirror) location(name string) string {
+	return "s3://" + m.bucket + "/" + m.objectKey(name)
+}
+
+// put uploads the file at path under the prefix. sum is its hex SHA-256,
+// which S3 checks against the body; without it the payload goes unsigned.
+func (m *s3Mirror) put(path, name, sum string, now time.Time) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, m.endpoint.String(), file)
+	if err != nil {
+		return err
+	}
+	// RawPath keeps Go from escaping the path differently from what was
+	// signed.
+	req.URL.Path = "/" + m.bucket + "/" + m.objectKey(name)
+	req.URL.RawPath = awsEscape(req.URL.Path)
+	req.ContentLength = info.Size()
+	if sum == "" {
+		sum = "UNSIGNED-PAYLOAD"
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sum)
+	if m.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", m.sessionToken)
+	}
+	m.sign(req, now)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &mirrorStatusError{code: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
+
+type mirrorStatusError struct {
+	code int
+	body string
+}
+
+func (e *mirrorStatusError) Error() string {
+	if e.body == "" {
+		return http.StatusText(e.code)
+	}
+	return fmt.Sprintf("%s: %s", http.StatusText(e.code), e.body)
+}
+
+// retryable reports whether another attempt might succeed: network
+// errors and server-side failures, but not rejected credentials.
+func retryable(err error) bool {
+	var status *mirrorStatusError
+	if errors.As(err, &status) {
+		return status.code >= 500 || status.code == http.StatusTooManyRequests || status.code == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// sign adds a Signature Version 4 Authorization header covering the host
+// and every header already set on req. X-Amz-Content-Sha256 must be set.
+func (m *s3Mirror) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + m.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + m.secretKey)
+	for _, part := range []string{date, m.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		m.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes a path the way SigV4 canonicalizes it:
+// everything but unreserved characters and slashes.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// mirrorUpload copies an accepted upload to each mirror in the
+// background, retrying transient failures, and reports the outcome in the
+// log.
+func (fs *FileServer) mirrorUpload(path, sum string) {
+	name := filepath.Base(path)
+	if rel, err := filepath.Rel(fs.inboxPath(), path); err == nil && !strings.HasPrefix(rel, "..") {
+		name = filepath.ToSlash(rel)
+	}
+	for _, m := range fs.mirrors {
+		fs.mirrorWG.Add(1)
+		go func() {
+			defer fs.mirrorWG.Done()
+			fs.mirrorTo(m, path, name, sum)
+		}()
+	}
+}
+
+func (fs *FileServer) mirrorTo(m uploadMirror, path, name, sum string) {
+	location := m.location(name)
+	var err error
+	for attempt := 1; attempt <= mirrorAttempts; attempt++ {
+		if attempt > 1 {
+			<-fs.clock.After(time.Duration(1<<(attempt-2)) * time.Second)
+		}
+		if err = m.put(path, name, sum, fs.clock.Now()); err == nil {
+			fs.addLog(fmt.Sprintf("Mirrored %s to %s", name, location))
+			fs.report(outputEvent{Event: "mirrored", Name: name, Path: location},
+				fmt.Sprintf("\n%sMirrored '%s' to %s\n", icon("☁️  "), name, location))
+			return
+		}
+		if !retryable(err) {
+			break
+		}
+		if attempt < mirrorAttempts {
+			fs.addLog(fmt.Sprintf("Mirroring %s failed (attempt %d/%d), retrying: %v", name, attempt, mirrorAttempts, err))
+		}
+	}
+	fs.addLog(fmt.Sprintf("Mirroring %s to %s failed: %v", name, location, err))
+	fs.report(outputEvent{Event: "mirror_failed", Name: name, Path: location, Error: err.Error()},
+		fmt.Sprintf("\n%sCould not mirror '%s' to %s: %v\n", icon("✗ "), name, location, err))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// remoteShare reads a send-mode share lazily: the tree comes from
+// /api/files and file contents from ranged requests to /api/file.
+type remoteShare struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// remoteEntry is a file or directory in the mounted tree.
+type remoteEntry struct {
+	name     string
+	path     string
+	size     int64
+	modTime  time.Time
+	children map[string]*remoteEntry
+}
+
+func (e *remoteEntry) isDir() bool {
+	return e.children != nil
+}
+
+// sortedChildren lists a directory's entries by name.
+func (e *remoteEntry) sortedChildren() []*remoteEntry {
+	children := make([]*remoteEntry, 0, len(e.children))
+	for _, child := range e.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// newRemoteShare accepts the URL printed by the server. A signed link is
+// opened once so its cookie authorizes the API requests that follow.
+func newRemoteShare(raw string) (*remoteShare, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid share URL %q", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	jar, _ := cookiejar.New(nil)
+	share := &remoteShare{base: u, client: &http.Client{Jar: jar}}
+	if u.RawQuery != "" {
+		resp, err := share.client.Get(u.String())
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", u.Redacted(), resp.Status)
+		}
+	}
+	return share, nil
+}
+
+func (s *remoteShare) endpoint(path string, query url.Values) string {
+	u := *s.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// tree fetches the listing and arranges it into directories.
+func (s *remoteShare) tree() (*remoteEntry, *fileListing, error) {
+	resp, err := s.client.Get(s.endpoint("api/files", nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var listing fileListing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, nil, fmt.Errorf("invalid listing: %v", err)
+	}
+	return buildTree(listing.Files), &listing, nil
+}
+
+// buildTree turns slash-separated listing names into nested entries.
+// Directories take the newest modification time of their contents.
+func buildTree(files []fileEntry) *remoteEntry {
+	root := &remoteEntry{children: map[string]*remoteEntry{}}
+	for _, f := range files {
+		dir := root
+		parts := strings.Split(f.Name, "/")
+		for i, part := range parts {
+			if part == "" || part == "." || part == ".." {
+				break
+			}
+			if f.ModTime.After(dir.modTime) {
+				dir.modTime = f.ModTime
+			}
+			if i == len(parts)-1 {
+				dir.children[part] = &remoteEntry{name: part, path: f.Name, size: f.Size, modTime: f.ModTime}
+				break
+			}
+			next := dir.children[part]
+			if next == nil || !next.isDir() {
+				next = &remoteEntry{name: part, children: map[string]*remoteEntry{}}
+				dir.children[part] = next
+			}
+			dir = next
+		}
+	}
+	return root
+}
+
+// readAt fills p from the file at offset off with a single range request.
+func (s *remoteShare) readAt(name string, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, s.endpoint("api/file", url.Values{"name": {name}}), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-"+strconv.FormatInt(off+int64(len(p))-1, 10))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The whole file came back; skip to the offset.
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return 0, io.EOF
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		return 0, fmt.Errorf("%s: %s", name, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func runMount(args []string) int {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s mount <url> <mountpoint>\n\nMounts a send-mode share read-only; files are fetched as they are read.\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 1
+	}
+	share, err := newRemoteShare(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	root, listing, err := share.tree()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot list share: %v\n", err)
+		return 1
+	}
+	if listing.Truncated {
+		fmt.Fprintf(os.Stderr, "Warning: the share has more than %d files; only the first %d are mounted\n", maxListEntries, maxListEntries)
+	}
+
+	mountpoint := flags.Arg(1)
+	unmount, wait, err := mountShare(share, root, mountpoint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: mount failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%sMounted %s on %s (%d files, %s)\n", icon("📂 "), share.base.Redacted(), mountpoint, len(listing.Files), formatSize(listing.TotalSize))
+	fmt.Printf("%sPress Ctrl+C to unmount\n", icon("⏹️  "))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		if err := unmount(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: unmount: %v\n", err)
+		}
+	}()
+	wait()
+	return 0
+}
+
+var errMountUnsupported = errors.New("mounting is not supported on this platform")
@@ -0,0 +1,89 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// remoteNode exposes a remoteEntry through FUSE.
+type remoteNode struct {
+	fs.Inode
+	share *remoteShare
+	entry *remoteEntry
+}
+
+var (
+	_ = (fs.NodeOnAdder)((*remoteNode)(nil))
+	_ = (fs.NodeGetattrer)((*remoteNode)(nil))
+	_ = (fs.NodeOpener)((*remoteNode)(nil))
+	_ = (fs.NodeReader)((*remoteNode)(nil))
+)
+
+// OnAdd builds the whole tree up front; only file contents are fetched
+// lazily.
+func (n *remoteNode) OnAdd(ctx context.Context) {
+	for _, child := range n.entry.sortedChildren() {
+		mode := uint32(syscall.S_IFREG)
+		if child.isDir() {
+			mode = syscall.S_IFDIR
+		}
+		node := &remoteNode{share: n.share, entry: child}
+		n.AddChild(child.name, n.NewPersistentInode(ctx, node, fs.StableAttr{Mode: mode}), true)
+		if child.isDir() {
+			node.OnAdd(ctx)
+		}
+	}
+}
+
+func (n *remoteNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode, out.Nlink = 0444, 1
+	if n.entry.isDir() {
+		out.Mode, out.Nlink = 0555, 2
+	}
+	out.Size = uint64(n.entry.size)
+	out.Blocks = (out.Size + 511) / 512
+	out.SetTimes(nil, &n.entry.modTime, &n.entry.modTime)
+	return fs.OK
+}
+
+func (n *remoteNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	return nil, fuse.FOPEN_KEEP_CACHE, fs.OK
+}
+
+func (n *remoteNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= n.entry.size {
+		return fuse.ReadResultData(nil), fs.OK
+	}
+	dest = dest[:min(int64(len(dest)), n.entry.size-off)]
+	read, err := n.share.readAt(n.entry.path, dest, off)
+	if err != nil && read == 0 {
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:read]), fs.OK
+}
+
+func mountShare(share *remoteShare, root *remoteEntry, mountpoint string) (func() error, func(), error) {
+	server, err := fs.Mount(mountpoint, &remoteNode{share: share, entry: root}, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      share.base.Redacted(),
+			Name:        "fileshare",
+			Options:     []string{"ro"},
+			DirectMount: true,
+		},
+		UID: uint32(os.Getuid()),
+		GID: uint32(os.Getgid()),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return server.Unmount, server.Wait, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package main
+
+func mountShare(share *remoteShare, root *remoteEntry, mountpoint string) (func() error, func(), error) {
+	return nil, nil, errMountUnsupported
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test arranging a listing into directories
+func TestBuildTree(t *testing.T) {
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := old.Add(time.Hour)
+	root := buildTree([]fileEntry{
+		{Name: "photos/2024/a.jpg", Size: 10, ModTime: old},
+		{Name: "photos/b.jpg", Size: 20, ModTime: recent},
+		{Name: "notes.txt", Size: 3, ModTime: old},
+		{Name: "../escape", Size: 1, ModTime: old},
+	})
+
+	tests := []struct {
+		path    []string
+		isDir   bool
+		size    int64
+		modTime time.Time
+	}{
+		{[]string{"photos"}, true, 0, recent},
+		{[]string{"photos", "2024"}, true, 0, old},
+		{[]string{"photos", "2024", "a.jpg"}, false, 10, old},
+		{[]string{"photos", "b.jpg"}, false, 20, recent},
+		{[]string{"notes.txt"}, false, 3, old},
+	}
+	for _, test := range tests {
+		entry := root
+		for _, part := range test.path {
+			if entry = entry.children[part]; entry == nil {
+				break
+			}
+		}
+		if entry == nil {
+			t.Errorf("%v missing from tree", test.path)
+			continue
+		}
+		if entry.isDir() != test.isDir || entry.size != test.size || !entry.modTime.Equal(test.modTime) {
+			t.Errorf("%v = dir %v size %d mtime %v, expected dir %v size %d mtime %v",
+				test.path, entry.isDir(), entry.size, entry.modTime, test.isDir, test.size, test.modTime)
+		}
+	}
+	if len(root.children) != 2 {
+		t.Errorf("Root has %d entries, expected photos and notes.txt", len(root.children))
+	}
+	if names := root.children["photos"].sortedChildren(); names[0].name != "2024" || names[1].name != "b.jpg" {
+		t.Errorf("sortedChildren out of order: %s, %s", names[0].name, names[1].name)
+	}
+}
+
+// Test reading a remote share through the listing and file endpoints
+func TestRemoteShare(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_mount_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	shared := filepath.Join(tempDir, "docs")
+	os.MkdirAll(filepath.Join(shared, "sub"), 0755)
+	os.WriteFile(filepath.Join(shared, "sub", "data.bin"), []byte("0123456789abcdef"), 0644)
+	os.WriteFile(filepath.Join(tempDir, "secret.txt"), []byte("x"), 0644)
+
+	fs := NewFileServer("send", shared, 8080, false)
+	fs.signKey = []byte("key")
+	// The client's cookie jar drops cookies that expired in real time.
+	fs.clock = &tickClock{now: time.Now()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/files", fs.handleFiles)
+	mux.HandleFunc("/api/file", fs.handleFile)
+	server := httptest.NewServer(fs.withSignature(mux))
+	defer server.Close()
+
+	if _, err := newRemoteShare("ftp://host/"); err == nil {
+		t.Errorf("newRemoteShare should reject non-HTTP URLs")
+	}
+	unsigned, _ := newRemoteShare(server.URL)
+	if _, _, err := unsigned.tree(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Listing without the signature error = %v, expected 403", err)
+	}
+
+	link := server.URL + "/?" + fs.signQuery("/", fs.clock.Now().Add(time.Hour))
+	share, err := newRemoteShare(link)
+	if err != nil {
+		t.Fatalf("newRemoteShare(%s) error: %v", link, err)
+	}
+	root, listing, err := share.tree()
+	if err != nil {
+		t.Fatalf("tree error: %v", err)
+	}
+	entry := root.children["sub"].children["data.bin"]
+	if len(listing.Files) != 1 || entry == nil || entry.size != 16 {
+		t.Fatalf("Unexpected tree for listing %+v", listing.Files)
+	}
+
+	tests := []struct {
+		off      int64
+		length   int
+		expected string
+		err      error
+	}{
+		{0, 4, "0123", nil},
+		{10, 6, "abcdef", nil},
+		{12, 10, "cdef", io.EOF},
+		{16, 4, "", io.EOF},
+	}
+	for _, test := range tests {
+		buf := make([]byte, test.length)
+		n, err := share.readAt(entry.path, buf, test.off)
+		if string(buf[:n]) != test.expected || err != test.err {
+			t.Errorf("readAt(%d, %d) = %q, %v; expected %q, %v", test.off, test.length, buf[:n], err, test.expected, test.err)
+		}
+	}
+	if _, err := share.readAt("../secret.txt", make([]byte, 1), 0); err == nil {
+		t.Errorf("Reading outside the share should fail")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const notifyTimeout = 10 * time.Second
+
+// telegramAPI is the Bot API base URL, replaced in tests.
+var telegramAPI = "https://api.telegram.org"
+
+// notifier posts a one-line message about a finished transfer or a
+// reached limit to a chat or push service (-notify).
+type notifier interface {
+	name() string
+	send(client *http.Client, text string) error
+}
+
+// ntfyNotifier publishes to an ntfy topic URL.
+type ntfyNotifier struct {
+	topic string
+}
+
+func (n ntfyNotifier) name() string { return "ntfy" }
+
+func (n ntfyNotifier) send(client *http.Client, text string) error {
+	req, err := http.NewRequest(http.MethodPost, n.topic, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", "fileshare")
+	return notifyDo(client, req)
+}
+
+// telegramNotifier sends through a bot to a chat, group or channel.
+type telegramNotifier struct {
+	token string
+	chat  string
+}
+
+func (n telegramNotifier) name() string { return "Telegram" }
+
+func (n telegramNotifier) send(client *http.Client, text string) error {
+	form := url.Values{"chat_id": {n.chat}, "text": {text}}
+	req, err := http.NewRequest(http.MethodPost, telegramAPI+"/bot"+n.token+"/sendMessage", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return notifyDo(client, req)
+}
+
+// slackNotifier posts to a Slack incoming webhook, which is bound to a
+// channel when it is created.
+type slackNotifier struct {
+	webhook string
+}
+
+func (n slackNotifier) name() string { return "Slack" }
+
+func (n slackNotifier) send(client *http.Client, text string) error {
+	body, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequest(http.MethodPost, n.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return notifyDo(client, req)
+}
+
+func notifyDo(client *http.Client, req *http.Request) error {
+	req.Header.Set("User-Agent", "fileshare/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL holds the bot token or webhook secret; keep it out of
+		// the log.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// newNotifier parses a -notify target: ntfy:<topic URL>,
+// telegram:<bot token>@<chat id> (the token may instead come from
+// FILESHARE_TELEGRAM_TOKEN, keeping it out of the process list) or
+// slack:<incoming webhook URL>.
+func newNotifier(spec string, getenv func(string) string) (notifier, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "ntfy":
+		if !httpURL(target) {
+			return nil, errors.New("-notify ntfy: needs a topic URL, e.g. ntfy:https://ntfy.sh/my-topic")
+		}
+		return ntfyNotifier{target}, nil
+	case "telegram":
+		token, chat, ok := strings.Cut(target, "@")
+		if !ok {
+			token, chat = getenv("FILESHARE_TELEGRAM_TOKEN"), target
+		}
+		if token == "" || chat == "" {
+			return nil, errors.New("-notify telegram: needs <bot token>@<chat id>, or a chat id with FILESHARE_TELEGRAM_TOKEN set")
+		}
+		return telegramNotifier{token, chat}, nil
+	case "slack":
+		if !httpURL(target) {
+			return nil, errors.New("-notify slack: needs an incoming webhook URL")
+		}
+		return slackNotifier{target}, nil
+	}
+	return nil, fmt.Errorf("-notify: unknown service '%s' (ntfy, telegram or slack)", kind)
+}
+
+func httpURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// notifyFlags collects repeated -notify flags.
+type notifyFlags struct {
+	values *[]string
+}
+
+func (f notifyFlags) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, ",")
+}
+
+func (f notifyFlags) Set(value string) error {
+	if _, err := newNotifier(value, os.Getenv); err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "-notify "))
+	}
+	*f.values = append(*f.values, value)
+	return nil
+}
+
+// notifyText is the message sent for ev, or false for events that aren't
+// worth a notification.
+func (fs *FileServer) notifyText(ev outputEvent) (string, bool) {
+	switch ev.Event {
+	case "completed":
+		direction := "to"
+		if fs.mode == "recv" || fs.mode == "both" && fs.lastReceived() {
+			direction = "from"
+		}
+		text := fmt.Sprintf("Transfer complete: %s %s %s (%s", ev.Name, direction, cmp.Or(ev.ClientHost, ev.Client), formatSize(ev.Size))
+		if len(ev.SHA256) > 12 {
+			text += ", sha256 " + ev.SHA256[:12] + "…"
+		}
+		return text + ")", true
+	case "cancelled":
+		return fmt.Sprintf("Transfer of %s cancelled", fs.shareName()), true
+	case "limit_reached":
+		return fmt.Sprintf("Receive limit of %s reached", formatSize(ev.Size)), true
+	case "budget_reached":
+		return fmt.Sprintf("Bandwidth budget of %s used up, new transfers are refused", formatSize(ev.Size)), true
+	case "mirror_failed":
+		return fmt.Sprintf("Could not mirror %s to %s: %s", ev.Name, ev.Path, ev.Error), true
+	case "extract_failed":
+		return fmt.Sprintf("Could not extract %s: %s", ev.Name, ev.Error), true
+	}
+	return "", false
+}
+
+// notify sends ev to every -notify target in the background. Failures are
+// only logged: a chat service being down mustn't hold up transfers.
+func (fs *FileServer) notify(ev outputEvent) {
+	if len(fs.notifiers) == 0 {
+		return
+	}
+	text, ok := fs.notifyText(ev)
+	if !ok {
+		return
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	for _, n := range fs.notifiers {
+		fs.notifyWG.Add(1)
+		go func() {
+			defer fs.notifyWG.Done()
+			if err := n.send(client, text); err != nil {
+				fs.addLog(fmt.Sprintf("%s notification failed: %v", n.name(), err))
+			}
+		}()
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test parsing -notify targets
+func TestNewNotifier(t *testing.T) {
+	env := map[string]string{"FILESHARE_TELEGRAM_TOKEN": "123:env"}
+	tests := []struct {
+		spec     string
+		expected notifier
+		errText  string
+	}{
+		{"ntfy:https://ntfy.sh/drops", ntfyNotifier{"https://ntfy.sh/drops"}, ""},
+		{"telegram:123:abc@-1001", telegramNotifier{"123:abc", "-1001"}, ""},
+		{"telegram:-1001", telegramNotifier{"123:env", "-1001"}, ""},
+		{"slack:https://hooks.slack.com/services/T/B/x", slackNotifier{"https://hooks.slack.com/services/T/B/x"}, ""},
+		{"ntfy:drops", nil, "topic URL"},
+		{"slack:", nil, "webhook"},
+		{"telegram:123:abc@", nil, "chat id"},
+		{"email:me@example.com", nil, "unknown service"},
+	}
+	for _, test := range tests {
+		n, err := newNotifier(test.spec, func(k string) string { return env[k] })
+		if test.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errText) {
+				t.Errorf("newNotifier(%q) error = %v, expected %q", test.spec, err, test.errText)
+			}
+			continue
+		}
+		if err != nil || n != test.expected {
+			t.Errorf("newNotifier(%q) = %#v (%v), expected %#v", test.spec, n, err, test.expected)
+		}
+	}
+	if _, err := newNotifier("telegram:-1001", func(string) string { return "" }); err == nil {
+		t.Error("Telegram without a token expected an error")
+	}
+}
+
+// Test each service gets the message in its own format
+func TestNotify(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]string{}
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got[r.URL.Path] = r.Header.Get("Content-Type") + " " + string(body)
+		mu.Unlock()
+	}))
+	defer remote.Close()
+	defer func(api string) { telegramAPI = api }(telegramAPI)
+	telegramAPI = remote.URL
+
+	fs := NewFileServer("recv", t.TempDir(), 8080, false)
+	fs.notifiers = []notifier{ntfyNotifier{remote.URL + "/drops"}, telegramNotifier{"123:abc", "42"}, slackNotifier{remote.URL + "/hook"}}
+	fs.notify(outputEvent{Event: "progress", Name: "report.pdf"})
+	fs.notify(outputEvent{Event: "completed", Client: "192.168.1.42", Name: "report.pdf", Size: 1288490189,
+		SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"})
+	fs.notifyWG.Wait()
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/drops", " Transfer complete: report.pdf from 192.168.1.42 (1.20 GB, sha256 9f86d081884c…)"},
+		{"/bot123:abc/sendMessage", "application/x-www-form-urlencoded chat_id=42&text=Transfer+complete%3A+report.pdf+from+192.168.1.42+%281.20+GB%2C+sha256+9f86d081884c%E2%80%A6%29"},
+		{"/hook", `application/json {"text":"Transfer complete: report.pdf from 192.168.1.42 (1.20 GB, sha256 9f86d081884c…)"}`},
+	}
+	for _, test := range tests {
+		if got[test.path] != test.expected {
+			t.Errorf("%s got %q, expected %q", test.path, got[test.path], test.expected)
+		}
+	}
+	if len(got) != len(tests) {
+		t.Errorf("Got %d notifications, expected %d (progress is not notified)", len(got), len(tests))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultProgressInterval is how often a running transfer's progress is
+// published by default (-progress-interval).
+const defaultProgressInterval = 250 * time.Millisecond
+
+// plainOutput drops emoji and box-drawing characters from console output,
+// which garble logs and non-UTF-8 terminals (-plain or NO_COLOR).
+var plainOutput bool
+
+func icon(s string) string {
+	if plainOutput {
+		return ""
+	}
+	return s
+}
+
+// outputEvent is one line of `-output json`.
+type outputEvent struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	Mode        string    `json:"mode,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Target      string    `json:"target,omitempty"`
+	URLs        []string  `json:"urls,omitempty"`
+	Code        string    `json:"code,omitempty"`
+	Client      string    `json:"client,omitempty"`
+	ClientHost  string    `json:"client_host,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	Transferred int64     `json:"transferred,omitempty"`
+	Progress    float64   `json:"progress,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Compression string    `json:"compression,omitempty"`
+	WireSize    int64     `json:"wire_size,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// eventWriter emits newline-delimited JSON events for wrapper scripts in
+// place of the human-readable console output.
+type eventWriter struct {
+	mu           sync.Mutex
+	enc          *json.Encoder
+	interval     time.Duration
+	lastProgress time.Time
+}
+
+func newEventWriter(w io.Writer, interval time.Duration) *eventWriter {
+	return &eventWriter{enc: json.NewEncoder(w), interval: interval}
+}
+
+func validOutputFormat(format string) bool {
+	return format == "text" || format == "json"
+}
+
+func (e *eventWriter) emit(ev outputEvent) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Event == "progress" {
+		// Progress is reported per buffer; keep the stream readable.
+		if ev.Transferred < ev.Size && ev.Time.Sub(e.lastProgress) < e.interval {
+			return
+		}
+		e.lastProgress = ev.Time
+	}
+	e.enc.Encode(ev)
+}
+
+// report prints msg for humans, or emits ev instead in JSON output mode,
+// and passes it on to any -notify targets.
+func (fs *FileServer) report(ev outputEvent, msg string) {
+	fs.notify(ev)
+	if fs.events != nil {
+		fs.events.emit(ev)
+		return
+	}
+	printConsole(msg)
+}
+
+func (fs *FileServer) emitReady() {
+	ips := getLocalIPs()
+	ready := outputEvent{Event: "ready", Mode: fs.mode, Version: versionString(), Target: fs.shareName(), Code: fs.shareCode}
+	for _, ip := range ips {
+		ready.URLs = append(ready.URLs, fs.signedURL(fmt.Sprintf("http://%s:%d%s", ip, fs.port, fs.basePath), "/"))
+	}
+	if fs.servesDownloads() {
+		if sources, _, err := fs.shareSources(); err == nil {
+			ready.Size = fs.targetSize(sources)
+		}
+	}
+	if fs.copyURL {
+		fs.copyShareURL(ips)
+	}
+	fs.events.emit(ready)
+}
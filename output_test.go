@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that progress events are throttled but the final one is kept
+func TestEventWriterProgress(t *testing.T) {
+	var buf bytes.Buffer
+	events := newEventWriter(&buf, defaultProgressInterval)
+	start := time.Now()
+	events.emit(outputEvent{Event: "progress", Time: start, Size: 100, Transferred: 10})
+	events.emit(outputEvent{Event: "progress", Time: start.Add(time.Millisecond), Size: 100, Transferred: 20})
+	events.emit(outputEvent{Event: "progress", Time: start.Add(2 * time.Millisecond), Size: 100, Transferred: 100})
+	events.emit(outputEvent{Event: "completed", Time: start.Add(3 * time.Millisecond), Size: 100})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %s", len(lines), buf.String())
+	}
+	var last outputEvent
+	json.Unmarshal([]byte(lines[1]), &last)
+	if last.Transferred != 100 {
+		t.Errorf("Final progress event should be kept, got %+v", last)
+	}
+
+	var nilWriter *eventWriter
+	nilWriter.emit(outputEvent{Event: "ready"})
+}
+
+// Test JSON events for a download
+func TestDownloadEvents(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_events_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := []byte("hello events")
+	target := filepath.Join(tempDir, "hello.txt")
+	os.WriteFile(target, content, 0644)
+
+	var buf bytes.Buffer
+	fs := NewFileServer("send", target, 8080, false)
+	fs.events = newEventWriter(&buf, defaultProgressInterval)
+
+	w := httptest.NewRecorder()
+	fs.handleDownload(w, httptest.NewRequest(http.MethodGet, "/api/download", nil))
+
+	var names []string
+	var completed outputEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev outputEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("Invalid JSON event %q: %v", line, err)
+		}
+		names = append(names, ev.Event)
+		if ev.Event == "completed" {
+			completed = ev
+		}
+	}
+	expected := "client_connected progress completed client_disconnected"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("Events = %v, expected %s", names, expected)
+	}
+	sum := sha256.Sum256(content)
+	if completed.SHA256 != hex.EncodeToString(sum[:]) || completed.Name != "hello.txt" || completed.Size != int64(len(content)) {
+		t.Errorf("Unexpected completed event: %+v", completed)
+	}
+}
+
+// Test that plain output drops decorations
+func TestIcon(t *testing.T) {
+	defer func() { plainOutput = false }()
+
+	if icon("📤 ") != "📤 " {
+		t.Error("icon should keep the emoji by default")
+	}
+	plainOutput = true
+	if icon("📤 ") != "" {
+		t.Error("icon should be empty in plain mode")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// minParallelSize is the smallest download split into parallel range
+// requests; below it the extra round trips cost more than they save.
+const minParallelSize = 8 << 20
+
+// byteRange is an inclusive range of bytes, as in a Range header.
+type byteRange struct {
+	start, end int64
+}
+
+// splitRanges divides size bytes into at most n contiguous ranges.
+func splitRanges(size int64, n int) []byteRange {
+	if n < 1 {
+		n = 1
+	}
+	if int64(n) > size {
+		n = int(size)
+	}
+	if n == 0 {
+		return nil
+	}
+	chunk := (size + int64(n) - 1) / int64(n)
+	var ranges []byteRange
+	for start := int64(0); start < size; start += chunk {
+		ranges = append(ranges, byteRange{start, min(start+chunk, size) - 1})
+	}
+	return ranges
+}
+
+// fetchRange downloads one range of url into dst at the same offset.
+func fetchRange(url string, r byteRange, dst io.WriterAt) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.start, r.end))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("bytes %d-%d: %s: %s", r.start, r.end, resp.Status, msg)
+	}
+	n, err := io.Copy(io.NewOffsetWriter(dst, r.start), resp.Body)
+	if err == nil && n != r.end-r.start+1 {
+		err = fmt.Errorf("bytes %d-%d: %w", r.start, r.end, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// fetchParallel downloads size bytes of url into dst over n concurrent
+// range requests, counting them on bar.
+func fetchParallel(url string, size int64, n int, dst *os.File, bar *progressBar) error {
+	if err := dst.Truncate(size); err != nil {
+		return err
+	}
+	ranges := splitRanges(size, n)
+	return fetchRanges(url, ranges, len(ranges), progressWriterAt{dst, bar})
+}
+
+// fetchRanges downloads ranges of url into dst, at most n at a time.
+func fetchRanges(url string, ranges []byteRange, n int, dst io.WriterAt) error {
+	jobs := make(chan byteRange)
+	errs := make(chan error, len(ranges))
+	for range max(min(n, len(ranges)), 1) {
+		go func() {
+			for r := range jobs {
+				errs <- fetchRange(url, r, dst)
+			}
+		}()
+	}
+	for _, r := range ranges {
+		jobs <- r
+	}
+	close(jobs)
+	var first error
+	for range ranges {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// fetchShareParallel saves the download described by head into dir using
+// n connections.
+func fetchShareParallel(url, dir string, head *http.Response, n int, bar *progressBar) (string, int64, error) {
+	name, err := responseFilename(head.Header)
+	if err != nil {
+		return "", 0, err
+	}
+	dst, savePath, err := createUploadFile(dir, name, conflictRename, time.Now())
+	if err != nil {
+		return "", 0, err
+	}
+	bar.begin(0, head.ContentLength)
+	err = fetchParallel(url, head.ContentLength, n, dst, bar)
+	bar.end()
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(savePath)
+		return "", 0, err
+	}
+	return savePath, head.ContentLength, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test splitting a download into ranges
+func TestSplitRanges(t *testing.T) {
+	tests := []struct {
+		size     int64
+		n        int
+		expected []byteRange
+	}{
+		{100, 4, []byteRange{{0, 24}, {25, 49}, {50, 74}, {75, 99}}},
+		{10, 3, []byteRange{{0, 3}, {4, 7}, {8, 9}}},
+		{2, 4, []byteRange{{0, 0}, {1, 1}}},
+		{50, 1, []byteRange{{0, 49}}},
+		{50, 0, []byteRange{{0, 49}}},
+		{0, 4, nil},
+	}
+	for _, test := range tests {
+		result := splitRanges(test.size, test.n)
+		if len(result) != len(test.expected) {
+			t.Errorf("splitRanges(%d, %d) = %v, expected %v", test.size, test.n, result, test.expected)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.expected[i] {
+				t.Errorf("splitRanges(%d, %d) = %v, expected %v", test.size, test.n, result, test.expected)
+				break
+			}
+		}
+	}
+}
+
+// Test reassembling a download fetched over parallel ranges
+func TestFetchParallel(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_parallel_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := make([]byte, 300*1024+7)
+	rand.New(rand.NewSource(1)).Read(content)
+	os.WriteFile(filepath.Join(tempDir, "data.bin"), content, 0644)
+
+	fs := NewFileServer("send", filepath.Join(tempDir, "data.bin"), 8080, false)
+	server := httptest.NewServer(http.HandlerFunc(fs.handleDownload))
+	defer server.Close()
+
+	for _, n := range []int{1, 3, 8} {
+		dst, err := os.Create(filepath.Join(tempDir, "out.bin"))
+		if err != nil {
+			t.Fatalf("Failed to create output: %v", err)
+		}
+		err = fetchParallel(server.URL, int64(len(content)), n, dst, nil)
+		dst.Close()
+		if err != nil {
+			t.Errorf("fetchParallel with %d connections error: %v", n, err)
+			continue
+		}
+		data, _ := os.ReadFile(filepath.Join(tempDir, "out.bin"))
+		if !bytes.Equal(data, content) {
+			t.Errorf("fetchParallel with %d connections: file differs from the share", n)
+		}
+	}
+
+	// A server that ignores Range must not produce a corrupt file.
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer plain.Close()
+	dst, _ := os.Create(filepath.Join(tempDir, "plain.bin"))
+	defer dst.Close()
+	if err := fetchParallel(plain.URL, int64(len(content)), 4, dst, nil); err == nil {
+		t.Error("fetchParallel should fail when ranges are not honored")
+	}
+}
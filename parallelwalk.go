@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// walkWorkers is how many directories walkParallel reads at once. On
+// NFS and SMB mounts a directory read is mostly a round trip, so this is
+// well above the CPU count.
+const walkWorkers = 16
+
+// walkParallel calls fn for root and everything below it like
+// filepath.Walk, but with walkWorkers goroutines taking directories off a
+// shared stack as they go idle, so one deep subtree doesn't leave the
+// others waiting. fn is called from several goroutines at once and in no
+// particular order. Returning filepath.SkipDir for a directory skips its
+// contents; any other error stops the walk.
+func walkParallel(root string, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if err := fn(root, info, nil); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	var (
+		mu      sync.Mutex
+		wake    = sync.NewCond(&mu)
+		stack   = []string{root}
+		pending = 1 // directories on the stack or being read
+		failed  error
+		wg      sync.WaitGroup
+	)
+	for range walkWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			for {
+				for len(stack) == 0 && pending > 0 && failed == nil {
+					wake.Wait()
+				}
+				if pending == 0 || failed != nil {
+					return
+				}
+				// Last in, first out keeps the stack as short as the
+				// tree is deep rather than as wide.
+				dir := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				mu.Unlock()
+				subdirs, err := walkDir(dir, fn)
+				mu.Lock()
+				if err != nil && failed == nil {
+					failed = err
+				}
+				stack = append(stack, subdirs...)
+				pending += len(subdirs) - 1
+				wake.Broadcast()
+			}
+		}()
+	}
+	wg.Wait()
+	return failed
+}
+
+// walkDir calls fn for the entries of dir and returns the subdirectories
+// to descend into.
+func walkDir(dir string, fn filepath.WalkFunc) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		info, _ := os.Lstat(dir)
+		if err := fn(dir, info, err); err != nil && err != filepath.SkipDir {
+			return nil, err
+		}
+		return nil, nil
+	}
+	var subdirs []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			if err := fn(path, nil, err); err != nil && err != filepath.SkipDir {
+				return nil, err
+			}
+			continue
+		}
+		err = fn(path, info, nil)
+		if err == filepath.SkipDir {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			subdirs = append(subdirs, path)
+		}
+	}
+	return subdirs, nil
+}
+
+// walkOrder compares slash-separated entry names in the order
+// filepath.Walk visits them: element by element, so "a/b" comes before
+// "a.txt" even though '.' sorts before '/'.
+func walkOrder(a, b string) int {
+	for a != "" && b != "" {
+		aElem, aRest, _ := strings.Cut(a, "/")
+		bElem, bRest, _ := strings.Cut(b, "/")
+		if c := strings.Compare(aElem, bElem); c != 0 {
+			return c
+		}
+		a, b = aRest, bRest
+	}
+	return cmp.Compare(len(a), len(b))
+}
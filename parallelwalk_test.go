@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Test that the parallel walk visits what filepath.Walk does
+func TestWalkParallel(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 20 {
+		for j := range 5 {
+			sub := filepath.Join(dir, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j))
+			os.MkdirAll(sub, 0755)
+			os.WriteFile(filepath.Join(sub, "f.txt"), []byte("data"), 0644)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "top.txt"), []byte("top"), 0644)
+
+	tests := []struct {
+		name string
+		skip string // directory name to skip, if any
+	}{
+		{"whole tree", ""},
+		{"skipping e3", "e3"},
+	}
+	for _, test := range tests {
+		visit := func(seen *[]string, mu *sync.Mutex) filepath.WalkFunc {
+			return func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				*seen = append(*seen, p)
+				mu.Unlock()
+				if info.IsDir() && info.Name() == test.skip {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		var mu sync.Mutex
+		var expected, got []string
+		filepath.Walk(dir, visit(&expected, &mu))
+		if err := walkParallel(dir, visit(&got, &mu)); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		slices.Sort(got)
+		slices.Sort(expected)
+		if !slices.Equal(got, expected) {
+			t.Errorf("%s: visited %d paths, expected %d", test.name, len(got), len(expected))
+		}
+	}
+
+	size, err := calculateDirSize(dir)
+	if err != nil || size != 20*5*4+3 {
+		t.Errorf("calculateDirSize = %d, %v, expected %d", size, err, 20*5*4+3)
+	}
+	if _, err := calculateDirSize(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("calculateDirSize of a missing directory expected an error")
+	}
+}
+
+// Test that listings keep the archive order
+func TestListFilesOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/b/c.txt", "a.txt", "a-b/x.txt", "a/z.txt", "b/a.txt", "a/b.txt"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	sources := []archiveSource{{path: filepath.Join(dir, "b"), name: "b"}, {path: filepath.Join(dir, "a"), name: "a"}, {path: dir}}
+	var expected []string
+	walkSources(sources, func(_, name string, fi os.FileInfo) error {
+		if !fi.IsDir() {
+			expected = append(expected, name)
+		}
+		return nil
+	})
+	listing, err := listFiles(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range listing.Files {
+		got = append(got, f.Name)
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("listFiles order %v, expected %v", got, expected)
+	}
+
+	many := t.TempDir()
+	for i := range maxListEntries + 10 {
+		os.WriteFile(filepath.Join(many, fmt.Sprintf("f%05d", i)), nil, 0644)
+	}
+	listing, err = listFiles([]archiveSource{{path: many}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Files) != maxListEntries || !listing.Truncated || listing.Files[maxListEntries-1].Name != fmt.Sprintf("f%05d", maxListEntries-1) {
+		t.Errorf("Truncated listing has %d files (truncated %v), expected the first %d", len(listing.Files), listing.Truncated, maxListEntries)
+	}
+}
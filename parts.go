@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadIdleTimeout drops a multi-part upload, and frees the client lock,
+// when no part has arrived for this long.
+const uploadIdleTimeout = 10 * time.Minute
+
+// uploadSession is a file being uploaded as parts that may arrive
+// concurrently and in any order. Parts are written into a hidden temporary
+// file next to the final name, which is reserved at init so conflicts are
+// reported before any data is sent.
+type uploadSession struct {
+	id       string
+	clientIP string
+	path     string
+	tmpPath  string
+	size     int64
+	file     *os.File
+	timer    *time.Timer
+
+	mu       sync.Mutex
+	parts    map[int64]int64 // offset -> length
+	received int64
+}
+
+// covered reports whether the parts received so far cover the whole file.
+func (s *uploadSession) covered() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offsets := make([]int64, 0, len(s.parts))
+	for off := range s.parts {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	var end int64
+	for _, off := range offsets {
+		if off > end {
+			return false
+		}
+		end = max(end, off+s.parts[off])
+	}
+	return end >= s.size
+}
+
+// addPart records a part and returns the bytes received so far. A part
+// sent again at the same offset (a retry) replaces the earlier one.
+func (s *uploadSession) addPart(offset, n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received += n - s.parts[offset]
+	s.parts[offset] = n
+	return s.received
+}
+
+func (fs *FileServer) getUploadSession(w http.ResponseWriter, r *http.Request) *uploadSession {
+	fs.uploadsMu.Lock()
+	session := fs.uploads[r.URL.Query().Get("id")]
+	fs.uploadsMu.Unlock()
+	if session == nil || session.clientIP != fs.getClientIP(r) {
+		httpError(w, r, "Unknown upload", http.StatusNotFound)
+		return nil
+	}
+	return session
+}
+
+// endUploadSession removes session and releases its client. When keep is
+// false the partial upload and its reserved name are deleted.
+func (fs *FileServer) endUploadSession(session *uploadSession, keep bool) bool {
+	fs.uploadsMu.Lock()
+	_, ok := fs.uploads[session.id]
+	delete(fs.uploads, session.id)
+	fs.uploadsMu.Unlock()
+	if !ok {
+		return false
+	}
+	session.timer.Stop()
+	session.file.Close()
+	if !keep {
+		os.Remove(session.tmpPath)
+		os.Remove(session.path)
+	}
+	fs.releaseClient(session.clientIP)
+	return true
+}
+
+// handleUploadInit starts a multi-part upload. Form fields: name, size and
+// optionally dir, as for /api/upload. With -cas a sha256 field lets the
+// server finish at once when it already has that content.
+func (fs *FileServer) handleUploadInit(w http.ResponseWriter, r *http.Request) {
+	if !fs.receivesUploads() {
+		httpError(w, r, "Server is not in receive mode", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if fs.atRest != nil {
+		// Parts arrive out of order, so they can't be encrypted as a stream.
+		httpError(w, r, "Multi-part uploads are not available with -encrypt-at-rest", http.StatusNotImplemented)
+		return
+	}
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size < 0 {
+		httpError(w, r, "Invalid size", http.StatusBadRequest)
+		return
+	}
+
+	sum := strings.ToLower(r.FormValue("sha256"))
+	if sum != "" && !validSHA256(sum) {
+		httpError(w, r, "Invalid sha256", http.StatusBadRequest)
+		return
+	}
+	known := fs.cas && sum != "" && fs.casHas(sum, size)
+
+	if !known {
+		if err := fs.checkQuota(size); err != nil {
+			fs.rejectQuota(w, r, err)
+			return
+		}
+	}
+
+	clientIP := fs.getClientIP(r)
+	if !fs.acquireClient(clientIP) {
+		fs.rejectBusy(w, r)
+		return
+	}
+	held := false
+	defer func() {
+		if !held {
+			fs.releaseClient(clientIP)
+		}
+	}()
+
+	dir, filename, ok := fs.uploadTarget(w, r, clientIP, r.FormValue("name"), r.FormValue("dir"))
+	if !ok {
+		return
+	}
+	reserved, savePath, err := createUploadFile(dir, filename, fs.onConflict, fs.clock.Now())
+	if os.IsExist(err) {
+		writeUploadConflict(w, r, filename, savePath)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Failed to create file", http.StatusInternalServerError)
+		return
+	}
+	reserved.Close()
+	if known {
+		fs.finishKnownUpload(w, r, clientIP, savePath, sum, size)
+		return
+	}
+
+	id := newRequestID()
+	tmpPath := filepath.Join(dir, "."+filepath.Base(savePath)+"."+id+".part")
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err != nil {
+		os.Remove(savePath)
+		if file != nil {
+			file.Close()
+			os.Remove(tmpPath)
+		}
+		httpError(w, r, "Failed to create file", http.StatusInternalServerError)
+		return
+	}
+
+	session := &uploadSession{id: id, clientIP: clientIP, path: savePath, tmpPath: tmpPath,
+		size: size, file: file, parts: make(map[int64]int64)}
+	session.timer = time.AfterFunc(uploadIdleTimeout, func() {
+		if fs.endUploadSession(session, false) {
+			fs.addLog(fmt.Sprintf("Upload of '%s' abandoned by %s", filepath.Base(savePath), fs.clientLabel(clientIP)))
+		}
+	})
+	fs.uploadsMu.Lock()
+	fs.uploads[id] = session
+	fs.uploadsMu.Unlock()
+	held = true
+
+	fs.events.emit(outputEvent{Event: "client_connected", Client: clientIP, ClientHost: fs.clientHost(clientIP)})
+	fs.startUpload(clientIP, size)
+	fs.logRequest(r, fmt.Sprintf("Started multi-part upload from %s: %s", fs.clientLabel(clientIP), filepath.Base(savePath)))
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"%s","name":"%s","size":%d}`, id, filepath.Base(savePath), size)
+}
+
+// handleUploadPart stores the request body at ?offset= of upload ?id=.
+// Both come from the query string so the body is never parsed as a form.
+func (fs *FileServer) handleUploadPart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := fs.getUploadSession(w, r)
+	if session == nil {
+		return
+	}
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 || offset > session.size || offset+r.ContentLength > session.size {
+		httpError(w, r, "Part is outside the file", http.StatusBadRequest)
+		return
+	}
+	session.timer.Reset(uploadIdleTimeout)
+	defer fs.trackClient(r, activityUploading)()
+
+	n, err := io.CopyBuffer(io.NewOffsetWriter(session.file, offset), io.LimitReader(r.Body, session.size-offset), fs.copyBuffer())
+	if err == nil {
+		// Anything left over means the part runs past the end of the file.
+		var extra [1]byte
+		if m, _ := r.Body.Read(extra[:]); m > 0 {
+			httpError(w, r, "Part is outside the file", http.StatusBadRequest)
+			return
+		}
+	}
+	if err != nil {
+		httpError(w, r, "Failed to write part", http.StatusInternalServerError)
+		return
+	}
+	received := session.addPart(offset, n)
+	fs.updateProgress(received)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"received":%d}`, received)
+}
+
+// handleUploadComplete checks that every byte has arrived, then moves the
+// assembled file into place.
+func (fs *FileServer) handleUploadComplete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := fs.getUploadSession(w, r)
+	if session == nil {
+		return
+	}
+	if !session.covered() {
+		httpError(w, r, "Upload is missing parts", http.StatusConflict)
+		return
+	}
+
+	hasher := sha256.New()
+	if _, err := io.CopyBuffer(hasher, io.NewSectionReader(session.file, 0, session.size), fs.copyBuffer()); err != nil {
+		httpError(w, r, "Failed to read upload", http.StatusInternalServerError)
+		return
+	}
+	if !fs.endUploadSession(session, true) {
+		httpError(w, r, "Unknown upload", http.StatusNotFound)
+		return
+	}
+	if err := os.Rename(session.tmpPath, session.path); err != nil {
+		os.Remove(session.tmpPath)
+		os.Remove(session.path)
+		fs.failTransfer(err)
+		httpError(w, r, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+
+	clientIP := session.clientIP
+	clientLabel := fs.clientLabel(clientIP)
+	savedName := filepath.Base(session.path)
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	fs.completeTransfer(sum)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("Upload completed from %s: %s (%s)%s", clientLabel, savedName, formatSize(session.size), hashSuffix(sum)))
+
+	fs.report(outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP),
+		Name: savedName, Path: session.path, Size: session.size, SHA256: sum},
+		fmt.Sprintf("\n%sReceived '%s' from %s (%s)\n%s", icon("✓ "), savedName, clientLabel, formatSize(session.size), hashLine(sum)))
+	fs.addReceived(session.size)
+	fs.afterReceive(session.path, sum)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"success","path":"%s","name":"%s","size":%d,"sha256":"%s"}`, session.path, savedName, session.size, sum)
+}
+
+// handleUploadAbort discards a multi-part upload.
+func (fs *FileServer) handleUploadAbort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := fs.getUploadSession(w, r)
+	if session == nil {
+		return
+	}
+	if fs.endUploadSession(session, false) {
+		fs.cancel(session.clientIP)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"cancelled"}`)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func partsRequest(method, target string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.RemoteAddr = "192.168.1.20:5555"
+	return req
+}
+
+func initUpload(t *testing.T, fs *FileServer, name string, size int) (string, int) {
+	form := url.Values{"name": {name}, "size": {fmt.Sprint(size)}}
+	req := partsRequest("POST", "/api/upload/init", []byte(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	fs.handleUploadInit(rec, req)
+	var result struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	return result.ID, rec.Code
+}
+
+func sendPart(fs *FileServer, id string, offset int, data []byte) int {
+	rec := httptest.NewRecorder()
+	fs.handleUploadPart(rec, partsRequest("PUT", fmt.Sprintf("/api/upload/part?id=%s&offset=%d", id, offset), data))
+	return rec.Code
+}
+
+// Test uploading a file as concurrent parts
+func TestMultipartUpload(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_parts_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	content := []byte(strings.Repeat("0123456789", 1000))
+	id, code := initUpload(t, fs, "big.bin", len(content))
+	if code != http.StatusOK || id == "" {
+		t.Fatalf("init = %d, expected 200 with an id", code)
+	}
+
+	if _, code := initUpload(t, fs, "big.bin", 10); code != http.StatusConflict {
+		t.Errorf("init for a reserved name = %d, expected 409", code)
+	}
+	other := partsRequest("PUT", "/api/upload/part?id="+id+"&offset=0", []byte("x"))
+	other.RemoteAddr = "10.0.0.9:4444"
+	rec := httptest.NewRecorder()
+	fs.handleUploadPart(rec, other)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("part from another client = %d, expected 404", rec.Code)
+	}
+	if code := sendPart(fs, id, len(content)-2, []byte("xyz")); code != http.StatusBadRequest {
+		t.Errorf("part past the end = %d, expected 400", code)
+	}
+
+	// Send the last part first to check completion waits for all of them.
+	if code := sendPart(fs, id, 6000, content[6000:]); code != http.StatusOK {
+		t.Fatalf("part at 6000 = %d, expected 200", code)
+	}
+	rec = httptest.NewRecorder()
+	fs.handleUploadComplete(rec, partsRequest("POST", "/api/upload/complete?id="+id, nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("complete with missing parts = %d, expected 409", rec.Code)
+	}
+
+	var wg sync.WaitGroup
+	for _, off := range []int{0, 3000} {
+		wg.Add(1)
+		go func(off int) {
+			defer wg.Done()
+			if code := sendPart(fs, id, off, content[off:off+3000]); code != http.StatusOK {
+				t.Errorf("part at %d = %d, expected 200", off, code)
+			}
+		}(off)
+	}
+	wg.Wait()
+
+	rec = httptest.NewRecorder()
+	fs.handleUploadComplete(rec, partsRequest("POST", "/api/upload/complete?id="+id, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("complete = %d, expected 200: %s", rec.Code, rec.Body.String())
+	}
+	sum := sha256.Sum256(content)
+	if !strings.Contains(rec.Body.String(), hex.EncodeToString(sum[:])) {
+		t.Errorf("complete response should carry the SHA-256, got %s", rec.Body.String())
+	}
+	data, _ := os.ReadFile(filepath.Join(tempDir, "big.bin"))
+	if !bytes.Equal(data, content) {
+		t.Errorf("Assembled file differs from the upload (%d bytes)", len(data))
+	}
+	entries, _ := os.ReadDir(tempDir)
+	if len(entries) != 1 {
+		t.Errorf("Only the assembled file should remain, got %d entries", len(entries))
+	}
+	if fs.activeClient != "" {
+		t.Errorf("complete should release the client, still held by %s", fs.activeClient)
+	}
+}
+
+// Test aborting a multi-part upload
+func TestMultipartUploadAbort(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_parts_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	id, _ := initUpload(t, fs, "gone.bin", 100)
+	sendPart(fs, id, 0, make([]byte, 50))
+
+	busy := partsRequest("POST", "/api/upload/init?name=other.bin&size=10", nil)
+	busy.RemoteAddr = "10.0.0.9:4444"
+	rec := httptest.NewRecorder()
+	fs.handleUploadInit(rec, busy)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("init from another client during an upload = %d, expected 503", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	fs.handleUploadAbort(rec, partsRequest("POST", "/api/upload/abort?id="+id, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("abort = %d, expected 200", rec.Code)
+	}
+	entries, _ := os.ReadDir(tempDir)
+	if len(entries) != 0 {
+		t.Errorf("abort should remove the partial upload, %d entries left", len(entries))
+	}
+	if code := sendPart(fs, id, 50, make([]byte, 50)); code != http.StatusNotFound {
+		t.Errorf("part after abort = %d, expected 404", code)
+	}
+}
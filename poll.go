@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// pollTimeout is how long /api/poll waits for something to report,
+	// well under the idle timeouts of common proxies.
+	pollTimeout = 25 * time.Second
+	// pollLogSize is how many named events are kept for pages between
+	// polls. A page further behind is sent a "dropped" event.
+	pollLogSize = 64
+)
+
+// polledEvent is one event stream frame as /api/poll returns it. Data is
+// the frame's data, still encoded, so pages handle both alike.
+type polledEvent struct {
+	ID    uint64 `json:"id"`
+	Event string `json:"event,omitempty"`
+	Data  string `json:"data"`
+}
+
+// pollLog numbers what goes out on the event streams, for pages behind
+// proxies that block or buffer them. Only the latest status is kept, as
+// on the streams a newer one replaces those still queued.
+type pollLog struct {
+	mu      sync.Mutex
+	last    uint64
+	status  polledEvent
+	events  []polledEvent
+	evicted uint64 // id of the newest event pushed out of events
+	changed chan struct{}
+}
+
+func (l *pollLog) add(event, data string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last++
+	ev := polledEvent{ID: l.last, Event: event, Data: data}
+	if event == "" {
+		l.status = ev
+	} else {
+		if len(l.events) == pollLogSize {
+			l.evicted = l.events[0].ID
+			l.events = slices.Delete(l.events, 0, 1)
+		}
+		l.events = append(l.events, ev)
+	}
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+// since returns the events after id and the id to poll from next. With
+// nothing to report yet it returns a channel closed on the next event.
+func (l *pollLog) since(id uint64) ([]polledEvent, uint64, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []polledEvent
+	for _, ev := range l.events {
+		if ev.ID > id {
+			events = append(events, ev)
+		}
+	}
+	if l.status.ID > id {
+		i, _ := slices.BinarySearchFunc(events, l.status.ID, func(ev polledEvent, id uint64) int { return cmp.Compare(ev.ID, id) })
+		events = slices.Insert(events, i, l.status)
+	}
+	if id < l.evicted {
+		// Counts ids, so superseded statuses too: at most this many.
+		events = slices.Insert(events, 0, polledEvent{ID: l.last, Event: "dropped", Data: fmt.Sprintf(`{"dropped":%d}`, l.evicted-id)})
+	}
+	if len(events) > 0 {
+		return events, l.last, nil
+	}
+	if l.changed == nil {
+		l.changed = make(chan struct{})
+	}
+	return nil, l.last, l.changed
+}
+
+// handlePoll is the long-polling fallback for /api/events: it answers as
+// soon as anything newer than ?since= happens, or after pollTimeout with
+// no events. A first poll (no since, or an id from before a restart) gets
+// the current status at once. Pollers don't show in the clients list,
+// since each poll is a separate request.
+func (fs *FileServer) handlePoll(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	s := r.URL.Query().Get("since")
+	if s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			httpError(w, r, "Invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, last, changed := fs.polls.since(since)
+	if s == "" || since > last {
+		events = []polledEvent{{ID: last, Data: fs.statusJSON()}}
+	}
+	timeout := fs.clock.After(pollTimeout)
+wait:
+	for len(events) == 0 {
+		select {
+		case <-changed:
+			events, last, changed = fs.polls.since(since)
+		case <-timeout:
+			break wait
+		case <-fs.done:
+			break wait
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if events == nil {
+		events = []polledEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(struct {
+		ID     uint64        `json:"id"`
+		Events []polledEvent `json:"events"`
+	}{last, events})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test what a poll since a given id returns
+func TestPollLog(t *testing.T) {
+	var log pollLog
+	log.add("", `{"status":"waiting"}`)      // 1
+	log.add("clients", `[]`)                 // 2
+	log.add("", `{"status":"transferring"}`) // 3
+	log.add("files", `{"changes":1}`)        // 4
+	log.add("", `{"status":"completed"}`)    // 5
+	tests := []struct {
+		since    uint64
+		expected []uint64 // ids returned, in order
+		wait     bool
+	}{
+		{0, []uint64{2, 4, 5}, false},
+		{2, []uint64{4, 5}, false},
+		{4, []uint64{5}, false},
+		{5, nil, true},
+	}
+	for _, test := range tests {
+		events, last, changed := log.since(test.since)
+		var ids []uint64
+		for _, ev := range events {
+			ids = append(ids, ev.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expected) || last != 5 || (changed != nil) != test.wait {
+			t.Errorf("since(%d) = %v, %d, waiting %v; expected %v, 5, waiting %v", test.since, ids, last, changed != nil, test.expected, test.wait)
+		}
+	}
+
+	for i := range pollLogSize {
+		log.add("files", fmt.Sprintf(`{"changes":%d}`, i))
+	}
+	events, _, _ := log.since(2)
+	if len(events) != 2+pollLogSize || events[0].Event != "dropped" || events[0].Data != `{"dropped":2}` || events[1].ID != 5 {
+		t.Errorf("Poll from before the log expected a dropped event, the status and the %d kept, got %d starting with %+v", pollLogSize, len(events), events[:2])
+	}
+}
+
+// Test /api/poll answers at once, on the next event and on timeout
+func TestHandlePoll(t *testing.T) {
+	fs := NewFileServer("send", "/tmp/test.txt", 8080, false)
+	type response struct {
+		ID     uint64        `json:"id"`
+		Events []polledEvent `json:"events"`
+	}
+	poll := func(query string) response {
+		rec := httptest.NewRecorder()
+		fs.handlePoll(rec, httptest.NewRequest("GET", "/api/poll"+query, nil))
+		var body response
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid /api/poll JSON %q: %v", rec.Body.String(), err)
+		}
+		return body
+	}
+
+	first := poll("")
+	if len(first.Events) != 1 || first.Events[0].Event != "" {
+		t.Fatalf("First poll = %+v, expected the current status", first)
+	}
+
+	got := make(chan response)
+	go func() { got <- poll(fmt.Sprintf("?since=%d", first.ID)) }()
+	time.Sleep(50 * time.Millisecond)
+	fs.broadcast("files", `{"changes":1}`)
+	select {
+	case next := <-got:
+		if len(next.Events) != 1 || next.Events[0].Event != "files" || next.ID != first.ID+1 {
+			t.Errorf("Poll after an event = %+v, expected the files event", next)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Poll didn't return on a new event")
+	}
+
+	fs.clock = fastClock{}
+	if idle := poll(fmt.Sprintf("?since=%d", first.ID+1)); len(idle.Events) != 0 || idle.ID != first.ID+1 {
+		t.Errorf("Poll on timeout = %+v, expected no events", idle)
+	}
+
+	rec := httptest.NewRecorder()
+	fs.handlePoll(rec, httptest.NewRequest("GET", "/api/poll?since=x", nil))
+	if rec.Code != 400 {
+		t.Errorf("Invalid since: status %d, expected 400", rec.Code)
+	}
+}
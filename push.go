@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pushAttempts = 4
+
+// pushBackoff is the wait before the first retry, doubling after; shortened
+// in tests.
+var pushBackoff = time.Second
+
+// pushFile is one file to upload, and the slash-separated folder on the
+// server it goes in.
+type pushFile struct {
+	path string
+	dir  string
+	size int64
+}
+
+// pushFiles lists what pushing paths into remoteDir uploads: files as
+// they are, and directories with their structure under their own name.
+func pushFiles(paths []string, remoteDir string) ([]pushFile, error) {
+	var files []pushFile
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, pushFile{p, remoteDir, info.Size()})
+			continue
+		}
+		root := filepath.Dir(filepath.Clean(p))
+		err = filepath.WalkDir(p, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, filepath.Dir(file))
+			if err != nil {
+				return err
+			}
+			files = append(files, pushFile{file, path.Join(remoteDir, filepath.ToSlash(rel)), info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// pushOne uploads f to the server at base, retrying transient failures
+// and waiting as long as a busy server asks, and returns the name it was
+// saved under. newBar gives each attempt's progress bar.
+func pushOne(client *http.Client, base, token string, f pushFile, newBar func() *progressBar) (string, error) {
+	var err error
+	for attempt := 1; attempt <= pushAttempts; attempt++ {
+		var name string
+		var retryAfter time.Duration
+		name, retryAfter, err = pushAttempt(client, base, token, f, newBar())
+		if err == nil {
+			return name, nil
+		}
+		if !retryable(err) || attempt == pushAttempts {
+			break
+		}
+		wait := max(time.Duration(1<<(attempt-1))*pushBackoff, retryAfter)
+		fmt.Fprintf(os.Stderr, "%s: %v, retrying in %s (attempt %d/%d)\n", f.path, err, wait, attempt+1, pushAttempts)
+		time.Sleep(wait)
+	}
+	return "", err
+}
+
+func pushAttempt(client *http.Client, base, token string, f pushFile, bar *progressBar) (string, time.Duration, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	// The form around the file is built up front so the request can say
+	// how long it is.
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	if f.dir != "" && f.dir != "." {
+		mw.WriteField("dir", f.dir)
+	}
+	mw.CreateFormFile("file", filepath.Base(f.path))
+	head := bytes.Clone(form.Bytes())
+	form.Reset()
+	mw.Close()
+	body := io.MultiReader(bytes.NewReader(head), &progressReader{io.LimitReader(file, f.size), bar.add}, &form)
+
+	req, err := http.NewRequest(http.MethodPost, base+"api/upload", body)
+	if err != nil {
+		return "", 0, err
+	}
+	req.ContentLength = int64(len(head)) + f.size + int64(form.Len())
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("User-Agent", "fileshare/"+version)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	bar.begin(0, f.size)
+	resp, err := client.Do(req)
+	bar.end()
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return "", time.Duration(retryAfter) * time.Second, &mirrorStatusError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	var saved struct {
+		Name string `json:"name"`
+	}
+	json.NewDecoder(resp.Body).Decode(&saved)
+	return saved.Name, 0, nil
+}
+
+func runPush(args []string) int {
+	flags := flag.NewFlagSet("push", flag.ExitOnError)
+	remoteDir := flags.String("dir", "", "Folder on the server to upload into")
+	token := flags.String("token", "", "Password or access token of the server; '-' reads it from FILESHARE_TOKEN")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s push [-dir folder] [-token secret] <file-or-dir>... <http://host:port>\n\nUploads files to a server in recv mode; directories keep their structure.\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 1
+	}
+	target := flags.Arg(flags.NArg() - 1)
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		fmt.Fprintf(os.Stderr, "Error: the last argument must be the server's http(s) address, got '%s'\n", target)
+		return 1
+	}
+	if *token == "-" {
+		*token = os.Getenv("FILESHARE_TOKEN")
+	}
+	files, err := pushFiles(flags.Args()[:flags.NArg()-1], strings.Trim(*remoteDir, "/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	base := shareBase(target)
+	newBar := func() *progressBar { return nil }
+	if isTerminal(os.Stderr) {
+		newBar = func() *progressBar { return newProgressBar(os.Stderr) }
+	}
+	client := &http.Client{}
+	failed := 0
+	var sent int64
+	for _, f := range files {
+		fmt.Fprintf(os.Stderr, "%s (%s)\n", f.path, formatSize(f.size))
+		name, err := pushOne(client, base, *token, f, newBar)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s: %v\n", icon("✗ "), f.path, err)
+			failed++
+			continue
+		}
+		sent += f.size
+		fmt.Printf("%sUploaded %s as %s\n", icon("✓ "), f.path, path.Join(f.dir, name))
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files failed\n", failed, len(files))
+		return 1
+	}
+	fmt.Printf("%sPushed %d files (%s)\n", icon("✓ "), len(files), formatSize(sent))
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test pushing files and directories keeps their structure on the server
+func TestPushFiles(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "trip", "day1"), 0755)
+	os.WriteFile(filepath.Join(src, "trip", "day1", "beach.jpg"), []byte("beach"), 0644)
+	os.WriteFile(filepath.Join(src, "trip", "notes.txt"), []byte("notes"), 0644)
+	os.WriteFile(filepath.Join(src, "report.pdf"), []byte("report"), 0644)
+
+	dst := t.TempDir()
+	recv := NewFileServer("recv", dst, 8080, false)
+	server := httptest.NewServer(http.HandlerFunc(recv.handleUpload))
+	defer server.Close()
+
+	files, err := pushFiles([]string{filepath.Join(src, "trip"), filepath.Join(src, "report.pdf")}, "backup")
+	if err != nil {
+		t.Fatalf("pushFiles error: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("pushFiles found %d files, expected 3", len(files))
+	}
+	noBar := func() *progressBar { return nil }
+	for _, f := range files {
+		if _, err := pushOne(server.Client(), server.URL+"/", "", f, noBar); err != nil {
+			t.Errorf("Push %s error: %v", f.path, err)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		content string
+	}{
+		{"backup/trip/day1/beach.jpg", "beach"},
+		{"backup/trip/notes.txt", "notes"},
+		{"backup/report.pdf", "report"},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join(dst, test.path))
+		if err != nil || string(data) != test.content {
+			t.Errorf("%s = %q (%v), expected %q", test.path, data, err, test.content)
+		}
+	}
+}
+
+// Test push retries transient failures but not rejected uploads
+func TestPushRetry(t *testing.T) {
+	defer func(backoff time.Duration) { pushBackoff = backoff }(pushBackoff)
+	pushBackoff = time.Millisecond
+
+	file := filepath.Join(t.TempDir(), "data.bin")
+	os.WriteFile(file, []byte("payload"), 0644)
+
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		attempts int
+		errText  string
+	}{
+		{"busy then accepted", 1, http.StatusServiceUnavailable, 2, ""},
+		{"server errors", pushAttempts, http.StatusInternalServerError, pushAttempts, "Internal Server Error"},
+		{"unauthorized", 1, http.StatusUnauthorized, 1, "Unauthorized"},
+	}
+	for _, test := range tests {
+		recv := NewFileServer("recv", t.TempDir(), 8080, false)
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts <= test.failures {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, http.StatusText(test.status), test.status)
+				return
+			}
+			recv.handleUpload(w, r)
+		}))
+		name, err := pushOne(server.Client(), server.URL+"/", "", pushFile{file, "", 7}, func() *progressBar { return nil })
+		server.Close()
+		if attempts != test.attempts {
+			t.Errorf("%s: %d attempts, expected %d", test.name, attempts, test.attempts)
+		}
+		if test.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errText) {
+				t.Errorf("%s: error = %v, expected %q", test.name, err, test.errText)
+			}
+			continue
+		}
+		if err != nil || name != "data.bin" {
+			t.Errorf("%s: saved as %q (%v), expected data.bin", test.name, name, err)
+		}
+	}
+}
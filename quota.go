@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	errQuotaReached  = errors.New("upload limit reached")
+	errQuotaExceeded = errors.New("upload is larger than what is left of the upload limit")
+)
+
+// parseSize reads sizes like 500M, 50GB or 1.5TiB (1024-based, as
+// formatSize prints them). A bare number is bytes.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	}
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+	shift := strings.Index("KMGT", unit) + 1
+	if unit == "" {
+		shift = 0
+	} else if shift == 0 || len(unit) > 1 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(value * float64(int64(1)<<(10*shift))), nil
+}
+
+// quotaLeft returns how many more bytes may be uploaded, or -1 without a
+// -max-total limit.
+func (fs *FileServer) quotaLeft() int64 {
+	if fs.maxTotal <= 0 {
+		return -1
+	}
+	fs.quotaMu.Lock()
+	defer fs.quotaMu.Unlock()
+	return max(fs.maxTotal-fs.receivedTotal, 0)
+}
+
+// checkQuota tells whether an upload of size bytes (-1 if unknown) may
+// start.
+func (fs *FileServer) checkQuota(size int64) error {
+	left := fs.quotaLeft()
+	switch {
+	case left < 0:
+		return nil
+	case left == 0:
+		return errQuotaReached
+	case size > left:
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// rejectQuota answers an upload refused by checkQuota.
+func (fs *FileServer) rejectQuota(w http.ResponseWriter, r *http.Request, err error) {
+	auditNote(r, err.Error())
+	if err == errQuotaReached {
+		httpError(w, r, "Upload limit reached", http.StatusInsufficientStorage)
+		return
+	}
+	httpError(w, r, fmt.Sprintf("Upload too large: only %s left", formatSize(fs.quotaLeft())), http.StatusRequestEntityTooLarge)
+}
+
+// addReceived counts a completed upload against -max-total and, once the
+// limit is reached, announces it and stops the server with -max-total-exit.
+func (fs *FileServer) addReceived(n int64) {
+	if fs.maxTotal <= 0 {
+		return
+	}
+	fs.quotaMu.Lock()
+	before := fs.receivedTotal
+	fs.receivedTotal += n
+	reached := before < fs.maxTotal && fs.receivedTotal >= fs.maxTotal
+	fs.quotaMu.Unlock()
+	if !reached {
+		return
+	}
+	fs.addLog(fmt.Sprintf("Upload limit of %s reached, further uploads are rejected", formatSize(fs.maxTotal)))
+	fs.report(outputEvent{Event: "limit_reached", Size: fs.maxTotal},
+		fmt.Sprintf("\n%sUpload limit of %s reached\n", icon("⛔ "), formatSize(fs.maxTotal)))
+	if fs.maxTotalExit {
+		go func() {
+			// Let the last response and status reach the client first.
+			<-fs.clock.After(500 * time.Millisecond)
+			fs.shutdown()
+		}()
+	}
+}
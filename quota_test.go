@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test size parsing
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		err      bool
+	}{
+		{"1024", 1024, false},
+		{"500K", 500 << 10, false},
+		{"50GB", 50 << 30, false},
+		{"1.5GiB", 3 << 29, false},
+		{"2t", 2 << 40, false},
+		{" 10 MB ", 10 << 20, false},
+		{"", 0, true},
+		{"GB", 0, true},
+		{"10PB", 0, true},
+		{"-5M", 0, true},
+		{"lots", 0, true},
+	}
+	for _, test := range tests {
+		result, err := parseSize(test.input)
+		if (err != nil) != test.err || result != test.expected {
+			t.Errorf("parseSize(%q) = %d, %v, expected %d (error %t)", test.input, result, err, test.expected, test.err)
+		}
+	}
+}
+
+// Test that uploads stop once -max-total is reached
+func TestUploadQuota(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_quota_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, lowMem := range []bool{false, true} {
+		fs := NewFileServer("recv", tempDir, 8080, false)
+		fs.maxTotal = 10
+		fs.lowMem = lowMem
+		tests := []struct {
+			name     string
+			content  string
+			expected int
+		}{
+			{"a.txt", "123456", http.StatusOK},
+			{"b.txt", "123456", http.StatusRequestEntityTooLarge},
+			{"c.txt", "1234", http.StatusOK},
+			{"d.txt", "1", http.StatusInsufficientStorage},
+		}
+		for _, test := range tests {
+			rec := httptest.NewRecorder()
+			fs.handleUpload(rec, newUploadRequest(t, test.name, test.content, nil))
+			if rec.Code != test.expected {
+				t.Errorf("Upload %s (low-mem %t) status = %d, expected %d", test.name, lowMem, rec.Code, test.expected)
+			}
+			_, err := os.Stat(filepath.Join(tempDir, test.name))
+			if saved := err == nil; saved != (test.expected == http.StatusOK) {
+				t.Errorf("Upload %s (low-mem %t) saved = %t, expected %t", test.name, lowMem, saved, !saved)
+			}
+			os.Remove(filepath.Join(tempDir, test.name))
+		}
+	}
+}
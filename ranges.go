@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// rangeSettle is how long a ranged download that reaches the end of the
+// file, but not from its start, waits for further requests before it
+// counts as a completed resume; a download manager's first range may
+// simply not have arrived yet.
+const rangeSettle = 2 * time.Second
+
+// rangedDownload collects the byte-range requests a download manager
+// (aria2, IDM, a browser resuming) splits one download into, so that
+// together they show up as a single transfer with overall progress.
+type rangedDownload struct {
+	clientIP string
+	size     int64
+	active   int
+	requests int
+	sent     int64
+	served   []byteRange
+}
+
+// covered returns where the single span formed by the ranges served so
+// far starts, if it runs to the end of the file, or -1. The span needn't
+// start at zero: a resumed download only asks for the rest.
+func (d *rangedDownload) covered() int64 {
+	if len(d.served) == 0 {
+		return -1
+	}
+	ranges := append([]byteRange(nil), d.served...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	end := ranges[0].end
+	for _, r := range ranges[1:] {
+		if r.start > end+1 {
+			return -1
+		}
+		end = max(end, r.end)
+	}
+	if end < d.size-1 {
+		return -1
+	}
+	return ranges[0].start
+}
+
+// rangeResponseWriter adds what is written to the ranged download.
+type rangeResponseWriter struct {
+	http.ResponseWriter
+	fs *FileServer
+}
+
+func (w *rangeResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.fs.rangeSent(int64(n))
+	return n, err
+}
+
+func (w *rangeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// beginRange adds a request to the ranged download, starting the transfer
+// if there isn't one yet, and reports whether it did.
+func (fs *FileServer) beginRange(clientIP string, size int64) bool {
+	fs.rangedMu.Lock()
+	start := fs.ranged == nil || fs.ranged.clientIP != clientIP || fs.ranged.size != size
+	if start {
+		fs.ranged = &rangedDownload{clientIP: clientIP, size: size}
+	}
+	fs.ranged.active++
+	fs.ranged.requests++
+	fs.rangedMu.Unlock()
+	if start {
+		fs.startTransfer(clientIP, size)
+	}
+	return start
+}
+
+func (fs *FileServer) rangeSent(n int64) {
+	fs.rangedMu.Lock()
+	d := fs.ranged
+	d.sent += n
+	sent := min(d.sent, d.size)
+	fs.rangedMu.Unlock()
+	fs.updateProgress(sent)
+}
+
+// endRange records a finished request and calls done once the last
+// request in flight completes the download.
+func (fs *FileServer) endRange(r byteRange, ok bool, done func(requests int)) {
+	fs.rangedMu.Lock()
+	d := fs.ranged
+	if ok {
+		d.served = append(d.served, r)
+	}
+	d.active--
+	start := d.covered()
+	if d.active > 0 || start < 0 {
+		fs.rangedMu.Unlock()
+		return
+	}
+	if start == 0 {
+		fs.ranged = nil
+		fs.rangedMu.Unlock()
+		done(d.requests)
+		return
+	}
+	fs.rangedMu.Unlock()
+	go func() {
+		<-fs.clock.After(rangeSettle)
+		fs.rangedMu.Lock()
+		settled := fs.ranged == d && d.active == 0
+		if settled {
+			fs.ranged = nil
+		}
+		fs.rangedMu.Unlock()
+		if settled {
+			done(d.requests)
+		}
+	}()
+}
+
+// serveRanges answers a Range request for a single shared file. Requests
+// that overlap in time, or follow each other until the file is covered,
+// count as one transfer.
+func (fs *FileServer) serveRanges(w http.ResponseWriter, r *http.Request, clientIP string, target string, info os.FileInfo) {
+	f, err := os.Open(target)
+	if err != nil {
+		httpError(w, r, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	clientLabel := fs.clientLabel(clientIP)
+	if fs.beginRange(clientIP, info.Size()) {
+		fs.logRequest(r, fmt.Sprintf("Started download from %s (byte ranges)", clientLabel))
+	}
+	http.ServeContent(&rangeResponseWriter{w, fs}, r, fs.downloadFilename(false), info.ModTime(), f)
+	rng, ok := parseRange(r.Header.Get("Range"), info.Size())
+	ok = ok && rng.start < info.Size() && r.Context().Err() == nil
+	fs.endRange(rng, ok, func(requests int) {
+		// Partial content; a hash of the ranges would be misleading.
+		fs.completeTransfer("")
+		fs.countDownload(fs.downloadFilename(false), clientIP)
+		fs.logRequest(r, fmt.Sprintf("Download completed for %s (%d range requests)", clientLabel, requests))
+		completed := outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP),
+			Name: fs.downloadFilename(false), Size: info.Size()}
+		fs.report(completed, fmt.Sprintf("\n%sTransfer completed to %s\n", icon("✓ "), clientLabel))
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that a download split into ranges counts as one transfer
+func TestRangedDownload(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_ranges_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	target := filepath.Join(tempDir, "data.bin")
+	os.WriteFile(target, content, 0644)
+	size := int64(len(content))
+
+	fs := NewFileServer("send", target, 8080, false)
+	server := httptest.NewServer(http.HandlerFunc(fs.handleDownload))
+	defer server.Close()
+
+	// Like aria2 or IDM: several connections at once, each with a range.
+	dst, err := os.Create(filepath.Join(tempDir, "out.bin"))
+	if err != nil {
+		t.Fatalf("Failed to create output: %v", err)
+	}
+	err = fetchParallel(server.URL, size, 6, dst, nil)
+	dst.Close()
+	data, _ := os.ReadFile(filepath.Join(tempDir, "out.bin"))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Parallel download failed (%v)", err)
+	}
+	if fs.status.Status != "completed" || fs.status.Transferred != size {
+		t.Errorf("After parallel ranges: status %q, %d bytes, expected completed, %d", fs.status.Status, fs.status.Transferred, size)
+	}
+	if fs.stats.started != 1 || fs.stats.completed != 1 {
+		t.Errorf("Parallel ranges counted as %d started, %d completed, expected 1 each", fs.stats.started, fs.stats.completed)
+	}
+	if fs.activeClient != "" {
+		t.Errorf("Client lock still held by %q after the download", fs.activeClient)
+	}
+
+	tests := []struct {
+		name     string
+		ranges   []string
+		expected string
+	}{
+		{"resume", []string{"bytes=1000-"}, "completed"},
+		{"middle only", []string{"bytes=1000-1999"}, "transferring"},
+		{"gap", []string{"bytes=0-999", "bytes=2000-"}, "transferring"},
+		{"sequential pieces", []string{"bytes=0-99999", fmt.Sprintf("bytes=100000-%d", size-1)}, "completed"},
+		{"suffix", []string{"bytes=-500"}, "completed"},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", target, 8080, false)
+		fs.clock = fastClock{}
+		status := func() string {
+			fs.statusMu.RLock()
+			defer fs.statusMu.RUnlock()
+			return fs.status.Status
+		}
+		for _, rng := range test.ranges {
+			req := httptest.NewRequest("GET", "/api/download", nil)
+			req.Header.Set("Range", rng)
+			rec := httptest.NewRecorder()
+			fs.handleDownload(rec, req)
+			if rec.Code != http.StatusPartialContent {
+				t.Errorf("%s: %s returned %d, expected %d", test.name, rng, rec.Code, http.StatusPartialContent)
+			}
+		}
+		// A resume completes after rangeSettle, which fastClock skips.
+		deadline := time.Now().Add(2 * time.Second)
+		for status() != test.expected && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if result := status(); result != test.expected {
+			t.Errorf("%s: status %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLineInterval is the least time the speed is measured over, and
+	// how often the line is redrawn without -progress-interval.
+	rateLineInterval = time.Second
+	// Without a terminal the line can't be redrawn, so it is printed
+	// only every rateLogEvery to keep logs short.
+	rateLogEvery = 10 * time.Second
+)
+
+// consoleMu keeps the rate line and other console messages from
+// interleaving.
+var consoleMu sync.Mutex
+
+// rateLineShown is true while the terminal cursor sits at the end of a
+// rate line, so the next message must start on a fresh line.
+var rateLineShown bool
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printConsole writes a human-readable message, moving past a pending
+// rate line first.
+func printConsole(msg string) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	if rateLineShown {
+		fmt.Println()
+		rateLineShown = false
+	}
+	fmt.Print(msg)
+}
+
+// formatRateLine describes a running transfer: speed, amount, percentage
+// and peer.
+func formatRateLine(status TransferStatus, rate float64, peer string) string {
+	line := fmt.Sprintf("%s%s/s  %s", icon("⇅ "), formatSize(int64(rate)), formatSize(status.Transferred))
+	if status.Size > 0 {
+		line += fmt.Sprintf(" / %s  %.1f%%", formatSize(status.Size), status.Progress)
+	}
+	if status.Phase == phaseScanning || status.Phase == phaseCompressing {
+		line += "  " + status.Phase
+	}
+	if peer != "" {
+		line += "  " + peer
+	}
+	return line
+}
+
+// showRate prints the rate line to w while a transfer runs, redrawing it
+// in place on a terminal every -progress-interval, until the server stops.
+// The speed is only remeasured once rateLineInterval has passed, so a
+// short interval moves the percentage along without making it jumpy.
+func (fs *FileServer) showRate(w io.Writer, tty bool) {
+	var lastBytes int64
+	var rate float64
+	var logged time.Time
+	lastTime := fs.clock.Now()
+	for {
+		select {
+		case <-fs.done:
+			return
+		case <-fs.clock.After(cmp.Or(fs.progressEvery, rateLineInterval)):
+		}
+
+		fs.statusMu.RLock()
+		status := *fs.status
+		fs.statusMu.RUnlock()
+		now := fs.clock.Now()
+		if status.Status != "transferring" {
+			lastBytes, lastTime, rate, logged = 0, now, 0, time.Time{}
+			continue
+		}
+		if status.Transferred < lastBytes {
+			// A new transfer started since the last tick.
+			lastBytes = 0
+		}
+		if elapsed := now.Sub(lastTime); elapsed >= rateLineInterval || (rate == 0 && elapsed > 0) {
+			rate = float64(status.Transferred-lastBytes) / elapsed.Seconds()
+			lastBytes, lastTime = status.Transferred, now
+		}
+
+		line := formatRateLine(status, rate, fs.clientLabel(status.ClientIP))
+		consoleMu.Lock()
+		if tty {
+			fmt.Fprintf(w, "\r%s\x1b[K", line)
+			rateLineShown = true
+		} else if logged.IsZero() || now.Sub(logged) >= rateLogEvery {
+			fmt.Fprintln(w, line)
+			logged = now
+		}
+		consoleMu.Unlock()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test the transfer rate line
+func TestFormatRateLine(t *testing.T) {
+	tests := []struct {
+		status   TransferStatus
+		rate     float64
+		peer     string
+		expected string
+	}{
+		{TransferStatus{Transferred: 512 << 20, Size: 1 << 30, Progress: 50}, 12 << 20, "192.168.1.20",
+			"⇅ 12.00 MB/s  512.00 MB / 1.00 GB  50.0%  192.168.1.20"},
+		{TransferStatus{Transferred: 2048}, 1024, "", "⇅ 1.00 KB/s  2.00 KB"},
+		{TransferStatus{Transferred: 100, Size: 400, Progress: 25, Phase: phaseCompressing}, 0, "laptop (10.0.0.5)",
+			"⇅ 0 B/s  100 B / 400 B  25.0%  compressing  laptop (10.0.0.5)"},
+	}
+	for _, test := range tests {
+		if result := formatRateLine(test.status, test.rate, test.peer); result != test.expected {
+			t.Errorf("formatRateLine(%+v) = %q, expected %q", test.status, result, test.expected)
+		}
+	}
+}
+
+// tickClock fires After only when the test sends a tick, and reports on
+// waiting each time the caller comes back for the next one.
+type tickClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	ticks   chan time.Time
+	waiting chan struct{}
+}
+
+func (c *tickClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *tickClock) After(d time.Duration) <-chan time.Time {
+	c.waiting <- struct{}{}
+	return c.ticks
+}
+
+// tick advances the clock and returns once the tick has been handled.
+func (c *tickClock) tick(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+	c.ticks <- c.now
+	<-c.waiting
+}
+
+// Test the rate line is redrawn in place on a terminal
+func TestShowRate(t *testing.T) {
+	clock := &tickClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ticks: make(chan time.Time), waiting: make(chan struct{})}
+	fs := NewFileServer("send", "/tmp/test.txt", 8080, false)
+	fs.clock = clock
+	var out bytes.Buffer
+	stopped := make(chan struct{})
+	go func() {
+		fs.showRate(&out, true)
+		close(stopped)
+	}()
+	<-clock.waiting
+
+	clock.tick(time.Second) // idle: nothing printed
+	fs.startTransfer("192.168.1.20", 10<<20)
+	fs.updateProgress(2 << 20)
+	clock.tick(time.Second)
+	fs.updateProgress(6 << 20)
+	clock.tick(2 * time.Second)
+	fs.shutdown()
+	<-stopped
+
+	lines := strings.Split(strings.TrimPrefix(out.String(), "\r"), "\r")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 redraws, got %q", out.String())
+	}
+	if !strings.Contains(lines[0], "2.00 MB/s  2.00 MB / 10.00 MB  20.0%") {
+		t.Errorf("First line = %q, expected the first second's rate", lines[0])
+	}
+	if !strings.Contains(lines[1], "2.00 MB/s  6.00 MB / 10.00 MB  60.0%  192.168.1.20") || !strings.HasSuffix(lines[1], "\x1b[K") {
+		t.Errorf("Second line = %q, expected the rate over the last two seconds", lines[1])
+	}
+}
+
+// Test a short -progress-interval redraws often but keeps measuring the
+// speed over a second
+func TestShowRateInterval(t *testing.T) {
+	clock := &tickClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ticks: make(chan time.Time), waiting: make(chan struct{})}
+	fs := NewFileServer("send", "/tmp/test.txt", 8080, false)
+	fs.clock = clock
+	fs.progressEvery = 250 * time.Millisecond
+	var out bytes.Buffer
+	stopped := make(chan struct{})
+	go func() {
+		fs.showRate(&out, true)
+		close(stopped)
+	}()
+	<-clock.waiting
+
+	fs.startTransfer("192.168.1.20", 10<<20)
+	fs.updateProgress(1 << 20)
+	clock.tick(250 * time.Millisecond)
+	fs.updateProgress(2 << 20)
+	clock.tick(250 * time.Millisecond)
+	fs.updateProgress(6 << 20)
+	clock.tick(750 * time.Millisecond)
+	fs.shutdown()
+	<-stopped
+
+	lines := strings.Split(strings.TrimPrefix(out.String(), "\r"), "\r")
+	expected := []string{
+		"4.00 MB/s  1.00 MB / 10.00 MB  10.0%",
+		"4.00 MB/s  2.00 MB / 10.00 MB  20.0%",
+		"5.00 MB/s  6.00 MB / 10.00 MB  60.0%",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d redraws, got %q", len(expected), out.String())
+	}
+	for i := range expected {
+		if !strings.Contains(lines[i], expected[i]) {
+			t.Errorf("Line %d = %q, expected %q", i, lines[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A -url download in progress is kept under these names in its cache
+// folder, the validator letting a later run resume it only if the remote
+// file hasn't changed since.
+const (
+	relayPartial   = ".download.part"
+	relayValidator = ".download.validator"
+)
+
+// relayCacheDir is where -url downloads are kept: dir if given, else the
+// user's cache directory.
+func relayCacheDir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "fileshare", "relay"), nil
+}
+
+// cacheURL downloads rawURL into a folder of its own under cacheDir and
+// returns the file's path, so a share can serve it to the LAN having
+// fetched it from the internet once. Later runs share the copy already
+// there; delete it to fetch again. An interrupted download is resumed
+// when the server supports ranges. Progress notes go to w.
+func cacheURL(ctx context.Context, rawURL, cacheDir string, w io.Writer) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("-url must be an http or https URL")
+	}
+	sum := sha256.Sum256([]byte(u.String()))
+	dir := filepath.Join(cacheDir, hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if cached, ok := cachedFile(dir); ok {
+		fmt.Fprintf(w, "%sSharing the cached copy of %s\n", icon("📦 "), u.Redacted())
+		return cached, nil
+	}
+
+	partial := filepath.Join(dir, relayPartial)
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "fileshare/"+version)
+	validator, _ := os.ReadFile(filepath.Join(dir, relayValidator))
+	if offset > 0 && len(validator) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(validator))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		fmt.Fprintf(w, "%sResuming %s at %s\n", icon("🌐 "), u.Redacted(), formatSize(offset))
+	case http.StatusOK:
+		if err := f.Truncate(0); err != nil {
+			return "", err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		offset = 0
+		// Weak ETags can't be used with If-Range.
+		validator := resp.Header.Get("ETag")
+		if validator == "" || strings.HasPrefix(validator, "W/") {
+			validator = resp.Header.Get("Last-Modified")
+		}
+		os.WriteFile(filepath.Join(dir, relayValidator), []byte(validator), 0644)
+		size := "unknown size"
+		if resp.ContentLength >= 0 {
+			size = formatSize(resp.ContentLength)
+		}
+		fmt.Fprintf(w, "%sFetching %s (%s)\n", icon("🌐 "), u.Redacted(), size)
+	default:
+		return "", fmt.Errorf("fetching %s: %s", u.Redacted(), resp.Status)
+	}
+
+	name, err := sanitizeFilename(fetchedName(resp))
+	if err != nil || name == relayPartial || name == relayValidator {
+		name = "download"
+	}
+	n, err := io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %v (%s kept to resume)", u.Redacted(), err, formatSize(offset+n))
+	}
+	path := filepath.Join(dir, name)
+	if err := os.Rename(partial, path); err != nil {
+		return "", err
+	}
+	os.Remove(filepath.Join(dir, relayValidator))
+	fmt.Fprintf(w, "%sCached '%s' (%s) in %s\n", icon("✓ "), name, formatSize(offset+n), dir)
+	return path, nil
+}
+
+// cachedFile finds a finished download in a -url cache folder.
+func cachedFile(dir string) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && entry.Name() != relayPartial && entry.Name() != relayValidator {
+			return filepath.Join(dir, entry.Name()), true
+		}
+	}
+	return "", false
+}
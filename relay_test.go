@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test -url downloads once, resumes and refetches a changed file
+func TestCacheURL(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	etag := `"v1"`
+	var mu sync.Mutex
+	var ranges []string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "big.iso", time.Time{}, bytes.NewReader(content))
+	}))
+	defer remote.Close()
+	requests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		got := ranges
+		ranges = nil
+		return got
+	}
+
+	cache := t.TempDir()
+	url := remote.URL + "/isos/big.iso"
+	path, err := cacheURL(context.Background(), url, cache, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); filepath.Base(path) != "big.iso" || !bytes.Equal(data, content) {
+		t.Errorf("Cached %s with %d bytes, expected big.iso with %d", filepath.Base(path), len(data), len(content))
+	}
+	if again, err := cacheURL(context.Background(), url, cache, io.Discard); err != nil || again != path {
+		t.Errorf("Second run = %s, %v, expected the cached %s", again, err, path)
+	}
+	if got := requests(); len(got) != 1 {
+		t.Errorf("Expected one request for two runs, got %d", len(got))
+	}
+
+	// An interrupted download resumes while the ETag still matches, and
+	// starts over once it doesn't.
+	tests := []struct {
+		name     string
+		etag     string
+		expected string // Range header sent
+	}{
+		{"resume", `"v1"`, "bytes=4000-"},
+		{"changed", `"v2"`, "bytes=4000-"},
+	}
+	for _, test := range tests {
+		os.Remove(path)
+		dir := filepath.Dir(path)
+		os.WriteFile(filepath.Join(dir, relayPartial), content[:4000], 0644)
+		os.WriteFile(filepath.Join(dir, relayValidator), []byte(`"v1"`), 0644)
+		etag = test.etag
+		got, err := cacheURL(context.Background(), url, cache, io.Discard)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if data, _ := os.ReadFile(got); !bytes.Equal(data, content) {
+			t.Errorf("%s: cached %d bytes, expected the whole %d", test.name, len(data), len(content))
+		}
+		if sent := requests(); len(sent) != 1 || sent[0] != test.expected {
+			t.Errorf("%s: sent Range %q, expected %q", test.name, sent, test.expected)
+		}
+		if _, err := os.Stat(filepath.Join(dir, relayPartial)); !os.IsNotExist(err) {
+			t.Errorf("%s: partial download left behind", test.name)
+		}
+	}
+
+	if err := Run(context.Background(), Options{Mode: "recv", Path: cache, URL: url}); err == nil || !strings.Contains(err.Error(), "-url requires send mode") {
+		t.Errorf("-url with recv: got %v, expected a usage error", err)
+	}
+	if _, err := cacheURL(context.Background(), "ftp://example.com/x", cache, io.Discard); err == nil {
+		t.Errorf("Expected an error for a non-http URL")
+	}
+}
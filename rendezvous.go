@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// discoveryPort is where `send -code` instances answer `get -code` queries
+// broadcast on the LAN.
+const discoveryPort = 51900
+
+type discoveryQuery struct {
+	Find string `json:"find"`
+}
+
+type discoveryReply struct {
+	Code string `json:"code"`
+	Port int    `json:"port"`
+	Path string `json:"path"`
+}
+
+// listenDiscovery answers broadcast queries for this instance's share code.
+func (fs *FileServer) listenDiscovery(addr string) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := fs.answerDiscovery(buf[:n]); reply != nil {
+				conn.WriteTo(reply, from)
+			}
+		}
+	}()
+	return conn, nil
+}
+
+func (fs *FileServer) answerDiscovery(packet []byte) []byte {
+	var query discoveryQuery
+	if json.Unmarshal(packet, &query) != nil || fs.shareCode == "" || query.Find != fs.shareCode {
+		return nil
+	}
+	reply, _ := json.Marshal(discoveryReply{Code: fs.shareCode, Port: fs.port, Path: fs.basePath})
+	return reply
+}
+
+// broadcastAddrs lists the limited broadcast address plus the directed
+// broadcast address of every IPv4 interface, since some networks drop
+// 255.255.255.255.
+func broadcastAddrs(port int) []string {
+	addrs := []string{fmt.Sprintf("255.255.255.255:%d", port)}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return addrs
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		ifAddrs, _ := iface.Addrs()
+		for _, a := range ifAddrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			ip, mask := ipnet.IP.To4(), net.IP(ipnet.Mask).To4()
+			if mask == nil {
+				continue
+			}
+			bcast := make(net.IP, 4)
+			for i := range bcast {
+				bcast[i] = ip[i] | ^mask[i]
+			}
+			addrs = append(addrs, fmt.Sprintf("%s:%d", bcast, port))
+		}
+	}
+	return addrs
+}
+
+// discoverShare queries targets until an instance sharing code answers and
+// returns its base URL.
+func discoverShare(code string, targets []string, timeout time.Duration) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	query, _ := json.Marshal(discoveryQuery{Find: code})
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 512)
+	for time.Now().Before(deadline) {
+		for _, target := range targets {
+			if addr, err := net.ResolveUDPAddr("udp4", target); err == nil {
+				conn.WriteTo(query, addr)
+			}
+		}
+
+		wait := time.Now().Add(time.Second)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			var reply discoveryReply
+			if json.Unmarshal(buf[:n], &reply) != nil || reply.Code != code {
+				continue
+			}
+			host := from.(*net.UDPAddr).IP.String()
+			return fmt.Sprintf("http://%s:%d%s/", host, reply.Port, reply.Path), nil
+		}
+	}
+	return "", fmt.Errorf("no share with code '%s' found on the local network", code)
+}
+
+// fetchShare downloads the share at base into dir, using the server's
+// suggested filename. Large files are fetched over parallel range requests
+// when the server supports them, sparse files without their holes, and
+// anything else over one resumable request. Progress is drawn on bar.
+func fetchShare(base, dir string, parallel int, bar *progressBar) (string, int64, error) {
+	url := base + "api/download"
+	if head, err := http.Head(url); err == nil {
+		head.Body.Close()
+		// Each range of an archive is generated from the start, so
+		// fetching one in parallel would mostly read files twice.
+		zipped := head.Header.Get("Content-Type") == "application/zip"
+		ranged := head.StatusCode == http.StatusOK && head.Header.Get("Accept-Ranges") == "bytes" && !zipped
+		if ranged {
+			if extents, ok := fetchExtents(base, head.ContentLength); ok {
+				return fetchShareSparse(url, dir, head, extents, parallel, bar)
+			}
+		}
+		if ranged && parallel > 1 && head.ContentLength >= minParallelSize {
+			return fetchShareParallel(url, dir, head, parallel, bar)
+		}
+		if head.StatusCode == http.StatusOK {
+			return fetchResumable(url, dir, head, bar)
+		}
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", 0, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+
+	name, err := responseFilename(resp.Header)
+	if err != nil {
+		return "", 0, err
+	}
+
+	dst, savePath, err := createUploadFile(dir, name, conflictRename, time.Now())
+	if err != nil {
+		return "", 0, err
+	}
+	bar.begin(0, resp.ContentLength)
+	n, err := io.Copy(progressWriter{dst, bar}, resp.Body)
+	bar.end()
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(savePath)
+		return "", 0, err
+	}
+	return savePath, n, nil
+}
+
+// responseFilename is the sanitized filename suggested by a download's
+// Content-Disposition.
+func responseFilename(h http.Header) (string, error) {
+	name := "download"
+	if _, params, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	return sanitizeFilename(name)
+}
+
+// shareBase turns the address of a share's page, or of its download, into
+// the base fetchShare expects.
+func shareBase(addr string) string {
+	addr = strings.TrimSuffix(addr, "api/download")
+	return strings.TrimSuffix(addr, "/") + "/"
+}
+
+func runGet(args []string) int {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	code := flags.String("code", "", "Share code printed by 'send -code'")
+	outDir := flags.String("o", ".", "Directory to save the download in")
+	timeout := flags.Duration("timeout", 10*time.Second, "How long to search the network")
+	parallel := flags.Int("parallel", 4, "Connections used for large downloads when the server supports ranges (1 for a single stream)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s get [-o dir] <http://host:port | -code phrase>\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	base := ""
+	if arg := flags.Arg(0); *code == "" && (strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")) {
+		base = shareBase(arg)
+	} else if *code == "" {
+		*code = arg
+	}
+	if base == "" && *code == "" {
+		flags.Usage()
+		return 1
+	}
+
+	if base == "" {
+		fmt.Printf("Looking for '%s' on the local network...\n", *code)
+		var err error
+		if base, err = discoverShare(*code, broadcastAddrs(discoveryPort), *timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Found %s\n", base)
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var bar *progressBar
+	if isTerminal(os.Stderr) {
+		bar = newProgressBar(os.Stderr)
+	}
+	savePath, n, err := fetchShare(base, *outDir, *parallel, bar)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: download failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%sSaved %s (%s)\n", icon("✓ "), filepath.Clean(savePath), formatSize(n))
+	return 0
+}
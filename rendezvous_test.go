@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test finding a coded share over UDP and downloading it
+func TestDiscoverAndFetchShare(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_rendezvous_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	target := filepath.Join(tempDir, "report.txt")
+	os.WriteFile(target, []byte("quarterly numbers"), 0644)
+
+	fs := NewFileServer("send", target, 0, false)
+	fs.shareCode = "blue-tiger-42"
+	fs.basePath = "/" + fs.shareCode
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/download", fs.handleDownload)
+	server := httptest.NewServer(fs.mountBasePath(mux))
+	defer server.Close()
+	fs.port = server.Listener.Addr().(*net.TCPAddr).Port
+
+	conn, err := fs.listenDiscovery("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listenDiscovery error: %v", err)
+	}
+	defer conn.Close()
+	targets := []string{conn.LocalAddr().String()}
+
+	if _, err := discoverShare("red-fox-1", targets, 300*time.Millisecond); err == nil {
+		t.Error("A different code should not be found")
+	}
+
+	base, err := discoverShare(fs.shareCode, targets, 2*time.Second)
+	if err != nil {
+		t.Fatalf("discoverShare error: %v", err)
+	}
+	if base != server.URL+"/blue-tiger-42/" {
+		t.Errorf("discoverShare = %s, expected %s/blue-tiger-42/", base, server.URL)
+	}
+
+	outDir := filepath.Join(tempDir, "out")
+	os.Mkdir(outDir, 0755)
+	savePath, n, err := fetchShare(base, outDir, 4, nil)
+	if err != nil {
+		t.Fatalf("fetchShare error: %v", err)
+	}
+	data, _ := os.ReadFile(savePath)
+	if filepath.Base(savePath) != "report.txt" || string(data) != "quarterly numbers" || n != int64(len(data)) {
+		t.Errorf("Unexpected download %s (%d bytes): %q", savePath, n, data)
+	}
+}
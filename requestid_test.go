@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test request ID propagation to headers, errors and logs
+func TestRequestID(t *testing.T) {
+	fs := NewFileServer("recv", "/tmp", 8080, false)
+	handler := withRequestID(http.HandlerFunc(fs.handleDownload))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/download", nil))
+	id := rec.Header().Get("X-Request-ID")
+	if id == "" {
+		t.Fatal("X-Request-ID header should be set")
+	}
+	if !strings.Contains(rec.Body.String(), id) {
+		t.Errorf("Error body should contain request ID %s, got %q", id, rec.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/api/download", nil)
+	req.Header.Set("X-Request-ID", "client-supplied-1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "client-supplied-1" {
+		t.Errorf("Valid client request ID should be kept, got %s", got)
+	}
+
+	req = httptest.NewRequest("GET", "/api/download", nil)
+	req.Header.Set("X-Request-ID", "bad id\nwith newline")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); strings.Contains(got, " ") {
+		t.Errorf("Invalid client request ID should be replaced, got %q", got)
+	}
+
+	withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.logRequest(r, "hello")
+		id = requestID(r)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	fs.logMu.RLock()
+	last := fs.transferLog[len(fs.transferLog)-1]
+	fs.logMu.RUnlock()
+	if !strings.Contains(last, "["+id+"] hello") {
+		t.Errorf("Log entry should contain request ID, got %q", last)
+	}
+}
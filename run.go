@@ -0,0 +1,590 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FileSystem is the part of the filesystem used to validate and prepare
+// the share target.
+type FileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Glob(pattern string) ([]string, error)
+}
+
+type osFS struct{}
+
+func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFS) Glob(pattern string) ([]string, error)        { return filepath.Glob(pattern) }
+
+// Clock provides the time for status timestamps, logs and the auto-exit
+// delay.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Options configures a server run. main fills it from the command line.
+type Options struct {
+	Mode     string
+	Path     string
+	Port     int
+	AutoExit bool
+	// AutoExitOn limits AutoExit to these comma-separated outcomes
+	// (completed, cancelled, error); empty means any.
+	AutoExitOn string
+
+	DownloadName   string
+	OnConflict     string
+	PerClientDir   bool
+	AdminToken     string
+	Message        string
+	Motd           string
+	Terms          string
+	CopyURL        bool
+	Output         string
+	ShareCode      bool
+	BasePath       string
+	CORSOrigins    string
+	CORSMethods    string
+	CORSHeaders    string
+	TrustedProxies string
+	MaxConns       int
+	Debug          bool
+	Watch          bool
+	ResolveHosts   bool
+	MDNS           bool
+	AuditLog       string
+	CtlSocket      string
+	GRPCAddr       string
+	DLNA           bool
+	Cast           string
+	LowMem         bool
+	Compress       string
+	ZipStore       bool
+	SkipHidden     bool
+	GitIgnore      bool
+	HardLinks      bool
+	AllowFetch     bool
+	MorePaths      []string  // further send targets, shared together with Path
+	RecvDir        string    // where uploads go in both mode, which shares Path
+	Stdin          io.Reader // shared by "send -", os.Stdin if nil
+	Stdout         io.Writer // receives the upload with "recv -", os.Stdout if nil
+	URL            string
+	URLCache       string
+	MaxTotal       string
+	Budget         string
+	SSEHeartbeat   time.Duration
+	ProgressEvery  time.Duration
+	Torrent        bool
+	TorrentPort    int
+	SignedTTL      time.Duration
+	MirrorS3       string
+	ForwardWebDAV  string
+	AutoExtract    bool
+	Flatten        bool
+	CAS            bool
+	EncryptAtRest  string
+	Password       string
+	Tokens         []string
+	Notify         []string
+	Title          string
+	Logo           string
+	Accent         string
+	UIDir          string
+	MaxTotalExit   bool
+	LogFile        string
+	LogMaxSize     string
+	LogMaxAge      time.Duration
+	LogKeep        int
+
+	// FS and Clock default to the real filesystem and time.
+	FS    FileSystem
+	Clock Clock
+	// Ready, if set, is called once the server is listening.
+	Ready func(fs *FileServer)
+}
+
+// usageError marks invalid options, for which main also prints the usage.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// validate checks option values that don't depend on the target.
+func (opts *Options) validate() error {
+	switch opts.Mode {
+	case "send", "recv", "serve", "both", "clipboard", "p2p", "daemon":
+	default:
+		return usageError{"mode must be 'send', 'recv', 'serve', 'both', 'clipboard', 'p2p' or 'daemon'"}
+	}
+	if opts.Mode == "daemon" {
+		if opts.Path != "" || opts.URL != "" {
+			return usageError{"daemon takes no path; add shares through /admin/shares"}
+		}
+		if opts.AdminToken == "" {
+			return errors.New("daemon requires -admin-token to protect /admin/shares")
+		}
+		if opts.AutoExit || opts.ShareCode || opts.CopyURL || opts.CtlSocket != "" || opts.GRPCAddr != "" || opts.DLNA || opts.Cast != "" || opts.Torrent || opts.Budget != "" || opts.SignedTTL > 0 {
+			// These belong to a single server: its own listeners, or with
+			// -signed-ttl the links it prints at startup.
+			return errors.New("daemon cannot be combined with -auto-exit, -code, -copy, -ctl-socket, -grpc-addr, -dlna, -cast, -torrent, -bandwidth-budget or -signed-ttl")
+		}
+		if opts.EncryptAtRest == "-" {
+			// Shares are created by API requests, with nobody at the terminal.
+			return errors.New("daemon cannot ask for the -encrypt-at-rest passphrase on the terminal")
+		}
+	}
+	if opts.Mode == "both" {
+		if opts.Path == "" || opts.RecvDir == "" {
+			return usageError{"both needs -send <path> and -recv <dir>"}
+		}
+		if opts.Path == "-" || opts.RecvDir == "-" {
+			return usageError{"both cannot use standard input or output"}
+		}
+	}
+	if modeNeedsPath(opts.Mode) && opts.Path == "" {
+		return usageError{fmt.Sprintf("%s needs a path", opts.Mode)}
+	}
+	if len(opts.MorePaths) > 0 && opts.Mode != "send" {
+		return usageError{"only send takes several paths"}
+	}
+	if opts.Mode == "send" && opts.Path == "-" {
+		if len(opts.MorePaths) > 0 {
+			return usageError{"standard input (-) cannot be shared together with other paths"}
+		}
+		if opts.DLNA || opts.Cast != "" || opts.Torrent {
+			// These read the share more than once, or out of order.
+			return errors.New("send - cannot be combined with -dlna, -cast or -torrent")
+		}
+	}
+	if opts.Mode == "serve" && opts.Path == "-" {
+		return usageError{"serve needs a directory"}
+	}
+	if opts.Mode == "recv" && opts.Path == "-" {
+		if opts.PerClientDir || opts.AutoExtract || opts.EncryptAtRest != "" || opts.MirrorS3 != "" || opts.ForwardWebDAV != "" || opts.CAS || opts.GRPCAddr != "" {
+			// These work on the saved file, or save it themselves.
+			return errors.New("recv - cannot be combined with -per-client-dir, -auto-extract, -encrypt-at-rest, -mirror-s3, -forward-webdav, -cas or -grpc-addr")
+		}
+	}
+	if !validConflictPolicy(opts.OnConflict) {
+		return errors.New("-on-conflict must be 'reject' or 'rename'")
+	}
+	if opts.DLNA && opts.Mode != "send" {
+		return errors.New("-dlna requires send mode")
+	}
+	if opts.Compress != "" && !validCompression(opts.Compress) {
+		return errors.New("-compress must be 'off' or 'gzip'")
+	}
+	if opts.Torrent && opts.Mode != "send" {
+		return errors.New("-torrent requires send mode")
+	}
+	if opts.TorrentPort < 0 || opts.TorrentPort > 65535 {
+		return errors.New("-torrent-port must be between 0 and 65535")
+	}
+	if opts.MirrorS3 != "" && !receivingMode(opts.Mode) {
+		return errors.New("-mirror-s3 requires recv mode")
+	}
+	if opts.AutoExtract && !receivingMode(opts.Mode) {
+		return errors.New("-auto-extract requires recv mode")
+	}
+	if opts.CAS && !receivingMode(opts.Mode) {
+		return errors.New("-cas requires recv mode")
+	}
+	if opts.EncryptAtRest != "" && !receivingMode(opts.Mode) {
+		return errors.New("-encrypt-at-rest requires recv mode")
+	}
+	if opts.EncryptAtRest != "" && opts.AutoExtract {
+		// Extracting would leave the archive's contents unencrypted.
+		return errors.New("-encrypt-at-rest cannot be combined with -auto-extract")
+	}
+	if opts.Flatten && !opts.AutoExtract {
+		return errors.New("-flatten requires -auto-extract")
+	}
+	if opts.ForwardWebDAV != "" && !receivingMode(opts.Mode) {
+		return errors.New("-forward-webdav requires recv mode")
+	}
+	if (opts.Password != "" || len(opts.Tokens) > 0) && (opts.DLNA || opts.Cast != "" || opts.Torrent) {
+		// Renderers and torrent peers have no way to sign in.
+		return errors.New("-password and -token cannot be combined with -dlna, -cast or -torrent")
+	}
+	for _, spec := range opts.Notify {
+		if _, err := newNotifier(spec, os.Getenv); err != nil {
+			return err
+		}
+	}
+	if _, err := parseAccessTokens(opts.Tokens); err != nil {
+		return err
+	}
+	if opts.Accent != "" {
+		if _, err := parseAccent(opts.Accent); err != nil {
+			return err
+		}
+	}
+	if opts.SkipHidden && opts.Mode != "send" {
+		return errors.New("-skip-hidden requires send mode")
+	}
+	if opts.GitIgnore && opts.Mode != "send" {
+		return errors.New("-gitignore requires send mode")
+	}
+	if opts.HardLinks && opts.Mode != "send" {
+		return errors.New("-hardlinks requires send mode")
+	}
+	if opts.AllowFetch && !receivingMode(opts.Mode) {
+		return errors.New("-allow-fetch requires recv mode")
+	}
+	if opts.Terms != "" && opts.Mode != "send" {
+		return errors.New("-terms requires send mode")
+	}
+	if opts.Terms != "" && (opts.DLNA || opts.Cast != "" || opts.Torrent || opts.GRPCAddr != "") {
+		// Renderers, torrent peers and RPC clients never see the page.
+		return errors.New("-terms cannot be combined with -dlna, -cast, -torrent or -grpc-addr")
+	}
+	if strings.ContainsAny(opts.Motd, "\r\n") {
+		return errors.New("-motd must be a single line; use -message for longer notes")
+	}
+	if utf8.RuneCountInString(opts.Motd) > maxMotdLength {
+		return fmt.Errorf("-motd must be at most %d characters", maxMotdLength)
+	}
+	if opts.SignedTTL < 0 {
+		return errors.New("-signed-ttl must be positive")
+	}
+	if opts.SignedTTL > 0 && opts.GRPCAddr != "" {
+		// gRPC calls carry no link to sign, so they would skip the expiry.
+		return errors.New("-signed-ttl cannot be combined with -grpc-addr")
+	}
+	if opts.SSEHeartbeat < 0 {
+		return errors.New("-sse-heartbeat must be positive")
+	}
+	if opts.ProgressEvery < 0 {
+		return errors.New("-progress-interval must be positive")
+	}
+	if _, err := parseExitOutcomes(opts.AutoExitOn); err != nil {
+		return err
+	}
+	if opts.MaxTotal != "" {
+		if !receivingMode(opts.Mode) {
+			return errors.New("-max-total requires recv mode")
+		}
+		if limit, err := parseSize(opts.MaxTotal); err != nil || limit <= 0 {
+			return fmt.Errorf("-max-total: invalid size '%s'", opts.MaxTotal)
+		}
+	}
+	if opts.Budget != "" {
+		if budget, err := parseSize(opts.Budget); err != nil || budget <= 0 {
+			return fmt.Errorf("-bandwidth-budget: invalid size '%s'", opts.Budget)
+		}
+	}
+	if opts.LogMaxSize != "" {
+		if size, err := parseSize(opts.LogMaxSize); err != nil || size <= 0 {
+			return fmt.Errorf("-log-max-size: invalid size '%s'", opts.LogMaxSize)
+		}
+	}
+	if opts.LogMaxAge < 0 {
+		return errors.New("-log-max-age must be positive")
+	}
+	if opts.LogKeep < 0 {
+		return errors.New("-log-keep must not be negative")
+	}
+	if (opts.LogMaxSize != "" || opts.LogMaxAge > 0) && opts.LogFile == "" && opts.AuditLog == "" {
+		return errors.New("-log-max-size and -log-max-age require -log-file or -audit-log")
+	}
+	if !validOutputFormat(opts.Output) {
+		return errors.New("-output must be 'text' or 'json'")
+	}
+	return nil
+}
+
+// newServer builds a configured, not yet listening server from opts. The
+// returned cleanup releases what it opened.
+func newServer(opts Options) (*FileServer, func(), error) {
+	if opts.FS == nil {
+		opts.FS = osFS{}
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = conflictReject
+	}
+	if opts.Output == "" {
+		opts.Output = "text"
+	}
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	path := targetPath(opts.Path)
+	var sources []string
+	var err error
+	if opts.Path == "-" && modeNeedsPath(opts.Mode) {
+		path = opts.Path
+	} else if len(opts.MorePaths) > 0 {
+		paths := []string{path}
+		for _, p := range opts.MorePaths {
+			paths = append(paths, targetPath(p))
+		}
+		sources, err = expandSendTargets(opts.FS, paths)
+		path = strings.Join(paths, " ")
+	} else {
+		sources, err = prepareTarget(opts.FS, opts.Mode, path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sources) == 1 {
+		path, sources = sources[0], nil
+	}
+	recvDir := ""
+	if opts.Mode == "both" {
+		recvDir = targetPath(opts.RecvDir)
+		if _, err := prepareTarget(opts.FS, "recv", recvDir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	trustedNets, err := parseTrustedProxies(opts.TrustedProxies)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := NewFileServer(opts.Mode, path, opts.Port, opts.AutoExit)
+	server.exitOn, _ = parseExitOutcomes(opts.AutoExitOn)
+	server.fsys = opts.FS
+	server.clock = opts.Clock
+	server.started = opts.Clock.Now()
+	server.status.StartTime = server.started
+	server.sources = sources
+	server.recvDir = recvDir
+	if path == "-" && opts.Mode == "send" {
+		server.stdin = opts.Stdin
+		if server.stdin == nil {
+			server.stdin = os.Stdin
+		}
+		server.status.Size = -1
+	}
+	if path == "-" && opts.Mode == "recv" {
+		server.stdout = opts.Stdout
+		if server.stdout == nil {
+			server.stdout = os.Stdout
+		}
+	}
+	server.onConflict = opts.OnConflict
+	server.perClientDir = opts.PerClientDir
+	server.adminToken = opts.AdminToken
+	if opts.DownloadName != "" {
+		server.downloadName = filepath.Base(opts.DownloadName)
+	}
+	server.status.Path = server.shareName()
+	if opts.Message != "" {
+		text, err := loadMessage(opts.Message)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read message: %v", err)
+		}
+		server.message = renderMarkdown(text)
+	}
+	server.motd = strings.TrimSpace(opts.Motd)
+	if opts.Terms != "" {
+		text, err := loadMessage(opts.Terms)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read terms: %v", err)
+		}
+		if strings.TrimSpace(text) == "" {
+			return nil, nil, errors.New("-terms is empty")
+		}
+		server.terms = renderMarkdown(text)
+		server.termsKey = newSignKey()
+	}
+	server.copyURL = opts.CopyURL
+	if opts.Output == "json" {
+		server.events = newEventWriter(os.Stdout, opts.ProgressEvery)
+	}
+	server.ctlSocket = opts.CtlSocket
+	server.grpcAddr = opts.GRPCAddr
+	server.dlna = opts.DLNA
+	if opts.Cast != "" && server.shareMediaKind() == "" {
+		return nil, nil, errors.New("-cast requires sharing a single video or audio file")
+	}
+	server.castTo = opts.Cast
+	server.compress = opts.Compress
+	server.zipStore = opts.ZipStore
+	server.skipHidden = opts.SkipHidden
+	server.gitignore = opts.GitIgnore
+	server.hardLinks = opts.HardLinks
+	server.allowFetch = opts.AllowFetch
+	if opts.SSEHeartbeat > 0 {
+		server.heartbeat = opts.SSEHeartbeat
+	}
+	for _, spec := range opts.Notify {
+		n, _ := newNotifier(spec, os.Getenv)
+		server.notifiers = append(server.notifiers, n)
+	}
+	if opts.MirrorS3 != "" {
+		mirror, err := newS3Mirror(opts.MirrorS3, os.Getenv)
+		if err != nil {
+			return nil, nil, err
+		}
+		server.mirrors = append(server.mirrors, mirror)
+	}
+	server.autoExtract = opts.AutoExtract
+	server.flatten = opts.Flatten
+	server.cas = opts.CAS
+	server.password = opts.Password
+	server.tokens, _ = parseAccessTokens(opts.Tokens)
+	if opts.UIDir != "" {
+		if info, err := os.Stat(opts.UIDir); err != nil || !info.IsDir() {
+			return nil, nil, fmt.Errorf("-ui-dir %s is not a directory", opts.UIDir)
+		}
+		server.uiDir = opts.UIDir
+	}
+	if opts.Title != "" || opts.Logo != "" || opts.Accent != "" {
+		brand := &branding{title: cmp.Or(opts.Title, defaultTitle)}
+		if opts.Accent != "" {
+			brand.accent, _ = parseAccent(opts.Accent)
+		}
+		if opts.Logo != "" {
+			var err error
+			if brand.logo, brand.logoType, err = loadLogo(opts.Logo); err != nil {
+				return nil, nil, fmt.Errorf("-logo: %v", err)
+			}
+		}
+		server.brand = brand
+	}
+	if passphrase := opts.EncryptAtRest; passphrase != "" {
+		if passphrase == "-" {
+			var err error
+			if passphrase, err = readPassphrase("Passphrase for received files: "); err != nil || passphrase == "" {
+				return nil, nil, errors.New("-encrypt-at-rest needs a passphrase")
+			}
+		}
+		server.atRest = newAtRestKey(passphrase)
+	}
+	if opts.ForwardWebDAV != "" {
+		forward, err := newWebDAVForward(opts.ForwardWebDAV, os.Getenv)
+		if err != nil {
+			return nil, nil, err
+		}
+		server.mirrors = append(server.mirrors, forward)
+	}
+	if opts.SignedTTL > 0 {
+		server.signKey = newSignKey()
+		server.signTTL = opts.SignedTTL
+	}
+	if opts.Torrent {
+		server.torrentPort = cmp.Or(opts.TorrentPort, defaultTorrentPort)
+	}
+	if opts.Budget != "" {
+		server.bandwidthBudget, _ = parseSize(opts.Budget)
+	}
+	if opts.MaxTotal != "" {
+		server.maxTotal, _ = parseSize(opts.MaxTotal)
+		server.maxTotalExit = opts.MaxTotalExit
+	}
+	server.progressEvery = opts.ProgressEvery
+	if opts.LowMem {
+		server.lowMem = true
+		server.progressEvery = max(server.progressEvery, lowMemProgressInterval)
+		debug.SetGCPercent(lowMemGCPercent)
+	}
+	server.trustedNets = trustedNets
+	server.basePath = normalizeBasePath(opts.BasePath)
+	if opts.ShareCode {
+		server.shareCode = newShareCode()
+		server.basePath += "/" + server.shareCode
+	}
+	server.cors = newCORSPolicy(opts.CORSOrigins, opts.CORSMethods, opts.CORSHeaders)
+	server.maxConns = opts.MaxConns
+	server.debug = opts.Debug
+	server.watch = opts.Watch
+	server.ready = opts.Ready
+	if opts.ResolveHosts || opts.MDNS {
+		server.resolver = newHostResolver(opts.MDNS)
+	}
+
+	rotate := logRotation{maxAge: opts.LogMaxAge, keep: opts.LogKeep}
+	if opts.LogMaxSize != "" {
+		rotate.maxSize, _ = parseSize(opts.LogMaxSize)
+	}
+	var closers []func() error
+	cleanup := func() {
+		for _, closeLog := range closers {
+			closeLog()
+		}
+	}
+	if opts.AuditLog != "" {
+		audit, err := openAuditLog(opts.AuditLog, rotate, opts.Clock)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot open audit log: %v", err)
+		}
+		server.audit = audit
+		closers = append(closers, audit.Close)
+	}
+	if opts.LogFile != "" {
+		logFile, err := openRotatingFile(opts.LogFile, rotate, opts.Clock)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("cannot open log file: %v", err)
+		}
+		server.logFile = logFile
+		closers = append(closers, logFile.Close)
+	}
+	return server, cleanup, nil
+}
+
+// Run serves opts until ctx is cancelled, the transfer completes with
+// AutoExit, or a shutdown is requested over the control socket.
+func Run(ctx context.Context, opts Options) error {
+	if opts.Mode == "daemon" {
+		if err := opts.validate(); err != nil {
+			return err
+		}
+		return runDaemon(ctx, opts)
+	}
+	if opts.URL != "" {
+		if opts.Mode != "send" || opts.Path != "" {
+			return usageError{"-url requires send mode and takes the place of the path"}
+		}
+		cacheDir, err := relayCacheDir(opts.URLCache)
+		if err != nil {
+			return err
+		}
+		if opts.Path, err = cacheURL(ctx, opts.URL, cacheDir, os.Stderr); err != nil {
+			return err
+		}
+	}
+	if opts.Mode == "recv" && opts.Path == "-" && opts.Stdout == nil {
+		// The upload gets the real standard output to itself; everything
+		// printed for the user goes to stderr instead.
+		stdout := os.Stdout
+		opts.Stdout, os.Stdout = stdout, os.Stderr
+		defer func() { os.Stdout = stdout }()
+	}
+	server, cleanup, err := newServer(opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := server.Start(ctx); err != nil {
+		return err
+	}
+	return server.stdoutErr
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFS is an in-memory FileSystem: paths map to whether they are
+// directories.
+type fakeFS struct {
+	paths map[string]bool
+	made  []string
+}
+
+type fakeInfo struct {
+	name  string
+	isDir bool
+}
+
+func (i fakeInfo) Name() string       { return filepath.Base(i.name) }
+func (i fakeInfo) Size() int64        { return 0 }
+func (i fakeInfo) Mode() os.FileMode  { return 0644 }
+func (i fakeInfo) ModTime() time.Time { return time.Time{} }
+func (i fakeInfo) IsDir() bool        { return i.isDir }
+func (i fakeInfo) Sys() interface{}   { return nil }
+
+func (f *fakeFS) Stat(name string) (os.FileInfo, error) {
+	isDir, ok := f.paths[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return fakeInfo{name, isDir}, nil
+}
+
+func (f *fakeFS) MkdirAll(path string, perm os.FileMode) error {
+	if strings.HasPrefix(path, "/readonly") {
+		return os.ErrPermission
+	}
+	f.made = append(f.made, path)
+	return nil
+}
+
+func (f *fakeFS) Glob(pattern string) ([]string, error) {
+	var matches []string
+	for p := range f.paths {
+		if ok, _ := filepath.Match(pattern, p); ok {
+			matches = append(matches, p)
+		}
+	}
+	return matches, nil
+}
+
+// fastClock fires every timer immediately.
+type fastClock struct{}
+
+func (fastClock) Now() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+func (fastClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+// Test option and target validation
+func TestNewServerValidation(t *testing.T) {
+	fsys := &fakeFS{paths: map[string]bool{
+		"/share":            true,
+		"/share/report.pdf": false,
+		"/share/movie.mp4":  false,
+		"/share/a.log":      false,
+		"/share/b.log":      false,
+	}}
+	tests := []struct {
+		opts    Options
+		errText string
+		usage   bool
+	}{
+		{Options{Mode: "send", Path: "/share/report.pdf"}, "", false},
+		{Options{Mode: "send", Path: "/share/*.log"}, "", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", MorePaths: []string{"/share/*.log"}}, "", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", MorePaths: []string{"/share/missing.txt"}}, "cannot access '/share/missing.txt'", false},
+		{Options{Mode: "recv", Path: "/incoming"}, "", false},
+		{Options{Mode: "clipboard"}, "", false},
+		{Options{Mode: "upload", Path: "/share"}, "mode must be", true},
+		{Options{Mode: "send"}, "needs a path", true},
+		{Options{Mode: "send", Path: "/share/missing.pdf"}, "cannot access", false},
+		{Options{Mode: "send", Path: "/share/*.txt"}, "no files match", false},
+		{Options{Mode: "recv", Path: "/readonly/in"}, "cannot create directory", false},
+		{Options{Mode: "recv", Path: "/incoming", OnConflict: "overwrite"}, "-on-conflict", false},
+		{Options{Mode: "recv", Path: "/incoming", DLNA: true}, "-dlna requires send mode", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Output: "xml"}, "-output", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Compress: "zstd"}, "-compress", false},
+		{Options{Mode: "send", Path: "/share/a.log", Compress: "gzip"}, "", false},
+		{Options{Mode: "recv", Path: "/incoming", AutoExit: true, AutoExitOn: "completed,error"}, "", false},
+		{Options{Mode: "recv", Path: "/incoming", AutoExit: true, AutoExitOn: "done"}, "-auto-exit", false},
+		{Options{Mode: "recv", Path: "/incoming", MaxTotal: "50GB"}, "", false},
+		{Options{Mode: "recv", Path: "/incoming", SSEHeartbeat: 15 * time.Second}, "", false},
+		{Options{Mode: "recv", Path: "/incoming", SSEHeartbeat: -time.Second}, "-sse-heartbeat", false},
+		{Options{Mode: "recv", Path: "/incoming", ProgressEvery: -time.Second}, "-progress-interval", false},
+		{Options{Mode: "send", Path: "/tmp", SignedTTL: -time.Hour}, "-signed-ttl", false},
+		{Options{Mode: "send", Path: "/tmp", MirrorS3: "s3://drops"}, "-mirror-s3 requires recv mode", false},
+		{Options{Mode: "send", Path: "/tmp", ForwardWebDAV: "https://cloud.lan/dav/"}, "-forward-webdav requires recv mode", false},
+		{Options{Mode: "recv", Path: "/incoming", AutoExtract: true, Flatten: true}, "", false},
+		{Options{Mode: "recv", Path: "/incoming", Flatten: true}, "-flatten requires -auto-extract", false},
+		{Options{Mode: "send", Path: "/tmp", AutoExtract: true}, "-auto-extract requires recv mode", false},
+		{Options{Mode: "send", Path: "/tmp", CAS: true}, "-cas requires recv mode", false},
+		{Options{Mode: "send", Path: "/tmp", EncryptAtRest: "pw"}, "-encrypt-at-rest requires recv mode", false},
+		{Options{Mode: "send", Path: "/tmp", Password: "pw", DLNA: true}, "-password and -token cannot be combined", false},
+		{Options{Mode: "send", Path: "/tmp", Tokens: []string{"a:read:x", "b:write:x"}}, "reuses another token's secret", false},
+		{Options{Mode: "send", Path: "/tmp", Tokens: []string{"a:owner:x"}}, "scope must be", false},
+		{Options{Mode: "recv", Path: "/tmp", Notify: []string{"pager:555"}}, "unknown service", false},
+		{Options{Mode: "recv", Path: "/in", MorePaths: []string{"/in2"}}, "several paths", true},
+		{Options{Mode: "send", Path: "-"}, "", false},
+		{Options{Mode: "send", Path: "-", MorePaths: []string{"/share/a.log"}}, "together with other paths", true},
+		{Options{Mode: "send", Path: "-", Torrent: true}, "send - cannot be combined", false},
+		{Options{Mode: "recv", Path: "-"}, "", false},
+		{Options{Mode: "recv", Path: "-", AutoExtract: true}, "recv - cannot be combined", false},
+		{Options{Mode: "serve", Path: "/share"}, "", false},
+		{Options{Mode: "serve", Path: "/share/report.pdf"}, "not a directory", false},
+		{Options{Mode: "serve", Path: "/missing"}, "cannot access", false},
+		{Options{Mode: "serve", Path: "-"}, "serve needs a directory", true},
+		{Options{Mode: "both", Path: "/share/report.pdf", RecvDir: "/incoming"}, "", false},
+		{Options{Mode: "both", Path: "/share/report.pdf", RecvDir: "/incoming", AutoExtract: true}, "", false},
+		{Options{Mode: "both", Path: "/share/report.pdf"}, "both needs -send <path> and -recv <dir>", true},
+		{Options{Mode: "both", Path: "/share/report.pdf", RecvDir: "/readonly/in"}, "cannot create directory", false},
+		{Options{Mode: "both", Path: "-", RecvDir: "/incoming"}, "standard input or output", true},
+		{Options{Mode: "daemon"}, "daemon requires -admin-token", false},
+		{Options{Mode: "daemon", AdminToken: "secret", Path: "/share"}, "daemon takes no path", true},
+		{Options{Mode: "daemon", AdminToken: "secret", Torrent: true}, "daemon cannot be combined", false},
+		{Options{Mode: "daemon", AdminToken: "secret", EncryptAtRest: "-"}, "daemon cannot ask for the -encrypt-at-rest passphrase on the terminal", false},
+		{Options{Mode: "send", Path: "/tmp", Accent: "teal"}, "-accent must be a color", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", UIDir: "/nonexistent/theme"}, "-ui-dir /nonexistent/theme is not a directory", false},
+		{Options{Mode: "recv", Path: "/share", SkipHidden: true}, "-skip-hidden requires send mode", false},
+		{Options{Mode: "recv", Path: "/share", GitIgnore: true}, "-gitignore requires send mode", false},
+		{Options{Mode: "recv", Path: "/share", HardLinks: true}, "-hardlinks requires send mode", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", AllowFetch: true}, "-allow-fetch requires recv mode", false},
+		{Options{Mode: "recv", Path: "/share", Terms: "NDA applies"}, "-terms requires send mode", false},
+		{Options{Mode: "send", Path: "/share/movie.mp4", Terms: "NDA applies", Torrent: true}, "-terms cannot be combined with -dlna, -cast, -torrent or -grpc-addr", false},
+		{Options{Mode: "send", Path: "/share/movie.mp4", SignedTTL: time.Hour, GRPCAddr: ":50051"}, "-signed-ttl cannot be combined with -grpc-addr", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Terms: "NDA applies"}, "", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", LogMaxSize: "10MB"}, "-log-max-size and -log-max-age require -log-file or -audit-log", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", LogFile: "/tmp/x.log", LogMaxSize: "lots"}, "-log-max-size: invalid size 'lots'", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", LogFile: "/tmp/x.log", LogKeep: -1}, "-log-keep must not be negative", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Motd: "Grab the Q3 report; checksum below"}, "", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Motd: "line one\nline two"}, "-motd must be a single line; use -message for longer notes", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Motd: strings.Repeat("x", 201)}, "-motd must be at most 200 characters", false},
+		{Options{Mode: "recv", Path: "/tmp", EncryptAtRest: "pw", AutoExtract: true}, "cannot be combined with -auto-extract", false},
+		{Options{Mode: "recv", Path: "/incoming", Torrent: true}, "-torrent requires send mode", false},
+		{Options{Mode: "send", Path: "/tmp", Torrent: true, TorrentPort: 70000}, "-torrent-port", false},
+		{Options{Mode: "recv", Path: "/incoming", MaxTotal: "lots"}, "-max-total", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Budget: "0"}, "-bandwidth-budget", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", MaxTotal: "1G"}, "-max-total requires recv mode", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", Cast: "TV"}, "-cast requires", false},
+		{Options{Mode: "send", Path: "/share/movie.mp4", Cast: "TV"}, "", false},
+		{Options{Mode: "send", Path: "/share/report.pdf", TrustedProxies: "not-an-ip"}, "not-an-ip", false},
+	}
+	for _, test := range tests {
+		test.opts.FS = fsys
+		server, cleanup, err := newServer(test.opts)
+		if test.errText == "" {
+			if err != nil {
+				t.Errorf("newServer(%+v) error: %v", test.opts, err)
+				continue
+			}
+			cleanup()
+			if server.clock == nil || server.fsys != fsys {
+				t.Errorf("newServer(%+v) should set the clock and filesystem", test.opts)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.errText) {
+			t.Errorf("newServer(%+v) error = %v, expected it to mention %q", test.opts, err, test.errText)
+			continue
+		}
+		if errors.As(err, new(usageError)) != test.usage {
+			t.Errorf("newServer(%+v) usage error = %t, expected %t", test.opts, !test.usage, test.usage)
+		}
+	}
+
+	server, _, err := newServer(Options{Mode: "send", Path: "/share/*.log", FS: fsys})
+	if err != nil || len(server.getSources()) != 2 {
+		t.Errorf("Glob target should share both matches, got %v (%v)", server.getSources(), err)
+	}
+	if len(fsys.made) == 0 || fsys.made[0] != "/incoming" {
+		t.Errorf("recv should create its directory through the filesystem, got %v", fsys.made)
+	}
+}
+
+// Test that Run returns when its context is cancelled
+func TestRunShutdown(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_run_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var port int
+	opts := Options{Mode: "recv", Path: tempDir, Output: "json", Ready: func(fs *FileServer) {
+		port = fs.port
+		cancel()
+	}}
+	errc := make(chan error, 1)
+	go func() { errc <- Run(ctx, opts) }()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+		if port == 0 {
+			t.Error("Ready should see the listening port")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+}
+
+// Test that auto-exit stops the server after a transfer
+func TestRunAutoExit(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_run_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	opts := Options{Mode: "recv", Path: tempDir, Output: "json", AutoExit: true, Clock: fastClock{},
+		Ready: func(fs *FileServer) {
+			fs.startTransfer("192.168.1.42", 10)
+			fs.completeTransfer("")
+		}}
+	errc := make(chan error, 1)
+	go func() { errc <- Run(context.Background(), opts) }()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not exit after the transfer completed")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// accessScope is what a token or session may do; each scope includes the
+// ones below it.
+type accessScope int
+
+const (
+	scopeRead  accessScope = iota + 1 // browse and download
+	scopeWrite                        // also upload, cancel, post to the clipboard
+	scopeAdmin                        // also delete/rename files and sign URLs
+)
+
+var scopeNames = map[accessScope]string{scopeRead: "read", scopeWrite: "write", scopeAdmin: "admin"}
+
+func (s accessScope) String() string {
+	return scopeNames[s]
+}
+
+func parseScope(name string) (accessScope, bool) {
+	for scope, n := range scopeNames {
+		if n == name {
+			return scope, true
+		}
+	}
+	return 0, false
+}
+
+// accessToken is one -token: who it was given to, its scope and secret.
+type accessToken struct {
+	name   string
+	scope  accessScope
+	secret string
+}
+
+// parseAccessToken reads name:scope:secret; the secret may contain colons.
+func parseAccessToken(value string) (accessToken, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return accessToken{}, fmt.Errorf("-token %q must be name:scope:secret", value)
+	}
+	scope, ok := parseScope(parts[1])
+	if !ok {
+		return accessToken{}, fmt.Errorf("-token %s: scope must be 'read', 'write' or 'admin'", parts[0])
+	}
+	return accessToken{name: parts[0], scope: scope, secret: parts[2]}, nil
+}
+
+func parseAccessTokens(values []string) ([]accessToken, error) {
+	var tokens []accessToken
+	seen := map[string]bool{}
+	for _, value := range values {
+		token, err := parseAccessToken(value)
+		if err != nil {
+			return nil, err
+		}
+		if seen[token.secret] {
+			return nil, fmt.Errorf("-token %s reuses another token's secret", token.name)
+		}
+		seen[token.secret] = true
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// tokenFlags collects repeated -token flags.
+type tokenFlags struct {
+	values *[]string
+}
+
+func (f tokenFlags) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, ",")
+}
+
+func (f tokenFlags) Set(value string) error {
+	if _, err := parseAccessToken(value); err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "-token "))
+	}
+	*f.values = append(*f.values, value)
+	return nil
+}
+
+// accessGrant is the identity and scope a request was let in with.
+type accessGrant struct {
+	name  string
+	scope accessScope
+}
+
+type grantKey struct{}
+
+func requestGrant(r *http.Request) (accessGrant, bool) {
+	grant, ok := r.Context().Value(grantKey{}).(accessGrant)
+	return grant, ok
+}
+
+// credential looks up a secret: -password grants write, -admin-token
+// admin, and each -token its own scope.
+func (fs *FileServer) credential(secret string) (accessGrant, bool) {
+	if secret == "" {
+		return accessGrant{}, false
+	}
+	match := func(s string) bool {
+		return s != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s)) == 1
+	}
+	if match(fs.adminToken) {
+		return accessGrant{name: "admin", scope: scopeAdmin}, true
+	}
+	for _, token := range fs.tokens {
+		if match(token.secret) {
+			return accessGrant{name: token.name, scope: token.scope}, true
+		}
+	}
+	if match(fs.password) {
+		return accessGrant{name: "password", scope: scopeWrite}, true
+	}
+	return accessGrant{}, false
+}
+
+// requiredScope is the scope needed for r: anything that changes state
+// needs write, managing received files and clients and signing URLs needs
+// admin. gRPC calls are all POSTs, so they go by method instead.
+func requiredScope(r *http.Request) accessScope {
+	switch {
+	case r.URL.Path == "/api/sign" || r.URL.Path == "/api/file/rename":
+		return scopeAdmin
+	case r.URL.Path == grpcService+"Upload" || r.URL.Path == grpcService+"Cancel":
+		return scopeWrite
+	case strings.HasPrefix(r.URL.Path, grpcService):
+		// Download and the status calls.
+		return scopeRead
+	case r.URL.Path == "/api/file" && r.Method == http.MethodDelete:
+		return scopeAdmin
+	case strings.HasPrefix(r.URL.Path, "/api/clients/"):
+		return scopeAdmin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+		return scopeRead
+	case r.URL.Path == "/api/terms":
+		// Accepting the terms is part of downloading.
+		return scopeRead
+	default:
+		return scopeWrite
+	}
+}
+
+// canManage reports whether anyone can delete and rename received files.
+func (fs *FileServer) canManage() bool {
+	if fs.adminToken != "" {
+		return true
+	}
+	for _, token := range fs.tokens {
+		if token.scope == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScope names the scope r was let in with, "" when sign-in is off.
+func requestScope(r *http.Request) string {
+	if grant, ok := requestGrant(r); ok {
+		return grant.scope.String()
+	}
+	return ""
+}
+
+func withGrant(r *http.Request, grant accessGrant) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), grantKey{}, grant))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test parsing name:scope:secret tokens
+func TestParseAccessToken(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected accessToken
+		err      string
+	}{
+		{"alice:read:s3cret", accessToken{"alice", scopeRead, "s3cret"}, ""},
+		{"bob:admin:a:b:c", accessToken{"bob", scopeAdmin, "a:b:c"}, ""},
+		{"carol:write", accessToken{}, "must be name:scope:secret"},
+		{"dave:owner:x", accessToken{}, "scope must be"},
+		{":read:x", accessToken{}, "must be name:scope:secret"},
+		{"erin:read:", accessToken{}, "must be name:scope:secret"},
+	}
+	for _, test := range tests {
+		token, err := parseAccessToken(test.value)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseAccessToken(%q) error = %v, expected %q", test.value, err, test.err)
+			}
+			continue
+		}
+		if err != nil || token != test.expected {
+			t.Errorf("parseAccessToken(%q) = %+v, %v, expected %+v", test.value, token, err, test.expected)
+		}
+	}
+}
+
+// Test each token reaches only what its scope allows
+func TestTokenScopes(t *testing.T) {
+	fs := NewFileServer("recv", "/tmp", 8080, false)
+	fs.clock = fastClock{}
+	fs.tokens, _ = parseAccessTokens([]string{"viewer:read:r-secret", "uploader:write:w-secret", "owner:admin:a-secret"})
+	var granted accessGrant
+	handler := fs.withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		granted, _ = requestGrant(r)
+		if r.URL.Path == "/api/file" && r.Method == http.MethodDelete && !fs.requireAdmin(w, r) {
+			return
+		}
+	}))
+
+	tests := []struct {
+		method   string
+		target   string
+		token    string
+		expected int
+	}{
+		{"GET", "/api/files", "", http.StatusUnauthorized},
+		{"GET", "/api/files", "r-secret", http.StatusOK},
+		{"GET", "/api/download", "r-secret", http.StatusOK},
+		{"POST", "/api/upload", "r-secret", http.StatusForbidden},
+		{"POST", "/api/terms", "r-secret", http.StatusOK},
+		{"POST", "/api/upload", "w-secret", http.StatusOK},
+		{"POST", "/api/cancel", "w-secret", http.StatusOK},
+		{"DELETE", "/api/file?name=a", "w-secret", http.StatusForbidden},
+		{"POST", "/api/file/rename", "w-secret", http.StatusForbidden},
+		{"POST", "/api/clients/1/kick", "w-secret", http.StatusForbidden},
+		{"DELETE", "/api/file?name=a", "a-secret", http.StatusOK},
+		{"POST", "/api/sign", "a-secret", http.StatusOK},
+		{"POST", grpcService + "Download", "r-secret", http.StatusOK},
+		{"POST", grpcService + "WatchStatus", "r-secret", http.StatusOK},
+		{"POST", grpcService + "Upload", "r-secret", http.StatusForbidden},
+		{"POST", grpcService + "Upload", "w-secret", http.StatusOK},
+		{"POST", grpcService + "Cancel", "r-secret", http.StatusForbidden},
+		{"POST", grpcService + "Cancel", "w-secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("%s %s with %q = %d, expected %d", test.method, test.target, test.token, rec.Code, test.expected)
+		}
+	}
+
+	// A read-only session is upgraded by an admin token sent along.
+	sid := fs.sessions.create(accessGrant{name: "viewer", scope: scopeRead}, fastClock{}.Now())
+	req := httptest.NewRequest("DELETE", "/api/file?name=a", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: sid})
+	req.Header.Set("Authorization", "Bearer a-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || granted.name != "owner" {
+		t.Errorf("Session with admin token = %d as %q, expected 200 as owner", rec.Code, granted.name)
+	}
+	if !fs.canManage() {
+		t.Errorf("An admin token should enable file management")
+	}
+}
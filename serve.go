@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// browseListing is one folder of a serve share: its subfolders and files,
+// by name.
+type browseListing struct {
+	Path      string      `json:"path"`
+	Dirs      []fileEntry `json:"dirs"`
+	Files     []fileEntry `json:"files"`
+	Truncated bool        `json:"truncated"`
+}
+
+// servesDownloads reports whether clients download from this server: the
+// send share (also offered in both mode), or the directory tree of serve
+// mode.
+func (fs *FileServer) servesDownloads() bool {
+	return fs.mode == "send" || fs.mode == "serve" || fs.mode == "both"
+}
+
+// handleBrowse lists a folder of the served directory, given by ?path=
+// relative to it, so the page can be navigated one level at a time.
+// Links are followed only when they stay inside the directory.
+func (fs *FileServer) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "serve" {
+		httpError(w, r, "Server is not in serve mode", http.StatusBadRequest)
+		return
+	}
+	root := fs.getPath()
+	rel := strings.Trim(r.URL.Query().Get("path"), "/")
+	dir := root
+	if rel != "" {
+		full, err := resolveInside(root, rel)
+		if err != nil {
+			auditNote(r, "rejected path: "+rel)
+			httpError(w, r, "Invalid path", http.StatusBadRequest)
+			return
+		}
+		dir = full
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		httpError(w, r, "Folder not found", http.StatusNotFound)
+		return
+	}
+
+	listing := browseListing{Path: rel, Dirs: []fileEntry{}, Files: []fileEntry{}}
+	for _, e := range entries {
+		if len(listing.Dirs)+len(listing.Files) >= maxListEntries {
+			listing.Truncated = true
+			break
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			full, err := resolveInside(root, path.Join(rel, e.Name()))
+			if err != nil {
+				continue
+			}
+			if info, err = os.Stat(full); err != nil {
+				continue
+			}
+		}
+		switch {
+		case info.IsDir():
+			listing.Dirs = append(listing.Dirs, fileEntry{Name: e.Name(), ModTime: info.ModTime()})
+		case info.Mode().IsRegular():
+			listing.Files = append(listing.Files, fileEntry{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
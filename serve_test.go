@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test browsing a served directory one folder at a time
+func TestHandleBrowse(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "photos", "2024"), 0755)
+	os.WriteFile(filepath.Join(root, "readme.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(root, "photos", "cat.jpg"), []byte("meow"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "escape.txt"))
+	os.Symlink(filepath.Join(root, "readme.txt"), filepath.Join(root, "alias.txt"))
+
+	fs := NewFileServer("serve", root, 8080, false)
+	tests := []struct {
+		path   string
+		status int
+		dirs   string
+		files  string
+	}{
+		{"", http.StatusOK, "photos", "alias.txt readme.txt"},
+		{"photos", http.StatusOK, "2024", "cat.jpg"},
+		{"/photos/2024/", http.StatusOK, "", ""},
+		{"missing", http.StatusNotFound, "", ""},
+		{"../", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleBrowse(rec, httptest.NewRequest("GET", "/api/browse?path="+url.QueryEscape(test.path), nil))
+		if rec.Code != test.status {
+			t.Errorf("Browse %q = %d, expected %d", test.path, rec.Code, test.status)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var listing browseListing
+		json.NewDecoder(rec.Body).Decode(&listing)
+		var dirs, files []string
+		for _, d := range listing.Dirs {
+			dirs = append(dirs, d.Name)
+		}
+		for _, f := range listing.Files {
+			files = append(files, f.Name)
+		}
+		if strings.Join(dirs, " ") != test.dirs || strings.Join(files, " ") != test.files {
+			t.Errorf("Browse %q = dirs %v, files %v, expected %q and %q", test.path, dirs, files, test.dirs, test.files)
+		}
+	}
+
+	send := NewFileServer("send", root, 8080, false)
+	rec := httptest.NewRecorder()
+	send.handleBrowse(rec, httptest.NewRequest("GET", "/api/browse", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Browse in send mode = %d, expected 400", rec.Code)
+	}
+}
+
+// Test a served directory downloads file by file or as a zip, read-only
+func TestServeDownloads(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "docs"), 0755)
+	os.WriteFile(filepath.Join(root, "docs", "report.pdf"), []byte("report"), 0644)
+
+	fs := NewFileServer("serve", root, 8080, false)
+	tests := []struct {
+		method string
+		name   string
+		status int
+		body   string
+	}{
+		{"GET", "docs/report.pdf", http.StatusOK, "report"},
+		{"GET", "docs", http.StatusNotFound, ""},
+		{"GET", "../etc/passwd", http.StatusBadRequest, ""},
+		{"DELETE", "docs/report.pdf", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleFile(rec, httptest.NewRequest(test.method, "/api/file?name="+url.QueryEscape(test.name), nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s = %d, expected %d", test.method, test.name, rec.Code, test.status)
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("%s %s body = %q, expected %q", test.method, test.name, rec.Body.String(), test.body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs", "report.pdf")); err != nil {
+		t.Errorf("Served file was removed: %v", err)
+	}
+	if snap := fs.stats.snapshot(fs.clock.Now()); len(snap.Downloads) != 1 || snap.Downloads[0].Name != "docs/report.pdf" || snap.Downloads[0].Count != 1 {
+		t.Errorf("Stats downloads = %+v, expected docs/report.pdf once", snap.Downloads)
+	}
+
+	rec := httptest.NewRecorder()
+	fs.handleDownload(rec, httptest.NewRequest("GET", "/api/download", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Download all = %d, expected 200: %s", rec.Code, rec.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("Download all is not a zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if !strings.Contains(strings.Join(names, " "), "docs/report.pdf") {
+		t.Errorf("Zip entries = %v, expected docs/report.pdf", names)
+	}
+}
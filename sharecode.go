@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// Short, distinct words that are easy to say and spell over a call.
+var (
+	codeAdjectives = []string{
+		"amber", "blue", "bold", "brave", "brisk", "calm", "clever", "cold",
+		"coral", "crisp", "dark", "eager", "early", "fast", "fierce", "fluffy",
+		"gentle", "giant", "glad", "golden", "green", "happy", "honest", "jolly",
+		"kind", "late", "lazy", "little", "lucky", "mellow", "mighty", "misty",
+		"noble", "odd", "orange", "pink", "plain", "polite", "proud", "purple",
+		"quick", "quiet", "rapid", "red", "rusty", "shiny", "silent", "silver",
+		"sleepy", "smooth", "snowy", "solid", "spicy", "steady", "sunny", "swift",
+		"tall", "tidy", "tiny", "violet", "warm", "wild", "wise", "yellow",
+	}
+	codeNouns = []string{
+		"apple", "badger", "bear", "beaver", "bison", "canyon", "cedar", "cloud",
+		"comet", "cookie", "crane", "delta", "dolphin", "eagle", "falcon", "fern",
+		"forest", "fox", "garden", "gecko", "harbor", "hawk", "island", "jaguar",
+		"koala", "lake", "lemon", "lion", "llama", "maple", "meadow", "moon",
+		"moose", "mountain", "otter", "owl", "panda", "parrot", "peach", "pebble",
+		"penguin", "pepper", "pine", "planet", "pony", "rabbit", "raven", "river",
+		"robin", "rocket", "salmon", "shark", "sparrow", "star", "storm", "tiger",
+		"tulip", "turtle", "valley", "walrus", "whale", "willow", "wolf", "zebra",
+	}
+)
+
+// newShareCode returns a random path code like "blue-tiger-42".
+func newShareCode() string {
+	return fmt.Sprintf("%s-%s-%d", randomChoice(codeAdjectives), randomChoice(codeNouns), randomInt(100))
+}
+
+func randomChoice(words []string) string {
+	return words[randomInt(len(words))]
+}
+
+func randomInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(v.Int64())
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// Test generated share codes
+func TestNewShareCode(t *testing.T) {
+	pattern := regexp.MustCompile(`^[a-z]+-[a-z]+-\d{1,2}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		code := newShareCode()
+		if !pattern.MatchString(code) {
+			t.Errorf("Unexpected share code format: %s", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 40 {
+		t.Errorf("Share codes should vary, got %d distinct out of 50", len(seen))
+	}
+}
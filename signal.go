@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// relayWait is how long one end of a relay waits for the other to arrive.
+const relayWait = 60 * time.Second
+
+// signalHub connects browsers in p2p mode: it forwards WebRTC offers,
+// answers and ICE candidates between them, and pipes a transfer through
+// the server when a direct connection can't be established.
+type signalHub struct {
+	mu     sync.Mutex
+	peers  map[string]*signalPeer
+	relays map[string]*relayPipe
+}
+
+type signalPeer struct {
+	label  string
+	frames chan string
+}
+
+type relayPipe struct {
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	paired chan struct{}
+	ends   int
+}
+
+type peerInfo struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+type signalMessage struct {
+	From string          `json:"from"`
+	To   string          `json:"to"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newSignalHub() *signalHub {
+	return &signalHub{
+		peers:  make(map[string]*signalPeer),
+		relays: make(map[string]*relayPipe),
+	}
+}
+
+func newPeerID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (h *signalHub) join(id, label string) *signalPeer {
+	peer := &signalPeer{label: label, frames: make(chan string, 32)}
+	h.mu.Lock()
+	h.peers[id] = peer
+	h.mu.Unlock()
+	h.announcePeers()
+	return peer
+}
+
+func (h *signalHub) leave(id string) {
+	h.mu.Lock()
+	delete(h.peers, id)
+	h.mu.Unlock()
+	h.announcePeers()
+}
+
+// announcePeers tells every browser which other browsers it can send to.
+func (h *signalHub) announcePeers() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, peer := range h.peers {
+		others := []peerInfo{}
+		for otherID, other := range h.peers {
+			if otherID != id {
+				others = append(others, peerInfo{ID: otherID, Label: other.label})
+			}
+		}
+		sort.Slice(others, func(i, j int) bool { return others[i].ID < others[j].ID })
+		data, _ := json.Marshal(others)
+		select {
+		case peer.frames <- sseFrame("peers", string(data)):
+		default:
+		}
+	}
+}
+
+// forward delivers msg to its recipient and reports whether it is connected.
+func (h *signalHub) forward(msg signalMessage) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	peer, ok := h.peers[msg.To]
+	if !ok {
+		return false
+	}
+	data, _ := json.Marshal(map[string]interface{}{"from": msg.From, "data": msg.Data})
+	select {
+	case peer.frames <- sseFrame("signal", string(data)):
+	default:
+	}
+	return true
+}
+
+// joinRelay returns the pipe for token, creating it for whichever end
+// arrives first.
+func (h *signalHub) joinRelay(token string) *relayPipe {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.relays[token]
+	if !ok {
+		reader, writer := io.Pipe()
+		p = &relayPipe{reader: reader, writer: writer, paired: make(chan struct{})}
+		h.relays[token] = p
+	}
+	p.ends++
+	if p.ends == 2 {
+		close(p.paired)
+		delete(h.relays, token)
+	}
+	return p
+}
+
+// waitRelay blocks until the other end of p arrives, dropping the pipe if
+// it doesn't within relayWait.
+func (h *signalHub) waitRelay(r *http.Request, token string, p *relayPipe) bool {
+	timer := time.NewTimer(relayWait)
+	defer timer.Stop()
+	select {
+	case <-p.paired:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	select {
+	case <-p.paired:
+		// The other end arrived just as we gave up.
+		return true
+	default:
+	}
+	if h.relays[token] == p {
+		delete(h.relays, token)
+	}
+	return false
+}
+
+// handleSignal streams signaling messages to a browser on GET and forwards
+// a message to another browser on POST.
+func (fs *FileServer) handleSignal(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "p2p" {
+		httpError(w, r, "Server is not in p2p mode", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		id := newPeerID()
+		label := fs.clientLabel(fs.getClientIP(r))
+		peer := fs.signals.join(id, label)
+		defer fs.signals.leave(id)
+		fs.logRequest(r, fmt.Sprintf("Browser %s joined", label))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		defer endSSE(w)
+		if writeSSE(w, sseFrame("welcome", fmt.Sprintf(`{"id":"%s"}`, id))) != nil {
+			return
+		}
+
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			var frame string
+			select {
+			case frame = <-peer.frames:
+			case <-ticker.C:
+				frame = ":heartbeat\n\n"
+			case <-r.Context().Done():
+				return
+			}
+			if writeSSE(w, frame) != nil {
+				return
+			}
+		}
+	case http.MethodPost:
+		var msg signalMessage
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&msg); err != nil || msg.To == "" {
+			httpError(w, r, "Invalid signal message", http.StatusBadRequest)
+			return
+		}
+		if !fs.signals.forward(msg) {
+			httpError(w, r, "Peer not connected", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRelay pipes a POSTed file to the browser that GETs the same token,
+// for peers that can't reach each other directly.
+func (fs *FileServer) handleRelay(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "p2p" {
+		httpError(w, r, "Server is not in p2p mode", http.StatusBadRequest)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if len(token) < 8 {
+		httpError(w, r, "Missing relay token", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := fs.signals.joinRelay(token)
+	if !fs.signals.waitRelay(r, token, p) {
+		httpError(w, r, "The other browser did not connect", http.StatusGatewayTimeout)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		n, err := io.Copy(p.writer, r.Body)
+		p.writer.CloseWithError(err)
+		if err != nil {
+			httpError(w, r, "Relay interrupted", http.StatusBadGateway)
+			return
+		}
+		fs.logRequest(r, fmt.Sprintf("Relayed %s between browsers", formatSize(n)))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"relayed","size":%d}`, n)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if safe, err := sanitizeFilename(name); err == nil {
+		w.Header().Set("Content-Disposition", contentDisposition(safe))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, err := io.Copy(w, p.reader)
+	p.reader.CloseWithError(err)
+}
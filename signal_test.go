@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSSEEvent returns the data of the next SSE frame with the given event name
+func readSSEEvent(t *testing.T, reader *bufio.Reader, event string) string {
+	t.Helper()
+	current := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading event %s: %v", event, err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && current == event:
+			return strings.TrimPrefix(line, "data: ")
+		case line == "":
+			current = ""
+		}
+	}
+}
+
+// Test forwarding signaling messages between two browsers
+func TestSignalForwarding(t *testing.T) {
+	fs := NewFileServer("p2p", "", 8080, false)
+	server := httptest.NewServer(http.HandlerFunc(fs.handleSignal))
+	defer server.Close()
+
+	respA, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer respA.Body.Close()
+	readerA := bufio.NewReader(respA.Body)
+	idA := strings.TrimSuffix(strings.TrimPrefix(readSSEEvent(t, readerA, "welcome"), `{"id":"`), `"}`)
+
+	respB, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer respB.Body.Close()
+	readerB := bufio.NewReader(respB.Body)
+	idB := strings.TrimSuffix(strings.TrimPrefix(readSSEEvent(t, readerB, "welcome"), `{"id":"`), `"}`)
+
+	if peers := readSSEEvent(t, readerA, "peers"); peers != "[]" {
+		t.Errorf("First browser should initially see no peers, got %s", peers)
+	}
+	if peers := readSSEEvent(t, readerA, "peers"); !strings.Contains(peers, idB) {
+		t.Errorf("First browser should see the second one join, got %s", peers)
+	}
+
+	body := `{"from":"` + idA + `","to":"` + idB + `","data":{"type":"offer"}}`
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST signal failed: %v %v", err, resp)
+	}
+	if msg := readSSEEvent(t, readerB, "signal"); !strings.Contains(msg, idA) || !strings.Contains(msg, `"data":{"type":"offer"}`) {
+		t.Errorf("Unexpected forwarded signal: %s", msg)
+	}
+
+	resp, _ = http.Post(server.URL, "application/json", strings.NewReader(`{"to":"nobody","data":{}}`))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Signal to an unknown peer should be 404, got %d", resp.StatusCode)
+	}
+}
+
+// Test relaying a file between browsers through the server
+func TestRelay(t *testing.T) {
+	fs := NewFileServer("p2p", "", 8080, false)
+	server := httptest.NewServer(http.HandlerFunc(fs.handleRelay))
+	defer server.Close()
+
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := http.Get(server.URL + "?token=abcdef123456&name=photo.jpg")
+		if err != nil {
+			t.Errorf("Relay GET error: %v", err)
+		}
+		done <- resp
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	resp, err := http.Post(server.URL+"?token=abcdef123456", "application/octet-stream", strings.NewReader("image bytes"))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Relay POST failed: %v %v", err, resp)
+	}
+
+	got := <-done
+	defer got.Body.Close()
+	data, _ := io.ReadAll(got.Body)
+	if string(data) != "image bytes" {
+		t.Errorf("Relayed body = %q, expected %q", data, "image bytes")
+	}
+	if !strings.Contains(got.Header.Get("Content-Disposition"), "photo.jpg") {
+		t.Errorf("Relay should suggest the file name, got %q", got.Header.Get("Content-Disposition"))
+	}
+	if len(fs.signals.relays) != 0 {
+		t.Errorf("Relay pipe should be removed once paired, %d left", len(fs.signals.relays))
+	}
+
+	resp, _ = http.Get(server.URL + "?token=x")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Short relay token should be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signCookie carries the page's signature to the API calls it makes with
+// relative URLs.
+const signCookie = "fileshare-sig"
+
+func newSignKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// signature is the HMAC of a path (relative to the base path) and its
+// expiry, S3 presigned-URL style.
+func (fs *FileServer) signature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, fs.signKey)
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signQuery returns the query string that makes path valid until expires.
+func (fs *FileServer) signQuery(path string, expires time.Time) string {
+	unix := expires.Unix()
+	return "expires=" + strconv.FormatInt(unix, 10) + "&sig=" + fs.signature(path, unix)
+}
+
+// verifySignature checks sig for path; expired is set when the signature
+// is genuine but its time has passed.
+func (fs *FileServer) verifySignature(path, expires, sig string) (ok, expired bool) {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return false, false
+	}
+	if !hmac.Equal([]byte(sig), []byte(fs.signature(path, unix))) {
+		return false, false
+	}
+	if !fs.clock.Now().Before(time.Unix(unix, 0)) {
+		return false, true
+	}
+	return true, false
+}
+
+// signedExpiry returns the expiry of the signature r was let in with, so
+// links handed out in a response stop working at the same time.
+func signedExpiry(r *http.Request) string {
+	if expires := r.URL.Query().Get("expires"); expires != "" {
+		return expires
+	}
+	if cookie, err := r.Cookie(signCookie); err == nil {
+		expires, _, _ := strings.Cut(cookie.Value, ".")
+		return expires
+	}
+	return ""
+}
+
+// resign returns path with a signature expiring together with the one on
+// r, or path unchanged when signing is off.
+func (fs *FileServer) resign(r *http.Request, path string) string {
+	if fs.signKey == nil {
+		return path
+	}
+	unix, err := strconv.ParseInt(signedExpiry(r), 10, 64)
+	if err != nil {
+		return path
+	}
+	return path + "?" + fs.signQuery(path, time.Unix(unix, 0))
+}
+
+// signedURL appends the startup signature to a URL printed in the banner.
+func (fs *FileServer) signedURL(base, path string) string {
+	if fs.signKey == nil {
+		return base + path
+	}
+	return base + path + "?" + fs.signQuery(path, fs.signExpiry)
+}
+
+// withSignature rejects requests without a valid, unexpired signature in
+// the query or the page cookie. Admin tokens always pass so that new links
+// can be issued.
+func (fs *FileServer) withSignature(next http.Handler) http.Handler {
+	if fs.signKey == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if grant, ok := fs.credential(requestToken(r)); ok && grant.scope >= scopeAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		query := r.URL.Query()
+		ok, expired := fs.verifySignature(r.URL.Path, query.Get("expires"), query.Get("sig"))
+		if ok && r.URL.Path == "/" {
+			// The page fetches everything else with relative URLs.
+			expires := query.Get("expires")
+			unix, _ := strconv.ParseInt(expires, 10, 64)
+			http.SetCookie(w, &http.Cookie{
+				Name:     signCookie,
+				Value:    expires + "." + query.Get("sig"),
+				Path:     fs.basePath + "/",
+				Expires:  time.Unix(unix, 0),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		if !ok && !expired {
+			if cookie, err := r.Cookie(signCookie); err == nil {
+				expires, sig, _ := strings.Cut(cookie.Value, ".")
+				ok, expired = fs.verifySignature("/", expires, sig)
+			}
+		}
+		switch {
+		case ok:
+			next.ServeHTTP(w, r)
+		case expired:
+			auditNote(r, "expired link")
+			httpError(w, r, "This link has expired", http.StatusGone)
+		default:
+			auditNote(r, "missing or invalid signature")
+			httpError(w, r, "This link is not valid", http.StatusForbidden)
+		}
+	})
+}
+
+// handleSign issues a signed URL: POST /api/sign?path=/api/download&ttl=10m
+// with the admin token. The window defaults to -signed-ttl.
+func (fs *FileServer) handleSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !fs.requireAdmin(w, r) {
+		return
+	}
+	if fs.signKey == nil {
+		httpError(w, r, "Signed URLs are not enabled (-signed-ttl)", http.StatusNotFound)
+		return
+	}
+	path := cmp.Or(r.URL.Query().Get("path"), "/")
+	if !strings.HasPrefix(path, "/") {
+		httpError(w, r, "path must start with /", http.StatusBadRequest)
+		return
+	}
+	ttl := fs.signTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 {
+			httpError(w, r, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+	expires := fs.clock.Now().Add(ttl)
+	link := url.URL{Scheme: "http", Host: r.Host, Path: fs.basePath + path, RawQuery: fs.signQuery(path, expires)}
+	fs.logRequest(r, "Signed URL issued for "+path+" until "+expires.Format("15:04:05"))
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]string{"url": link.String(), "expires": expires.Format(time.RFC3339)})
+}
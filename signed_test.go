@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that only signed, unexpired requests get through
+func TestSignedURLs(t *testing.T) {
+	clock := &tickClock{now: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)}
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.clock = clock
+	fs.signKey = []byte("key")
+	fs.signTTL = time.Hour
+	fs.adminToken = "s3cret"
+	handler := fs.withSignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	expires := clock.now.Add(time.Hour)
+	page := "/?" + fs.signQuery("/", expires)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", page, nil))
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusOK || len(cookies) != 1 || !cookies[0].Expires.Equal(expires) {
+		t.Fatalf("Signed page = %d with cookies %v, expected 200 and a cookie until %v", rec.Code, cookies, expires)
+	}
+
+	tests := []struct {
+		target   string
+		cookie   bool
+		header   string
+		expected int
+	}{
+		{"/api/download", false, "", http.StatusForbidden},
+		{"/api/download?" + fs.signQuery("/api/download", expires), false, "", http.StatusOK},
+		{"/api/info?" + fs.signQuery("/api/download", expires), false, "", http.StatusForbidden},
+		{"/api/download?" + strings.Replace(fs.signQuery("/api/download", expires), "expires=1", "expires=2", 1), false, "", http.StatusForbidden},
+		{"/api/info", true, "", http.StatusOK},
+		{"/api/download?" + fs.signQuery("/api/download", clock.now), false, "", http.StatusGone},
+		{"/api/info", false, "Bearer s3cret", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.target, nil)
+		if test.cookie {
+			req.AddCookie(cookies[0])
+		}
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("GET %s (cookie %v) = %d, expected %d", test.target, test.cookie, rec.Code, test.expected)
+		}
+	}
+
+	// Once the window has passed, neither the link nor the cookie works.
+	clock.now = expires
+	for _, target := range []string{page, "/api/download?" + fs.signQuery("/api/download", expires)} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != http.StatusGone {
+			t.Errorf("Expired GET %s = %d, expected 410", target, rec.Code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/info", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGone {
+		t.Errorf("Expired cookie = %d, expected 410", rec.Code)
+	}
+}
+
+// Test issuing signed URLs through the admin API
+func TestHandleSign(t *testing.T) {
+	clock := &tickClock{now: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)}
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.clock = clock
+	fs.signKey = []byte("key")
+	fs.signTTL = time.Hour
+	fs.adminToken = "s3cret"
+	fs.basePath = "/blue-tiger-42"
+
+	tests := []struct {
+		query    string
+		token    string
+		expected int
+		path     string
+		expires  time.Time
+	}{
+		{"", "", http.StatusUnauthorized, "", time.Time{}},
+		{"", "s3cret", http.StatusOK, "/", clock.now.Add(time.Hour)},
+		{"?path=/api/download&ttl=10m", "s3cret", http.StatusOK, "/api/download", clock.now.Add(10 * time.Minute)},
+		{"?ttl=soon", "s3cret", http.StatusBadRequest, "", time.Time{}},
+		{"?path=api", "s3cret", http.StatusBadRequest, "", time.Time{}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/api/sign"+test.query, nil)
+		req.Host = "192.168.1.5:8080"
+		req.Header.Set("X-Admin-Token", test.token)
+		rec := httptest.NewRecorder()
+		fs.handleSign(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("POST /api/sign%s = %d, expected %d", test.query, rec.Code, test.expected)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var reply map[string]string
+		json.NewDecoder(rec.Body).Decode(&reply)
+		link, err := url.Parse(reply["url"])
+		if err != nil || link.Host != "192.168.1.5:8080" || link.Path != "/blue-tiger-42"+test.path {
+			t.Errorf("Signed URL %q, expected %s on 192.168.1.5:8080", reply["url"], test.path)
+			continue
+		}
+		q := link.Query()
+		if ok, _ := fs.verifySignature(test.path, q.Get("expires"), q.Get("sig")); !ok {
+			t.Errorf("Signed URL %q does not verify", reply["url"])
+		}
+		if reply["expires"] != test.expires.Format(time.RFC3339) {
+			t.Errorf("Expiry %s, expected %s", reply["expires"], test.expires.Format(time.RFC3339))
+		}
+	}
+}
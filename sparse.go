@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// wholeFile is the single extent of a size-byte file without holes.
+func wholeFile(size int64) []byteRange {
+	if size == 0 {
+		return nil
+	}
+	return []byteRange{{0, size - 1}}
+}
+
+// sparseChunk is the most of one data extent fetched in a single range
+// request, so a mostly full image still spreads over the connections.
+const sparseChunk = 64 << 20
+
+// fileExtents is a shared file's size and where it holds data; anything
+// else reads as zeros.
+type fileExtents struct {
+	Size int64      `json:"size"`
+	Data [][2]int64 `json:"data"` // inclusive byte ranges
+}
+
+func (e fileExtents) dataSize() int64 {
+	var n int64
+	for _, d := range e.Data {
+		n += d[1] - d[0] + 1
+	}
+	return n
+}
+
+// handleExtents serves /api/download.extents, the data map of a single
+// shared file, so 'get' can skip the holes of sparse files such as VM disk
+// images instead of downloading their zeros.
+func (fs *FileServer) handleExtents(w http.ResponseWriter, r *http.Request) {
+	if fs.mode != "send" {
+		httpError(w, r, "Server is not in send mode", http.StatusBadRequest)
+		return
+	}
+	sources, isArchive, err := fs.shareSources()
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	if isArchive {
+		httpError(w, r, "Extents are only available for single files", http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(sources[0].path)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	extents := fileExtents{Size: info.Size(), Data: [][2]int64{}}
+	for _, d := range dataExtents(f, info.Size()) {
+		extents.Data = append(extents.Data, [2]int64{d.start, d.end})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(extents)
+}
+
+// fetchExtents asks the share at base where its file holds data. ok is
+// false when the server can't tell or the file has no holes worth skipping.
+func fetchExtents(base string, size int64) (fileExtents, bool) {
+	resp, err := http.Get(base + "api/download.extents")
+	if err != nil {
+		return fileExtents{}, false
+	}
+	defer resp.Body.Close()
+	var extents fileExtents
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&extents) != nil {
+		return fileExtents{}, false
+	}
+	if extents.Size != size || extents.dataSize() >= size {
+		return fileExtents{}, false
+	}
+	for _, d := range extents.Data {
+		if d[0] < 0 || d[1] < d[0] || d[1] >= size {
+			return fileExtents{}, false
+		}
+	}
+	return extents, true
+}
+
+// fetchShareSparse saves the download described by head into dir as a
+// sparse file, fetching only the data extents over n connections.
+func fetchShareSparse(url, dir string, head *http.Response, extents fileExtents, n int, bar *progressBar) (string, int64, error) {
+	name, err := responseFilename(head.Header)
+	if err != nil {
+		return "", 0, err
+	}
+	dst, savePath, err := createUploadFile(dir, name, conflictRename, time.Now())
+	if err != nil {
+		return "", 0, err
+	}
+	var ranges []byteRange
+	for _, d := range extents.Data {
+		for start := d[0]; start <= d[1]; start += sparseChunk {
+			ranges = append(ranges, byteRange{start, min(start+sparseChunk-1, d[1])})
+		}
+	}
+	fmt.Printf("Sparse file: fetching %s of data out of %s\n", formatSize(extents.dataSize()), formatSize(extents.Size))
+	err = dst.Truncate(extents.Size)
+	if err == nil {
+		bar.begin(0, extents.dataSize())
+		err = fetchRanges(url, ranges, n, progressWriterAt{dst, bar})
+		bar.end()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(savePath)
+		return "", 0, err
+	}
+	return savePath, extents.Size, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// Test downloading a sparse file by its data extents
+func TestSparseFetch(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "disk.img")
+	const size = 20 << 20
+	f, _ := os.Create(target)
+	f.Truncate(size)
+	f.WriteAt([]byte("boot sector"), 0)
+	f.WriteAt(bytes.Repeat([]byte{0xaa}, 8192), 16<<20)
+	f.Close()
+
+	fs := NewFileServer("send", target, 0, false)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/download", fs.handleDownload)
+	mux.HandleFunc("/api/download.extents", fs.handleExtents)
+	var requested atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if r.Method == http.MethodGet && r.URL.Path == "/api/download" {
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+				requested.Add(end - start + 1)
+			} else {
+				requested.Add(size)
+			}
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	rec := httptest.NewRecorder()
+	fs.handleExtents(rec, httptest.NewRequest("GET", "/api/download.extents", nil))
+	var extents fileExtents
+	if err := json.Unmarshal(rec.Body.Bytes(), &extents); err != nil || extents.Size != size {
+		t.Fatalf("Unexpected extents %s (%v)", rec.Body, err)
+	}
+	covered := func(off int64) bool {
+		for _, d := range extents.Data {
+			if d[0] <= off && off <= d[1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, off := range []int64{0, 10, 16 << 20, 16<<20 + 8191} {
+		if !covered(off) {
+			t.Errorf("Data at %d is outside the extents %v", off, extents.Data)
+		}
+	}
+
+	outDir := filepath.Join(tempDir, "out")
+	os.Mkdir(outDir, 0755)
+	savePath, n, err := fetchShare(server.URL+"/", outDir, 3, nil)
+	if err != nil {
+		t.Fatalf("fetchShare error: %v", err)
+	}
+	got, _ := os.ReadFile(savePath)
+	want, _ := os.ReadFile(target)
+	if n != size || !bytes.Equal(got, want) {
+		t.Errorf("Downloaded %d bytes that differ from the shared image", n)
+	}
+	if requested.Load() != extents.dataSize() {
+		t.Errorf("Requested %d bytes, expected only the %d bytes of data", requested.Load(), extents.dataSize())
+	}
+
+	dir := NewFileServer("send", tempDir, 0, false)
+	rec = httptest.NewRecorder()
+	dir.handleExtents(rec, httptest.NewRequest("GET", "/api/download.extents", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Extents of a folder share returned %d, expected 404", rec.Code)
+	}
+}
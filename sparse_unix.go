@@ -0,0 +1,34 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataExtents returns the ranges of f that hold data, skipping holes. When
+// the filesystem can't tell, the whole file is one extent.
+func dataExtents(f *os.File, size int64) []byteRange {
+	var extents []byteRange
+	for off := int64(0); off < size; {
+		start, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// Only a hole is left.
+			break
+		}
+		if err != nil {
+			return wholeFile(size)
+		}
+		end, err := f.Seek(start, unix.SEEK_HOLE)
+		if err != nil {
+			return wholeFile(size)
+		}
+		end = min(end, size)
+		extents = append(extents, byteRange{start, end - 1})
+		off = end
+	}
+	return extents
+}
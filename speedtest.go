@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSpeedtestBytes = 100 << 20
+	maxSpeedtestBytes     = 1 << 30
+)
+
+type speedtestResult struct {
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	Mbps    float64 `json:"mbps"`
+}
+
+func newSpeedtestResult(n int64, elapsed time.Duration) speedtestResult {
+	secs := elapsed.Seconds()
+	result := speedtestResult{Bytes: n, Seconds: secs}
+	if secs > 0 {
+		result.Mbps = float64(n) * 8 / secs / 1e6
+	}
+	return result
+}
+
+// speedtestReader yields n bytes of incompressible data without allocating
+// more than one buffer.
+type speedtestReader struct {
+	block     []byte
+	remaining int64
+}
+
+func newSpeedtestReader(n int64) *speedtestReader {
+	block := make([]byte, 64*1024)
+	rand.Read(block)
+	return &speedtestReader{block: block, remaining: n}
+}
+
+func (s *speedtestReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n := copy(p, s.block)
+	s.remaining -= int64(n)
+	return n, nil
+}
+
+func (fs *FileServer) handleSpeedtest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		size := int64(defaultSpeedtestBytes)
+		if v := r.URL.Query().Get("bytes"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				httpError(w, r, "Invalid bytes parameter", http.StatusBadRequest)
+				return
+			}
+			size = n
+		}
+		if size > maxSpeedtestBytes {
+			size = maxSpeedtestBytes
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		io.Copy(w, newSpeedtestReader(size))
+	case http.MethodPost:
+		start := time.Now()
+		n, err := io.Copy(io.Discard, io.LimitReader(r.Body, maxSpeedtestBytes))
+		if err != nil {
+			httpError(w, r, "Failed to read upload", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newSpeedtestResult(n, time.Since(start)))
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func speedtestURL(base string) string {
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	return strings.TrimRight(base, "/") + "/api/speedtest"
+}
+
+func runSpeedtest(args []string) int {
+	flags := flag.NewFlagSet("speedtest", flag.ExitOnError)
+	size := flags.Int64("bytes", 100<<20, "Bytes to transfer in each direction")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s speedtest [-bytes n] <url>\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 1
+	}
+	target := speedtestURL(flags.Arg(0))
+
+	fmt.Printf("Testing against %s (%s each way)\n", target, formatSize(*size))
+
+	down, err := speedtestDownload(target, *size)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: download test failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%sDownload: %.1f Mbit/s (%s in %.2fs)\n", icon("⬇️  "), down.Mbps, formatSize(down.Bytes), down.Seconds)
+
+	up, err := speedtestUpload(target, *size)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: upload test failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%sUpload:   %.1f Mbit/s (%s in %.2fs)\n", icon("⬆️  "), up.Mbps, formatSize(up.Bytes), up.Seconds)
+	return 0
+}
+
+func speedtestDownload(target string, size int64) (speedtestResult, error) {
+	start := time.Now()
+	resp, err := http.Get(fmt.Sprintf("%s?bytes=%d", target, size))
+	if err != nil {
+		return speedtestResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return speedtestResult{}, fmt.Errorf("server returned %s", resp.Status)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return speedtestResult{}, err
+	}
+	return newSpeedtestResult(n, time.Since(start)), nil
+}
+
+func speedtestUpload(target string, size int64) (speedtestResult, error) {
+	resp, err := http.Post(target, "application/octet-stream", newSpeedtestReader(size))
+	if err != nil {
+		return speedtestResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return speedtestResult{}, fmt.Errorf("server returned %s", resp.Status)
+	}
+	var result speedtestResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return speedtestResult{}, err
+	}
+	return result, nil
+}
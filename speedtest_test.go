@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test speedtest endpoint in both directions
+func TestSpeedtest(t *testing.T) {
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	ts := httptest.NewServer(http.HandlerFunc(fs.handleSpeedtest))
+	defer ts.Close()
+
+	down, err := speedtestDownload(ts.URL, 300*1024)
+	if err != nil {
+		t.Fatalf("speedtestDownload error: %v", err)
+	}
+	if down.Bytes != 300*1024 {
+		t.Errorf("Downloaded %d bytes, expected %d", down.Bytes, 300*1024)
+	}
+
+	up, err := speedtestUpload(ts.URL, 200*1024)
+	if err != nil {
+		t.Fatalf("speedtestUpload error: %v", err)
+	}
+	if up.Bytes != 200*1024 {
+		t.Errorf("Server received %d bytes, expected %d", up.Bytes, 200*1024)
+	}
+
+	resp, err := http.Get(ts.URL + "?bytes=-1")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Negative size should be rejected, got %d", resp.StatusCode)
+	}
+
+	if got := speedtestURL("192.168.1.2:8080/"); got != "http://192.168.1.2:8080/api/speedtest" {
+		t.Errorf("speedtestURL = %s", got)
+	}
+}
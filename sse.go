@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSSEHeartbeat = 500 * time.Millisecond
+	// sseWriteTimeout bounds each event stream write, so a client that
+	// vanished without closing its connection (sleeping laptop, dropped
+	// Wi-Fi) is noticed once the socket buffers fill instead of blocking
+	// its handler, and its sseClients entry, forever.
+	sseWriteTimeout = 10 * time.Second
+	// sseQueueLimit is how many frames wait for a slow page before its
+	// events are dropped. Status frames are never dropped: a newer one
+	// replaces those still waiting.
+	sseQueueLimit = 32
+)
+
+type sseQueued struct {
+	frame  string
+	status bool
+}
+
+// sseClient queues frames for one event stream.
+type sseClient struct {
+	mu      sync.Mutex
+	queue   []sseQueued
+	limit   int
+	dropped int // events dropped since the page was last told
+	wake    chan struct{}
+}
+
+func newSSEClient(limit int) *sseClient {
+	return &sseClient{limit: limit, wake: make(chan struct{}, 1)}
+}
+
+// push queues frame, reporting false if it was an event dropped because
+// the queue is full. A status frame that doesn't fit replaces the queued
+// status frames instead.
+func (c *sseClient) push(frame string, status bool) bool {
+	c.mu.Lock()
+	if len(c.queue) >= c.limit {
+		if !status {
+			c.dropped++
+			c.mu.Unlock()
+			return false
+		}
+		c.queue = slices.DeleteFunc(c.queue, func(q sseQueued) bool { return q.status })
+	}
+	c.queue = append(c.queue, sseQueued{frame, status})
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// take returns the queued frames, followed by a "dropped" event if any
+// were lost so the page knows to refetch.
+func (c *sseClient) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frames := make([]string, 0, len(c.queue)+1)
+	for _, q := range c.queue {
+		frames = append(frames, q.frame)
+	}
+	c.queue = c.queue[:0]
+	if c.dropped > 0 {
+		frames = append(frames, sseFrame("dropped", fmt.Sprintf(`{"dropped":%d}`, c.dropped)))
+		c.dropped = 0
+	}
+	return frames
+}
+
+// writeSSE sends frame on an event stream. An error means the client is
+// gone and the stream should end.
+func writeSSE(w http.ResponseWriter, frame string) error {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+	if _, err := io.WriteString(w, frame); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// endSSE clears the write deadline set by writeSSE, in case the
+// connection is reused after the stream.
+func endSSE(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brokenWriter fails every write after the first okWrites, like a
+// connection whose client has gone away.
+type brokenWriter struct {
+	header   http.Header
+	okWrites int
+	writes   int
+}
+
+func (b *brokenWriter) Header() http.Header { return b.header }
+func (b *brokenWriter) WriteHeader(int)     {}
+func (b *brokenWriter) Flush()              {}
+
+func (b *brokenWriter) Write(p []byte) (int, error) {
+	b.writes++
+	if b.writes > b.okWrites {
+		return 0, errors.New("broken pipe")
+	}
+	return len(p), nil
+}
+
+// Test that event streams to vanished clients are dropped
+func TestSSEDeadClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		okWrites int
+	}{
+		{"initial status", 0},
+		{"heartbeat", 1},
+		{"several heartbeats", 3},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("send", "/tmp", 8080, false)
+		fs.heartbeat = 5 * time.Millisecond
+		w := &brokenWriter{header: http.Header{}, okWrites: test.okWrites}
+
+		done := make(chan struct{})
+		go func() {
+			fs.handleEvents(w, httptest.NewRequest("GET", "/api/events", nil))
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: event stream kept running after write errors", test.name)
+		}
+		fs.sseMu.RLock()
+		clients := len(fs.sseClients)
+		fs.sseMu.RUnlock()
+		if clients != 0 {
+			t.Errorf("%s: %d SSE clients left, expected 0", test.name, clients)
+		}
+		if w.writes != test.okWrites+1 {
+			t.Errorf("%s: %d writes, expected %d", test.name, w.writes, test.okWrites+1)
+		}
+	}
+}
+
+// Test that a slow page gets the latest status and learns of dropped events
+func TestSSEBackpressure(t *testing.T) {
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	client := newSSEClient(3)
+	fs.addSSEClient(client)
+
+	fs.broadcast("files", `{"changes":1}`)
+	fs.status.Progress = 10
+	fs.broadcastStatus()
+	fs.broadcast("files", `{"changes":2}`)
+	// The queue is full from here on.
+	fs.broadcast("files", `{"changes":3}`)
+	fs.broadcast("clients", `[]`)
+	fs.status.Progress = 20
+	fs.broadcastStatus()
+	fs.status.Progress = 30
+	fs.broadcastStatus()
+
+	tests := []struct {
+		frames   []string
+		expected []string
+	}{
+		{client.take(), []string{`event: files`, `event: files`, `"progress":30.00`, `event: dropped` + "\n" + `data: {"dropped":2}`}},
+		// Once read, the page is no longer behind.
+		{client.take(), nil},
+	}
+	for i, test := range tests {
+		if len(test.frames) != len(test.expected) {
+			t.Errorf("Read %d: got %q, expected %d frames", i, test.frames, len(test.expected))
+			continue
+		}
+		for j, want := range test.expected {
+			if !strings.Contains(test.frames[j], want) {
+				t.Errorf("Read %d: frame %d = %q, expected it to contain %q", i, j, test.frames[j], want)
+			}
+		}
+	}
+	if dropped := fs.sseDropped.Load(); dropped != 2 {
+		t.Errorf("sseDropped = %d, expected 2", dropped)
+	}
+}
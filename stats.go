@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// statsWindow is how many one-second throughput samples are kept.
+const statsWindow = 60
+
+// throughputStats counts bytes per second over the last statsWindow
+// seconds, plus running totals, for /api/stats. The zero value is ready.
+type throughputStats struct {
+	mu      sync.Mutex
+	samples [statsWindow]int64
+	newest  int64 // unix second of the newest sample
+
+	sent      int64
+	received  int64
+	started   int
+	completed int
+	failed    int
+	cancelled int
+
+	downloads map[string]*downloadTally
+	packings  map[string]*statsCompression
+}
+
+// downloadTally counts the completed downloads of one file or share.
+type downloadTally struct {
+	count   int
+	last    time.Time
+	clients map[string]int
+}
+
+type statsSample struct {
+	Time  int64 `json:"time"`
+	Bytes int64 `json:"bytes"`
+}
+
+type statsSnapshot struct {
+	UptimeSecs int64 `json:"uptime_secs"`
+	Totals     struct {
+		BytesSent     int64 `json:"bytes_sent"`
+		BytesReceived int64 `json:"bytes_received"`
+	} `json:"totals"`
+	Transfers struct {
+		Started   int `json:"started"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+		Cancelled int `json:"cancelled"`
+	} `json:"transfers"`
+	// CurrentBPS is the throughput of the last whole second.
+	CurrentBPS int64         `json:"current_bps"`
+	Samples    []statsSample `json:"samples"`
+	// Downloads counts completed downloads per file, most downloaded first.
+	Downloads []statsDownload `json:"downloads"`
+	// Compression totals completed downloads that were gzipped or zipped,
+	// per method.
+	Compression []statsCompression `json:"compression"`
+}
+
+type statsCompression struct {
+	Method    string  `json:"method"`
+	Downloads int     `json:"downloads"`
+	RawBytes  int64   `json:"raw_bytes"`
+	WireBytes int64   `json:"wire_bytes"`
+	Ratio     float64 `json:"ratio"`
+}
+
+type statsDownload struct {
+	Name    string        `json:"name"`
+	Count   int           `json:"count"`
+	Last    time.Time     `json:"last"`
+	Clients []statsClient `json:"clients"`
+}
+
+type statsClient struct {
+	Client string `json:"client"`
+	Count  int    `json:"count"`
+}
+
+// advance moves the window forward to sec, zeroing the seconds skipped.
+// The caller holds s.mu.
+func (s *throughputStats) advance(sec int64) {
+	if sec <= s.newest {
+		return
+	}
+	if sec-s.newest >= statsWindow {
+		s.samples = [statsWindow]int64{}
+	} else {
+		for t := s.newest + 1; t <= sec; t++ {
+			s.samples[t%statsWindow] = 0
+		}
+	}
+	s.newest = sec
+}
+
+func (s *throughputStats) add(now time.Time, n int64, received bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance(now.Unix())
+	s.samples[s.newest%statsWindow] += n
+	if received {
+		s.received += n
+	} else {
+		s.sent += n
+	}
+}
+
+// count records a transfer reaching state ("started", "completed",
+// "error" or "cancelled").
+func (s *throughputStats) count(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch state {
+	case "started":
+		s.started++
+	case "completed":
+		s.completed++
+	case "error":
+		s.failed++
+	case "cancelled":
+		s.cancelled++
+	}
+}
+
+// downloaded records a completed download of name by client.
+func (s *throughputStats) downloaded(name, client string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.downloads == nil {
+		s.downloads = make(map[string]*downloadTally)
+	}
+	d := s.downloads[name]
+	if d == nil {
+		d = &downloadTally{clients: make(map[string]int)}
+		s.downloads[name] = d
+	}
+	d.count++
+	d.last = now
+	d.clients[client]++
+}
+
+// packed records the packing of a completed download.
+func (s *throughputStats) packed(p packing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.packings == nil {
+		s.packings = make(map[string]*statsCompression)
+	}
+	c := s.packings[p.method]
+	if c == nil {
+		c = &statsCompression{Method: p.method}
+		s.packings[p.method] = c
+	}
+	c.Downloads++
+	c.RawBytes += p.raw
+	c.WireBytes += p.wire
+	c.Ratio = packing{raw: c.RawBytes, wire: c.WireBytes}.ratio()
+}
+
+func (s *throughputStats) snapshot(now time.Time) statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance(now.Unix())
+
+	var snap statsSnapshot
+	snap.Totals.BytesSent = s.sent
+	snap.Totals.BytesReceived = s.received
+	snap.Transfers.Started = s.started
+	snap.Transfers.Completed = s.completed
+	snap.Transfers.Failed = s.failed
+	snap.Transfers.Cancelled = s.cancelled
+	snap.CurrentBPS = s.samples[(s.newest-1)%statsWindow]
+	snap.Samples = make([]statsSample, 0, statsWindow)
+	for t := s.newest - statsWindow + 1; t <= s.newest; t++ {
+		snap.Samples = append(snap.Samples, statsSample{Time: t, Bytes: s.samples[t%statsWindow]})
+	}
+	snap.Downloads = make([]statsDownload, 0, len(s.downloads))
+	for name, d := range s.downloads {
+		download := statsDownload{Name: name, Count: d.count, Last: d.last}
+		for client, count := range d.clients {
+			download.Clients = append(download.Clients, statsClient{Client: client, Count: count})
+		}
+		slices.SortFunc(download.Clients, func(a, b statsClient) int {
+			return cmp.Or(b.Count-a.Count, cmp.Compare(a.Client, b.Client))
+		})
+		snap.Downloads = append(snap.Downloads, download)
+	}
+	slices.SortFunc(snap.Downloads, func(a, b statsDownload) int {
+		return cmp.Or(b.Count-a.Count, cmp.Compare(a.Name, b.Name))
+	})
+	snap.Compression = make([]statsCompression, 0, len(s.packings))
+	for _, c := range s.packings {
+		snap.Compression = append(snap.Compression, *c)
+	}
+	slices.SortFunc(snap.Compression, func(a, b statsCompression) int {
+		return cmp.Compare(a.Method, b.Method)
+	})
+	return snap
+}
+
+// countDownload records that clientIP finished downloading name.
+func (fs *FileServer) countDownload(name, clientIP string) {
+	fs.stats.downloaded(name, fs.clientLabel(clientIP), fs.clock.Now())
+}
+
+func (fs *FileServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	now := fs.clock.Now()
+	snap := fs.stats.snapshot(now)
+	snap.UptimeSecs = int64(now.Sub(fs.started).Seconds())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Test rolling throughput samples
+func TestThroughputStats(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var stats throughputStats
+	stats.add(start, 100, false)
+	stats.add(start.Add(500*time.Millisecond), 50, false)
+	stats.add(start.Add(time.Second), 200, true)
+	stats.add(start.Add(3*time.Second), 400, false)
+
+	snap := stats.snapshot(start.Add(3 * time.Second))
+	if len(snap.Samples) != statsWindow {
+		t.Fatalf("Expected %d samples, got %d", statsWindow, len(snap.Samples))
+	}
+	tests := []struct {
+		ago      int
+		expected int64
+	}{
+		{0, 400},
+		{1, 0},
+		{2, 200},
+		{3, 150},
+		{4, 0},
+	}
+	for _, test := range tests {
+		sample := snap.Samples[statsWindow-1-test.ago]
+		if sample.Time != start.Unix()+3-int64(test.ago) || sample.Bytes != test.expected {
+			t.Errorf("Sample %ds ago = %+v, expected %d bytes", test.ago, sample, test.expected)
+		}
+	}
+	if snap.CurrentBPS != 0 || snap.Totals.BytesSent != 550 || snap.Totals.BytesReceived != 200 {
+		t.Errorf("Unexpected totals: %+v", snap)
+	}
+
+	// Samples older than the window drop out.
+	snap = stats.snapshot(start.Add((statsWindow + 1) * time.Second))
+	for _, sample := range snap.Samples {
+		if sample.Bytes != 0 && sample.Time < start.Unix()+3 {
+			t.Errorf("Sample %+v should have left the window", sample)
+		}
+	}
+	snap = stats.snapshot(start.Add(10 * time.Minute))
+	for _, sample := range snap.Samples {
+		if sample.Bytes != 0 {
+			t.Errorf("Sample %+v should have left the window", sample)
+		}
+	}
+	if snap.Totals.BytesSent != 550 {
+		t.Errorf("Totals should outlive the window, got %d", snap.Totals.BytesSent)
+	}
+}
+
+// Test the stats endpoint after a download
+func TestHandleStats(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_stats_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.WriteFile(filepath.Join(tempDir, "data.txt"), make([]byte, 5000), 0644)
+
+	fs := NewFileServer("send", filepath.Join(tempDir, "data.txt"), 8080, false)
+	fs.handleDownload(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/download", nil))
+	fs.handleDownload(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/download", nil))
+
+	rec := httptest.NewRecorder()
+	fs.handleStats(rec, httptest.NewRequest("GET", "/api/stats", nil))
+	var snap statsSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("Invalid stats JSON: %v", err)
+	}
+	if snap.Totals.BytesSent != 10000 || snap.Transfers.Started != 2 || snap.Transfers.Completed != 2 {
+		t.Errorf("Unexpected stats after two downloads: %s", rec.Body.String())
+	}
+	var windowed int64
+	for _, sample := range snap.Samples {
+		windowed += sample.Bytes
+	}
+	if windowed != 10000 {
+		t.Errorf("Samples add up to %d, expected 10000", windowed)
+	}
+	if len(snap.Downloads) != 1 || snap.Downloads[0].Name != "data.txt" || snap.Downloads[0].Count != 2 ||
+		len(snap.Downloads[0].Clients) != 1 || snap.Downloads[0].Clients[0].Count != 2 {
+		t.Errorf("Unexpected download counts after two downloads: %+v", snap.Downloads)
+	}
+}
+
+// Test per-file download counters
+func TestDownloadCounts(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var stats throughputStats
+	stats.downloaded("release.tar.gz", "alice-laptop", start)
+	stats.downloaded("notes.txt", "bob", start.Add(time.Minute))
+	stats.downloaded("release.tar.gz", "bob", start.Add(2*time.Minute))
+	stats.downloaded("release.tar.gz", "bob", start.Add(3*time.Minute))
+
+	snap := stats.snapshot(start.Add(time.Hour))
+	tests := []struct {
+		name    string
+		count   int
+		last    time.Time
+		clients []statsClient
+	}{
+		{"release.tar.gz", 3, start.Add(3 * time.Minute), []statsClient{{"bob", 2}, {"alice-laptop", 1}}},
+		{"notes.txt", 1, start.Add(time.Minute), []statsClient{{"bob", 1}}},
+	}
+	if len(snap.Downloads) != len(tests) {
+		t.Fatalf("Expected %d files, got %+v", len(tests), snap.Downloads)
+	}
+	for i, test := range tests {
+		d := snap.Downloads[i]
+		if d.Name != test.name || d.Count != test.count || !d.Last.Equal(test.last) || !slices.Equal(d.Clients, test.clients) {
+			t.Errorf("Downloads[%d] = %+v, expected %s downloaded %d times by %v", i, d, test.name, test.count, test.clients)
+		}
+	}
+}
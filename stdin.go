@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// stdinName is what a share of standard input ("send -") is called
+// without -name.
+const stdinName = "stdin"
+
+// sharesStdin reports whether the share is standard input.
+func (fs *FileServer) sharesStdin() bool {
+	return fs.stdin != nil
+}
+
+func (fs *FileServer) setStdinHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", contentDisposition(fs.downloadFilename(false)))
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// serveStdin streams standard input to the client that downloads it
+// first. Its length isn't known, so the response goes out chunked, and a
+// pipe can only be read once: later downloads get 410 Gone, as does
+// everyone after a download that broke off.
+func (fs *FileServer) serveStdin(w http.ResponseWriter, r *http.Request, clientIP, clientLabel string) {
+	if !fs.stdinTaken.CompareAndSwap(false, true) {
+		httpError(w, r, "The piped data has already been sent", http.StatusGone)
+		return
+	}
+	fs.startTransfer(clientIP, -1)
+	fs.logRequest(r, fmt.Sprintf("Started streaming standard input to %s", clientLabel))
+	fs.setStdinHeaders(w)
+
+	hasher := sha256.New()
+	rc := http.NewResponseController(w)
+	buf := fs.copyBuffer()
+	var transferred int64
+	for {
+		n, err := fs.stdin.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				fs.failTransfer(writeErr)
+				return
+			}
+			// A command's output can trickle; pass each piece on rather
+			// than holding it until the buffer fills.
+			rc.Flush()
+			hasher.Write(buf[:n])
+			transferred += int64(n)
+			fs.updateProgress(transferred)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fs.failTransfer(err)
+			return
+		}
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	fs.completeTransfer(sum)
+	fs.countDownload(fs.downloadFilename(false), clientIP)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("Streamed %s of standard input to %s%s", formatSize(transferred), clientLabel, hashSuffix(sum)))
+	fs.report(outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP),
+		Name: fs.downloadFilename(false), Size: transferred, SHA256: sum},
+		fmt.Sprintf("\n%sStreamed %s to %s\n%s", icon("✓ "), formatSize(transferred), clientLabel, hashLine(sum)))
+}
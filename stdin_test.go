@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test streaming standard input to the first download
+func TestServeStdin(t *testing.T) {
+	dump := strings.Repeat("INSERT INTO t VALUES (1);\n", 10000)
+	fs, cleanup, err := newServer(Options{Mode: "send", Path: "-", DownloadName: "db.sql", Stdin: strings.NewReader(dump), FS: &fakeFS{}})
+	if err != nil {
+		t.Fatalf("newServer error: %v", err)
+	}
+	defer cleanup()
+	server := httptest.NewServer(http.HandlerFunc(fs.handleDownload))
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		code   int
+		body   string
+	}{
+		{"HEAD", http.StatusOK, ""},
+		{"GET", http.StatusOK, dump},
+		{"GET", http.StatusGone, ""},
+		{"HEAD", http.StatusGone, ""},
+	}
+	for i, test := range tests {
+		req, _ := http.NewRequest(test.method, server.URL+"/api/download", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%d: %s error: %v", i, test.method, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("%d: %s status %d, expected %d", i, test.method, resp.StatusCode, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		if resp.ContentLength != -1 || !strings.Contains(resp.Header.Get("Content-Disposition"), "db.sql") {
+			t.Errorf("%d: %s length %d, disposition %q; expected unknown length and db.sql", i, test.method, resp.ContentLength, resp.Header.Get("Content-Disposition"))
+		}
+		if test.body != "" && (string(body) != test.body || len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked") {
+			t.Errorf("%d: got %d bytes with transfer encoding %v, expected %d chunked", i, len(body), resp.TransferEncoding, len(test.body))
+		}
+	}
+	if fs.status.Status != "completed" || fs.status.Size != -1 || fs.status.Transferred != int64(len(dump)) {
+		t.Errorf("Status %s, size %d, %d transferred; expected completed, -1, %d", fs.status.Status, fs.status.Size, fs.status.Transferred, len(dump))
+	}
+}
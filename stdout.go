@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// stdoutRefused lists the receive endpoints that need files on disk,
+// refused when uploads go to standard output ("recv -").
+var stdoutRefused = map[string]bool{
+	"/api/upload/init":     true,
+	"/api/upload/part":     true,
+	"/api/upload/complete": true,
+	"/api/upload/abort":    true,
+	"/api/file":            true,
+	"/api/file/rename":     true,
+}
+
+// receivesToStdout reports whether uploads are written to standard output.
+func (fs *FileServer) receivesToStdout() bool {
+	return fs.stdout != nil
+}
+
+func (fs *FileServer) withStdoutOnly(next http.Handler) http.Handler {
+	if !fs.receivesToStdout() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stdoutRefused[r.URL.Path] {
+			httpError(w, r, "Uploads go to standard output; send the file in one request to /api/upload", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// receiveToStdout writes an upload to standard output for a command
+// reading the pipe. Only the first upload is taken, as two would run
+// together into one stream, and the server stops after it so the reader
+// sees the end of its input. One that breaks off leaves the reader with a
+// truncated stream, so it fails the run.
+func (fs *FileServer) receiveToStdout(w http.ResponseWriter, r *http.Request, file io.Reader, size int64, filename, clientIP, from string) {
+	if !fs.stdoutTaken.CompareAndSwap(false, true) {
+		httpError(w, r, "An upload has already been written to standard output", http.StatusGone)
+		return
+	}
+	defer fs.shutdown()
+
+	fs.startUpload(clientIP, size)
+	fs.logRequest(r, fmt.Sprintf("Started upload from %s to standard output: %s", from, filename))
+
+	hasher := sha256.New()
+	var transferred int64
+	buf := fs.copyBuffer()
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if _, writeErr := fs.stdout.Write(buf[:n]); writeErr != nil {
+				// The reading command exited.
+				fs.stdoutErr = fmt.Errorf("writing to standard output: %v", writeErr)
+				fs.failTransfer(writeErr)
+				httpError(w, r, "Failed to save file", http.StatusInternalServerError)
+				return
+			}
+			hasher.Write(buf[:n])
+			transferred += int64(n)
+			fs.updateProgress(transferred)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = r.Context().Err()
+		}
+		if err != nil {
+			fs.stdoutErr = fmt.Errorf("upload from %s interrupted after %s", from, formatSize(transferred))
+			fs.failTransfer(err)
+			fs.logRequest(r, fmt.Sprintf("Upload from %s interrupted: %s (%s received)", from, filename, formatSize(transferred)))
+			httpError(w, r, "Upload interrupted", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	fs.completeTransfer(sum)
+	auditHash(r, sum)
+	fs.logRequest(r, fmt.Sprintf("Upload completed from %s: %s (%s) written to standard output%s", from, filename, formatSize(transferred), hashSuffix(sum)))
+	fs.report(outputEvent{Event: "completed", Client: clientIP, ClientHost: fs.clientHost(clientIP),
+		Name: filename, Path: "-", Size: transferred, SHA256: sum},
+		fmt.Sprintf("\n%sPiped '%s' from %s (%s)\n%s", icon("✓ "), filename, from, formatSize(transferred), hashLine(sum)))
+	fs.addReceived(transferred)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"success","path":"-","name":"%s","size":%d,"sha256":"%s"}`, filename, transferred, sum)
+}
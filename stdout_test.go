@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test the first upload is written to standard output and the server stops
+func TestReceiveToStdout(t *testing.T) {
+	var out bytes.Buffer
+	fs, cleanup, err := newServer(Options{Mode: "recv", Path: "-", Stdout: &out, FS: &fakeFS{}})
+	if err != nil {
+		t.Fatalf("newServer error: %v", err)
+	}
+	defer cleanup()
+
+	tests := []struct {
+		filename string
+		content  string
+		code     int
+	}{
+		{"backup.tar.gz", "first archive", http.StatusOK},
+		{"other.tar.gz", "second archive", http.StatusGone},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		fs.handleUpload(rec, newUploadRequest(t, test.filename, test.content, map[string]string{"dir": "sub"}))
+		if rec.Code != test.code {
+			t.Errorf("Upload of %s: status %d, expected %d (%s)", test.filename, rec.Code, test.code, rec.Body.String())
+		}
+	}
+	if out.String() != "first archive" {
+		t.Errorf("Standard output got %q, expected only the first upload", out.String())
+	}
+	select {
+	case <-fs.done:
+	default:
+		t.Error("Server expected to stop after the upload")
+	}
+
+	rec := httptest.NewRecorder()
+	fs.withStdoutOnly(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("POST", "/api/upload/init", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Multipart upload init: status %d, expected 400", rec.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mediaKind returns "video" or "audio" when name can be played by an
+// HTML5 media element, and "" otherwise.
+func mediaKind(name string) string {
+	ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	switch {
+	case strings.HasPrefix(ctype, "video/"):
+		return "video"
+	case strings.HasPrefix(ctype, "audio/"):
+		return "audio"
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp4", ".m4v", ".webm", ".mov", ".mkv", ".ogv":
+		return "video"
+	case ".mp3", ".m4a", ".aac", ".ogg", ".oga", ".opus", ".wav", ".flac":
+		return "audio"
+	}
+	return ""
+}
+
+// shareMediaKind reports whether the current send target is a single
+// playable media file.
+func (fs *FileServer) shareMediaKind() string {
+	if fs.mode != "send" || len(fs.getSources()) > 0 {
+		return ""
+	}
+	return mediaKind(fs.getPath())
+}
+
+// handleStream serves a media share inline with full Range support so the
+// page's player can seek. Unlike /api/download it doesn't take the client
+// lock or mark the transfer completed; players issue many overlapping range
+// requests.
+func (fs *FileServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	if fs.shareMediaKind() == "" {
+		httpError(w, r, "The shared target is not a media file", http.StatusBadRequest)
+		return
+	}
+
+	target := fs.getPath()
+	f, err := os.Open(target)
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		httpError(w, r, "File not found", http.StatusNotFound)
+		return
+	}
+
+	defer fs.trackClient(r, activityStreaming)()
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-") {
+		fs.logRequest(r, fmt.Sprintf("%s started streaming %s", fs.clientLabel(fs.getClientIP(r)), info.Name()))
+	}
+
+	if ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(target))); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Content-Disposition", "inline")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
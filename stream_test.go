@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test media detection
+func TestMediaKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"movie.mp4", "video"},
+		{"Movie.MKV", "video"},
+		{"song.mp3", "audio"},
+		{"track.flac", "audio"},
+		{"report.pdf", ""},
+		{"noext", ""},
+	}
+	for _, test := range tests {
+		if result := mediaKind(test.name); result != test.expected {
+			t.Errorf("mediaKind(%s) = %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}
+
+// Test streaming with range requests
+func TestHandleStream(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_stream_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	movie := filepath.Join(tempDir, "movie.mp4")
+	os.WriteFile(movie, []byte("0123456789"), 0644)
+
+	fs := NewFileServer("send", movie, 8080, false)
+	req := httptest.NewRequest("GET", "/api/stream", nil)
+	req.Header.Set("Range", "bytes=4-6")
+	rec := httptest.NewRecorder()
+	fs.handleStream(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("Range request = %d, expected 206", rec.Code)
+	}
+	if rec.Body.String() != "456" {
+		t.Errorf("Range body = %q, expected 456", rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "video/mp4" {
+		t.Errorf("Content-Type = %s, expected video/mp4", rec.Header().Get("Content-Type"))
+	}
+	if fs.activeClient != "" {
+		t.Error("Streaming should not take the client lock")
+	}
+
+	other := NewFileServer("send", tempDir, 8080, false)
+	rec = httptest.NewRecorder()
+	other.handleStream(rec, httptest.NewRequest("GET", "/api/stream", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Streaming a directory = %d, expected 400", rec.Code)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hasGlobMeta reports whether p is a pattern. The volume is skipped, as
+// the ? of a \\?\ prefix is not a wildcard.
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p[len(filepath.VolumeName(p)):], "*?[")
+}
+
+// expandSendTarget resolves a send argument. A path that exists is used as
+// is; otherwise it is treated as a glob pattern (shells on Windows don't
+// expand them) and every match becomes part of the share.
+func expandSendTarget(fsys FileSystem, p string) ([]string, error) {
+	if _, err := fsys.Stat(p); err == nil || !hasGlobMeta(p) {
+		return nil, err
+	}
+	matches, err := fsys.Glob(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %v", p, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match '%s'", p)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// expandSendTargets resolves several send arguments into one list of
+// sources. Each becomes a top-level entry of the share, so two that would
+// get the same name are refused.
+func expandSendTargets(fsys FileSystem, paths []string) ([]string, error) {
+	var sources []string
+	seen := make(map[string]string)
+	for _, p := range paths {
+		matches, err := prepareTarget(fsys, "send", p)
+		if err != nil {
+			return nil, err
+		}
+		if matches == nil {
+			matches = []string{p}
+		}
+		for _, m := range matches {
+			name := filepath.Base(m)
+			if first, ok := seen[name]; ok {
+				return nil, fmt.Errorf("'%s' and '%s' would both be shared as '%s'", first, m, name)
+			}
+			seen[name] = m
+			sources = append(sources, m)
+		}
+	}
+	return sources, nil
+}
+
+func (fs *FileServer) getSources() []string {
+	fs.pathMu.RLock()
+	defer fs.pathMu.RUnlock()
+	return fs.sources
+}
+
+// shareSources describes what a download of the current send target
+// contains, and whether it has to be packed into an archive.
+// With -name set, archive entries are nested under a root folder of that
+// name. With -skip-hidden, hidden matches of a pattern are dropped and
+// hidden entries inside folders left out. A shared folder's ignore file is
+// applied to its contents.
+func (fs *FileServer) shareSources() ([]archiveSource, bool, error) {
+	root := ""
+	if fs.downloadName != "" {
+		root = strings.TrimSuffix(fs.downloadName, ".zip")
+	}
+
+	if matches := fs.getSources(); len(matches) > 0 {
+		sources := make([]archiveSource, 0, len(matches))
+		for _, m := range matches {
+			if fs.skipHidden {
+				if fi, err := os.Lstat(m); err == nil && isHidden(fi) {
+					continue
+				}
+			}
+			name := filepath.Base(m)
+			if root != "" {
+				name = root + "/" + name
+			}
+			sources = append(sources, archiveSource{path: m, name: name, skipHidden: fs.skipHidden, hardLinks: fs.hardLinks})
+		}
+		return sources, true, nil
+	}
+
+	target := fs.getPath()
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, false, err
+	}
+	if info.IsDir() {
+		rules, err := loadIgnoreRules(target, fs.gitignore)
+		if err != nil {
+			return nil, false, err
+		}
+		return []archiveSource{{path: target, name: root, skipHidden: fs.skipHidden, ignore: rules, hardLinks: fs.hardLinks}}, true, nil
+	}
+	return []archiveSource{{path: target, name: info.Name()}}, false, nil
+}
+
+// shareName is the base name used for the download and the status display.
+func (fs *FileServer) shareName() string {
+	if !modeNeedsPath(fs.mode) {
+		return fs.mode
+	}
+	if fs.downloadName != "" {
+		return strings.TrimSuffix(fs.downloadName, ".zip")
+	}
+	if fs.sharesStdin() {
+		return stdinName
+	}
+	if len(fs.getSources()) > 0 {
+		return "files"
+	}
+	return baseName(fs.getPath())
+}
+
+func (fs *FileServer) downloadFilename(isArchive bool) string {
+	if !isArchive {
+		if fs.downloadName != "" {
+			return fs.downloadName
+		}
+		if fs.sharesStdin() {
+			return stdinName
+		}
+		return baseName(fs.getPath())
+	}
+	return fs.shareName() + ".zip"
+}
+
+func contentDisposition(filename string) string {
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return "attachment"
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Test glob expansion of send targets
+func TestExpandSendTarget(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_glob_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, name := range []string{"a.log", "b.log", "c.txt"} {
+		os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644)
+	}
+
+	matches, err := expandSendTarget(osFS{}, filepath.Join(tempDir, "*.log"))
+	if err != nil {
+		t.Fatalf("expandSendTarget error: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Errorf("Expected 2 matches, got %v", matches)
+	}
+
+	if matches, err := expandSendTarget(osFS{}, filepath.Join(tempDir, "c.txt")); err != nil || matches != nil {
+		t.Errorf("Existing path should not be expanded: %v, %v", matches, err)
+	}
+	if _, err := expandSendTarget(osFS{}, filepath.Join(tempDir, "*.pdf")); err == nil {
+		t.Error("Pattern without matches should fail")
+	}
+	if _, err := expandSendTarget(osFS{}, filepath.Join(tempDir, "missing.txt")); err == nil {
+		t.Error("Missing literal path should fail")
+	}
+
+	fs := NewFileServer("send", filepath.Join(tempDir, "*.log"), 8080, false)
+	fs.sources = matches
+	sources, isArchive, err := fs.shareSources()
+	if err != nil || !isArchive || len(sources) != 2 {
+		t.Fatalf("shareSources = %v, %v, %v", sources, isArchive, err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeZipArchive(&buf, sources, zip.Deflate, nil); err != nil {
+		t.Fatalf("writeZipArchive error: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a.log" || names[1] != "b.log" {
+		t.Errorf("Archive entries = %v, expected [a.log b.log]", names)
+	}
+}
+
+// Test -name override of download filename and archive root
+func TestDownloadName(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_name_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.WriteFile(filepath.Join(tempDir, "data.csv"), []byte("1,2"), 0644)
+
+	fs := NewFileServer("send", tempDir, 8080, false)
+	if got := fs.downloadFilename(true); got != filepath.Base(tempDir)+".zip" {
+		t.Errorf("Default archive name = %s", got)
+	}
+
+	fs.downloadName = "report.zip"
+	if got := fs.downloadFilename(true); got != "report.zip" {
+		t.Errorf("Archive name = %s, expected report.zip", got)
+	}
+	sources, _, _ := fs.shareSources()
+	var buf bytes.Buffer
+	writeZipArchive(&buf, sources, zip.Deflate, nil)
+	zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if len(zr.File) != 2 || zr.File[0].Name != "report/" || zr.File[1].Name != "report/data.csv" {
+		t.Errorf("Entries should be nested under the custom root folder")
+	}
+
+	single := NewFileServer("send", filepath.Join(tempDir, "data.csv"), 8080, false)
+	single.downloadName = "Q3 résumé.csv"
+	if got := single.downloadFilename(false); got != "Q3 résumé.csv" {
+		t.Errorf("File download name = %s", got)
+	}
+	if got := contentDisposition("Q3 résumé.csv"); got != "attachment; filename*=utf-8''Q3%20r%C3%A9sum%C3%A9.csv" {
+		t.Errorf("contentDisposition = %s", got)
+	}
+}
+
+// Test several send arguments shared together
+func TestExpandSendTargets(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"a.pdf", "notes/todo.txt", "pics/1.jpg", "pics/2.jpg", "pics/3.png", "old/a.pdf"} {
+		path := filepath.Join(tempDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+	join := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(tempDir, name))
+		}
+		return paths
+	}
+
+	tests := []struct {
+		paths    []string
+		expected []string
+		errText  string
+	}{
+		{join("a.pdf", "notes", "pics/*.jpg"), join("a.pdf", "notes", "pics/1.jpg", "pics/2.jpg"), ""},
+		{join("a.pdf", "old/a.pdf"), nil, "would both be shared as 'a.pdf'"},
+		{join("a.pdf", "pics/*.gif"), nil, "no files match"},
+	}
+	for _, test := range tests {
+		sources, err := expandSendTargets(osFS{}, test.paths)
+		if test.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errText) {
+				t.Errorf("expandSendTargets(%v) error = %v, expected %q", test.paths, err, test.errText)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(sources, test.expected) {
+			t.Errorf("expandSendTargets(%v) = %v (%v), expected %v", test.paths, sources, err, test.expected)
+		}
+	}
+}
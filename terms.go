@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// termsCookie records that a browser accepted -terms; it is only valid for
+// the terms and the run it was issued for.
+const termsCookie = "fileshare-terms"
+
+// termsGated lists the endpoints that hand out the shared content.
+var termsGated = map[string]bool{
+	"/api/download":         true,
+	"/api/file":             true,
+	"/api/download.meta4":   true,
+	"/api/download.extents": true,
+	"/api/stream":           true,
+	"/api/gallery/image":    true,
+	"/api/gallery/zip":      true,
+}
+
+// termsToken is the value of termsCookie once the terms are accepted.
+func (fs *FileServer) termsToken() string {
+	mac := hmac.New(sha256.New, fs.termsKey)
+	mac.Write([]byte(fs.terms))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// termsAccepted reports whether r comes from a browser that accepted the
+// terms, or whether there are none.
+func (fs *FileServer) termsAccepted(r *http.Request) bool {
+	if fs.terms == "" {
+		return true
+	}
+	cookie, err := r.Cookie(termsCookie)
+	return err == nil && hmac.Equal([]byte(cookie.Value), []byte(fs.termsToken()))
+}
+
+// withTerms refuses downloads until the terms have been accepted on the
+// page. Admin tokens always pass.
+func (fs *FileServer) withTerms(next http.Handler) http.Handler {
+	if fs.terms == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if grant, ok := requestGrant(r); ok && grant.scope >= scopeAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if termsGated[r.URL.Path] && !fs.termsAccepted(r) {
+			auditNote(r, "terms not accepted")
+			httpError(w, r, "Accept the terms on the share page first", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleTerms records that the client accepted the terms and sets the
+// cookie that lets its downloads through.
+func (fs *FileServer) handleTerms(w http.ResponseWriter, r *http.Request) {
+	if fs.terms == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     termsCookie,
+		Value:    fs.termsToken(),
+		Path:     fs.basePath + "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	auditNote(r, "accepted the terms")
+	fs.logRequest(r, "Terms accepted by "+fs.clientLabel(fs.getClientIP(r)))
+	w.WriteHeader(http.StatusNoContent)
+}
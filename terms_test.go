@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test that downloads wait for the terms to be accepted
+func TestTermsGate(t *testing.T) {
+	fs := NewFileServer("send", "/share/report.pdf", 8080, false)
+	fs.terms = "<p>Internal use only</p>\n"
+	fs.termsKey = newSignKey()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/terms", fs.handleTerms)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	handler := fs.withTerms(mux)
+
+	accept := httptest.NewRecorder()
+	handler.ServeHTTP(accept, httptest.NewRequest("POST", "/api/terms", nil))
+	if accept.Code != http.StatusNoContent {
+		t.Fatalf("POST /api/terms = %d, expected 204", accept.Code)
+	}
+	accepted := accept.Result().Cookies()[0]
+
+	other := NewFileServer("send", "/share/report.pdf", 8080, false)
+	other.terms = fs.terms
+	other.termsKey = newSignKey()
+	stale := &http.Cookie{Name: termsCookie, Value: other.termsToken()}
+
+	tests := []struct {
+		method   string
+		target   string
+		cookie   *http.Cookie
+		expected int
+	}{
+		{"GET", "/api/files", nil, http.StatusOK},
+		{"GET", "/api/info", nil, http.StatusOK},
+		{"GET", "/api/download", nil, http.StatusForbidden},
+		{"GET", "/api/stream", nil, http.StatusForbidden},
+		{"GET", "/api/gallery/zip", nil, http.StatusForbidden},
+		{"GET", "/api/download", stale, http.StatusForbidden},
+		{"GET", "/api/file?name=report.pdf", nil, http.StatusForbidden},
+		{"GET", "/api/download", accepted, http.StatusOK},
+		{"GET", "/api/file?name=report.pdf", accepted, http.StatusOK},
+		{"GET", "/api/gallery/image?name=a.jpg", accepted, http.StatusOK},
+		{"GET", "/api/terms", nil, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.target, nil)
+		if test.cookie != nil {
+			req.AddCookie(test.cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("%s %s = %d, expected %d", test.method, test.target, rec.Code, test.expected)
+		}
+	}
+
+	// Without -terms nothing is gated.
+	plain := NewFileServer("send", "/share/report.pdf", 8080, false)
+	rec := httptest.NewRecorder()
+	plain.withTerms(mux).ServeHTTP(rec, httptest.NewRequest("GET", "/api/download", nil))
+	if rec.Code != http.StatusOK || !plain.termsAccepted(httptest.NewRequest("GET", "/", nil)) {
+		t.Errorf("Download without -terms = %d, expected 200", rec.Code)
+	}
+}
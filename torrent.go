@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A minimal BitTorrent seeder for one-to-many distribution: the shared
+// file as a single-file torrent, an HTTP tracker under /api/announce and a
+// peer-wire listener that serves pieces. Downloaders find each other
+// through the tracker and trade pieces among themselves, so the host's
+// uplink isn't the bottleneck. There is no DHT; the tracker is enough on
+// a LAN.
+
+const (
+	defaultTorrentPort = 6881
+	torrentInterval    = 30 * time.Second
+	torrentPeerExpiry  = 3 * torrentInterval
+	torrentMaxPieces   = 2000
+	torrentMinPiece    = 256 << 10
+	torrentMaxPiece    = 16 << 20
+	torrentMaxBlock    = 128 << 10
+	torrentMaxMessage  = 1 << 20
+	torrentIdleTimeout = 3 * time.Minute
+	torrentProtocol    = "BitTorrent protocol"
+)
+
+// Peer wire message IDs.
+const (
+	btChoke         = 0
+	btUnchoke       = 1
+	btInterested    = 2
+	btNotInterested = 3
+	btHave          = 4
+	btBitfield      = 5
+	btRequest       = 6
+	btPiece         = 7
+)
+
+// rawBencode is already-encoded bencode, embedded as is.
+type rawBencode []byte
+
+// bencode appends v to buf. It handles the types torrents and tracker
+// replies use: integers, strings, lists and string-keyed dictionaries,
+// whose keys it sorts as the format requires.
+func bencode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(v))
+		buf.Write(v)
+	case rawBencode:
+		buf.Write(v)
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			bencode(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			bencode(buf, k)
+			bencode(buf, v[k])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", v))
+	}
+}
+
+type torrent struct {
+	path        string
+	name        string
+	size        int64
+	pieceLength int64
+	pieces      []byte // SHA-1 of each piece, concatenated
+	info        []byte // bencoded info dictionary
+	infoHash    [20]byte
+	peerID      [20]byte
+	tracker     tracker
+}
+
+// torrentPieceLength picks a power-of-two piece size that keeps the piece
+// count, and so the .torrent, small.
+func torrentPieceLength(size int64) int64 {
+	length := int64(torrentMinPiece)
+	for length < torrentMaxPiece && size/length > torrentMaxPieces {
+		length *= 2
+	}
+	return length
+}
+
+// newTorrent hashes the file at path into a single-file torrent that
+// downloads as name.
+func newTorrent(path, name string) (*torrent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("-torrent needs a single file")
+	}
+
+	t := &torrent{path: path, name: name, size: info.Size(), pieceLength: torrentPieceLength(info.Size())}
+	buf := make([]byte, t.pieceLength)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			t.pieces = append(t.pieces, sum[:]...)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var encoded bytes.Buffer
+	bencode(&encoded, map[string]any{
+		"name":         name,
+		"length":       t.size,
+		"piece length": t.pieceLength,
+		"pieces":       t.pieces,
+	})
+	t.info = encoded.Bytes()
+	t.infoHash = sha1.Sum(t.info)
+	copy(t.peerID[:], "-FS0001-")
+	rand.Read(t.peerID[8:])
+	t.tracker.peers = make(map[string]*trackerPeer)
+	return t, nil
+}
+
+func (t *torrent) numPieces() int {
+	return len(t.pieces) / sha1.Size
+}
+
+// metainfo is the .torrent file, announcing to announce.
+func (t *torrent) metainfo(announce string) []byte {
+	var buf bytes.Buffer
+	bencode(&buf, map[string]any{
+		"announce":   announce,
+		"created by": "fileshare/" + buildInfo().Version,
+		"info":       rawBencode(t.info),
+	})
+	return buf.Bytes()
+}
+
+func (t *torrent) magnet(announce string) string {
+	return fmt.Sprintf("magnet:?xt=urn:btih:%x&dn=%s&xl=%d&tr=%s",
+		t.infoHash, url.QueryEscape(t.name), t.size, url.QueryEscape(announce))
+}
+
+type trackerPeer struct {
+	ip   net.IP
+	port int
+	seen time.Time
+	done bool
+}
+
+// tracker remembers the peers that announced recently.
+type tracker struct {
+	mu    sync.Mutex
+	peers map[string]*trackerPeer // by peer ID
+}
+
+// announce records peer (or forgets it when it stops) and returns the
+// other live peers in compact form, plus the seeder and leecher counts.
+func (tr *tracker) announce(id string, peer *trackerPeer, stopped bool) (compact []byte, seeders, leechers int) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if stopped {
+		delete(tr.peers, id)
+	} else {
+		tr.peers[id] = peer
+	}
+	for other, p := range tr.peers {
+		if peer.seen.Sub(p.seen) > torrentPeerExpiry {
+			delete(tr.peers, other)
+			continue
+		}
+		if p.done {
+			seeders++
+		} else {
+			leechers++
+		}
+		if ip4 := p.ip.To4(); other != id && ip4 != nil {
+			compact = append(compact, ip4...)
+			compact = binary.BigEndian.AppendUint16(compact, uint16(p.port))
+		}
+	}
+	return compact, seeders, leechers
+}
+
+// torrentURL is an address of the web server as reached by r.
+func (fs *FileServer) torrentURL(r *http.Request, path string) string {
+	return "http://" + r.Host + fs.basePath + path
+}
+
+// handleTorrentFile serves the .torrent, announcing to the tracker on the
+// address the client used.
+func (fs *FileServer) handleTorrentFile(w http.ResponseWriter, r *http.Request) {
+	if fs.torrent == nil {
+		httpError(w, r, "Torrent seeding is not enabled (-torrent)", http.StatusNotFound)
+		return
+	}
+	fs.logRequest(r, fmt.Sprintf("Torrent requested by %s", fs.clientLabel(fs.getClientIP(r))))
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", contentDisposition(fs.torrent.name+".torrent"))
+	w.Write(fs.torrent.metainfo(fs.torrentURL(r, fs.resign(r, "/api/announce"))))
+}
+
+func trackerFailure(w http.ResponseWriter, reason string) {
+	var buf bytes.Buffer
+	bencode(&buf, map[string]any{"failure reason": reason})
+	w.Write(buf.Bytes())
+}
+
+// handleAnnounce is the tracker. Besides the peers that announced, every
+// reply lists this server's seeder at the address the peer reached us on.
+func (fs *FileServer) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	if fs.torrent == nil {
+		httpError(w, r, "Torrent seeding is not enabled (-torrent)", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	q := r.URL.Query()
+	if q.Get("info_hash") != string(fs.torrent.infoHash[:]) {
+		trackerFailure(w, "unknown info_hash")
+		return
+	}
+	id := q.Get("peer_id")
+	port, err := strconv.Atoi(q.Get("port"))
+	if len(id) != 20 || err != nil || port <= 0 || port > 65535 {
+		trackerFailure(w, "invalid announce")
+		return
+	}
+	clientIP := fs.getClientIP(r)
+	peer := &trackerPeer{ip: net.ParseIP(clientIP), port: port, seen: fs.clock.Now(),
+		done: q.Get("left") == "0"}
+	if q.Get("event") == "started" {
+		fs.logRequest(r, fmt.Sprintf("Torrent peer %s joined", fs.clientLabel(clientIP)))
+	}
+	if q.Get("event") == "completed" {
+		fs.logRequest(r, fmt.Sprintf("Torrent peer %s finished downloading", fs.clientLabel(clientIP)))
+	}
+	peers, seeders, leechers := fs.torrent.tracker.announce(id, peer, q.Get("event") == "stopped")
+
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		if ip4 := local.IP.To4(); ip4 != nil {
+			seed := binary.BigEndian.AppendUint16(append([]byte(nil), ip4...), uint16(fs.torrentPort))
+			peers = append(seed, peers...)
+			seeders++
+		}
+	}
+
+	var buf bytes.Buffer
+	bencode(&buf, map[string]any{
+		"interval":   int(torrentInterval / time.Second),
+		"complete":   seeders,
+		"incomplete": leechers,
+		"peers":      peers,
+	})
+	w.Write(buf.Bytes())
+}
+
+// startTorrent hashes the shared file and starts seeding it.
+func (fs *FileServer) startTorrent() (net.Listener, error) {
+	sources, isArchive, err := fs.shareSources()
+	if err != nil {
+		return nil, err
+	}
+	if isArchive {
+		return nil, errors.New("-torrent needs a single file")
+	}
+	t, err := newTorrent(sources[0].path, fs.downloadFilename(false))
+	if err != nil {
+		return nil, err
+	}
+	fs.torrent = t
+	return fs.listenTorrent(fmt.Sprintf(":%d", fs.torrentPort))
+}
+
+// listenTorrent accepts peer-wire connections on addr.
+func (fs *FileServer) listenTorrent(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	fs.torrentPort = listener.Addr().(*net.TCPAddr).Port
+	listener = fs.meterListener(listener, true)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fs.seedPeer(conn)
+		}
+	}()
+	return listener, nil
+}
+
+func writeBTMessage(w io.Writer, id byte, payload ...[]byte) error {
+	length := 1
+	for _, p := range payload {
+		length += len(p)
+	}
+	msg := binary.BigEndian.AppendUint32(make([]byte, 0, 5), uint32(length))
+	msg = append(msg, id)
+	for _, p := range payload {
+		msg = append(msg, p...)
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// seedPeer speaks the peer wire protocol with one downloader: it has every
+// piece, never chokes and answers block requests from the file.
+func (fs *FileServer) seedPeer(conn net.Conn) {
+	defer conn.Close()
+	t := fs.torrent
+	label := fs.clientLabel(hostOnly(conn.RemoteAddr().String()))
+
+	conn.SetDeadline(time.Now().Add(torrentIdleTimeout))
+	var hs [68]byte
+	if _, err := io.ReadFull(conn, hs[:]); err != nil {
+		return
+	}
+	if hs[0] != byte(len(torrentProtocol)) || string(hs[1:20]) != torrentProtocol || !bytes.Equal(hs[28:48], t.infoHash[:]) {
+		return
+	}
+	reply := append([]byte{byte(len(torrentProtocol))}, torrentProtocol...)
+	reply = append(reply, make([]byte, 8)...)
+	reply = append(reply, t.infoHash[:]...)
+	reply = append(reply, t.peerID[:]...)
+	if _, err := conn.Write(reply); err != nil {
+		return
+	}
+
+	bitfield := make([]byte, (t.numPieces()+7)/8)
+	for i := 0; i < t.numPieces(); i++ {
+		bitfield[i/8] |= 0x80 >> (i % 8)
+	}
+	if writeBTMessage(conn, btBitfield, bitfield) != nil || writeBTMessage(conn, btUnchoke) != nil {
+		return
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fs.addLog(fmt.Sprintf("Torrent peer %s connected", label))
+	var sent int64
+	defer func() {
+		fs.addLog(fmt.Sprintf("Torrent peer %s disconnected (%s sent)", label, formatSize(sent)))
+	}()
+
+	block := make([]byte, torrentMaxBlock)
+	var header [4]byte
+	for {
+		conn.SetDeadline(time.Now().Add(torrentIdleTimeout))
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(header[:])
+		if length == 0 {
+			continue // keep-alive
+		}
+		if length > torrentMaxMessage {
+			return
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+
+		switch msg[0] {
+		case btInterested:
+			if writeBTMessage(conn, btUnchoke) != nil {
+				return
+			}
+		case btRequest:
+			if len(msg) != 13 {
+				return
+			}
+			index := int64(binary.BigEndian.Uint32(msg[1:5]))
+			begin := int64(binary.BigEndian.Uint32(msg[5:9]))
+			n := int64(binary.BigEndian.Uint32(msg[9:13]))
+			offset := index*t.pieceLength + begin
+			if index >= int64(t.numPieces()) || n == 0 || n > torrentMaxBlock || begin+n > t.pieceLength || offset+n > t.size {
+				return
+			}
+			if _, err := f.ReadAt(block[:n], offset); err != nil {
+				return
+			}
+			if writeBTMessage(conn, btPiece, msg[1:9], block[:n]) != nil {
+				return
+			}
+			sent += n
+			fs.stats.add(fs.clock.Now(), n, false)
+		}
+	}
+}
+
+// hostOnly strips the port from a host:port address.
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test bencoding
+func TestBencode(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{42, "i42e"},
+		{int64(-7), "i-7e"},
+		{"spam", "4:spam"},
+		{[]byte{0, 1}, "2:\x00\x01"},
+		{[]any{"a", 1}, "l1:ai1ee"},
+		{map[string]any{"zeta": 1, "alpha": "x"}, "d5:alpha1:x4:zetai1ee"},
+		{map[string]any{"info": rawBencode("de")}, "d4:infodee"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		bencode(&buf, test.value)
+		if buf.String() != test.expected {
+			t.Errorf("bencode(%v) = %q, expected %q", test.value, buf.String(), test.expected)
+		}
+	}
+}
+
+// Test piece sizes stay within bounds
+func TestTorrentPieceLength(t *testing.T) {
+	tests := []struct {
+		size     int64
+		expected int64
+	}{
+		{0, 256 << 10},
+		{100 << 20, 256 << 10},
+		{1 << 30, 1 << 20},
+		{8 << 30, 8 << 20},
+		{1 << 40, 16 << 20},
+	}
+	for _, test := range tests {
+		if result := torrentPieceLength(test.size); result != test.expected {
+			t.Errorf("torrentPieceLength(%d) = %d, expected %d", test.size, result, test.expected)
+		}
+	}
+}
+
+// Test the tracker and seeder with a minimal peer
+func TestTorrentSeeding(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_torrent_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	content := make([]byte, 600<<10+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	target := filepath.Join(tempDir, "image.iso")
+	os.WriteFile(target, content, 0644)
+
+	fs := NewFileServer("send", target, 8080, false)
+	fs.torrentPort = 0
+	seeder, err := fs.startTorrent()
+	if err != nil {
+		t.Fatalf("startTorrent error: %v", err)
+	}
+	defer seeder.Close()
+	tor := fs.torrent
+	if tor.numPieces() != 3 || !bytes.Contains(tor.info, []byte("4:name9:image.iso")) {
+		t.Errorf("Torrent has %d pieces, info %q", tor.numPieces(), tor.info[:40])
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/download.torrent", nil)
+	req.Host = "192.168.1.5:8080"
+	fs.handleTorrentFile(rec, req)
+	if !strings.Contains(rec.Body.String(), "8:announce36:http://192.168.1.5:8080/api/announce") {
+		t.Errorf("Torrent file does not announce to the tracker: %q", rec.Body.String()[:60])
+	}
+
+	announce := func(peerID, port, event string) string {
+		q := url.Values{"info_hash": {string(tor.infoHash[:])}, "peer_id": {peerID}, "port": {port}, "left": {"1"}, "event": {event}}
+		req := httptest.NewRequest("GET", "/api/announce?"+q.Encode(), nil)
+		req.RemoteAddr = "192.168.1." + port[:2] + ":5000"
+		rec := httptest.NewRecorder()
+		fs.handleAnnounce(rec, req)
+		return rec.Body.String()
+	}
+	announce("peer-aaaaaaaaaaaaaaa", "41000", "started")
+	reply := announce("peer-bbbbbbbbbbbbbbb", "42000", "started")
+	if !strings.Contains(reply, "5:peers6:\xc0\xa8\x01\x29\xa0\x28") || !strings.Contains(reply, "10:incompletei2e") {
+		t.Errorf("Announce reply should list the other peer: %q", reply)
+	}
+	if reply := announce("peer-bbbbbbbbbbbbbbb", "42000", "stopped"); !strings.Contains(reply, "10:incompletei1e") {
+		t.Errorf("A stopped peer should be forgotten: %q", reply)
+	}
+	q := url.Values{"info_hash": {"wrong"}, "peer_id": {"peer-ccccccccccccccc"}, "port": {"1"}}
+	rec = httptest.NewRecorder()
+	fs.handleAnnounce(rec, httptest.NewRequest("GET", "/api/announce?"+q.Encode(), nil))
+	if !strings.Contains(rec.Body.String(), "failure reason") {
+		t.Errorf("Announcing an unknown torrent should fail: %q", rec.Body.String())
+	}
+
+	// Download every piece from the seeder and check it against the torrent.
+	conn, err := net.Dial("tcp", seeder.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial seeder: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	hs := append([]byte{19}, torrentProtocol...)
+	hs = append(hs, make([]byte, 8)...)
+	hs = append(hs, tor.infoHash[:]...)
+	hs = append(hs, "peer-ddddddddddddddd"...)
+	conn.Write(hs)
+	reply68 := make([]byte, 68)
+	if _, err := io.ReadFull(conn, reply68); err != nil || !bytes.Equal(reply68[28:48], tor.infoHash[:]) {
+		t.Fatalf("Bad handshake reply (%v)", err)
+	}
+	readMessage := func() []byte {
+		var header [4]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			t.Fatalf("Read message: %v", err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(header[:]))
+		io.ReadFull(conn, msg)
+		return msg
+	}
+	if msg := readMessage(); msg[0] != btBitfield || msg[1] != 0xe0 {
+		t.Errorf("Expected a full bitfield, got %v", msg)
+	}
+	if msg := readMessage(); msg[0] != btUnchoke {
+		t.Errorf("Expected unchoke, got %v", msg)
+	}
+	writeBTMessage(conn, btInterested)
+	readMessage()
+
+	var downloaded []byte
+	for index := 0; index < tor.numPieces(); index++ {
+		var piece []byte
+		for begin := int64(0); begin < tor.pieceLength; begin += 16 << 10 {
+			offset := int64(index)*tor.pieceLength + begin
+			if offset >= tor.size {
+				break
+			}
+			n := min(16<<10, tor.size-offset)
+			payload := binary.BigEndian.AppendUint32(nil, uint32(index))
+			payload = binary.BigEndian.AppendUint32(payload, uint32(begin))
+			payload = binary.BigEndian.AppendUint32(payload, uint32(n))
+			writeBTMessage(conn, btRequest, payload)
+			msg := readMessage()
+			if msg[0] != btPiece || !bytes.Equal(msg[1:9], payload[:8]) {
+				t.Fatalf("Unexpected reply to request %d/%d: %v", index, begin, msg[:9])
+			}
+			piece = append(piece, msg[9:]...)
+		}
+		if sum := sha1.Sum(piece); !bytes.Equal(sum[:], tor.pieces[index*20:index*20+20]) {
+			t.Errorf("Piece %d does not match its hash", index)
+		}
+		downloaded = append(downloaded, piece...)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("Pieces from the seeder differ from the file")
+	}
+
+	// A request past the end of the file drops the connection.
+	payload := binary.BigEndian.AppendUint32(nil, 2)
+	payload = binary.BigEndian.AppendUint32(payload, 200<<10)
+	payload = binary.BigEndian.AppendUint32(payload, 16<<10)
+	writeBTMessage(conn, btRequest, payload)
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Seeder should close the connection on an invalid request")
+	}
+}
+
+// Test that -torrent is limited to single files
+func TestTorrentNeedsFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_torrent_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	fs := NewFileServer("send", tempDir, 8080, false)
+	if _, err := fs.startTorrent(); err == nil || !strings.Contains(err.Error(), "single file") {
+		t.Errorf("startTorrent on a directory error = %v, expected a single-file error", err)
+	}
+	rec := httptest.NewRecorder()
+	fs.handleTorrentFile(rec, httptest.NewRequest("GET", "/api/download.torrent", nil))
+	if rec.Code != 404 {
+		t.Errorf("Torrent file without -torrent: status %d, expected 404", rec.Code)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The page, its stylesheet, script, icons and the login and busy
+// templates are built in from web/. A file of the same name in -ui-dir
+// replaces the built-in one; they are read on every request so a theme can
+// be edited while the server runs.
+//
+//	index.html  the share page, served as is (after -title/-accent)
+//	style.css   its stylesheet (after -accent), served as /static/style.css
+//	app.js      its script, served as /static/app.js
+//	favicon.svg, favicon.ico
+//	login.html  html/template with .Action .Error .Title .Accent .Logo
+//	busy.html   html/template with .RetryAfter
+//
+// Every other file in -ui-dir is served under /ui/, e.g. /ui/theme.css.
+//
+//go:embed web
+var webFiles embed.FS
+
+const (
+	staticPrefix  = "/static/"
+	uiAssetPrefix = "/ui/"
+)
+
+// brandedFiles use the built-in title and colors that -title and -accent
+// replace.
+var brandedFiles = map[string]bool{"index.html": true, "style.css": true, "favicon.svg": true}
+
+// uiFile reads name from -ui-dir, reporting false when there is none.
+func (fs *FileServer) uiFile(name string) (string, bool) {
+	if fs.uiDir == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(filepath.Join(fs.uiDir, name))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// webFile returns name from -ui-dir or the built-in copy, with the
+// branding applied.
+func (fs *FileServer) webFile(name string) (string, bool) {
+	text, ok := fs.uiFile(name)
+	if !ok {
+		data, err := webFiles.ReadFile(path.Join("web", name))
+		if err != nil {
+			return "", false
+		}
+		text = string(data)
+	}
+	if fs.brand != nil && brandedFiles[name] {
+		text = fs.brand.apply(text)
+	}
+	return text, true
+}
+
+// uiTemplate parses name from -ui-dir or the built-in copy. A replacement
+// that doesn't parse is logged and the built-in one used instead.
+func (fs *FileServer) uiTemplate(name string) *template.Template {
+	if text, ok := fs.uiFile(name); ok {
+		tmpl, err := template.New(name).Parse(text)
+		if err == nil {
+			return tmpl
+		}
+		fs.addLog(fmt.Sprintf("Ignoring %s: %v", name, err))
+	}
+	return template.Must(template.ParseFS(webFiles, path.Join("web", name)))
+}
+
+// handleStatic serves the page's stylesheet, script and icons. Browsers
+// revalidate them with the ETag, so a changed theme shows up on reload.
+func (fs *FileServer) handleStatic(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, staticPrefix)
+	if r.URL.Path == "/favicon.ico" {
+		name = "favicon.ico"
+	}
+	if name == "" || strings.Contains(name, "/") || strings.HasSuffix(name, ".html") {
+		http.NotFound(w, r)
+		return
+	}
+	text, ok := fs.webFile(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256([]byte(text))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, name, time.Time{}, strings.NewReader(text))
+}
+
+// handleUIAsset serves the theme's other files: stylesheets, images,
+// fonts. Directories are not listed.
+func (fs *FileServer) handleUIAsset(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(r.URL.Path, uiAssetPrefix)
+	full, err := resolveInside(fs.uiDir, rel)
+	if err != nil || fs.uiDir == "" {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test files in -ui-dir replace the built-in pages and missing ones fall
+// back
+func TestUIDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte(`<html><title>FileShare</title><link rel="stylesheet" href="ui/theme.css"></html>`), 0644)
+	os.WriteFile(filepath.Join(dir, "login.html"), []byte(`<form action="{{.Action}}">{{.Title}} {{.Error}}</form>`), 0644)
+	os.WriteFile(filepath.Join(dir, "theme.css"), []byte("body { color: red; }"), 0644)
+	os.Mkdir(filepath.Join(dir, "fonts"), 0755)
+
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	fs.uiDir = dir
+	fs.brand = &branding{title: "Acme"}
+
+	rec := httptest.NewRecorder()
+	fs.handleIndex(rec, httptest.NewRequest("GET", "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "ui/theme.css") || !strings.Contains(body, "<title>Acme</title>") {
+		t.Errorf("Index = %q, expected the theme's page with the title applied", body)
+	}
+
+	rec = httptest.NewRecorder()
+	fs.writeLogin(rec, http.StatusUnauthorized, "Wrong <password>")
+	if body := rec.Body.String(); body != `<form action="/login">Acme Wrong &lt;password&gt;</form>` {
+		t.Errorf("Login = %q, expected the theme's template", body)
+	}
+
+	// busy.html is missing, so the built-in page is used.
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	fs.rejectBusy(rec, req)
+	if !strings.Contains(rec.Body.String(), "Someone else is transferring") {
+		t.Errorf("Busy page should fall back to the built-in one")
+	}
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/ui/theme.css", http.StatusOK},
+		{"/ui/fonts", http.StatusNotFound},
+		{"/ui/missing.css", http.StatusNotFound},
+		{"/ui/../ui_test.go", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = test.path
+		fs.handleUIAsset(rec, req)
+		if rec.Code != test.expected {
+			t.Errorf("GET %s = %d, expected %d", test.path, rec.Code, test.expected)
+		}
+	}
+
+	// A broken template is logged and ignored.
+	os.WriteFile(filepath.Join(dir, "login.html"), []byte(`{{.Title`), 0644)
+	rec = httptest.NewRecorder()
+	fs.writeLogin(rec, http.StatusOK, "")
+	if !strings.Contains(rec.Body.String(), "Sign in") || !strings.Contains(strings.Join(fs.transferLog, "\n"), "Ignoring login.html") {
+		t.Errorf("Broken login.html should fall back to the built-in page and be logged")
+	}
+}
+
+// Test the built-in assets are served with ETags and can be replaced
+func TestStaticAssets(t *testing.T) {
+	fs := NewFileServer("send", "/tmp", 8080, false)
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		fs.handleStatic(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		path        string
+		expected    int
+		contentType string
+	}{
+		{"/static/app.js", http.StatusOK, "text/javascript"},
+		{"/static/style.css", http.StatusOK, "text/css"},
+		{"/static/favicon.svg", http.StatusOK, "image/svg+xml"},
+		{"/favicon.ico", http.StatusOK, "image/"},
+		{"/static/login.html", http.StatusNotFound, ""},
+		{"/static/missing.js", http.StatusNotFound, ""},
+		{"/static/", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		rec := get(test.path, "")
+		if rec.Code != test.expected || !strings.HasPrefix(rec.Header().Get("Content-Type"), test.contentType) {
+			t.Errorf("GET %s = %d %q, expected %d %q", test.path, rec.Code, rec.Header().Get("Content-Type"), test.expected, test.contentType)
+		}
+	}
+
+	rec := get("/static/app.js", "")
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Assets should carry an ETag and be revalidated, got %v", rec.Header())
+	}
+	if rec := get("/static/app.js", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("Revalidation = %d, expected 304", rec.Code)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log('theme');"), 0644)
+	fs.uiDir = dir
+	rec = get("/static/app.js", etag)
+	if rec.Code != http.StatusOK || rec.Body.String() != "console.log('theme');" {
+		t.Errorf("Replaced app.js = %d %q, expected the theme's script", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	conflictReject = "reject"
+	conflictRename = "rename"
+)
+
+func validConflictPolicy(policy string) bool {
+	return policy == conflictReject || policy == conflictRename
+}
+
+// sanitizeFilename reduces a client-supplied name to a safe base name,
+// replacing characters that are invalid on common filesystems.
+func sanitizeFilename(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid file name")
+	}
+	if len(name) > 255 {
+		ext := filepath.Ext(name)
+		if len(ext) > 32 {
+			ext = ""
+		}
+		cut := 255 - len(ext)
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut] + ext
+	}
+	return name, nil
+}
+
+// timestampedName turns report.pdf into report_2024-05-01_15-30-12.pdf.
+func timestampedName(name string, now time.Time, attempt int) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	stamped := base + "_" + now.Format("2006-01-02_15-04-05")
+	if attempt > 1 {
+		stamped += fmt.Sprintf("_%d", attempt)
+	}
+	return stamped + ext
+}
+
+// createUploadFile creates name inside dir without ever overwriting an
+// existing file. Under the rename policy a conflicting upload gets a
+// timestamp suffix; otherwise os.ErrExist is returned.
+func createUploadFile(dir, name, policy string, now time.Time) (*os.File, string, error) {
+	candidate := name
+	for attempt := 0; attempt < 100; attempt++ {
+		if attempt > 0 {
+			candidate = timestampedName(name, now, attempt)
+		}
+		savePath := filepath.Join(dir, candidate)
+		f, err := os.OpenFile(savePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return f, savePath, nil
+		}
+		if !os.IsExist(err) || policy != conflictRename {
+			return nil, savePath, err
+		}
+	}
+	return nil, "", fmt.Errorf("too many files named like '%s'", name)
+}
+
+// afterReceive runs the post-receive steps for a completed upload: the
+// content store, extraction, then copies to mirrors.
+func (fs *FileServer) afterReceive(path, sum string) {
+	if fs.cas {
+		fs.storeObject(path, sum)
+	}
+	if fs.autoExtract {
+		fs.extractUpload(path)
+	}
+	if fs.atRest != nil {
+		// sum is of the plaintext, not of the encrypted file being copied.
+		sum = ""
+	}
+	fs.mirrorUpload(path, sum)
+}
+
+// clientDirName is the subdirectory used for a client under -per-client-dir:
+// its resolved hostname when known, otherwise its IP.
+func (fs *FileServer) clientDirName(clientIP string) string {
+	name := clientIP
+	if fs.resolver != nil {
+		if host := fs.resolver.lookup(clientIP); host != "" {
+			name = host
+		}
+	}
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '/', '\\', '%':
+			return '-'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		name = "unknown"
+	}
+	return name
+}
+
+func (fs *FileServer) uploadDir(clientIP string) (string, error) {
+	dir := fs.inboxPath()
+	if !fs.perClientDir {
+		return dir, nil
+	}
+	dir = filepath.Join(dir, fs.clientDirName(clientIP))
+	return dir, os.MkdirAll(dir, 0755)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test conflict handling for uploaded file names
+func TestCreateUploadFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_upload_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	now := time.Date(2024, 5, 1, 15, 30, 12, 0, time.Local)
+
+	f, savePath, err := createUploadFile(tempDir, "report.pdf", conflictReject, now)
+	if err != nil {
+		t.Fatalf("First upload should succeed: %v", err)
+	}
+	f.Close()
+	if filepath.Base(savePath) != "report.pdf" {
+		t.Errorf("First upload saved as %s", savePath)
+	}
+
+	if _, _, err := createUploadFile(tempDir, "report.pdf", conflictReject, now); !os.IsExist(err) {
+		t.Errorf("Conflicting upload under reject policy should fail with ErrExist, got %v", err)
+	}
+
+	expected := []string{"report_2024-05-01_15-30-12.pdf", "report_2024-05-01_15-30-12_2.pdf"}
+	for _, name := range expected {
+		f, savePath, err := createUploadFile(tempDir, "report.pdf", conflictRename, now)
+		if err != nil {
+			t.Fatalf("Rename policy should not fail: %v", err)
+		}
+		f.Close()
+		if filepath.Base(savePath) != name {
+			t.Errorf("Renamed upload saved as %s, expected %s", filepath.Base(savePath), name)
+		}
+	}
+}
+
+// Test per-client upload directories
+func TestPerClientDir(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_perclient_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	if dir, _ := fs.uploadDir("192.168.1.42"); dir != tempDir {
+		t.Errorf("Without -per-client-dir uploads go to the root, got %s", dir)
+	}
+
+	fs.perClientDir = true
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"192.168.1.42", "192.168.1.42"},
+		{"fe80::1", "fe80--1"},
+	}
+	for _, test := range tests {
+		dir, err := fs.uploadDir(test.ip)
+		if err != nil {
+			t.Fatalf("uploadDir error: %v", err)
+		}
+		if dir != filepath.Join(tempDir, test.expected) {
+			t.Errorf("uploadDir(%s) = %s, expected %s", test.ip, dir, test.expected)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("uploadDir should create %s", dir)
+		}
+	}
+}
+
+// Test sanitizing of client-supplied file names
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"report.pdf", "report.pdf", true},
+		{"../../etc/passwd", "passwd", true},
+		{`C:\Users\me\notes.txt`, "notes.txt", true},
+		{"what?.txt", "what_.txt", true},
+		{"bad\x00name.txt", "badname.txt", true},
+		{"trailing. ", "trailing", true},
+		{strings.Repeat("报告", 100) + ".pdf", strings.Repeat("报告", 41) + "报.pdf", true},
+		{"..", "", false},
+		{"dir/", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		result, err := sanitizeFilename(test.input)
+		if (err == nil) != test.ok || result != test.expected {
+			t.Errorf("sanitizeFilename(%q) = %q, %v; expected %q, ok=%v", test.input, result, err, test.expected, test.ok)
+		}
+	}
+}
+
+// newUploadRequest builds a multipart upload of content with extra form fields
+func newUploadRequest(t *testing.T, filename, content string, fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for key, value := range fields {
+		mw.WriteField(key, value)
+	}
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("CreateFormFile error: %v", err)
+	}
+	part.Write([]byte(content))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.RemoteAddr = "192.168.1.42:5000"
+	return req
+}
+
+// Test uploading into a subdirectory of the receive root
+func TestUploadSubdir(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_subdir_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs := NewFileServer("recv", tempDir, 8080, false)
+	tests := []struct {
+		dir      string
+		code     int
+		expected string
+	}{
+		{"photos/2024", http.StatusOK, filepath.Join(tempDir, "photos", "2024", "a.jpg")},
+		{"", http.StatusOK, filepath.Join(tempDir, "a.jpg")},
+		{"../outside", http.StatusBadRequest, ""},
+		{"photos/../../outside", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		fs.handleUpload(w, newUploadRequest(t, "a.jpg", "jpeg", map[string]string{"dir": test.dir}))
+		if w.Code != test.code {
+			t.Errorf("Upload to dir %q returned %d, expected %d", test.dir, w.Code, test.code)
+		}
+		if test.expected != "" {
+			if _, err := os.Stat(test.expected); err != nil {
+				t.Errorf("Upload to dir %q should create %s", test.dir, test.expected)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(tempDir), "outside")); err == nil {
+		t.Error("Upload must not create directories outside the receive root")
+	}
+}
+
+// Test that completed transfers report their SHA-256
+func TestTransferHash(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_upload_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	const content = "hash me"
+	sum := sha256.Sum256([]byte(content))
+	expected := hex.EncodeToString(sum[:])
+
+	recv := NewFileServer("recv", tempDir, 8080, false)
+	rec := httptest.NewRecorder()
+	recv.handleUpload(rec, newUploadRequest(t, "hash.txt", content, nil))
+	var result struct {
+		SHA256 string `json:"sha256"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.SHA256 != expected {
+		t.Errorf("Upload response sha256 = %q (%v), expected %s", result.SHA256, err, expected)
+	}
+	if recv.status.SHA256 != expected {
+		t.Errorf("Upload status sha256 = %q, expected %s", recv.status.SHA256, expected)
+	}
+
+	send := NewFileServer("send", filepath.Join(tempDir, "hash.txt"), 8080, false)
+	send.handleDownload(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/download", nil))
+	if send.status.SHA256 != expected {
+		t.Errorf("Download status sha256 = %q, expected %s", send.status.SHA256, expected)
+	}
+
+	req := httptest.NewRequest("GET", "/api/download", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	send.handleDownload(httptest.NewRecorder(), req)
+	if send.status.SHA256 != "" {
+		t.Errorf("Partial download should not report a sha256, got %q", send.status.SHA256)
+	}
+}
+
+// cancelReader cancels the request after the first read, as if the tab
+// was closed mid-upload, but keeps returning data.
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p[:min(len(p), 16)])
+	c.cancel()
+	return n, err
+}
+
+// Test that uploads cut off by the client are not kept as completed files
+func TestUploadInterrupted(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "fileshare_upload_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := strings.Repeat("partial upload ", 1000)
+	tests := []struct {
+		name     string
+		lowMem   bool
+		truncate bool
+		status   string
+	}{
+		{"truncated", false, true, "waiting"},
+		{"truncated low-mem", true, true, "error"},
+		{"cancelled low-mem", true, false, "error"},
+	}
+	for _, test := range tests {
+		fs := NewFileServer("recv", tempDir, 8080, false)
+		fs.lowMem = test.lowMem
+		req := newUploadRequest(t, "partial.txt", content, nil)
+		body, _ := io.ReadAll(req.Body)
+		if test.truncate {
+			req.Body = io.NopCloser(bytes.NewReader(body[:len(body)/2]))
+		} else {
+			ctx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			req = req.WithContext(ctx)
+			req.Body = io.NopCloser(&cancelReader{bytes.NewReader(body), cancel})
+		}
+
+		rec := httptest.NewRecorder()
+		fs.handleUpload(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status code %d, expected %d", test.name, rec.Code, http.StatusBadRequest)
+		}
+		if fs.status.Status != test.status {
+			t.Errorf("%s: transfer status %q, expected %q", test.name, fs.status.Status, test.status)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "partial.txt")); err == nil {
+			t.Errorf("%s: partial file was kept", test.name)
+			os.Remove(filepath.Join(tempDir, "partial.txt"))
+		}
+		if !fs.acquireClient("192.168.1.43") {
+			t.Errorf("%s: client lock was not released", test.name)
+		}
+	}
+}
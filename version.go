@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type buildMeta struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+}
+
+func buildInfo() buildMeta {
+	meta := buildMeta{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	// Fall back to the VCS stamp embedded by `go build` when ldflags weren't set.
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if meta.Commit == "" && len(setting.Value) >= 7 {
+					meta.Commit = setting.Value[:7]
+				}
+			case "vcs.time":
+				if meta.BuildDate == "" {
+					meta.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+	if meta.Commit == "" {
+		meta.Commit = "unknown"
+	}
+	if meta.BuildDate == "" {
+		meta.BuildDate = "unknown"
+	}
+	return meta
+}
+
+func versionString() string {
+	meta := buildInfo()
+	return fmt.Sprintf("%s (%s)", meta.Version, meta.Commit)
+}
+
+func printVersion() {
+	meta := buildInfo()
+	fmt.Printf("fileshare %s\n", meta.Version)
+	fmt.Printf("  commit:     %s\n", meta.Commit)
+	fmt.Printf("  built:      %s\n", meta.BuildDate)
+	fmt.Printf("  go version: %s\n", meta.GoVersion)
+	fmt.Printf("  platform:   %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test version metadata defaults and header
+func TestVersionHeader(t *testing.T) {
+	meta := buildInfo()
+	if meta.Version == "" || meta.Commit == "" || meta.BuildDate == "" {
+		t.Errorf("buildInfo should never return empty fields: %+v", meta)
+	}
+	if !strings.HasPrefix(meta.GoVersion, "go") {
+		t.Errorf("GoVersion should start with 'go', got %s", meta.GoVersion)
+	}
+
+	fs := NewFileServer("send", "/tmp", 0, false)
+	handler := fs.withCommonHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/info", nil))
+	if got := rec.Header().Get("X-FileShare-Version"); got != versionString() {
+		t.Errorf("X-FileShare-Version = %q, expected %q", got, versionString())
+	}
+}
@@ -0,0 +1,1135 @@
+const dropZone = document.getElementById('drop-zone');
+const fileInput = document.getElementById('file-input');
+const progressContainer = document.getElementById('progress');
+const progressFill = document.getElementById('progress-fill');
+const progressText = document.getElementById('progress-text');
+const statusEl = document.getElementById('status');
+const cancelBtn = document.getElementById('cancel-btn');
+const uploadSection = document.getElementById('upload-section');
+const downloadSection = document.getElementById('download-section');
+const downloadBtn = document.getElementById('download-btn');
+const logEntries = document.getElementById('log-entries');
+const curlCmd = document.getElementById('curl-cmd');
+const clipboardSection = document.getElementById('clipboard-section');
+const clipText = document.getElementById('clip-text');
+const p2pSection = document.getElementById('p2p-section');
+const peerList = document.getElementById('peer-list');
+const p2pInput = document.getElementById('p2p-input');
+
+let currentMode = '';
+let browsePath = '';
+let targetName = '';
+let previousStatus = '';
+let canManage = false;
+let showDownloadCounts = false;
+let canKick = false;
+let signedInAdmin = false;
+let eventSource = null;
+
+// Initialize
+async function init() {
+    await updateInfo();
+    fetchClients();
+    connectSSE();
+    fetchLogs();
+}
+
+async function updateInfo() {
+    try {
+        const response = await fetch('api/info');
+        const data = await response.json();
+        currentMode = data.mode;
+        canManage = data.manage;
+        canKick = data.admin;
+        signedInAdmin = data.scope === 'admin';
+        
+        document.getElementById('mode').textContent = data.mode.toUpperCase();
+        targetName = data.path;
+        document.getElementById('target').textContent = data.path + ' (' + (data.size < 0 ? 'piped, size unknown' : formatSize(data.size)) + ')';
+        document.getElementById('footer').textContent = 'FileShare ' + data.version;
+        if (data.login) {
+            const signOut = document.createElement('form');
+            signOut.method = 'post';
+            signOut.action = 'logout';
+            signOut.style.display = 'inline';
+            signOut.innerHTML = ' · <button type="submit" style="background: none; border: none; color: inherit; text-decoration: underline; cursor: pointer; font: inherit;">Sign out</button>';
+            document.getElementById('footer').appendChild(signOut);
+        }
+        if (data.motd) {
+            const motdEl = document.getElementById('motd');
+            motdEl.textContent = data.motd;
+            motdEl.classList.remove('hidden');
+        }
+        if (data.message) {
+            // Rendered and escaped server-side.
+            const messageEl = document.getElementById('message');
+            messageEl.innerHTML = data.message;
+            messageEl.classList.remove('hidden');
+        }
+        
+        if (data.mode === 'both') {
+            // Offer the share and take uploads on the same page.
+            uploadSection.classList.remove('hidden');
+            document.getElementById('drop-zone').classList.toggle('hidden', data.scope === 'read');
+            document.getElementById('fetch-url').classList.toggle('hidden', !data.fetch || data.scope === 'read');
+            await showDownloads(data);
+            curlCmd.textContent = 'curl -O -J "' + apiURL('api/download') + '"\n' +
+                'curl -F "file=@YOUR_FILE" "' + apiURL('api/upload') + '"';
+        } else if (data.mode === 'send') {
+            uploadSection.classList.add('hidden');
+            if (data.scope === 'admin') {
+                showDownloadCounts = true;
+                fetchDownloadCounts();
+            }
+            if (data.terms && !data.accepted) {
+                showTerms(data);
+            } else {
+                await showDownloads(data);
+            }
+        } else if (data.mode === 'serve') {
+            uploadSection.classList.add('hidden');
+            downloadSection.classList.remove('hidden');
+            downloadBtn.textContent = 'Download All as ZIP';
+            document.getElementById('file-list').classList.add('browser');
+            fetchFiles();
+            curlCmd.textContent = 'curl -O -J "' + apiURL('api/download') + '"  # or api/file?name=PATH for one file';
+        } else if (data.mode === 'clipboard') {
+            uploadSection.classList.add('hidden');
+            downloadSection.classList.add('hidden');
+            clipboardSection.classList.remove('hidden');
+            fetchClipboard();
+            curlCmd.textContent = 'curl --data-binary @- "' + apiURL('api/clipboard') + '"';
+        } else if (data.mode === 'p2p') {
+            uploadSection.classList.add('hidden');
+            downloadSection.classList.add('hidden');
+            p2pSection.classList.remove('hidden');
+            connectSignal();
+            curlCmd.textContent = '# Open ' + document.baseURI + ' on both devices';
+        } else {
+            uploadSection.classList.remove('hidden');
+            downloadSection.classList.add('hidden');
+            document.getElementById('drop-zone').classList.toggle('hidden', data.scope === 'read');
+            document.getElementById('fetch-url').classList.toggle('hidden', !data.fetch || data.scope === 'read');
+            fetchFiles();
+            curlCmd.textContent = 'curl -F "file=@YOUR_FILE" [-F "name=NEW_NAME"] [-F "dir=SUB/DIR"] "' + apiURL('api/upload') + '"';
+        }
+        
+        updateStatus(data);
+    } catch (e) {
+        console.error('Failed to get info:', e);
+    }
+}
+
+// Handlers for the status stream's events, fed by EventSource or, where
+// a proxy blocks event streams, by polling api/poll.
+const eventHandlers = {
+    message: (e) => {
+        if (e.data.startsWith(':heartbeat')) return;
+    
+        try {
+            const data = JSON.parse(e.data);
+            updateStatus(data);
+            const lastStatus = previousStatus;
+            previousStatus = data.status;
+        
+            if (data.status === 'transferring') {
+                progressContainer.classList.add('active');
+                progressContainer.classList.toggle('streaming', data.size < 0);
+                progressFill.style.width = data.progress + '%';
+                progressText.textContent = progressLabel(data);
+                cancelBtn.classList.remove('hidden');
+                document.getElementById('hash').classList.add('hidden');
+            } else if (data.status === 'completed') {
+                if (lastStatus !== 'completed' && (currentMode === 'recv' || currentMode === 'both')) {
+                    fetchFiles();
+                }
+                if (lastStatus !== 'completed' && showDownloadCounts) {
+                    fetchDownloadCounts();
+                }
+                progressContainer.classList.remove('streaming');
+                progressFill.style.width = '100%';
+                progressText.textContent = data.size < 0 ? formatSize(data.transferred) + ' - Complete!' : '100% - Complete!';
+                cancelBtn.classList.add('hidden');
+                if (data.sha256) {
+                    const hash = document.getElementById('hash');
+                    hash.textContent = 'SHA-256 ' + data.sha256;
+                    hash.classList.remove('hidden');
+                }
+            }
+        } catch (e) {
+            console.error('Failed to parse status:', e);
+        }
+    },
+    dropped: (e) => {
+        // The page fell behind and missed some events: catch up.
+        console.warn('Missed ' + JSON.parse(e.data).dropped + ' events, refreshing');
+        fetchClients();
+        if (currentMode === 'clipboard') {
+            fetchClipboard();
+        } else if (currentMode !== 'p2p') {
+            fetchFiles();
+        }
+        if (showDownloadCounts) fetchDownloadCounts();
+    },
+    clients: (e) => {
+        showClients(JSON.parse(e.data));
+    },
+    files: (e) => {
+        const data = JSON.parse(e.data);
+        document.getElementById('target').textContent = targetName + ' (' + formatSize(data.size) + ')';
+        fetchFiles();
+    },
+    clipboard: (e) => {
+        // Don't clobber what the user is typing.
+        if (document.activeElement !== clipText) {
+            clipText.value = JSON.parse(e.data).text;
+        }
+    },
+};
+
+// The server sends the status as soon as a stream opens, so a stream that
+// stays silent this long is being held back by something in between.
+const sseSilenceTimeout = 5000;
+let polling = false;
+
+function connectSSE() {
+    if (eventSource) {
+        eventSource.close();
+    }
+    if (polling) return;
+    
+    eventSource = new EventSource('api/events');
+    let opened = false;
+    const silence = setTimeout(() => {
+        if (!opened) startPolling();
+    }, sseSilenceTimeout);
+    
+    eventSource.onmessage = (e) => {
+        opened = true;
+        clearTimeout(silence);
+        eventHandlers.message(e);
+    };
+    for (const name of ['dropped', 'clients', 'files', 'clipboard']) {
+        eventSource.addEventListener(name, eventHandlers[name]);
+    }
+    
+    eventSource.onerror = () => {
+        clearTimeout(silence);
+        if (!opened) {
+            // Refused outright, as some proxies do with event streams.
+            startPolling();
+            return;
+        }
+        console.log('SSE connection lost, retrying...');
+        setTimeout(connectSSE, 1000);
+    };
+}
+
+async function startPolling() {
+    if (polling) return;
+    polling = true;
+    if (eventSource) {
+        eventSource.close();
+        eventSource = null;
+    }
+    console.warn('Event stream unavailable, falling back to polling');
+    let since = null;
+    for (;;) {
+        try {
+            const response = await fetch(since === null ? 'api/poll' : 'api/poll?since=' + since);
+            if (!response.ok) throw new Error('HTTP ' + response.status);
+            const body = await response.json();
+            since = body.id;
+            for (const ev of body.events) {
+                eventHandlers[ev.event || 'message']?.({ data: ev.data });
+            }
+        } catch (e) {
+            console.error('Poll failed:', e);
+            await new Promise((resolve) => setTimeout(resolve, 1000));
+        }
+    }
+}
+
+async function fetchClipboard() {
+    try {
+        const response = await fetch('api/clipboard');
+        clipText.value = (await response.json()).text;
+    } catch (e) {
+        console.error('Failed to get clipboard:', e);
+    }
+}
+
+document.getElementById('clip-copy').addEventListener('click', async (e) => {
+    try {
+        await navigator.clipboard.writeText(clipText.value);
+    } catch (e) {
+        // navigator.clipboard needs a secure context; plain http
+        // on the LAN usually isn't one.
+        clipText.select();
+        document.execCommand('copy');
+    }
+    e.target.textContent = 'Copied!';
+    setTimeout(() => { e.target.textContent = 'Copy'; }, 1500);
+});
+
+document.getElementById('clip-send').addEventListener('click', async () => {
+    const body = new URLSearchParams();
+    body.set('text', clipText.value);
+    const response = await fetch('api/clipboard', {method: 'POST', body: body});
+    if (!response.ok) {
+        alert(await response.text());
+    }
+    clipText.blur();
+});
+
+// p2p mode: the server only relays WebRTC signaling between browsers
+// (and the file itself if they can't connect directly).
+let peerId = null;
+let signalSource = null;
+let sendTarget = null;
+const connections = {};
+
+function connectSignal() {
+    if (signalSource) {
+        return;
+    }
+    signalSource = new EventSource('api/signal');
+    signalSource.addEventListener('welcome', (e) => {
+        peerId = JSON.parse(e.data).id;
+    });
+    signalSource.addEventListener('peers', (e) => renderPeers(JSON.parse(e.data)));
+    signalSource.addEventListener('signal', (e) => {
+        const msg = JSON.parse(e.data);
+        handlePeerSignal(msg.from, msg.data).catch((err) => console.error('Signal failed:', err));
+    });
+}
+
+function renderPeers(peers) {
+    if (peers.length === 0) {
+        peerList.innerHTML = '<div class="file"><span class="name">Waiting for another browser to open this page...</span></div>';
+        return;
+    }
+    peerList.innerHTML = peers.map(p =>
+        '<div class="file"><span class="name">' + escapeHtml(p.label) + '</span>' +
+        '<a href="#" data-peer="' + escapeHtml(p.id) + '">Send file</a></div>'
+    ).join('');
+}
+
+peerList.addEventListener('click', (e) => {
+    const peer = e.target.dataset.peer;
+    if (peer) {
+        e.preventDefault();
+        sendTarget = peer;
+        p2pInput.click();
+    }
+});
+
+p2pInput.addEventListener('change', () => {
+    if (p2pInput.files.length > 0 && sendTarget) {
+        sendToPeer(sendTarget, p2pInput.files[0]);
+    }
+    p2pInput.value = '';
+});
+
+function signal(to, data) {
+    return fetch('api/signal', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ from: peerId, to: to, data: data })
+    });
+}
+
+function newConnection(peer) {
+    if (connections[peer]) {
+        connections[peer].close();
+    }
+    // LAN peers reach each other through host candidates; no STUN.
+    const pc = new RTCPeerConnection({ iceServers: [] });
+    pc.onicecandidate = (e) => {
+        if (e.candidate) {
+            signal(peer, { type: 'candidate', candidate: e.candidate });
+        }
+    };
+    connections[peer] = pc;
+    return pc;
+}
+
+function showPeerProgress(done, total) {
+    const percent = total > 0 ? done / total * 100 : 100;
+    progressContainer.classList.add('active');
+    progressFill.style.width = percent + '%';
+    progressText.textContent = percent.toFixed(1) + '% (' + formatSize(done) + ' / ' + formatSize(total) + ')';
+}
+
+async function sendToPeer(peer, file) {
+    const pc = newConnection(peer);
+    const channel = pc.createDataChannel('file');
+    channel.binaryType = 'arraybuffer';
+    const fallback = setTimeout(() => {
+        if (channel.readyState !== 'open') {
+            pc.close();
+            relayToPeer(peer, file);
+        }
+    }, 10000);
+    
+    channel.onopen = async () => {
+        clearTimeout(fallback);
+        channel.send(JSON.stringify({ name: file.name, size: file.size }));
+        channel.bufferedAmountLowThreshold = 1 << 20;
+        let offset = 0;
+        while (offset < file.size) {
+            if (channel.bufferedAmount > 4 << 20) {
+                await new Promise(resolve => { channel.onbufferedamountlow = resolve; });
+            }
+            const chunk = await file.slice(offset, offset + 16384).arrayBuffer();
+            channel.send(chunk);
+            offset += chunk.byteLength;
+            showPeerProgress(offset, file.size);
+        }
+        channel.send(JSON.stringify({ done: true }));
+    };
+    
+    await pc.setLocalDescription(await pc.createOffer());
+    signal(peer, { type: 'offer', sdp: pc.localDescription });
+}
+
+async function relayToPeer(peer, file) {
+    const token = Math.random().toString(36).slice(2) + Date.now().toString(36);
+    await signal(peer, { type: 'relay', token: token, name: file.name, size: file.size });
+    showPeerProgress(0, file.size);
+    const response = await fetch('api/relay?token=' + token, { method: 'POST', body: file });
+    if (response.ok) {
+        showPeerProgress(file.size, file.size);
+    } else {
+        alert('Relay failed: ' + (await response.text()));
+    }
+}
+
+async function handlePeerSignal(from, data) {
+    if (data.type === 'offer') {
+        const pc = newConnection(from);
+        pc.ondatachannel = (e) => receiveFromPeer(e.channel);
+        await pc.setRemoteDescription(data.sdp);
+        await pc.setLocalDescription(await pc.createAnswer());
+        signal(from, { type: 'answer', sdp: pc.localDescription });
+    } else if (data.type === 'answer' && connections[from]) {
+        await connections[from].setRemoteDescription(data.sdp);
+    } else if (data.type === 'candidate' && connections[from]) {
+        await connections[from].addIceCandidate(data.candidate);
+    } else if (data.type === 'relay') {
+        // Let the browser's downloader stream the relayed file to disk.
+        const link = document.createElement('a');
+        link.href = 'api/relay?token=' + encodeURIComponent(data.token) + '&name=' + encodeURIComponent(data.name);
+        link.download = data.name;
+        link.click();
+    }
+}
+
+function receiveFromPeer(channel) {
+    channel.binaryType = 'arraybuffer';
+    let meta = null;
+    let chunks = [];
+    let received = 0;
+    channel.onmessage = (e) => {
+        if (typeof e.data === 'string') {
+            const msg = JSON.parse(e.data);
+            if (msg.done) {
+                addPeerFile(meta.name, new Blob(chunks));
+                chunks = [];
+            } else {
+                meta = msg;
+                received = 0;
+            }
+            return;
+        }
+        chunks.push(e.data);
+        received += e.data.byteLength;
+        showPeerProgress(received, meta.size);
+    };
+}
+
+function addPeerFile(name, blob) {
+    const list = document.getElementById('p2p-received');
+    const row = document.createElement('div');
+    row.className = 'file';
+    const link = document.createElement('a');
+    link.className = 'name';
+    link.href = URL.createObjectURL(blob);
+    link.download = name;
+    link.textContent = name;
+    const size = document.createElement('span');
+    size.className = 'size';
+    size.textContent = formatSize(blob.size);
+    row.append(link, size);
+    list.prepend(row);
+    list.classList.remove('hidden');
+    document.getElementById('p2p-received-title').classList.remove('hidden');
+}
+
+async function fetchFiles() {
+    if (currentMode === 'serve') {
+        await browse(browsePath);
+        return;
+    }
+    if (currentMode === 'both') {
+        await Promise.all([listFiles(false), listFiles(true)]);
+        return;
+    }
+    if (galleryImages) {
+        await fetchGallery();
+        if (galleryImages) return;
+    }
+    await listFiles(currentMode === 'recv');
+}
+
+// listFiles shows the files of the share, or with received those uploaded,
+// which both mode asks for separately.
+async function listFiles(received) {
+    try {
+        const response = await fetch(received ? 'api/files?received=1' : 'api/files');
+        const listing = await response.json();
+        const list = document.getElementById(received ? 'received-list' : 'file-list');
+        if (listing.files.length <= (received ? 0 : 1)) {
+            list.classList.add('hidden');
+            return;
+        }
+        list.innerHTML = listing.files.map(f => {
+            const name = received
+                ? '<a href="api/file?received=1&name=' + encodeURIComponent(f.name) + '" download>' + escapeHtml(f.name) + '</a>'
+                : escapeHtml(f.name);
+            const actions = received && canManage
+                ? ' <span class="actions"><a href="#" data-rename="' + escapeHtml(f.name) + '" title="Rename">✏️</a> <a href="#" data-delete="' + escapeHtml(f.name) + '" title="Delete">🗑️</a></span>'
+                : '';
+            return '<div class="file"><span class="name">' + name + '</span><span class="size">' + formatSize(f.size) + actions + '</span></div>';
+        }).join('') + (listing.truncated ? '<div class="file"><span class="name">…</span></div>' : '');
+        list.classList.remove('hidden');
+        if (received) {
+            document.getElementById('received-title').classList.remove('hidden');
+        }
+    } catch (e) {
+        console.error('Failed to fetch files:', e);
+    }
+}
+
+// browse shows a folder of a serve share: subfolders open in place, files
+// download one at a time.
+async function browse(path) {
+    try {
+        const response = await fetch('api/browse?path=' + encodeURIComponent(path));
+        if (!response.ok) {
+            throw new Error(await response.text());
+        }
+        const listing = await response.json();
+        browsePath = listing.path;
+        const prefix = browsePath ? browsePath + '/' : '';
+        const folder = (p, label) => '<div class="file"><span class="name"><a href="#" data-browse="' + encodeURIComponent(p) + '">' + label + '</a></span></div>';
+        let rows = '';
+        if (browsePath) {
+            const parent = browsePath.includes('/') ? browsePath.slice(0, browsePath.lastIndexOf('/')) : '';
+            rows += folder(parent, '⬆️ /' + escapeHtml(browsePath));
+        }
+        rows += listing.dirs.map(d => folder(prefix + d.name, '📁 ' + escapeHtml(d.name) + '/')).join('');
+        rows += listing.files.map(f => '<div class="file"><span class="name"><a href="api/file?name=' + encodeURIComponent(prefix + f.name) + '" download>' +
+            escapeHtml(f.name) + '</a></span><span class="size">' + formatSize(f.size) + '</span></div>').join('');
+        if (listing.truncated) {
+            rows += '<div class="file"><span class="name">…</span></div>';
+        } else if (listing.dirs.length + listing.files.length === 0) {
+            rows += '<div class="file"><span class="name">Empty folder</span></div>';
+        }
+        const list = document.getElementById('file-list');
+        list.innerHTML = rows;
+        list.classList.remove('hidden');
+    } catch (e) {
+        console.error('Failed to browse:', e);
+    }
+}
+
+document.getElementById('file-list').addEventListener('click', (e) => {
+    const target = e.target.closest('[data-browse]');
+    if (!target) return;
+    e.preventDefault();
+    browse(decodeURIComponent(target.dataset.browse));
+});
+
+// fetchDownloadCounts lists who has downloaded what, for admins.
+async function fetchDownloadCounts() {
+    try {
+        const response = await fetch('api/stats');
+        const stats = await response.json();
+        const list = document.getElementById('downloads-list');
+        if (stats.downloads.length === 0) {
+            list.innerHTML = '<div class="file"><span class="name">No downloads yet</span></div>';
+        } else {
+            list.innerHTML = stats.downloads.map(d => {
+                const clients = d.clients.map(c => escapeHtml(c.client) + (c.count > 1 ? ' ×' + c.count : '')).join(', ');
+                return '<div class="file"><span class="name">' + escapeHtml(d.name) + '<br><small>' + clients + '</small></span>' +
+                    '<span class="size">' + d.count + '×</span></div>';
+            }).join('');
+        }
+        document.getElementById('downloads-section').classList.remove('hidden');
+    } catch (e) {
+        console.error('Failed to fetch download counts:', e);
+    }
+}
+
+let galleryImages = null;
+let lightboxIndex = 0;
+
+function imageURL(name, extra) {
+    return 'api/gallery/image?name=' + encodeURIComponent(name) + (extra || '');
+}
+
+async function fetchGallery() {
+    try {
+        const response = await fetch('api/gallery');
+        const listing = await response.json();
+        if (!listing.gallery) {
+            galleryImages = null;
+            document.getElementById('gallery').classList.add('hidden');
+            return;
+        }
+        const selected = new Set(selectedImages());
+        galleryImages = listing.images;
+        const grid = document.getElementById('gallery-grid');
+        grid.innerHTML = '';
+        galleryImages.forEach((img, i) => {
+            const tile = document.createElement('div');
+            tile.className = 'tile';
+            tile.dataset.index = i;
+            tile.title = img.name + ' (' + formatSize(img.size) + ')';
+            const thumb = document.createElement('img');
+            thumb.loading = 'lazy';
+            thumb.src = imageURL(img.name, '&thumb=1');
+            thumb.alt = img.name;
+            const box = document.createElement('input');
+            box.type = 'checkbox';
+            box.checked = selected.has(img.name);
+            tile.appendChild(thumb);
+            tile.appendChild(box);
+            grid.appendChild(tile);
+        });
+        document.getElementById('file-list').classList.add('hidden');
+        document.getElementById('gallery').classList.remove('hidden');
+        updateGallerySelection();
+    } catch (e) {
+        console.error('Failed to fetch gallery:', e);
+    }
+}
+
+function selectedImages() {
+    return Array.from(document.querySelectorAll('#gallery-grid input:checked'))
+        .map(c => galleryImages[parseInt(c.parentNode.dataset.index)].name);
+}
+
+function updateGallerySelection() {
+    const count = document.querySelectorAll('#gallery-grid input:checked').length;
+    document.getElementById('gallery-count').textContent = count;
+    document.getElementById('gallery-download').classList.toggle('hidden', count === 0);
+    const all = count > 0 && count === galleryImages.length;
+    document.getElementById('gallery-select-all').textContent = all ? 'Select none' : 'Select all';
+}
+
+function showLightbox(index) {
+    lightboxIndex = (index + galleryImages.length) % galleryImages.length;
+    const img = galleryImages[lightboxIndex];
+    document.getElementById('lightbox-img').src = imageURL(img.name);
+    document.getElementById('lightbox-name').textContent = img.name + ' (' + formatSize(img.size) + ')';
+    document.getElementById('lightbox-download').href = imageURL(img.name, '&download=1');
+    document.getElementById('lightbox').classList.remove('hidden');
+}
+
+function closeLightbox() {
+    document.getElementById('lightbox').classList.add('hidden');
+    document.getElementById('lightbox-img').removeAttribute('src');
+}
+
+document.getElementById('gallery-grid').addEventListener('click', (e) => {
+    if (e.target.type === 'checkbox') {
+        updateGallerySelection();
+        return;
+    }
+    const tile = e.target.closest('.tile');
+    if (tile) showLightbox(parseInt(tile.dataset.index));
+});
+
+document.getElementById('gallery-select-all').addEventListener('click', () => {
+    const boxes = document.querySelectorAll('#gallery-grid input');
+    const all = Array.from(boxes).every(c => c.checked);
+    boxes.forEach(c => { c.checked = !all; });
+    updateGallerySelection();
+});
+
+document.getElementById('gallery-download').addEventListener('click', () => {
+    // A form post lets the browser handle the zip as a normal download.
+    const form = document.createElement('form');
+    form.method = 'POST';
+    form.action = 'api/gallery/zip';
+    selectedImages().forEach(name => {
+        const input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = 'name';
+        input.value = name;
+        form.appendChild(input);
+    });
+    document.body.appendChild(form);
+    form.submit();
+    form.remove();
+});
+
+document.getElementById('lightbox-prev').addEventListener('click', () => showLightbox(lightboxIndex - 1));
+document.getElementById('lightbox-next').addEventListener('click', () => showLightbox(lightboxIndex + 1));
+document.getElementById('lightbox-close').addEventListener('click', closeLightbox);
+document.getElementById('lightbox').addEventListener('click', (e) => {
+    if (e.target.id === 'lightbox') closeLightbox();
+});
+document.addEventListener('keydown', (e) => {
+    if (document.getElementById('lightbox').classList.contains('hidden')) return;
+    if (e.key === 'Escape') closeLightbox();
+    if (e.key === 'ArrowLeft') showLightbox(lightboxIndex - 1);
+    if (e.key === 'ArrowRight') showLightbox(lightboxIndex + 1);
+});
+
+function showPlayer(kind) {
+    const player = document.getElementById('player');
+    if (player.firstChild) return;
+    const media = document.createElement(kind);
+    media.controls = true;
+    media.preload = 'metadata';
+    media.src = 'api/stream';
+    player.appendChild(media);
+    player.classList.remove('hidden');
+    findCastDevices();
+}
+
+async function findCastDevices() {
+    try {
+        const res = await fetch('api/cast');
+        if (!res.ok) return;
+        const devices = await res.json();
+        if (!devices.length) return;
+        const select = document.getElementById('cast-device');
+        select.innerHTML = '';
+        devices.forEach(d => {
+            const option = document.createElement('option');
+            option.value = d.addr;
+            option.textContent = d.name;
+            select.appendChild(option);
+        });
+        document.getElementById('cast').classList.remove('hidden');
+    } catch (e) {
+        console.error('Failed to find cast devices:', e);
+    }
+}
+
+document.getElementById('cast-btn').addEventListener('click', async () => {
+    const btn = document.getElementById('cast-btn');
+    btn.disabled = true;
+    try {
+        const res = await fetch('api/cast', {
+            method: 'POST',
+            body: new URLSearchParams({ device: document.getElementById('cast-device').value })
+        });
+        if (!res.ok) {
+            alert(await res.text());
+        } else {
+            const media = document.querySelector('#player video, #player audio');
+            if (media) media.pause();
+        }
+    } catch (e) {
+        alert('Cast failed: ' + e.message);
+    } finally {
+        btn.disabled = false;
+    }
+});
+
+function adminToken() {
+    let token = localStorage.getItem('fileshare-admin-token');
+    if (!token) {
+        token = prompt('Admin token');
+        if (token) localStorage.setItem('fileshare-admin-token', token);
+    }
+    return token;
+}
+
+async function manageFile(url, options) {
+    const token = adminToken();
+    if (!token) return;
+    options.headers = { 'Authorization': 'Bearer ' + token };
+    const response = await fetch(url, options);
+    if (response.status === 401) {
+        localStorage.removeItem('fileshare-admin-token');
+    }
+    if (!response.ok) {
+        alert(await response.text());
+    }
+    fetchFiles();
+}
+
+document.getElementById('received-list').addEventListener('click', (e) => {
+    const target = e.target.closest('[data-delete], [data-rename]');
+    if (!target) return;
+    e.preventDefault();
+    if (target.dataset.delete) {
+        const name = target.dataset.delete;
+        if (confirm('Delete "' + name + '"?')) {
+            manageFile('api/file?name=' + encodeURIComponent(name), { method: 'DELETE' });
+        }
+    } else {
+        const name = target.dataset.rename;
+        const to = prompt('New name', name);
+        if (to && to !== name) {
+            const body = new URLSearchParams({ name: name, to: to });
+            manageFile('api/file/rename', { method: 'POST', body: body });
+        }
+    }
+});
+
+async function fetchLogs() {
+    try {
+        const response = await fetch('api/log');
+        const logs = await response.json();
+        renderLogs(logs);
+    } catch (e) {
+        console.error('Failed to fetch logs:', e);
+    }
+}
+
+function renderLogs(logs) {
+    logEntries.innerHTML = logs.map(log => 
+        '<div class="log-entry">' + escapeHtml(log) + '</div>'
+    ).join('');
+    logEntries.scrollTop = logEntries.scrollHeight;
+}
+
+function updateStatus(data) {
+    statusEl.className = 'status ' + data.status;
+    
+    switch(data.status) {
+        case 'waiting':
+            statusEl.textContent = '⏳ Waiting for connection...';
+            break;
+        case 'transferring':
+            statusEl.textContent = '📤 Transferring... ' + (data.size < 0 ? formatSize(data.transferred) : data.progress.toFixed(1) + '%');
+            break;
+        case 'completed':
+            statusEl.textContent = '✅ Transfer completed!';
+            break;
+        case 'cancelled':
+            statusEl.textContent = '❌ Transfer cancelled';
+            break;
+        case 'error':
+            statusEl.textContent = '⚠️ Error: ' + (data.error || 'Unknown error');
+            break;
+    }
+}
+
+async function fetchClients() {
+    try {
+        const response = await fetch('api/clients');
+        showClients((await response.json()).clients);
+    } catch (e) {
+        console.error('Failed to fetch clients:', e);
+    }
+}
+
+function showClients(clients) {
+    const el = document.getElementById('clients');
+    if (clients.length === 0) {
+        el.textContent = 'None';
+        return;
+    }
+    el.innerHTML = clients.map(c => {
+        const name = c.host ? escapeHtml(c.host) + ' (' + escapeHtml(c.ip) + ')' : escapeHtml(c.ip);
+        const kick = canKick ? ' <a href="#" data-kick="' + c.id + '" title="Disconnect and ban for 10 minutes">Kick</a>' : '';
+        return '<div class="client" title="' + escapeHtml(c.user_agent).replace(/"/g, '&quot;') + '">' + name +
+            ' <small>' + c.activity + ' · ' + escapeHtml(userAgentName(c.user_agent)) + kick + '</small></div>';
+    }).join('');
+}
+
+document.getElementById('clients').addEventListener('click', async (e) => {
+    const target = e.target.closest('[data-kick]');
+    if (!target) return;
+    e.preventDefault();
+    if (!confirm('Disconnect this client and ban it for 10 minutes?')) return;
+    const options = { method: 'POST' };
+    if (!signedInAdmin) {
+        const token = adminToken();
+        if (!token) return;
+        options.headers = { 'Authorization': 'Bearer ' + token };
+    }
+    const response = await fetch('api/clients/' + target.dataset.kick + '/kick', options);
+    if (response.status === 401) {
+        localStorage.removeItem('fileshare-admin-token');
+    }
+    if (!response.ok) {
+        alert(await response.text());
+    }
+});
+
+// userAgentName shortens a User-Agent to the browser or tool name.
+function userAgentName(ua) {
+    // Chrome's says Safari too, and Edge's says both.
+    for (const name of ['Edg', 'Firefox', 'Chrome', 'Safari', 'curl', 'Wget', 'fileshare']) {
+        const match = ua.match(new RegExp('\\b' + name + '/[\\d.]+'));
+        if (match) return match[0].replace(/^Edg\//, 'Edge/');
+    }
+    return ua.length > 30 ? ua.slice(0, 30) + '…' : (ua || 'unknown');
+}
+
+async function showDownloads(data) {
+    downloadSection.classList.remove('hidden');
+    await fetchGallery();
+    if (!galleryImages) fetchFiles();
+    if (data.media) {
+        showPlayer(data.media);
+    }
+    curlCmd.textContent = 'curl -O -J "' + apiURL('api/download') + '"';
+    if (data.terms) {
+        curlCmd.textContent = 'curl -c terms.txt -X POST "' + apiURL('api/terms') + '" && ' +
+            'curl -b terms.txt -O -J "' + apiURL('api/download') + '"';
+    }
+}
+
+// showTerms holds the download back until the recipient accepts -terms.
+function showTerms(data) {
+    const section = document.getElementById('terms-section');
+    const check = document.getElementById('terms-check');
+    const button = document.getElementById('terms-btn');
+    // Rendered and escaped server-side.
+    document.getElementById('terms').innerHTML = data.terms;
+    section.classList.remove('hidden');
+    check.onchange = () => { button.disabled = !check.checked; };
+    button.onclick = async () => {
+        button.disabled = true;
+        const response = await fetch('api/terms', { method: 'POST' });
+        if (!response.ok) {
+            alert('Could not accept the terms: ' + await response.text());
+            button.disabled = false;
+            return;
+        }
+        section.classList.add('hidden');
+        await showDownloads(data);
+    };
+}
+
+function apiURL(path) {
+    return new URL(path, document.baseURI).href;
+}
+
+function formatSize(bytes) {
+    if (bytes === 0) return '0 B';
+    const k = 1024;
+    const sizes = ['B', 'KB', 'MB', 'GB'];
+    const i = Math.floor(Math.log(bytes) / Math.log(k));
+    return parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + ' ' + sizes[i];
+}
+
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
+
+// File upload
+dropZone.addEventListener('click', () => fileInput.click());
+
+dropZone.addEventListener('dragover', (e) => {
+    e.preventDefault();
+    dropZone.classList.add('dragover');
+});
+
+dropZone.addEventListener('dragleave', () => {
+    dropZone.classList.remove('dragover');
+});
+
+dropZone.addEventListener('drop', async (e) => {
+    e.preventDefault();
+    dropZone.classList.remove('dragover');
+    // The dropped items are only readable until the first await.
+    const entries = [...e.dataTransfer.items].map((item) => item.webkitGetAsEntry?.()).filter(Boolean);
+    const files = [...e.dataTransfer.files];
+    if (entries.length === 0) {
+        for (const file of files) {
+            if (!await uploadFile(file)) break;
+        }
+        return;
+    }
+    for (const entry of entries) {
+        if (!await uploadEntry(entry, '')) break;
+    }
+});
+
+// uploadEntry uploads a dropped file, or walks a dropped folder one
+// directory at a time, uploading its files one by one into the same
+// relative folders on the server.
+async function uploadEntry(entry, dir) {
+    if (entry.isFile) {
+        const file = await new Promise((resolve, reject) => entry.file(resolve, reject));
+        return uploadFile(file, dir);
+    }
+    const path = dir ? dir + '/' + entry.name : entry.name;
+    for (const child of await readEntries(entry.createReader())) {
+        if (!await uploadEntry(child, path)) return false;
+    }
+    return true;
+}
+
+// readEntries lists a directory; browsers hand the entries out in
+// batches, ending with an empty one.
+function readEntries(reader) {
+    return new Promise((resolve, reject) => {
+        const entries = [];
+        const next = () => reader.readEntries((batch) => {
+            if (batch.length === 0) {
+                resolve(entries);
+                return;
+            }
+            entries.push(...batch);
+            next();
+        }, reject);
+        next();
+    });
+}
+
+fileInput.addEventListener('change', (e) => {
+    if (e.target.files.length > 0) {
+        uploadFile(e.target.files[0]);
+    }
+});
+
+// Phones and tablets can't drop files, but can upload a photo straight
+// from the camera.
+if (window.matchMedia('(pointer: coarse)').matches) {
+    const cameraInput = document.getElementById('camera-input');
+    const cameraBtn = document.getElementById('camera-btn');
+    document.getElementById('drop-text').textContent = 'Tap to choose a file';
+    cameraBtn.classList.remove('hidden');
+    cameraBtn.addEventListener('click', () => cameraInput.click());
+    cameraInput.addEventListener('change', (e) => {
+        if (e.target.files.length > 0) {
+            uploadFile(e.target.files[0]);
+            e.target.value = '';
+        }
+    });
+}
+
+// The server downloads a pasted link itself (-allow-fetch), reporting
+// progress like an upload.
+document.getElementById('fetch-btn').addEventListener('click', async (e) => {
+    const input = document.getElementById('fetch-input');
+    const url = input.value.trim();
+    if (!url) return;
+    e.target.disabled = true;
+    progressContainer.classList.add('active');
+    try {
+        const response = await fetch('api/fetch', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ url: url })
+        });
+        if (response.status === 503) {
+            retryWhenFree(response, () => e.target.click());
+        } else if (!response.ok) {
+            throw new Error(await response.text());
+        } else {
+            input.value = '';
+        }
+    } catch (err) {
+        console.error('Fetch failed:', err);
+        alert('Fetch failed: ' + err.message);
+    } finally {
+        e.target.disabled = false;
+    }
+});
+
+// uploadFile uploads file into dir (relative to the share, '' for its
+// top), resolving to whether it worked.
+async function uploadFile(file, dir) {
+    const formData = new FormData();
+    // Fields before the file, for servers streaming uploads (-low-mem).
+    if (dir) formData.append('dir', dir);
+    formData.append('file', file);
+    
+    progressContainer.classList.add('active');
+    cancelBtn.classList.remove('hidden');
+    
+    try {
+        const response = await fetch('api/upload', {
+            method: 'POST',
+            body: formData
+        });
+        
+        if (response.status === 503) {
+            return await new Promise((resolve) => retryWhenFree(response, () => resolve(uploadFile(file, dir))));
+        } else if (response.status === 409) {
+            const data = await response.json();
+            if (confirm('File "' + file.name + '" already exists. Overwrite?')) {
+                // TODO: Implement overwrite
+                alert('Please rename the file or choose a different name');
+            }
+            return false;
+        } else if (!response.ok) {
+            const text = await response.text();
+            throw new Error(text);
+        }
+        return true;
+    } catch (e) {
+        console.error('Upload failed:', e);
+        alert('Upload failed: ' + e.message);
+        return false;
+    }
+}
+
+function progressLabel(data) {
+    if (data.phase === 'scanning') {
+        return 'Scanning files…';
+    }
+    if (data.size < 0) {
+        return formatSize(data.transferred) + ' sent';
+    }
+    const amounts = data.progress.toFixed(1) + '% (' + formatSize(data.transferred) + ' / ' + formatSize(data.size) + ')';
+    if (data.phase === 'compressing') {
+        return 'Compressing ' + amounts + ' · sent ' + formatSize(data.sent);
+    }
+    if (data.progress >= 100 && data.sent) {
+        return 'Finishing… ' + formatSize(data.sent) + ' sent';
+    }
+    return amounts;
+}
+
+// Another client holds the share: count down the server's
+// Retry-After, then try again.
+function retryWhenFree(response, retry) {
+    let left = parseInt(response.headers.get('Retry-After'), 10) || 10;
+    cancelBtn.classList.add('hidden');
+    const tick = () => {
+        if (left <= 0) {
+            retry();
+            return;
+        }
+        progressText.textContent = 'Someone else is transferring, retrying in ' + left + 's…';
+        left--;
+        setTimeout(tick, 1000);
+    };
+    tick();
+}
+
+// Download
+downloadBtn.addEventListener('click', () => {
+    window.location.href = 'api/download';
+});
+
+// Cancel
+cancelBtn.addEventListener('click', async () => {
+    try {
+        await fetch('api/cancel', { method: 'POST' });
+    } catch (e) {
+        console.error('Cancel failed:', e);
+    }
+});
+
+// Refresh logs periodically
+setInterval(fetchLogs, 1000);
+
+// Start
+init();
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// webdavForward copies received files into a WebDAV folder (Nextcloud,
+// ownCloud, Apache mod_dav...), creating subdirectories as needed.
+type webdavForward struct {
+	base     *url.URL
+	user     string
+	password string
+	client   *http.Client
+}
+
+// newWebDAVForward parses the folder URL. Credentials come from the URL,
+// FILESHARE_WEBDAV_USER/FILESHARE_WEBDAV_PASSWORD, or the host's entry in
+// ~/.netrc (or $NETRC), in that order.
+func newWebDAVForward(target string, getenv func(string) string) (*webdavForward, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("-forward-webdav must be an http(s) folder URL")
+	}
+	f := &webdavForward{client: &http.Client{}}
+	if u.User != nil {
+		f.user = u.User.Username()
+		f.password, _ = u.User.Password()
+		u.User = nil
+	} else if user := getenv("FILESHARE_WEBDAV_USER"); user != "" {
+		f.user, f.password = user, getenv("FILESHARE_WEBDAV_PASSWORD")
+	} else {
+		path := getenv("NETRC")
+		if path == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, ".netrc")
+			}
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			f.user, f.password = netrcLogin(data, u.Hostname())
+		}
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawPath = ""
+	f.base = u
+	return f, nil
+}
+
+// netrcLogin returns the login and password for host from a .netrc file,
+// falling back to its default entry.
+func netrcLogin(data []byte, host string) (string, string) {
+	var login, password, defLogin, defPassword string
+	var current string // "host", "default" or "" for another machine
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "machine":
+			current = ""
+			if scanner.Scan() && scanner.Text() == host {
+				current = "host"
+			}
+		case "default":
+			current = "default"
+		case "login", "password":
+			key := scanner.Text()
+			if !scanner.Scan() {
+				continue
+			}
+			value := scanner.Text()
+			switch {
+			case current == "host" && key == "login":
+				login = value
+			case current == "host":
+				password = value
+			case current == "default" && key == "login":
+				defLogin = value
+			case current == "default":
+				defPassword = value
+			}
+		}
+	}
+	if login != "" || password != "" {
+		return login, password
+	}
+	return defLogin, defPassword
+}
+
+// fileURL is the escaped URL of name (slash-separated) under the folder.
+func (f *webdavForward) fileURL(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return f.base.String() + strings.Join(parts, "/")
+}
+
+func (f *webdavForward) location(name string) string {
+	return f.base.String() + name
+}
+
+func (f *webdavForward) do(method, target string, body io.Reader, size int64, header http.Header) error {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if f.user != "" || f.password != "" {
+		req.SetBasicAuth(f.user, f.password)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &mirrorStatusError{code: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// put uploads the file, creating missing parent folders when the server
+// answers 409 Conflict.
+func (f *webdavForward) put(path, name, sum string, now time.Time) error {
+	err := f.putFile(path, name, sum)
+	var status *mirrorStatusError
+	if !errors.As(err, &status) || status.code != http.StatusConflict || !strings.Contains(name, "/") {
+		return err
+	}
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		err := f.do("MKCOL", f.fileURL(strings.Join(parts[:i], "/"))+"/", nil, 0, nil)
+		// 405 means the folder is already there.
+		if errors.As(err, &status) && status.code == http.StatusMethodNotAllowed {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.putFile(path, name, sum)
+}
+
+func (f *webdavForward) putFile(path, name, sum string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if sum != "" {
+		// Nextcloud and ownCloud keep this as the file's checksum.
+		header.Set("OC-Checksum", "SHA256:"+sum)
+	}
+	return f.do(http.MethodPut, f.fileURL(name), file, info.Size(), header)
+}
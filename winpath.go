@@ -0,0 +1,110 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Windows paths a share can be given as: drive-relative (D:photos), UNC
+// (\\server\share\dir) and long paths, which the plain Win32 API refuses
+// past MAX_PATH unless they carry the \\?\ prefix. The win* helpers work on
+// Windows syntax whatever the platform, so they can be tested anywhere.
+
+// maxDirPath is MAX_PATH less room for an 8.3 file name, the limit
+// CreateDirectory applies.
+const maxDirPath = 260 - 12
+
+func isWinSep(c byte) bool {
+	return c == '\\' || c == '/'
+}
+
+// winVolumeLen returns the length of p's volume: "C:", `\\server\share`,
+// `\\?\C:` or `\\?\UNC\server\share`.
+func winVolumeLen(p string) int {
+	if len(p) >= 2 && p[1] == ':' && ('a' <= p[0]|0x20 && p[0]|0x20 <= 'z') {
+		return 2
+	}
+	if len(p) < 3 || !isWinSep(p[0]) || !isWinSep(p[1]) || isWinSep(p[2]) {
+		return 0
+	}
+	// \\?\ and \\.\ prefixes: a drive, a UNC share or a device follows.
+	if len(p) >= 4 && (p[2] == '?' || p[2] == '.') && isWinSep(p[3]) {
+		rest := p[4:]
+		if n := winVolumeLen(rest); n == 2 {
+			return 4 + n
+		}
+		if len(rest) >= 4 && strings.EqualFold(rest[:3], "UNC") && isWinSep(rest[3]) {
+			return 4 + 4 + winElems(rest[4:], 2)
+		}
+		return 4 + winElems(rest, 1)
+	}
+	return 2 + winElems(p[2:], 2)
+}
+
+// winElems returns the length of the first n separator-delimited elements
+// of p, without the trailing separator.
+func winElems(p string, n int) int {
+	i := 0
+	for ; n > 0; n-- {
+		for i < len(p) && !isWinSep(p[i]) {
+			i++
+		}
+		if n > 1 && i < len(p) {
+			i++
+		}
+	}
+	return i
+}
+
+// winBase is filepath.Base for Windows paths, except that the root of a
+// drive or share is named after the drive letter or share instead of `\`,
+// so a download of it gets a usable name.
+func winBase(p string) string {
+	vol := p[:winVolumeLen(p)]
+	rest := strings.TrimRight(p[len(vol):], `\/`)
+	if rest != "" {
+		return rest[strings.LastIndexAny(rest, `\/`)+1:]
+	}
+	vol = strings.TrimRight(vol, ":")
+	if vol == "" {
+		return `\`
+	}
+	return vol[strings.LastIndexAny(vol, `\/`)+1:]
+}
+
+// winLongPath adds the \\?\ prefix to an absolute path too long for the
+// plain Win32 API. Anything else is returned as is.
+func winLongPath(p string) string {
+	if len(p) < maxDirPath || strings.HasPrefix(p, `\\?\`) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+	p = strings.ReplaceAll(p, "/", `\`)
+	switch {
+	case strings.HasPrefix(p, `\\`):
+		return `\\?\UNC\` + p[2:]
+	case len(p) >= 3 && winVolumeLen(p) == 2 && p[2] == '\\':
+		return `\\?\` + p
+	}
+	return p
+}
+
+// baseName names a share after the last element of its path.
+func baseName(p string) string {
+	if filepath.Separator == '\\' {
+		return winBase(p)
+	}
+	return filepath.Base(p)
+}
+
+// targetPath readies a send or recv argument. On Windows it is made
+// absolute, resolving drive-relative paths against that drive's current
+// directory, and given the \\?\ prefix when long.
+func targetPath(p string) string {
+	if filepath.Separator != '\\' || hasGlobMeta(p) {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	return winLongPath(p)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test naming shares from Windows paths
+func TestWinBase(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{`C:\Users\ann\Photos`, "Photos"},
+		{`C:\Users\ann\Photos\`, "Photos"},
+		{`C:/Users/ann/report.pdf`, "report.pdf"},
+		{`D:photos`, "photos"},
+		{`C:\`, "C"},
+		{`D:`, "D"},
+		{`\\nas\media\Movies`, "Movies"},
+		{`\\nas\media`, "media"},
+		{`\\nas\media\`, "media"},
+		{`\\?\C:\very\long\folder`, "folder"},
+		{`\\?\C:\`, "C"},
+		{`\\?\UNC\nas\media\Movies`, "Movies"},
+		{`\\?\UNC\nas\media`, "media"},
+		{`\`, `\`},
+		{`notes.txt`, "notes.txt"},
+	}
+	for _, test := range tests {
+		if got := winBase(test.path); got != test.expected {
+			t.Errorf("winBase(%q) = %q, expected %q", test.path, got, test.expected)
+		}
+	}
+}
+
+// Test adding the \\?\ prefix to long Windows paths
+func TestWinLongPath(t *testing.T) {
+	long := strings.Repeat(`folder\`, 40) + "file.bin"
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{`C:\short\file.bin`, `C:\short\file.bin`},
+		{`\\nas\media\short`, `\\nas\media\short`},
+		{`C:\` + long, `\\?\C:\` + long},
+		{`C:/` + strings.ReplaceAll(long, `\`, "/"), `\\?\C:\` + long},
+		{`\\nas\media\` + long, `\\?\UNC\nas\media\` + long},
+		{`\\?\C:\` + long, `\\?\C:\` + long},
+		{`\\?\UNC\nas\media\` + long, `\\?\UNC\nas\media\` + long},
+		// Relative paths can't take the prefix.
+		{long, long},
+		{`C:` + long, `C:` + long},
+	}
+	for _, test := range tests {
+		if got := winLongPath(test.path); got != test.expected {
+			t.Errorf("winLongPath(%q) = %q, expected %q", test.path, got, test.expected)
+		}
+	}
+}